@@ -0,0 +1,225 @@
+// Package cli implements the db command, which runs one-off commands against a database, serves it, or
+// opens an interactive prompt.
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/filesystem"
+)
+
+// The exit codes returned by Main.
+const (
+	ExitOK       = 0 // The command succeeded.
+	ExitError    = 1 // The command failed.
+	ExitUsage    = 2 // The command line was not valid.
+	ExitNotFound = 3 // A key the command needed was not found.
+)
+
+// errUsage is returned by commands whose arguments are not valid, once the usage has been written.
+var errUsage = errors.New("usage")
+
+// A command is one of the db command's subcommands.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(e *env, args []string) error
+}
+
+// An env holds the global flags, and the input and output, that commands run with.
+type env struct {
+	dbName   string
+	json     bool
+	readOnly bool
+	log      bool
+	slow     time.Duration
+
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+// Main runs the db command with the given arguments, not including the program name, and returns its
+// exit code. Global flags come before the command:
+//
+//	db [-path <dir>] [-name <name>] [-json] [-readonly] [-log] [-slow <duration>] <command> [<argument>...]
+//
+// With no command, Main runs the interactive prompt if stdin is a terminal, and otherwise runs stdin as
+// a script of prompt commands.
+func Main(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	e := &env{stdin: stdin, stdout: stdout, stderr: stderr}
+	fs := flag.NewFlagSet("db", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dir := fs.String("path", ".", "the `directory` holding the database")
+	name := fs.String("name", "test", "the database's `name`; its files are <name>.dat and <name>.lock")
+	fs.BoolVar(&e.json, "json", false, "write results as JSON")
+	fs.BoolVar(&e.readOnly, "readonly", false, "open the database read-only for the prompt and scripts")
+	fs.BoolVar(&e.log, "log", false, "log what the database does to standard error")
+	fs.DurationVar(&e.slow, "slow", 0, "log reads and writes taking at least this `duration`; implies -log")
+	fs.Usage = func() { printUsage(stderr, fs) }
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+	e.dbName = filepath.Join(*dir, *name)
+
+	args = fs.Args()
+	if len(args) == 0 {
+		args = []string{"script"}
+		if interactive(stdin) {
+			args[0] = "repl"
+		}
+	}
+	if args[0] == "help" {
+		printUsage(stdout, fs)
+		return ExitOK
+	}
+	c := lookup(args[0])
+	if c == nil {
+		fmt.Fprintf(stderr, "db: unknown command %q; try 'db help'\n", args[0])
+		return ExitUsage
+	}
+	return e.exit(c.run(e, args[1:]))
+}
+
+func printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: db [<flag>...] <command> [<argument>...]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-50s %s\n", c.usage, c.summary)
+	}
+	fmt.Fprintln(w, "\nFlags:")
+	out := fs.Output()
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fs.SetOutput(out)
+	fmt.Fprintf(w, "\nWith no command, db runs the prompt, or runs standard input as a script if it is not a terminal.\n"+
+		"The exit status is %d on success, %d on error, %d for bad usage and %d if a key is not found.\n",
+		ExitOK, ExitError, ExitUsage, ExitNotFound)
+}
+
+// interactive returns true if r is a terminal.
+func interactive(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func lookup(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// exit reports err, if there is one, and returns the exit code for it.
+func (e *env) exit(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case err == flag.ErrHelp:
+		return ExitOK
+	case err == errUsage:
+		return ExitUsage
+	case errors.Is(err, file.ErrNotFound):
+		fmt.Fprintln(e.stderr, "db:", err)
+		return ExitNotFound
+	default:
+		fmt.Fprintln(e.stderr, "db:", err)
+		return ExitError
+	}
+}
+
+// flags returns a FlagSet for a command's own flags. The global -json flag may also be given among them.
+func (e *env) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.BoolVar(&e.json, "json", e.json, "write results as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "usage: db %s\n", lookup(name).usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses a command's flags, which may come before, between or after its arguments, and returns the
+// arguments. Arguments after -- are never taken as flags, so keys beginning with - may follow it. If the
+// flags are not valid, or there are fewer than min or more than max arguments, parse writes the command's
+// usage and returns errUsage. A max less than 0 means there is no limit.
+func (e *env) parse(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	var rest, literal []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, literal = args[:i], args[i+1:]
+			break
+		}
+	}
+	for {
+		if err := fs.Parse(args); err == flag.ErrHelp {
+			return nil, err
+		} else if err != nil {
+			return nil, errUsage
+		}
+		if args = fs.Args(); len(args) == 0 {
+			break
+		}
+		rest, args = append(rest, args[0]), args[1:]
+	}
+	rest = append(rest, literal...)
+	if len(rest) < min || (max >= 0 && len(rest) > max) {
+		fs.Usage()
+		return nil, errUsage
+	}
+	return rest, nil
+}
+
+// open opens the database, logging to stderr if -log or -slow was given.
+func (e *env) open(option ...filesystem.Option) (*database.DB, error) {
+	if e.log || e.slow > 0 {
+		option = append(option, filesystem.LogTo(file.NewStdLogger(log.New(e.stderr, "", log.LstdFlags))))
+	}
+	if e.slow > 0 {
+		option = append(option, filesystem.SlowThreshold(e.slow))
+	}
+	return database.Init(e.dbName, option...)
+}
+
+// result writes v to stdout, as JSON if the -json flag was given, and otherwise as text.
+func (e *env) result(v interface{}) error {
+	if e.json {
+		return json.NewEncoder(e.stdout).Encode(v)
+	}
+	s := fmt.Sprint(v)
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	_, err := io.WriteString(e.stdout, s)
+	return err
+}
+
+// input returns the file named by the first of args, or stdin if there isn't one or it is -.
+func (e *env) input(args []string) (io.ReadCloser, error) {
+	if len(args) == 0 || args[0] == "-" {
+		return ioutil.NopCloser(e.stdin), nil
+	}
+	return os.Open(args[0])
+}
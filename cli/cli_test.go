@@ -0,0 +1,197 @@
+package cli_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthew-burr/db/cli"
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A CLI runs the db command against a database in a temporary directory.
+type CLI struct {
+	t   *testing.T
+	Dir string
+}
+
+func SetupCLI(t *testing.T) *CLI {
+	dir, err := ioutil.TempDir("", "cli_test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return &CLI{t: t, Dir: dir}
+}
+
+// Run runs the command with the given standard input, returning its exit code and output.
+func (c *CLI) Run(stdin string, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = cli.Main(append([]string{"-path", c.Dir}, args...), strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+// MustRun runs the command, failing the test unless it succeeds, and returns its output.
+func (c *CLI) MustRun(args ...string) string {
+	code, stdout, stderr := c.Run("", args...)
+	require.Equal(c.t, cli.ExitOK, code, "%v: %s", args, stderr)
+	return stdout
+}
+
+func TestMain_PutGetDel(t *testing.T) {
+	c := SetupCLI(t)
+
+	c.MustRun("put", "greeting", "hello world")
+	assert.Equal(t, "hello world\n", c.MustRun("get", "greeting"))
+	c.MustRun("del", "greeting")
+
+	code, stdout, stderr := c.Run("", "get", "greeting")
+	assert.Equal(t, cli.ExitNotFound, code)
+	assert.Empty(t, stdout)
+	assert.Equal(t, "db: key not found\n", stderr)
+
+	code, _, _ = c.Run("", "del", "greeting")
+	assert.Equal(t, cli.ExitNotFound, code)
+}
+
+func TestMain_FlagsMayFollowArguments(t *testing.T) {
+	c := SetupCLI(t)
+
+	c.MustRun("put", "session", "abc", "-ttl", "1h")
+	c.MustRun("put", "--", "-dash", "-value")
+	assert.Equal(t, "-value\n", c.MustRun("get", "--", "-dash"))
+
+	var rec database.Record
+	require.NoError(t, json.Unmarshal([]byte(c.MustRun("get", "session", "--json")), &rec))
+	assert.Equal(t, "abc", rec.Value)
+	assert.NotNil(t, rec.Expires)
+}
+
+func TestMain_Scan(t *testing.T) {
+	c := SetupCLI(t)
+	for _, k := range []string{"user:1", "user:2", "user:3", "item:1"} {
+		c.MustRun("put", k, "v"+k)
+	}
+
+	assert.Equal(t, "user:1\tvuser:1\nuser:2\tvuser:2\nuser:3\tvuser:3\n", c.MustRun("scan", "user:"))
+	assert.Equal(t, "item:1\tvitem:1\nuser:1\tvuser:1\n", c.MustRun("scan", "-limit", "2"))
+
+	lines := strings.Split(strings.TrimSpace(c.MustRun("-json", "scan", "item:")), "\n")
+	require.Len(t, lines, 1)
+	var rec database.Record
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, database.Record{Key: "item:1", Value: "vitem:1"}, rec)
+}
+
+func TestMain_CheckAndCompact(t *testing.T) {
+	c := SetupCLI(t)
+	c.MustRun("put", "a", "1")
+	c.MustRun("put", "a", "2")
+
+	var report struct {
+		LiveKeys int `json:"live_keys"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(c.MustRun("check", "-json")), &report))
+	assert.Equal(t, 1, report.LiveKeys)
+
+	assert.Contains(t, c.MustRun("compact"), "keeping 1 entries")
+	assert.Equal(t, "2\n", c.MustRun("get", "a"))
+
+	var stats database.Stats
+	require.NoError(t, json.Unmarshal([]byte(c.MustRun("stats", "-json")), &stats))
+	assert.Equal(t, 1, stats.LiveKeys)
+	assert.Equal(t, int64(0), stats.DeadBytes)
+}
+
+func TestMain_ExportImport(t *testing.T) {
+	c := SetupCLI(t)
+	c.MustRun("put", "a", "1")
+	c.MustRun("put", "b", "2")
+	exported := filepath.Join(c.Dir, "out.csv")
+	c.MustRun("export", exported, "-csv")
+
+	c.MustRun("-name", "copy", "import", "-csv", exported)
+	assert.Equal(t, "a\t1\nb\t2\n", c.MustRun("-name", "copy", "scan"))
+}
+
+func TestMain_Script(t *testing.T) {
+	c := SetupCLI(t)
+
+	code, stdout, stderr := c.Run("write a 1\nread a\n")
+	assert.Equal(t, cli.ExitOK, code, stderr)
+	assert.Equal(t, "written\na: 1\n", stdout)
+
+	code, _, stderr = c.Run("write b 2\nwrite c\nwrite d 4\n", "script")
+	assert.Equal(t, cli.ExitError, code)
+	assert.Equal(t, "db: line 2: usage: write <key> <value> [<ttl>]\n", stderr)
+	assert.Equal(t, "2\n", c.MustRun("get", "b"))
+
+	code, _, _ = c.Run("ttl nope\n", "script")
+	assert.Equal(t, cli.ExitNotFound, code)
+
+	code, _, stderr = c.Run("write e 5\n", "-readonly", "script")
+	assert.Equal(t, cli.ExitError, code)
+	assert.Contains(t, stderr, "read-only")
+}
+
+func TestMain_Usage(t *testing.T) {
+	c := SetupCLI(t)
+
+	code, _, stderr := c.Run("", "frobnicate")
+	assert.Equal(t, cli.ExitUsage, code)
+	assert.Contains(t, stderr, `unknown command "frobnicate"`)
+
+	code, _, stderr = c.Run("", "put", "a")
+	assert.Equal(t, cli.ExitUsage, code)
+	assert.Contains(t, stderr, "usage: db put [-ttl <duration>] <key> <value>")
+
+	code, _, _ = c.Run("", "-nope")
+	assert.Equal(t, cli.ExitUsage, code)
+
+	assert.Contains(t, c.MustRun("help"), "Commands:")
+}
+
+func TestMain_Log(t *testing.T) {
+	c := SetupCLI(t)
+
+	code, _, stderr := c.Run("", "-log", "put", "a", "1")
+	assert.Equal(t, cli.ExitOK, code)
+	assert.Contains(t, stderr, "INFO opened path=")
+
+	code, _, stderr = c.Run("", "-slow", "1ns", "get", "a")
+	assert.Equal(t, cli.ExitOK, code)
+	assert.Contains(t, stderr, "WARN slow operation op=get key=a")
+}
+
+func TestMain_Debug(t *testing.T) {
+	c := SetupCLI(t)
+	c.MustRun("put", "a", "1")
+	c.MustRun("put", "a", "2")
+
+	var in file.Inspection
+	require.NoError(t, json.Unmarshal([]byte(c.MustRun("debug", "-json", "a")), &in))
+	assert.Len(t, in.Occurrences, 2)
+	assert.Contains(t, c.MustRun("debug", "a"), "Key Occurrences: 2")
+}
+
+func TestMain_Dump(t *testing.T) {
+	c := SetupCLI(t)
+	c.MustRun("put", "a", "1")
+	c.MustRun("put", "b", "2")
+	c.MustRun("del", "a")
+
+	out := c.MustRun("dump")
+	assert.Contains(t, out, "3 records, 3 shown")
+	assert.Regexp(t, `\n\s+22\s+8\s+1\s+1\s+"a"\s+-\n`, out)
+
+	out = c.MustRun("dump", "-key", "b", "-from", "11")
+	assert.Contains(t, out, "2 records, 1 shown")
+
+	code, _, _ := c.Run("", "dump", "missing.dat")
+	assert.Equal(t, cli.ExitError, code)
+}
@@ -0,0 +1,641 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/filesystem"
+	"github.com/matthew-burr/db/repl"
+	"github.com/matthew-burr/db/replication"
+	"github.com/matthew-burr/db/server"
+)
+
+var commands []*command
+
+func init() {
+	// The table is built in init because the commands' usage messages refer to it.
+	commands = []*command{
+		{"get", "get <key>", "Prints the key's value", get},
+		{"put", "put [-ttl <duration>] <key> <value>", "Writes the value to the key", put},
+		{"del", "del <key>", "Deletes the key", del},
+		{"scan", "scan [-limit <n>] [<prefix>]", "Lists the entries whose keys begin with prefix", scan},
+		{"check", "check", "Checks the database for corruption", check},
+		{"compact", "compact", "Rewrites the database without its garbage", compact},
+		{"debug", "debug <key>", "Shows every entry for the key and what the index holds", debug},
+		{"dump", "dump [-from <offset>] [-to <offset>] [-key <prefix>] [-hex] [-values <n>] [<file>]",
+			"Lists every record in the log, or in another .dat file", dump},
+		{"stats", "stats", "Shows the database's size and how much of it is garbage", stats},
+		{"export", "export [-csv | -jsonl] [<file>]", "Writes every live entry to a file", exportEntries},
+		{"import", "import [-csv | -jsonl] [<file>]", "Loads entries from a file", importEntries},
+		{"dump-text", "dump-text [<file>]", "Writes the whole log as text", dumpText},
+		{"load-text", "load-text [<file>]", "Creates the database from a log written by dump-text", loadText},
+		{"backup", "backup [-compact | -since <previous>] <file>", "Writes a backup of the database", backup},
+		{"restore", "restore [-until <point>] <base> [<increment>...]", "Creates the database from backups", restore},
+		{"repair", "repair", "Salvages a damaged database", repair},
+		{"serve", "serve [-metrics <addr>] [<addr>]", "Serves the database over HTTP", serveHTTP},
+		{"resp", "resp [-metrics <addr>] [<addr>]", "Serves the database over the Redis protocol", serveRESP},
+		{"replica", "replica <primary URL>", "Keeps the database up to date with a primary", replicate},
+		{"repl", "repl", "Runs the interactive prompt", runREPL},
+		{"script", "script [<file>]", "Runs a file of prompt commands, stopping at the first that fails", script},
+	}
+}
+
+// get writes a key's value, or with -json, its Record.
+func get(e *env, args []string) error {
+	args, err := e.parse(e.flags("get"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	db, err := e.open(filesystem.Follow)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	entry, err := db.Get(args[0])
+	if err != nil {
+		return err
+	}
+	if e.json {
+		return e.result(database.NewRecord(entry))
+	}
+	return e.result(entry.Value())
+}
+
+func put(e *env, args []string) error {
+	fs := e.flags("put")
+	ttl := fs.Duration("ttl", 0, "expire the key after this `duration`")
+	args, err := e.parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	db, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	var option []file.EntryOption
+	if *ttl > 0 {
+		option = append(option, file.TTL(*ttl))
+	}
+	_, err = db.Write(args[0], args[1], option...)
+	return err
+}
+
+// del deletes a key, failing with file.ErrNotFound if it does not exist.
+func del(e *env, args []string) error {
+	args, err := e.parse(e.flags("del"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	db, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	if _, err := db.Get(args[0]); err != nil {
+		return err
+	}
+	_, err = db.Delete(args[0])
+	return err
+}
+
+// scanPage is how many entries scan reads from the index at a time.
+const scanPage = 1000
+
+// scan writes the keys with a prefix and their values, one per line and separated by a tab, or with
+// -json, their Records in JSON Lines.
+func scan(e *env, args []string) error {
+	fs := e.flags("scan")
+	limit := fs.Int("limit", 0, "list at most `n` keys, or every key if 0")
+	args, err := e.parse(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	prefix := ""
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+
+	db, err := e.open(filesystem.Follow)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	enc := json.NewEncoder(e.stdout)
+	from, to := file.PrefixRange(prefix)
+	for count := 0; ; {
+		page := scanPage
+		if *limit > 0 && *limit-count < page {
+			page = *limit - count
+		}
+		entries, err := db.Scan(from, to, page)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if e.json {
+				err = enc.Encode(database.NewRecord(entry))
+			} else {
+				_, err = fmt.Fprintf(e.stdout, "%s\t%s\n", entry.Key(), entry.Value())
+			}
+			if err != nil {
+				return err
+			}
+		}
+		count += len(entries)
+		if len(entries) < page || count == *limit {
+			return nil
+		}
+		from = entries[len(entries)-1].Key() + "\x00"
+	}
+}
+
+// check verifies the database, failing if it finds corruption or index errors. Like backup, it follows
+// the database rather than locking it.
+func check(e *env, args []string) error {
+	if _, err := e.parse(e.flags("check"), args, 0, 0); err != nil {
+		return err
+	}
+
+	db, err := e.open(filesystem.Follow)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	report := db.Verify()
+	if err := e.result(report); err != nil {
+		return err
+	}
+	if !report.OK() {
+		return errors.New("the database has errors")
+	}
+	return nil
+}
+
+func compact(e *env, args []string) error {
+	if _, err := e.parse(e.flags("compact"), args, 0, 0); err != nil {
+		return err
+	}
+
+	db, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	report, err := db.Compact()
+	if err != nil {
+		return err
+	}
+	return e.result(report)
+}
+
+// stats writes the database's Stats. Like backup, it follows the database rather than locking it, so
+// the counters, which start from zero when the database is opened, are always zero; a server's counters
+// are available from its -metrics endpoint.
+func stats(e *env, args []string) error {
+	if _, err := e.parse(e.flags("stats"), args, 0, 0); err != nil {
+		return err
+	}
+
+	db, err := e.open(filesystem.Follow)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	stats, err := db.Stats()
+	if err != nil {
+		return err
+	}
+	return e.result(stats)
+}
+
+// debug writes what the database holds for a key, or with -json, its file.Inspection. Like backup, it
+// follows the database rather than locking it.
+func debug(e *env, args []string) error {
+	args, err := e.parse(e.flags("debug"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	db, err := e.open(filesystem.Follow)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	return e.result(db.Inspect(args[0]))
+}
+
+// dump lists the records in the database's log, or in the .dat file given, byte by byte. It reads the
+// file directly, rather than opening the database, so it works on a damaged log and needs no lock.
+func dump(e *env, args []string) error {
+	fs := e.flags("dump")
+	from := fs.Int64("from", 0, "list records beginning at or after this `offset`")
+	to := fs.Int64("to", 0, "list records beginning before this `offset`; 0 means the end of the file")
+	prefix := fs.String("key", "", "list only records whose keys begin with this `prefix`")
+	hex := fs.Bool("hex", false, "show the bytes of regions that cannot be decoded")
+	values := fs.Int("values", file.PreviewLength, "show at most `n` bytes of each value; -1 shows whole values")
+	args, err := e.parse(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	path := e.dbName + ".dat"
+	if len(args) > 0 {
+		path = args[0]
+	}
+	option := []file.DumpOption{file.DumpRange(*from, *to), file.DumpKey(*prefix), file.DumpValueLength(*values)}
+	if *hex {
+		option = append(option, file.DumpHex)
+	}
+	_, err = file.Dump(e.stdout, path, option...)
+	return err
+}
+
+// formatFlags adds the -csv and -jsonl flags to fs, and returns a function that returns the format they
+// name, JSON Lines by default.
+func formatFlags(fs *flag.FlagSet) func() database.Format {
+	csv := fs.Bool("csv", false, "use CSV")
+	fs.Bool("jsonl", false, "use JSON Lines (the default)")
+	return func() database.Format {
+		if *csv {
+			return database.CSV
+		}
+		return database.JSONLines
+	}
+}
+
+// exportEntries writes every live entry to a file, or to standard output. Like backup, it follows the
+// database rather than locking it.
+func exportEntries(e *env, args []string) error {
+	fs := e.flags("export")
+	format := formatFlags(fs)
+	args, err := e.parse(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	db, err := e.open(filesystem.Follow)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	var count int
+	err = e.output(args, func(w io.Writer) (err error) {
+		count, err = db.Export(w, format())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "exported %d entries\n", count)
+	return nil
+}
+
+// importEntries loads entries from a file, or from standard input.
+func importEntries(e *env, args []string) error {
+	fs := e.flags("import")
+	format := formatFlags(fs)
+	args, err := e.parse(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	in, err := e.input(args)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	db, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	report, err := db.Import(in, format())
+	if rerr := e.result(report); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// dumpText writes the database's whole log as text to a file, or to standard output. Like backup, it
+// follows the database rather than locking it.
+func dumpText(e *env, args []string) error {
+	args, err := e.parse(e.flags("dump-text"), args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	db, err := e.open(filesystem.Follow)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	var count int
+	err = e.output(args, func(w io.Writer) (err error) {
+		count, err = db.DumpText(w)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "dumped %d entries\n", count)
+	return nil
+}
+
+// loadText creates the database from a log written by dump-text, read from a file or from standard
+// input. The database must not exist yet.
+func loadText(e *env, args []string) error {
+	args, err := e.parse(e.flags("load-text"), args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	in, err := e.input(args)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	count, err := database.LoadText(in, e.dbName)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "loaded %d entries\n", count)
+	return nil
+}
+
+// output calls fn with the file named by the first of args, or stdout if there isn't one or it is -.
+func (e *env) output(args []string, fn func(w io.Writer) error) error {
+	if len(args) == 0 || args[0] == "-" {
+		return fn(e.stdout)
+	}
+	out, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	err = fn(out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// backup writes a backup of the database to a file. With -since, it writes an incremental backup holding
+// only what was written after the previous backup file was taken. It follows the database rather than
+// locking it, so it can run while another process is writing to the database.
+func backup(e *env, args []string) error {
+	fs := e.flags("backup")
+	compacted := fs.Bool("compact", false, "back up only the live entries")
+	since := fs.String("since", "", "write an incremental backup following the backup in this `file`")
+	args, err := e.parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var option []file.BackupOption
+	switch {
+	case *compacted && *since != "":
+		fs.Usage()
+		return errUsage
+	case *compacted:
+		option = append(option, file.Compacted)
+	case *since != "":
+		prev, err := os.Open(*since)
+		if err != nil {
+			return err
+		}
+		info, err := file.ReadBackupInfo(prev)
+		prev.Close()
+		if err != nil {
+			return err
+		}
+		option = append(option, file.Since(info))
+	}
+
+	db, err := e.open(filesystem.Follow)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	out, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := db.Backup(out, option...)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(args[0])
+		return err
+	}
+	return e.result(info)
+}
+
+// restore creates the database from a base backup file and any incremental backups taken after it. The
+// database must not exist yet.
+func restore(e *env, args []string) error {
+	fs := e.flags("restore")
+	until := fs.String("until", "", "restore up to this `point`, either an offset or an RFC 3339 time")
+	args, err := e.parse(fs, args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	var option []file.RestoreOption
+	if *until != "" {
+		if offset, err := strconv.ParseInt(*until, 10, 64); err == nil {
+			option = append(option, file.UntilOffset(offset))
+		} else if t, err := time.Parse(time.RFC3339, *until); err == nil {
+			option = append(option, file.UntilTime(t))
+		} else {
+			return fmt.Errorf("-until takes an offset or an RFC 3339 time, not %q", *until)
+		}
+	}
+
+	var backups []io.Reader
+	for _, name := range args {
+		in, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		backups = append(backups, in)
+	}
+
+	info, err := database.RestoreChain(e.dbName, backups, option...)
+	if err != nil {
+		return err
+	}
+	return e.result(info)
+}
+
+func repair(e *env, args []string) error {
+	if _, err := e.parse(e.flags("repair"), args, 0, 0); err != nil {
+		return err
+	}
+
+	report, err := database.Repair(e.dbName)
+	if err != nil {
+		return err
+	}
+	return e.result(report)
+}
+
+// interruptContext returns a context that is canceled when the process is interrupted.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sig:
+			signal.Stop(sig)
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(sig)
+		}
+	}()
+	return ctx, cancel
+}
+
+// serveHTTP serves the database over HTTP until the process is interrupted.
+func serveHTTP(e *env, args []string) error {
+	return serve(e, "serve", args, server.Serve, ":8080")
+}
+
+// serveRESP serves the database over the Redis protocol until the process is interrupted.
+func serveRESP(e *env, args []string) error {
+	return serve(e, "resp", args, server.ServeRESP, ":6379")
+}
+
+func serve(e *env, name string, args []string, serveFunc func(context.Context, string, *database.DB) error, addr string) error {
+	fs := e.flags(name)
+	metrics := fs.String("metrics", "", "also serve Prometheus metrics at /metrics on this `address`")
+	args, err := e.parse(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		addr = args[0]
+	}
+
+	db, err := e.open()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	if *metrics != "" {
+		go func() {
+			if err := server.ServeMetrics(ctx, *metrics, db); err != nil {
+				fmt.Fprintln(e.stderr, "db: metrics:", err)
+			}
+		}()
+		fmt.Fprintf(e.stdout, "serving metrics on %s\n", *metrics)
+	}
+	fmt.Fprintf(e.stdout, "serving on %s\n", addr)
+	// The server shuts down the database when it stops.
+	return serveFunc(ctx, addr, db)
+}
+
+// replicate keeps the database up to date with the primary until the process is interrupted.
+func replicate(e *env, args []string) error {
+	args, err := e.parse(e.flags("replica"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	db, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	replica := replication.NewReplica(db, strings.TrimSuffix(args[0], "/")+"/replication")
+	fmt.Fprintf(e.stdout, "replicating from %s\n", args[0])
+	err = replica.Run(ctx)
+	fmt.Fprintf(e.stdout, "stopped with lag of %d bytes\n", replica.Lag())
+	return err
+}
+
+// runREPL runs the interactive prompt. With -readonly, the database is opened read-only.
+func runREPL(e *env, args []string) error {
+	if _, err := e.parse(e.flags("repl"), args, 0, 0); err != nil {
+		return err
+	}
+
+	db, err := e.openForCommands()
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+	return repl.New(db, e.stdin, e.stdout).Run()
+}
+
+// script runs a file of prompt commands, or those read from standard input, stopping at the first that
+// fails. With -readonly, the database is opened read-only.
+func script(e *env, args []string) error {
+	args, err := e.parse(e.flags("script"), args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	in, err := e.input(args)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	db, err := e.openForCommands()
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+	return repl.New(db, in, e.stdout).RunScript()
+}
+
+// openForCommands opens the database for the prompt and scripts, honoring the -readonly flag.
+func (e *env) openForCommands() (*database.DB, error) {
+	if e.readOnly {
+		return e.open(filesystem.ReadOnly)
+	}
+	return e.open()
+}
@@ -0,0 +1,353 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/server"
+)
+
+var (
+	// ErrNotFound is returned when a key does not exist. It is the same error the database returns, so
+	// callers can check for it the same way whether they use a DB directly or through a Client.
+	ErrNotFound = file.ErrNotFound
+
+	// ErrReadOnly is returned when writing to a server whose database is read-only.
+	ErrReadOnly = file.ErrReadOnly
+
+	// ErrTooLarge is returned when a key or value is too long for the database to store, or a request is
+	// too large for the server to accept.
+	ErrTooLarge = file.ErrTooLarge
+
+	// ErrConditionFailed is returned when the server does not make a conditional write because its
+	// condition does not hold.
+	ErrConditionFailed = file.ErrConditionFailed
+
+	// ErrBadRequest is returned when the server rejects a request as malformed.
+	ErrBadRequest = errors.New("bad request")
+
+	// ErrServer is returned when the server fails to handle a request.
+	ErrServer = errors.New("server error")
+
+	// ErrUnsupported is returned for batches the HTTP API cannot express: those with expiring entries,
+	// merge operands or entries in a named bucket.
+	ErrUnsupported = errors.New("not supported by the server")
+)
+
+// An Error is an error response from the server. Errors wrap one of the package's sentinel errors, so
+// they can be tested with errors.Is.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+// Error returns the server's message.
+func (e *Error) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Unwrap returns the sentinel error that corresponds to the server's error code.
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case server.CodeNotFound:
+		return ErrNotFound
+	case server.CodeReadOnly:
+		return ErrReadOnly
+	case server.CodeBadRequest:
+		return ErrBadRequest
+	case server.CodeTooLarge:
+		return ErrTooLarge
+	case server.CodeConflict:
+		return ErrConditionFailed
+	}
+	return ErrServer
+}
+
+const (
+	// DefaultRetries is the number of times an idempotent request is retried by default.
+	DefaultRetries = 3
+
+	// DefaultBackoff is the default delay before the first retry; it doubles with each retry.
+	DefaultBackoff = 50 * time.Millisecond
+
+	// DefaultMaxConns is the default number of idle connections the Client keeps open to the server.
+	DefaultMaxConns = 16
+)
+
+// An Option is an optional setting you may provide to a Client.
+type Option func(*Client)
+
+// Retries is an Option that sets how many times an idempotent request is retried after a network error
+// or server error.
+func Retries(n int) Option {
+	return func(c *Client) {
+		c.retries = n
+	}
+}
+
+// Backoff is an Option that sets the delay before the first retry.
+func Backoff(d time.Duration) Option {
+	return func(c *Client) {
+		c.backoff = d
+	}
+}
+
+// MaxConns is an Option that sets how many idle connections the Client keeps open to the server.
+func MaxConns(n int) Option {
+	return func(c *Client) {
+		c.maxConns = n
+	}
+}
+
+// HTTPClient is an Option that makes the Client send its requests with a given http.Client instead of
+// its own. MaxConns has no effect when it is used.
+func HTTPClient(h *http.Client) Option {
+	return func(c *Client) {
+		c.http = h
+	}
+}
+
+// A Client accesses a database served by the server package over HTTP. It offers the same operations as
+// database.DB, each bounded by a context.Context. A Client is safe for concurrent use, and reuses
+// connections to the server between requests.
+type Client struct {
+	base     *url.URL
+	http     *http.Client
+	retries  int
+	backoff  time.Duration
+	maxConns int
+}
+
+// New creates a Client for the server at baseURL, such as "http://localhost:8080".
+func New(baseURL string, option ...Option) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		base:     base,
+		retries:  DefaultRetries,
+		backoff:  DefaultBackoff,
+		maxConns: DefaultMaxConns,
+	}
+	for _, o := range option {
+		o(c)
+	}
+
+	if c.http == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = c.maxConns
+		transport.MaxIdleConnsPerHost = c.maxConns
+		c.http = &http.Client{Transport: transport}
+	}
+	return c, nil
+}
+
+// Close closes the Client's idle connections.
+func (c *Client) Close() {
+	c.http.CloseIdleConnections()
+}
+
+// Write writes the value to the key.
+func (c *Client) Write(ctx context.Context, key, value string) (file.DBFileEntry, error) {
+	entry := file.NewEntry(key, file.Value(value))
+	_, err := c.do(ctx, true, http.MethodPut, c.keyPath(key), nil, []byte(value))
+	return entry, err
+}
+
+// Read reads a key's entry. If the key does not exist, Read returns ErrNotFound.
+func (c *Client) Read(ctx context.Context, key string) (file.DBFileEntry, error) {
+	value, err := c.do(ctx, true, http.MethodGet, c.keyPath(key), nil, nil)
+	if err != nil {
+		return file.NewEntry(key), err
+	}
+	return file.NewEntry(key, file.Value(string(value))), nil
+}
+
+// Delete deletes the key.
+func (c *Client) Delete(ctx context.Context, key string) (file.DBFileEntry, error) {
+	_, err := c.do(ctx, true, http.MethodDelete, c.keyPath(key), nil, nil)
+	return file.NewEntry(key, file.Deleted), err
+}
+
+// Scan returns up to limit entries with keys in the range [from, to), in key order, fetching as many
+// pages from the server as it takes. An empty to leaves the range unbounded, as does a limit less than
+// one. Use file.PrefixRange to scan the keys with a given prefix.
+func (c *Client) Scan(ctx context.Context, from, to string, limit int) ([]file.DBFileEntry, error) {
+	entries := make([]file.DBFileEntry, 0)
+	query := url.Values{}
+	if from != "" {
+		query.Set("start", from)
+	}
+	if to != "" {
+		query.Set("end", to)
+	}
+
+	for {
+		pageSize := server.MaxLimit
+		if limit > 0 && limit-len(entries) < pageSize {
+			pageSize = limit - len(entries)
+		}
+		query.Set("limit", strconv.Itoa(pageSize))
+
+		body, err := c.do(ctx, true, http.MethodGet, "/keys", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var listing server.Listing
+		if err := json.Unmarshal(body, &listing); err != nil {
+			return nil, err
+		}
+		for _, e := range listing.Entries {
+			entries = append(entries, file.NewEntry(e.Key, file.Value(e.Value)))
+		}
+
+		if listing.Next == "" || (limit > 0 && len(entries) >= limit) {
+			return entries, nil
+		}
+		query.Set("after", listing.Next)
+	}
+}
+
+// Batch applies all of the writes and deletes in a batch together. Because a batch may not be safe to
+// apply twice, Batch is never retried. It returns ErrUnsupported, sending nothing, if the batch has an
+// entry that expires, a merge operand or an entry in a named bucket.
+func (c *Client) Batch(ctx context.Context, b *file.Batch) error {
+	req := server.BatchRequest{Ops: make([]server.Op, 0, b.Len())}
+	for _, e := range b.Entries() {
+		switch {
+		case !e.Expires().IsZero(), e.Operator() != "", e.Bucket() != file.DefaultBucket:
+			return ErrUnsupported
+		case e.Deleted():
+			req.Ops = append(req.Ops, server.Op{Op: "delete", Key: e.Key()})
+		default:
+			req.Ops = append(req.Ops, server.Op{Op: "put", Key: e.Key(), Value: e.Value()})
+		}
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, false, http.MethodPost, "/batch", nil, body)
+	return err
+}
+
+// ReadBatch reads several keys with one request, returning the entries it found and the keys that do
+// not exist.
+func (c *Client) ReadBatch(ctx context.Context, keys ...string) (entries []file.DBFileEntry, missing []string, err error) {
+	body, err := json.Marshal(server.GetRequest{Keys: keys})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err = c.do(ctx, true, http.MethodPost, "/batch/get", nil, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var resp server.GetResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, nil, err
+	}
+	for _, e := range resp.Entries {
+		entries = append(entries, file.NewEntry(e.Key, file.Value(e.Value)))
+	}
+	return entries, resp.Missing, nil
+}
+
+// keyPath returns the escaped path of a key.
+func (c *Client) keyPath(key string) string {
+	return "/keys/" + url.PathEscape(key)
+}
+
+// do sends a request, to the escaped path, and returns the body of a successful response. If idempotent is true, requests
+// that fail with a network error or a 5xx response are retried, backing off between attempts, until
+// they succeed, the retries are used up, or ctx is done.
+func (c *Client) do(ctx context.Context, idempotent bool, method, path string, query url.Values, body []byte) ([]byte, error) {
+	u := *c.base
+	escaped := u.EscapedPath() + path
+	unescaped, err := url.PathUnescape(escaped)
+	if err != nil {
+		return nil, err
+	}
+	u.Path, u.RawPath = unescaped, escaped
+	u.RawQuery = query.Encode()
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		respBody, retry, err := c.attempt(ctx, method, u.String(), body)
+		if err == nil || !retry || !idempotent || attempt >= c.retries {
+			return respBody, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends a request once. It reports whether a failed request is worth retrying.
+func (c *Client) attempt(ctx context.Context, method, url string, body []byte) (respBody []byte, retry bool, err error) {
+	var rdr io.Reader
+	if body != nil {
+		rdr = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, rdr)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, ctx.Err() == nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		e := &Error{StatusCode: resp.StatusCode}
+		var se server.Error
+		if json.Unmarshal(respBody, &se) == nil && se.Code != "" {
+			e.Code, e.Message = se.Code, se.Message
+		} else {
+			e.Code, e.Message = codeFor(resp.StatusCode), http.StatusText(resp.StatusCode)
+		}
+		return nil, resp.StatusCode >= 500, e
+	}
+	return respBody, false, nil
+}
+
+// codeFor returns the error code for a response that did not come with one, such as one from a proxy.
+func codeFor(status int) string {
+	switch {
+	case status == http.StatusNotFound:
+		return server.CodeNotFound
+	case status == http.StatusConflict:
+		return server.CodeConflict
+	case status < 500:
+		return server.CodeBadRequest
+	}
+	return server.CodeInternal
+}
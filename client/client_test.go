@@ -0,0 +1,227 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/client"
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/filesystem"
+	"github.com/matthew-burr/db/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func SetupTestClient(t *testing.T, option ...client.Option) (c *client.Client, db *database.DB, cleanup func()) {
+	db, err := database.Init("client_test")
+	require.NoError(t, err)
+	srv := httptest.NewServer(server.NewHandler(db))
+	c, err = client.New(srv.URL, option...)
+	require.NoError(t, err)
+	return c, db, func() {
+		c.Close()
+		srv.Close()
+		db.Shutdown()
+		os.Remove("client_test.dat")
+		os.Remove("client_test.lock")
+	}
+}
+
+func TestClient_WriteReadDelete(t *testing.T) {
+	c, _, cleanup := SetupTestClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	_, err := c.Write(ctx, "a key/with?odd chars", "value")
+	require.NoError(t, err)
+
+	got, err := c.Read(ctx, "a key/with?odd chars")
+	require.NoError(t, err)
+	assert.Equal(t, "value", got.Value())
+
+	_, err = c.Delete(ctx, "a key/with?odd chars")
+	require.NoError(t, err)
+
+	_, err = c.Read(ctx, "a key/with?odd chars")
+	assert.True(t, errors.Is(err, client.ErrNotFound))
+	var e *client.Error
+	require.True(t, errors.As(err, &e))
+	assert.Equal(t, http.StatusNotFound, e.StatusCode)
+}
+
+func TestClient_KeysWithPathSegments(t *testing.T) {
+	c, db, cleanup := SetupTestClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	keys := []string{"a//b", "a/../b", "..", ".", "/leading", "trailing/", "%2F", "a b"}
+	for _, key := range keys {
+		_, err := c.Write(ctx, key, "value of "+key)
+		require.NoError(t, err, key)
+	}
+	for _, key := range keys {
+		got, err := c.Read(ctx, key)
+		require.NoError(t, err, key)
+		assert.Equal(t, "value of "+key, got.Value())
+		assert.Equal(t, "value of "+key, db.Read(key).Value(), "the key is stored as given")
+	}
+}
+
+func TestClient_ScanFollowsPages(t *testing.T) {
+	c, db, cleanup := SetupTestClient(t)
+	defer cleanup()
+
+	b := file.NewBatch()
+	for i := 0; i < server.MaxLimit+10; i++ {
+		b.Write(string(rune('a'+i%26))+time.Duration(i).String(), "v")
+	}
+	require.NoError(t, db.WriteBatch(b))
+
+	got, err := c.Scan(context.Background(), "", "", 0)
+	require.NoError(t, err)
+	assert.Len(t, got, server.MaxLimit+10)
+
+	got, err = c.Scan(context.Background(), "", "", 5)
+	require.NoError(t, err)
+	assert.Len(t, got, 5)
+
+	from, to := file.PrefixRange("b")
+	got, err = c.Scan(context.Background(), from, to, 0)
+	require.NoError(t, err)
+	for _, e := range got {
+		assert.Equal(t, byte('b'), e.Key()[0])
+	}
+}
+
+func TestClient_BatchAndReadBatch(t *testing.T) {
+	c, _, cleanup := SetupTestClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	require.NoError(t, c.Batch(ctx, file.NewBatch().Write("a", "1").Write("b", "2").Delete("a")))
+
+	entries, missing, err := c.ReadBatch(ctx, "a", "b")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "2", entries[0].Value())
+	assert.Equal(t, []string{"a"}, missing)
+
+	for _, e := range []file.DBFileEntry{
+		file.NewEntry("c", file.TTL(time.Hour)),
+		file.NewEntry("c", file.Value("1"), file.MergeOperand(file.Counter.Name())),
+		file.NewEntry("c", file.Value("1"), file.InBucket(2)),
+	} {
+		err = c.Batch(ctx, file.NewBatch().Write("d", "4").Add(e))
+		assert.Equal(t, client.ErrUnsupported, err)
+	}
+	_, err = c.Read(ctx, "d")
+	assert.True(t, errors.Is(err, client.ErrNotFound), "nothing in an unsupported batch is written")
+}
+
+func TestClient_ReadOnlyError(t *testing.T) {
+	db, err := database.Init("client_test")
+	require.NoError(t, err)
+	db.Shutdown()
+	defer os.Remove("client_test.dat")
+	defer os.Remove("client_test.lock")
+
+	db, err = database.Init("client_test", filesystem.ReadOnly)
+	require.NoError(t, err)
+	defer db.Shutdown()
+	srv := httptest.NewServer(server.NewHandler(db))
+	defer srv.Close()
+
+	c, err := client.New(srv.URL)
+	require.NoError(t, err)
+	_, err = c.Write(context.Background(), "k", "v")
+	assert.True(t, errors.Is(err, client.ErrReadOnly))
+}
+
+func FlakyServer(failures int32, status int) (srv *httptest.Server, calls *int32) {
+	calls = new(int32)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(calls, 1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("value"))
+	}))
+	return srv, calls
+}
+
+func TestClient_RetriesIdempotentRequests(t *testing.T) {
+	srv, calls := FlakyServer(2, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	c, err := client.New(srv.URL, client.Backoff(time.Millisecond))
+	require.NoError(t, err)
+	got, err := c.Read(context.Background(), "k")
+	require.NoError(t, err)
+	assert.Equal(t, "value", got.Value())
+	assert.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestClient_GivesUpAfterRetries(t *testing.T) {
+	srv, calls := FlakyServer(10, http.StatusBadGateway)
+	defer srv.Close()
+
+	c, err := client.New(srv.URL, client.Retries(2), client.Backoff(time.Millisecond))
+	require.NoError(t, err)
+	_, err = c.Read(context.Background(), "k")
+	assert.True(t, errors.Is(err, client.ErrServer))
+	assert.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestClient_DoesNotRetryBatches(t *testing.T) {
+	srv, calls := FlakyServer(1, http.StatusInternalServerError)
+	defer srv.Close()
+
+	c, err := client.New(srv.URL, client.Backoff(time.Millisecond))
+	require.NoError(t, err)
+	err = c.Batch(context.Background(), file.NewBatch().Write("a", "1"))
+	assert.True(t, errors.Is(err, client.ErrServer))
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
+
+func TestClient_DoesNotRetryClientErrors(t *testing.T) {
+	srv, calls := FlakyServer(1, http.StatusBadRequest)
+	defer srv.Close()
+
+	c, err := client.New(srv.URL, client.Backoff(time.Millisecond))
+	require.NoError(t, err)
+	_, err = c.Read(context.Background(), "k")
+	assert.True(t, errors.Is(err, client.ErrBadRequest))
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
+
+func TestClient_HonorsContextTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c, err := client.New(srv.URL)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = c.Read(ctx, "k")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestClient_ConflictIsConditionFailed(t *testing.T) {
+	srv, _ := FlakyServer(1, http.StatusConflict)
+	defer srv.Close()
+
+	c, err := client.New(srv.URL)
+	require.NoError(t, err)
+	_, err = c.Write(context.Background(), "k", "v")
+	assert.True(t, errors.Is(err, client.ErrConditionFailed))
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+)
+
+// A Namespace is a handle on one of the database's buckets, whose keys are independent of those in the
+// database's other buckets. Keys written with DB's own methods are in the default bucket, whose name is
+// empty. Get a Namespace with DB.Bucket.
+type Namespace struct {
+	db      *DB
+	bucket  *file.Bucket
+	metrics *bucketMetrics
+}
+
+// bucketMetrics holds the counters a DB keeps for one bucket.
+type bucketMetrics struct {
+	reads, scans, writes, deletes uint64
+}
+
+// BucketStats holds counters and gauges describing one of a DB's buckets. Like those in Stats, the
+// counters start from zero when the DB is opened.
+type BucketStats struct {
+	Name      string `json:"name"`
+	Reads     uint64 `json:"reads"`
+	Scans     uint64 `json:"scans"`
+	Writes    uint64 `json:"writes"`
+	Deletes   uint64 `json:"deletes"`
+	LiveKeys  int    `json:"live_keys"`
+	LiveBytes int64  `json:"live_bytes"`
+}
+
+// Bucket returns the bucket with the given name, creating it if it does not exist. Each bucket has its
+// own index, so scanning one does not touch the keys of the others. It returns file.ErrNoBucket if the
+// bucket does not exist and the database was opened read-only.
+func (d *DB) Bucket(name string) (*Namespace, error) {
+	b, err := d.DBFile.Bucket(name)
+	if err != nil {
+		d.metrics.failed(err)
+		return nil, err
+	}
+	return &Namespace{db: d, bucket: b, metrics: d.metrics.bucket(name)}, nil
+}
+
+// DropBucket deletes a bucket and every key in it. It writes a single entry, however many keys the
+// bucket holds; their space is reclaimed when the database is next compacted.
+func (d *DB) DropBucket(name string) error {
+	err := d.DBFile.DropBucket(name)
+	if err == nil {
+		d.metrics.dropBucket(name)
+	}
+	d.metrics.failed(err)
+	return err
+}
+
+// Buckets returns the names of the database's named buckets, in sorted order.
+func (d *DB) Buckets() []string {
+	buckets := d.DBFile.Buckets()
+	names := make([]string, len(buckets))
+	for i, b := range buckets {
+		names[i] = b.Name()
+	}
+	return names
+}
+
+// Name returns the bucket's name.
+func (n *Namespace) Name() string {
+	return n.bucket.Name()
+}
+
+// Write writes the value to the key in the bucket. Options such as file.TTL may be given to set other
+// properties of the entry. It returns file.ErrNoBucket if the bucket has been dropped.
+func (n *Namespace) Write(key, value string, option ...file.EntryOption) (file.DBFileEntry, error) {
+	defer n.db.metrics.writeLatency.observe(time.Now())
+	entry, err := n.bucket.WriteEntry(file.NewEntry(key, append([]file.EntryOption{file.Value(value)}, option...)...))
+	if err == nil {
+		count(&n.db.metrics.writes, 1)
+		count(&n.metrics.writes, 1)
+	}
+	n.db.metrics.failed(err)
+	return entry, err
+}
+
+// Get reads a key's entry from the bucket, returning file.ErrNotFound if the key does not exist.
+func (n *Namespace) Get(key string) (file.DBFileEntry, error) {
+	defer n.db.metrics.readLatency.observe(time.Now())
+	entry, err := n.bucket.Get(key)
+	count(&n.db.metrics.reads, 1)
+	count(&n.metrics.reads, 1)
+	n.db.metrics.failed(err)
+	return entry, err
+}
+
+// Delete removes a key from the bucket.
+func (n *Namespace) Delete(key string) (file.DBFileEntry, error) {
+	defer n.db.metrics.writeLatency.observe(time.Now())
+	entry, err := n.bucket.DeleteEntry(key)
+	if err == nil {
+		count(&n.db.metrics.deletes, 1)
+		count(&n.metrics.deletes, 1)
+	}
+	n.db.metrics.failed(err)
+	return entry, err
+}
+
+// Scan returns up to limit entries from the bucket with keys in the range [from, to), in key order, as
+// DB.Scan does for the default bucket.
+func (n *Namespace) Scan(from, to string, limit int) ([]file.DBFileEntry, error) {
+	defer n.db.metrics.readLatency.observe(time.Now())
+	entries, err := n.bucket.Scan(from, to, limit)
+	count(&n.db.metrics.scans, 1)
+	count(&n.metrics.scans, 1)
+	n.db.metrics.failed(err)
+	return entries, err
+}
+
+// InBucket returns an EntryOption that puts an entry in the bucket, for adding entries to a file.Batch.
+func (n *Namespace) InBucket() file.EntryOption {
+	return file.InBucket(n.bucket.ID())
+}
+
+// Stats returns the bucket's counters and gauges.
+func (n *Namespace) Stats() (*BucketStats, error) {
+	u, err := n.bucket.Usage()
+	if err != nil {
+		return nil, err
+	}
+	m := n.metrics
+	return &BucketStats{
+		Name:      n.bucket.Name(),
+		Reads:     atomic.LoadUint64(&m.reads),
+		Scans:     atomic.LoadUint64(&m.scans),
+		Writes:    atomic.LoadUint64(&m.writes),
+		Deletes:   atomic.LoadUint64(&m.deletes),
+		LiveKeys:  u.Keys,
+		LiveBytes: u.LiveBytes,
+	}, nil
+}
@@ -0,0 +1,81 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucket_ScopesKeys(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	users, err := db.Bucket("users")
+	require.NoError(t, err)
+	db.Write("alice", "default")
+	users.Write("alice", "1")
+	users.Write("bob", "2")
+	users.Delete("bob")
+
+	entry, err := users.Get("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "1", entry.Value())
+	assert.Equal(t, "default", db.Read("alice").Value())
+	entries, err := users.Scan("", "", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "alice", entries[0].Key())
+
+	require.NoError(t, db.WriteBatch(file.NewBatch().Add(file.NewEntry("carol", file.Value("3"), users.InBucket()))))
+	_, err = users.Get("carol")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"users"}, db.Buckets())
+}
+
+func TestBucket_Stats(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	users, _ := db.Bucket("users")
+	users.Write("a", "1")
+	users.Write("a", "2")
+	users.Get("a")
+	db.Write("b", "1")
+
+	bs, err := users.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, "users", bs.Name)
+	assert.Equal(t, uint64(2), bs.Writes)
+	assert.Equal(t, uint64(1), bs.Reads)
+	assert.Equal(t, 1, bs.LiveKeys)
+
+	s, err := db.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), s.Writes)
+	assert.Equal(t, 2, s.LiveKeys)
+	require.Len(t, s.Buckets, 1)
+	assert.Equal(t, *bs, s.Buckets[0])
+	assert.Contains(t, s.String(), `bucket "users": 1 keys`)
+}
+
+func TestDropBucket_RemovesKeys(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	users, _ := db.Bucket("users")
+	users.Write("a", "1")
+	require.NoError(t, db.DropBucket("users"))
+
+	_, err := users.Get("a")
+	assert.Equal(t, file.ErrNoBucket, err)
+	assert.Empty(t, db.Buckets())
+
+	users, _ = db.Bucket("users")
+	_, err = users.Get("a")
+	assert.Equal(t, file.ErrNotFound, err)
+	bs, err := users.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), bs.Writes)
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"time"
+
+	"github.com/matthew-burr/db/file"
+)
+
+// CompareAndSwap writes new to the key if its current value is old, returning whether it did. The
+// comparison and the write are atomic: if several callers swap the same key from the same old value at
+// once, exactly one succeeds. A key that does not exist has no value, so it never matches.
+// It returns filesystem.ErrReadOnly if the database was opened read-only.
+func (d *DB) CompareAndSwap(key, old, new string, option ...file.EntryOption) (bool, error) {
+	return d.writeIf(file.NewEntry(key, append([]file.EntryOption{file.Value(new)}, option...)...), file.IfValue(old))
+}
+
+// WriteIfAbsent writes the value to the key if the key does not exist, or has expired, returning whether
+// it did. Like CompareAndSwap, it is atomic.
+// It returns filesystem.ErrReadOnly if the database was opened read-only.
+func (d *DB) WriteIfAbsent(key, value string, option ...file.EntryOption) (bool, error) {
+	return d.writeIf(file.NewEntry(key, append([]file.EntryOption{file.Value(value)}, option...)...), file.IfAbsent)
+}
+
+// DeleteIf deletes the key if its current value is expected, returning whether it did. Like
+// CompareAndSwap, it is atomic.
+// It returns filesystem.ErrReadOnly if the database was opened read-only.
+func (d *DB) DeleteIf(key, expected string) (bool, error) {
+	return d.writeIf(file.NewEntry(key, file.Deleted), file.IfValue(expected))
+}
+
+// writeIf writes an entry if cond holds, returning false rather than file.ErrConditionFailed if it does
+// not.
+func (d *DB) writeIf(entry file.DBFileEntry, cond file.Condition) (bool, error) {
+	defer d.metrics.writeLatency.observe(time.Now())
+	_, err := d.DBFile.WriteIf(entry, cond)
+	if err == file.ErrConditionFailed {
+		return false, nil
+	}
+	if err == nil {
+		if entry.Deleted() {
+			count(&d.metrics.deletes, 1)
+		} else {
+			count(&d.metrics.writes, 1)
+		}
+	}
+	d.metrics.failed(err)
+	return err == nil, err
+}
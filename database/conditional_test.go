@@ -0,0 +1,77 @@
+package database_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	swapped, err := db.CompareAndSwap("a", "", "1")
+	require.NoError(t, err)
+	assert.False(t, swapped, "a missing key has no value to compare")
+
+	db.Write("a", "1")
+	swapped, err = db.CompareAndSwap("a", "1", "2")
+	require.NoError(t, err)
+	assert.True(t, swapped)
+	swapped, _ = db.CompareAndSwap("a", "1", "3")
+	assert.False(t, swapped)
+	assert.Equal(t, "2", db.Read("a").Value())
+}
+
+func TestWriteIfAbsentAndDeleteIf(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	written, err := db.WriteIfAbsent("lock", "alice")
+	require.NoError(t, err)
+	assert.True(t, written)
+	written, _ = db.WriteIfAbsent("lock", "bob")
+	assert.False(t, written)
+
+	deleted, _ := db.DeleteIf("lock", "bob")
+	assert.False(t, deleted)
+	deleted, err = db.DeleteIf("lock", "alice")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	_, err = db.Get("lock")
+	assert.Error(t, err)
+
+	s, _ := db.Stats()
+	assert.Equal(t, uint64(1), s.Writes)
+	assert.Equal(t, uint64(1), s.Deletes)
+}
+
+func TestCompareAndSwap_IsAtomic(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	// Each worker increments the counter by swapping in the next value, retrying until its swap wins,
+	// so no increment is lost.
+	db.Write("counter", "0")
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				for {
+					current, _ := db.Get("counter")
+					n, _ := strconv.Atoi(current.Value())
+					if swapped, _ := db.CompareAndSwap("counter", current.Value(), strconv.Itoa(n+1)); swapped {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, "200", db.Read("counter").Value())
+}
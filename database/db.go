@@ -1,7 +1,9 @@
 package database
 
 import (
-	"os"
+	"context"
+	"io"
+	"time"
 
 	"github.com/matthew-burr/db/file"
 	"github.com/matthew-burr/db/filesystem"
@@ -10,31 +12,192 @@ import (
 // A DB is a simple key, value database.
 type DB struct {
 	DBFile *filesystem.DBFileSystem
+
+	metrics *metrics
 }
 
 // Init initializes the database from a file. Once initialized, you can start querying the database.
-func Init(dbName string) *DB {
-	fs := filesystem.Init(dbName)
-	return &DB{
-		DBFile: fs,
+// Init locks the database for the life of the DB; if another process already has it open, Init returns
+// filesystem.ErrLocked. Pass filesystem.ReadOnly to share the database with other readers instead, or
+// filesystem.Follow to read a database while another process writes to it.
+func Init(dbName string, option ...filesystem.Option) (*DB, error) {
+	fs, err := filesystem.Init(dbName, option...)
+	if err != nil {
+		return nil, err
 	}
+	return &DB{
+		DBFile:  fs,
+		metrics: newMetrics(),
+	}, nil
+}
+
+// Repair salvages a damaged database that is not currently open, recovering every entry that can still
+// be decoded. See filesystem.Repair.
+func Repair(dbName string) (*file.RepairReport, error) {
+	return filesystem.Repair(dbName)
+}
+
+// Restore creates a database from a backup written by Backup. The database must not already exist. See
+// filesystem.Restore.
+func Restore(r io.Reader, dbName string) (*file.BackupInfo, error) {
+	return filesystem.Restore(r, dbName)
+}
+
+// RestoreChain creates a database from a base backup followed by the incremental backups taken since,
+// in order. Pass file.UntilTime or file.UntilOffset to recover the database as it was at an earlier
+// point. See filesystem.RestoreChain.
+func RestoreChain(dbName string, backups []io.Reader, option ...file.RestoreOption) (*file.BackupInfo, error) {
+	return filesystem.RestoreChain(dbName, backups, option...)
+}
+
+// LoadText creates a database from its log in the text format, as written by DumpText. The database must
+// not already exist. See filesystem.LoadText.
+func LoadText(r io.Reader, dbName string) (int, error) {
+	return filesystem.LoadText(r, dbName)
 }
 
-// Write adds or updates a database entry by writing the value to the key.
-func (d *DB) Write(key, value string) file.DBFileEntry {
-	return d.DBFile.WriteEntry(file.NewEntry(key, file.Value(value)))
+// Write adds or updates a database entry by writing the value to the key. Options such as file.TTL may
+// be given to set other properties of the entry.
+// It returns filesystem.ErrReadOnly if the database was opened read-only.
+func (d *DB) Write(key, value string, option ...file.EntryOption) (file.DBFileEntry, error) {
+	defer d.metrics.writeLatency.observe(time.Now())
+	entry, err := d.DBFile.WriteEntry(file.NewEntry(key, append([]file.EntryOption{file.Value(value)}, option...)...))
+	if err == nil {
+		count(&d.metrics.writes, 1)
+	}
+	d.metrics.failed(err)
+	return entry, err
 }
 
 // Read reads a key's value into a string.
 // To facilitate a pattern of repeated reads, Read accepts a pointer to a string where it will
 // write the value, and then returns the DB.
 func (d *DB) Read(key string) file.DBFileEntry {
-	return d.DBFile.ReadEntry(key)
+	defer d.metrics.readLatency.observe(time.Now())
+	entry := d.DBFile.ReadEntry(key)
+	count(&d.metrics.reads, 1)
+	return entry
+}
+
+// Get reads a key's entry from the database. Unlike Read, it returns file.ErrNotFound if the key does
+// not exist.
+func (d *DB) Get(key string) (file.DBFileEntry, error) {
+	defer d.metrics.readLatency.observe(time.Now())
+	entry, err := d.DBFile.GetEntry(key)
+	count(&d.metrics.reads, 1)
+	d.metrics.failed(err)
+	return entry, err
+}
+
+// Scan returns up to limit entries with keys in the range [from, to), in key order. An empty to leaves
+// the range unbounded, as does a limit less than one. Use file.PrefixRange to scan the keys with a
+// given prefix.
+func (d *DB) Scan(from, to string, limit int) ([]file.DBFileEntry, error) {
+	defer d.metrics.readLatency.observe(time.Now())
+	entries, err := d.DBFile.Scan(from, to, limit)
+	count(&d.metrics.scans, 1)
+	d.metrics.failed(err)
+	return entries, err
+}
+
+// Len returns the number of keys in the database, without reading their values. It includes any that
+// have expired but have not yet been removed.
+func (d *DB) Len() int {
+	return d.DBFile.Len()
+}
+
+// Keys returns up to limit keys in the range [from, to), in key order, skipping any that have expired,
+// without reading their values. An empty to leaves the range unbounded, as does a limit less than one.
+func (d *DB) Keys(from, to string, limit int) []string {
+	defer d.metrics.readLatency.observe(time.Now())
+	keys := d.DBFile.Keys(from, to, limit)
+	count(&d.metrics.scans, 1)
+	return keys
+}
+
+// KeysAt returns up to n keys from the one at position in key order, and the position that follows them,
+// or 0 after the last key. See file.DBFile.KeysAt.
+func (d *DB) KeysAt(position, n int) ([]string, int) {
+	defer d.metrics.readLatency.observe(time.Now())
+	keys, next := d.DBFile.KeysAt(position, n)
+	count(&d.metrics.scans, 1)
+	return keys, next
+}
+
+// WriteBatch applies all of the writes and deletes in a batch together.
+// It returns filesystem.ErrReadOnly if the database was opened read-only.
+func (d *DB) WriteBatch(b *file.Batch) error {
+	defer d.metrics.writeLatency.observe(time.Now())
+	err := d.DBFile.WriteBatch(b)
+	if err == nil {
+		for _, entry := range b.Entries() {
+			if entry.Deleted() {
+				count(&d.metrics.deletes, 1)
+			} else {
+				count(&d.metrics.writes, 1)
+			}
+		}
+	}
+	d.metrics.failed(err)
+	return err
 }
 
 // Delete removes an entry from the database.
-func (d *DB) Delete(key string) file.DBFileEntry {
-	return d.DBFile.DeleteEntry(key)
+// It returns filesystem.ErrReadOnly if the database was opened read-only.
+func (d *DB) Delete(key string) (file.DBFileEntry, error) {
+	defer d.metrics.writeLatency.observe(time.Now())
+	entry, err := d.DBFile.DeleteEntry(key)
+	if err == nil {
+		count(&d.metrics.deletes, 1)
+	}
+	d.metrics.failed(err)
+	return entry, err
+}
+
+// Refresh brings a read-only database up to date with entries written by another process since it was
+// opened or last refreshed. A database opened with filesystem.Follow refreshes itself before every read.
+func (d *DB) Refresh() {
+	d.DBFile.Refresh()
+}
+
+// Watch returns a Watcher that delivers an event for every change made to keys with the prefix from now
+// on. Each event is delivered after its change has been written to the file. Pass "" to watch every key.
+func (d *DB) Watch(ctx context.Context, prefix string) *file.Watcher {
+	return d.DBFile.Watch(ctx, prefix, d.DBFile.File.CurrentOffset())
+}
+
+// WatchFrom is like Watch, but first replays the changes recorded in the file from offset onwards. Pass
+// the Next offset of the last event a previous Watcher delivered to resume where it left off, or 0 to
+// replay the whole history.
+func (d *DB) WatchFrom(ctx context.Context, prefix string, offset int64) *file.Watcher {
+	return d.DBFile.Watch(ctx, prefix, offset)
+}
+
+// Backup writes a consistent, checksummed copy of the database to w while it remains open for reads and
+// writes. Pass file.Compacted to copy only the live entries, or file.Since to copy only what has been
+// written since an earlier backup.
+func (d *DB) Backup(w io.Writer, option ...file.BackupOption) (*file.BackupInfo, error) {
+	return d.DBFile.Backup(w, option...)
+}
+
+// DumpText writes the database's whole log, including overwritten and deleted entries, to w as text, one
+// entry per line, and returns how many entries it wrote. The text can be read, diffed and edited, and
+// LoadText rebuilds a database from it.
+func (d *DB) DumpText(w io.Writer) (int, error) {
+	return d.DBFile.WriteText(w)
+}
+
+// Compact reclaims the space taken by overwritten, deleted and expired entries by rewriting the
+// database's log. Reads and writes wait only while the last few entries are copied and the new log is
+// swapped in. It returns filesystem.ErrReadOnly if the database was opened read-only.
+func (d *DB) Compact() (*file.CompactReport, error) {
+	start := time.Now()
+	report, err := d.DBFile.Compact()
+	if err == nil {
+		d.metrics.compaction.observe(start)
+	}
+	d.metrics.failed(err)
+	return report, err
 }
 
 // Shutdown closes the database and should always be executed before quitting the program.
@@ -42,8 +205,20 @@ func (d *DB) Shutdown() {
 	d.DBFile.Close()
 }
 
-// Debug provides some basic ability to check the validity of the database structure. Given a key, it will
-// determine the offset for that key, insure it's a valid offset, and return what data it finds at that offset.
-func (d *DB) Debug(key string) {
-	d.DBFile.Debug(os.Stdout, key)
+// Verify walks the whole database, checking that every entry decodes and that the index agrees with the
+// file, and reports what it finds.
+func (d *DB) Verify() *file.Report {
+	return d.DBFile.Verify()
+}
+
+// Debug writes what the database holds for a key, as found by Inspect, to w as text.
+func (d *DB) Debug(w io.Writer, key string) {
+	d.DBFile.Debug(w, key)
+}
+
+// Inspect reports every entry for a key in the database's log, and whether the index points at the
+// latest of them. It walks the whole log, so it is meant for investigating problems rather than for
+// reading keys.
+func (d *DB) Inspect(key string) *file.Inspection {
+	return d.DBFile.Inspect(key)
 }
@@ -1,19 +1,27 @@
 package database_test
 
 import (
+	"bytes"
+	"context"
 	"os"
 	"testing"
 
 	"github.com/matthew-burr/db/database"
 	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/filesystem"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func SetupDBForTests() (db *database.DB, cleanup func()) {
-	db = database.Init("db_test")
+	db, err := database.Init("db_test")
+	if err != nil {
+		panic(err)
+	}
 	cleanup = func() {
 		db.Shutdown()
 		os.Remove("db_test.dat")
+		os.Remove("db_test.lock")
 	}
 	return
 }
@@ -51,7 +59,8 @@ func TestWrite_ReturnsDBEntry(t *testing.T) {
 	db, c := SetupDBForTests()
 	defer c()
 
-	entry := db.Write("hello", "again")
+	entry, err := db.Write("hello", "again")
+	require.NoError(t, err)
 	assert.Equal(t, "hello", entry.Key())
 	assert.Equal(t, "again", entry.Value())
 
@@ -71,7 +80,124 @@ func TestDelete_ReturnsDeletedEntry(t *testing.T) {
 	defer c()
 
 	db.Write("delete", "test")
-	got := db.Delete("delete")
+	got, err := db.Delete("delete")
+	require.NoError(t, err)
 	want := file.NewEntry("delete", file.Deleted)
 	AssertEqualEntry(t, want, got)
 }
+
+func TestInit_ReturnsErrLockedWhenAlreadyOpen(t *testing.T) {
+	_, c := SetupDBForTests()
+	defer c()
+
+	_, err := database.Init("db_test")
+	assert.Equal(t, filesystem.ErrLocked, err)
+}
+
+func TestInit_ReadOnlyRejectsWrites(t *testing.T) {
+	db, c := SetupDBForTests()
+	db.Write("read", "only")
+	db.Shutdown()
+	defer c()
+
+	db, err := database.Init("db_test", filesystem.ReadOnly)
+	require.NoError(t, err)
+	defer db.Shutdown()
+
+	assert.Equal(t, "only", db.Read("read").Value())
+
+	_, err = db.Write("read", "write")
+	assert.Equal(t, filesystem.ErrReadOnly, err)
+
+	_, err = db.Delete("read")
+	assert.Equal(t, filesystem.ErrReadOnly, err)
+}
+
+func TestGet_ReturnsErrNotFound(t *testing.T) {
+	db, c := SetupDBForTests()
+	defer c()
+
+	_, err := db.Get("missing")
+	assert.Equal(t, file.ErrNotFound, err)
+}
+
+func TestScan_ReturnsPrefixedEntries(t *testing.T) {
+	db, c := SetupDBForTests()
+	defer c()
+	db.WriteBatch(file.NewBatch().Write("user:1", "a").Write("user:2", "b").Write("group:1", "c"))
+
+	from, to := file.PrefixRange("user:")
+	got, err := db.Scan(from, to, 0)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, "user:1", got[0].Key())
+	assert.Equal(t, "user:2", got[1].Key())
+}
+
+func TestWatch_DeliversChangesAfterCommit(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+	db.Write("before", "0")
+
+	w := db.Watch(context.Background(), "")
+	defer w.Stop()
+	db.Write("k", "v")
+
+	e := <-w.Events()
+	assert.Equal(t, "k", e.Key)
+	assert.Equal(t, "v", db.Read(e.Key).Value())
+
+	replay := db.WatchFrom(context.Background(), "", 0)
+	defer replay.Stop()
+	assert.Equal(t, "before", (<-replay.Events()).Key)
+	assert.Equal(t, "k", (<-replay.Events()).Key)
+}
+
+func TestBackup_RestoresIntoNewDatabase(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+	db.Write("hello", "world")
+
+	buf := new(bytes.Buffer)
+	_, err := db.Backup(buf)
+	require.NoError(t, err)
+
+	defer os.Remove("db_restore_test.dat")
+	defer os.Remove("db_restore_test.lock")
+	_, err = database.Restore(bytes.NewReader(buf.Bytes()), "db_restore_test")
+	require.NoError(t, err)
+
+	restored, err := database.Init("db_restore_test")
+	require.NoError(t, err)
+	defer restored.Shutdown()
+	assert.Equal(t, "world", restored.Read("hello").Value())
+
+	_, err = database.Restore(bytes.NewReader(buf.Bytes()), "db_restore_test")
+	assert.Error(t, err)
+}
+
+func TestDumpText_LoadTextRebuildsDatabase(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+	db.Write("a", "1")
+	db.Write("b", "2")
+	db.Delete("a")
+
+	buf := new(bytes.Buffer)
+	n, err := db.DumpText(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "a:1\nb:2\na\n", buf.String())
+
+	defer os.Remove("db_text_test.dat")
+	defer os.Remove("db_text_test.lock")
+	_, err = database.LoadText(buf, "db_text_test")
+	require.NoError(t, err)
+
+	loaded, err := database.Init("db_text_test")
+	require.NoError(t, err)
+	defer loaded.Shutdown()
+	_, err = loaded.Get("a")
+	assert.Equal(t, file.ErrNotFound, err)
+	assert.Equal(t, "2", loaded.Read("b").Value())
+}
@@ -0,0 +1,355 @@
+package database
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/matthew-burr/db/file"
+)
+
+// A Format is a text format in which entries can be exported and imported.
+type Format int
+
+const (
+	// JSONLines holds one JSON Record per line.
+	JSONLines Format = iota
+
+	// CSV holds one Record per row, under a header row naming the columns key, value, encoding and
+	// expires.
+	CSV
+)
+
+// ParseFormat returns the Format with the given name, either "jsonl" or "csv".
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "jsonl", "json":
+		return JSONLines, nil
+	case "csv":
+		return CSV, nil
+	}
+	return 0, fmt.Errorf("unknown format %q", name)
+}
+
+// Base64 is the Encoding of a Record whose key and value are base64 encoded. Records are encoded this way
+// when their key or value is not valid UTF-8, as binary data may not be, or cannot otherwise be
+// represented exactly in the format.
+const Base64 = "base64"
+
+// A Record is the exported form of an entry.
+type Record struct {
+	Key      string     `json:"key"`
+	Value    string     `json:"value"`
+	Encoding string     `json:"encoding,omitempty"` // Either "" or Base64.
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+var csvHeader = []string{"key", "value", "encoding", "expires"}
+
+// recordFrom returns the Record for an entry that is to be exported in the given format.
+func recordFrom(entry file.DBFileEntry, format Format) Record {
+	r := Record{Key: entry.Key(), Value: entry.Value()}
+	binary := !utf8.ValidString(r.Key) || !utf8.ValidString(r.Value)
+	// A CSV reader turns carriage returns within a field into newlines, so they would not survive.
+	if format == CSV && strings.ContainsRune(r.Key+r.Value, '\r') {
+		binary = true
+	}
+	if binary {
+		r.Key = base64.StdEncoding.EncodeToString([]byte(r.Key))
+		r.Value = base64.StdEncoding.EncodeToString([]byte(r.Value))
+		r.Encoding = Base64
+	}
+	if expires := entry.Expires(); !expires.IsZero() {
+		r.Expires = &expires
+	}
+	return r
+}
+
+// NewRecord returns the Record for an entry, as Export writes it in JSON Lines.
+func NewRecord(entry file.DBFileEntry) Record {
+	return recordFrom(entry, JSONLines)
+}
+
+// Entry returns the entry the Record describes. It returns an error wrapping file.ErrTooLarge if the key
+// or value is longer than file.MaxLength.
+func (r Record) Entry() (file.DBFileEntry, error) {
+	key, value := r.Key, r.Value
+	switch r.Encoding {
+	case "":
+	case Base64:
+		k, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return file.DBFileEntry{}, fmt.Errorf("key: %v", err)
+		}
+		v, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return file.DBFileEntry{}, fmt.Errorf("value: %v", err)
+		}
+		key, value = string(k), string(v)
+	default:
+		return file.DBFileEntry{}, fmt.Errorf("unknown encoding %q", r.Encoding)
+	}
+	if key == "" {
+		return file.DBFileEntry{}, errors.New("missing key")
+	}
+	if len(key) > file.MaxLength {
+		return file.DBFileEntry{}, fmt.Errorf("key: %w", file.ErrTooLarge)
+	}
+	if len(value) > file.MaxLength {
+		return file.DBFileEntry{}, fmt.Errorf("value: %w", file.ErrTooLarge)
+	}
+
+	option := []file.EntryOption{file.Value(value)}
+	if r.Expires != nil {
+		option = append(option, file.Expires(*r.Expires))
+	}
+	return file.NewEntry(key, option...), nil
+}
+
+// exportPage is how many entries Export reads from the index at a time.
+const exportPage = 1000
+
+// Export writes every live entry to w in the given format, in key order, and returns how many it wrote.
+// Entries are read a page at a time, so the database stays available while a large export runs; an
+// entry written during the export may or may not be included.
+func (d *DB) Export(w io.Writer, format Format) (int, error) {
+	bw := bufio.NewWriterSize(w, file.BufferSize)
+	var (
+		write func(Record) error
+		flush func() error
+	)
+	switch format {
+	case JSONLines:
+		enc := json.NewEncoder(bw)
+		write, flush = func(r Record) error { return enc.Encode(r) }, bw.Flush
+	case CSV:
+		cw := csv.NewWriter(bw)
+		if err := cw.Write(csvHeader); err != nil {
+			return 0, err
+		}
+		write = func(r Record) error {
+			expires := ""
+			if r.Expires != nil {
+				expires = r.Expires.Format(time.RFC3339Nano)
+			}
+			return cw.Write([]string{r.Key, r.Value, r.Encoding, expires})
+		}
+		flush = func() error {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+	default:
+		return 0, fmt.Errorf("unknown format %d", format)
+	}
+
+	count, from := 0, ""
+	for {
+		entries, err := d.Scan(from, "", exportPage)
+		if err != nil {
+			return count, err
+		}
+		for _, entry := range entries {
+			if err := write(recordFrom(entry, format)); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if len(entries) < exportPage {
+			return count, flush()
+		}
+		from = entries[len(entries)-1].Key() + "\x00"
+	}
+}
+
+// DefaultImportBatch is the number of entries Import writes together by default.
+const DefaultImportBatch = 1000
+
+// maxLineErrors is the most LineErrors an ImportReport keeps.
+const maxLineErrors = 100
+
+// A LineError describes a line that Import could not load.
+type LineError struct {
+	Line int    `json:"line"` // The line number, or for CSV, the row number counting the header as 1.
+	Err  string `json:"error"`
+}
+
+// An ImportReport summarizes an import.
+type ImportReport struct {
+	Imported int         `json:"imported"`  // The number of entries written.
+	BadLines int         `json:"bad_lines"` // The number of lines that could not be loaded.
+	Errors   []LineError `json:"errors"`    // The first of the bad lines, and what was wrong with them.
+}
+
+// String summarizes the ImportReport, listing the bad lines it kept.
+func (r *ImportReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "imported %d entries, skipped %d bad lines", r.Imported, r.BadLines)
+	for _, e := range r.Errors {
+		fmt.Fprintf(&sb, "\n  line %d: %s", e.Line, e.Err)
+	}
+	return sb.String()
+}
+
+func (r *ImportReport) badLine(line int, err error) {
+	r.BadLines++
+	if len(r.Errors) < maxLineErrors {
+		r.Errors = append(r.Errors, LineError{line, err.Error()})
+	}
+}
+
+// An ImportOption is an optional setting you may provide to Import.
+type ImportOption func(*importOptions)
+
+type importOptions struct {
+	batch int
+}
+
+// ImportBatch is an ImportOption that sets how many entries Import writes together.
+func ImportBatch(n int) ImportOption {
+	return func(o *importOptions) {
+		o.batch = n
+	}
+}
+
+// Import reads entries in the given format from r and writes them to the database. Rather than writing, and
+// syncing, each entry on its own, it writes them in batches. Lines that cannot be parsed, or whose key or
+// value is too long to store, are skipped and reported rather than stopping the import; Import only returns
+// an error if r cannot be read or the database cannot be written, in which case the report counts the entries
+// written before the failure.
+func (d *DB) Import(r io.Reader, format Format, option ...ImportOption) (*ImportReport, error) {
+	opts := importOptions{batch: DefaultImportBatch}
+	for _, o := range option {
+		o(&opts)
+	}
+
+	report := &ImportReport{Errors: make([]LineError, 0)}
+	b := file.NewBatch()
+	flush := func() error {
+		if b.Len() == 0 {
+			return nil
+		}
+		if err := d.WriteBatch(b); err != nil {
+			return err
+		}
+		report.Imported += b.Len()
+		b = file.NewBatch()
+		return nil
+	}
+	add := func(line int, rec Record, err error) error {
+		if err == nil {
+			var entry file.DBFileEntry
+			if entry, err = rec.Entry(); err == nil {
+				b.Add(entry)
+			}
+		}
+		if err != nil {
+			report.badLine(line, err)
+			return nil
+		}
+		if b.Len() >= opts.batch {
+			return flush()
+		}
+		return nil
+	}
+
+	var err error
+	switch format {
+	case JSONLines:
+		err = importJSONLines(r, add)
+	case CSV:
+		err = importCSV(r, add)
+	default:
+		err = fmt.Errorf("unknown format %d", format)
+	}
+	if err != nil {
+		return report, err
+	}
+	return report, flush()
+}
+
+// importJSONLines parses each line of r as a Record and passes it to add.
+func importJSONLines(r io.Reader, add func(line int, rec Record, err error) error) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, file.BufferSize), 1<<30)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+		var rec Record
+		err := json.Unmarshal([]byte(text), &rec)
+		if err := add(line, rec, err); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// importCSV parses each row of r as a Record and passes it to add. A header row naming the columns is
+// required, so that the columns may come in any order.
+func importCSV(r io.Reader, add func(line int, rec Record, err error) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	if _, ok := columns["key"]; !ok {
+		return errors.New("CSV header has no key column")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if perr, ok := err.(*csv.ParseError); ok {
+			if err := add(line, Record{}, perr.Err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		rec := Record{
+			Key:      field(row, "key"),
+			Value:    field(row, "value"),
+			Encoding: field(row, "encoding"),
+		}
+		if s := field(row, "expires"); s != "" {
+			var t time.Time
+			if t, err = time.Parse(time.RFC3339Nano, s); err == nil {
+				rec.Expires = &t
+			}
+		}
+		if err := add(line, rec, err); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package database_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport_JSONLines(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+	expires := time.Now().Add(time.Hour).Round(0).UTC()
+	db.Write("b", "2", file.Expires(expires))
+	db.Write("a", "1")
+	db.Write("bin", "\xff\x00")
+	db.Write("gone", "x")
+	db.Delete("gone")
+
+	buf := new(bytes.Buffer)
+	n, err := db.Export(buf, database.JSONLines)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, `{"key":"a","value":"1"}
+{"key":"b","value":"2","expires":"`+expires.Format(time.RFC3339Nano)+`"}
+{"key":"Ymlu","value":"/wA=","encoding":"base64"}
+`, buf.String())
+}
+
+func TestExport_CSV(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+	db.Write("a", "1,2")
+	db.Write("b", "line\r\nbreak")
+
+	buf := new(bytes.Buffer)
+	_, err := db.Export(buf, database.CSV)
+	require.NoError(t, err)
+	assert.Equal(t, "key,value,encoding,expires\na,\"1,2\",,\nYg==,bGluZQ0KYnJlYWs=,base64,\n", buf.String())
+}
+
+func TestImport_RoundTripsExport(t *testing.T) {
+	for _, format := range []database.Format{database.JSONLines, database.CSV} {
+		src, cleanup := SetupDBForTests()
+		src.Write("a", "1")
+		src.Write("bin", "\xff\r\n\x00")
+		src.Write("ttl", "x", file.TTL(time.Hour))
+		buf := new(bytes.Buffer)
+		_, err := src.Export(buf, format)
+		require.NoError(t, err)
+		cleanup()
+
+		dst, cleanup := SetupDBForTests()
+		report, err := dst.Import(buf, format, database.ImportBatch(2))
+		require.NoError(t, err)
+		assert.Equal(t, 3, report.Imported)
+		assert.Zero(t, report.BadLines)
+		assert.Equal(t, "1", dst.Read("a").Value())
+		assert.Equal(t, "\xff\r\n\x00", dst.Read("bin").Value())
+		ttl, err := dst.Get("ttl")
+		require.NoError(t, err)
+		assert.False(t, ttl.Expires().IsZero())
+		cleanup()
+	}
+}
+
+func TestImport_ReportsBadLines(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	report, err := db.Import(strings.NewReader(`{"key":"a","value":"1"}
+not json
+
+{"value":"no key"}
+{"key":"b","value":"!!","encoding":"base64"}
+{"key":"c","value":"3"}
+`), database.JSONLines)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 3, report.BadLines)
+	var lines []int
+	for _, e := range report.Errors {
+		lines = append(lines, e.Line)
+	}
+	assert.Equal(t, []int{2, 4, 5}, lines)
+	assert.Equal(t, "3", db.Read("c").Value())
+}
+
+func TestImport_ReportsRecordsTooLargeToStore(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	long := strings.Repeat("x", file.MaxLength+1)
+	report, err := db.Import(strings.NewReader(`{"key":"a","value":"1"}
+{"key":"`+long+`","value":"2"}
+{"key":"b","value":"`+long+`"}
+{"key":"c","value":"3"}
+`), database.JSONLines, database.ImportBatch(10))
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Imported)
+	assert.Equal(t, 2, report.BadLines)
+	assert.Equal(t, []database.LineError{
+		{Line: 2, Err: "key: " + file.ErrTooLarge.Error()},
+		{Line: 3, Err: "value: " + file.ErrTooLarge.Error()},
+	}, report.Errors)
+	assert.Equal(t, "1", db.Read("a").Value())
+	assert.Equal(t, "3", db.Read("c").Value())
+
+	report, err = db.Import(strings.NewReader("key,value\nd,4\ne,"+long+"\n"), database.CSV)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Imported)
+	assert.Equal(t, []database.LineError{{Line: 3, Err: "value: " + file.ErrTooLarge.Error()}}, report.Errors)
+	assert.True(t, db.DBFile.File.Verify().OK())
+}
+
+func TestImport_CSVReportsBadRows(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	report, err := db.Import(strings.NewReader("value,key\n1,a\n2,b,\"x\"y\n3,c\n4,d,extra,cols,ok\n"), database.CSV)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Imported)
+	assert.Equal(t, 1, report.BadLines)
+	assert.Equal(t, 3, report.Errors[0].Line)
+	assert.Equal(t, "3", db.Read("c").Value())
+}
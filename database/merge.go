@@ -0,0 +1,77 @@
+package database
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+)
+
+// Merge appends a merge operand for the key, which the named MergeOperator folds into the key's value
+// whenever it is read, and for good when the database is compacted. Unlike reading the value, changing it
+// and writing it back, Merge neither reads the key nor rewrites its whole value. It returns
+// file.ErrUnknownOperator if the database was not opened with the operator; see
+// filesystem.MergeOperators.
+// It returns filesystem.ErrReadOnly if the database was opened read-only.
+func (d *DB) Merge(key, operator, operand string) (file.DBFileEntry, error) {
+	defer d.metrics.writeLatency.observe(time.Now())
+	entry, err := d.DBFile.WriteEntry(file.NewEntry(key, file.Value(operand), file.MergeOperand(operator)))
+	if err == nil {
+		count(&d.metrics.writes, 1)
+	}
+	d.metrics.failed(err)
+	return entry, err
+}
+
+// Increment adds delta to the counter in the key, which is zero if the key does not exist, and returns
+// the counter's new value. It is atomic and cheap: it appends delta as a merge operand for file.Counter,
+// so concurrent increments are never lost. Incrementing a key whose value is not a decimal integer, or
+// past the range of an int64, returns an error and writes nothing, so the key's value is left as it was.
+// It returns filesystem.ErrReadOnly if the database was opened read-only.
+func (d *DB) Increment(key string, delta int64) (int64, error) {
+	defer d.metrics.writeLatency.observe(time.Now())
+	entry, err := d.DBFile.MergeEntry(counterOperand(key, delta))
+	if err == nil {
+		count(&d.metrics.writes, 1)
+	}
+	d.metrics.failed(err)
+	return counterValue(entry, err)
+}
+
+// Merge appends a merge operand for the key in the bucket, as DB.Merge does for the default bucket.
+func (n *Namespace) Merge(key, operator, operand string) (file.DBFileEntry, error) {
+	defer n.db.metrics.writeLatency.observe(time.Now())
+	entry, err := n.bucket.WriteEntry(file.NewEntry(key, file.Value(operand), file.MergeOperand(operator)))
+	if err == nil {
+		count(&n.db.metrics.writes, 1)
+		count(&n.metrics.writes, 1)
+	}
+	n.db.metrics.failed(err)
+	return entry, err
+}
+
+// Increment adds delta to the counter in the key in the bucket and returns its new value, as
+// DB.Increment does for the default bucket.
+func (n *Namespace) Increment(key string, delta int64) (int64, error) {
+	defer n.db.metrics.writeLatency.observe(time.Now())
+	entry, err := n.bucket.Merge(counterOperand(key, delta))
+	if err == nil {
+		count(&n.db.metrics.writes, 1)
+		count(&n.metrics.writes, 1)
+	}
+	n.db.metrics.failed(err)
+	return counterValue(entry, err)
+}
+
+// counterOperand returns the merge operand that adds delta to the counter in the key.
+func counterOperand(key string, delta int64) file.DBFileEntry {
+	return file.NewEntry(key, file.Value(strconv.FormatInt(delta, 10)), file.MergeOperand(file.Counter.Name()))
+}
+
+// counterValue parses the value of a counter, as returned with err by a merge.
+func counterValue(entry file.DBFileEntry, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(entry.Value(), 10, 64)
+}
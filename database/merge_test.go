@@ -0,0 +1,88 @@
+package database_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncrement_LosesNoUpdates(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				db.Increment("counter", 2)
+			}
+		}()
+	}
+	wg.Wait()
+	n, err := db.Increment("counter", -100)
+	require.NoError(t, err)
+	assert.Equal(t, int64(300), n)
+
+	entry, err := db.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, "300", entry.Value())
+
+	_, err = db.Compact()
+	require.NoError(t, err)
+	assert.Equal(t, "300", db.Read("counter").Value())
+}
+
+func TestIncrement_ReturnsEachNewValue(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = make(map[int64]bool)
+	)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				n, err := db.Increment("counter", 1)
+				assert.NoError(t, err)
+				mu.Lock()
+				seen[n] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, seen, 100, "no two increments see the same value")
+
+	db.Write("name", "x")
+	_, err := db.Increment("name", 1)
+	assert.Error(t, err)
+	entry, err := db.Get("name")
+	require.NoError(t, err, "a failed increment leaves the key readable")
+	assert.Equal(t, "x", entry.Value())
+}
+
+func TestMerge_RequiresKnownOperator(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	_, err := db.Merge("a", "missing", "1")
+	assert.Equal(t, file.ErrUnknownOperator, err)
+
+	users, _ := db.Bucket("users")
+	users.Increment("a", 1)
+	n, err := users.Increment("a", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	entry, err := users.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "2", entry.Value())
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the buckets of the read and write latency
+// histograms.
+var LatencyBuckets = []float64{0.00001, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.05, 0.1, 1}
+
+// CompactionBuckets are the upper bounds, in seconds, of the buckets of the compaction duration
+// histogram.
+var CompactionBuckets = []float64{0.01, 0.1, 1, 10, 60, 600}
+
+// A Bucket counts the observations in a Histogram no greater than its upper bound.
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// A Histogram summarizes a distribution of durations. Like a Prometheus histogram, its buckets are
+// cumulative, so each counts every observation up to its bound, and the last counts the same as Count
+// less any observations beyond it.
+type Histogram struct {
+	Buckets []Bucket `json:"buckets"`
+	Count   uint64   `json:"count"`
+	Sum     float64  `json:"sum"` // The total of the observations, in seconds.
+}
+
+// histogram records observations for a Histogram. It is safe for concurrent use.
+type histogram struct {
+	bounds []float64
+	counts []uint64 // Per bucket, not cumulative; the last counts observations beyond every bound.
+	sum    uint64   // In nanoseconds.
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// observe records the time since start.
+func (h *histogram) observe(start time.Time) {
+	d := time.Since(start)
+	i := 0
+	for i < len(h.bounds) && d.Seconds() > h.bounds[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{Buckets: make([]Bucket, len(h.bounds))}
+	for i := range h.counts {
+		s.Count += atomic.LoadUint64(&h.counts[i])
+		if i < len(h.bounds) {
+			s.Buckets[i] = Bucket{h.bounds[i], s.Count}
+		}
+	}
+	s.Sum = time.Duration(atomic.LoadUint64(&h.sum)).Seconds()
+	return s
+}
+
+// metrics holds the counters and histograms a DB keeps as it is used.
+type metrics struct {
+	reads, scans, writes, deletes, errors uint64
+	readLatency, writeLatency, compaction *histogram
+
+	mu      sync.Mutex
+	buckets map[string]*bucketMetrics
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		readLatency:  newHistogram(LatencyBuckets),
+		writeLatency: newHistogram(LatencyBuckets),
+		compaction:   newHistogram(CompactionBuckets),
+		buckets:      make(map[string]*bucketMetrics),
+	}
+}
+
+// bucket returns the counters for the bucket with the given name.
+func (m *metrics) bucket(name string) *bucketMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, found := m.buckets[name]
+	if !found {
+		b = &bucketMetrics{}
+		m.buckets[name] = b
+	}
+	return b
+}
+
+// dropBucket discards the counters for a bucket that has been dropped, so that a new bucket with the
+// same name starts from zero.
+func (m *metrics) dropBucket(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.buckets, name)
+}
+
+// count adds n to a counter.
+func count(counter *uint64, n int) {
+	atomic.AddUint64(counter, uint64(n))
+}
+
+// Stats holds counters and gauges describing a DB. The counters start from zero when the DB is
+// opened.
+type Stats struct {
+	Reads   uint64 `json:"reads"`   // The keys read by Read and Get.
+	Scans   uint64 `json:"scans"`   // The calls to Scan.
+	Writes  uint64 `json:"writes"`  // The keys written, including those in batches.
+	Deletes uint64 `json:"deletes"` // The keys deleted, including those in batches.
+	Errors  uint64 `json:"errors"`  // The reads, scans, writes and compactions that failed, other than for missing keys.
+
+	BytesAppended int64   `json:"bytes_appended"` // The bytes appended to the log.
+	FileSize      int64   `json:"file_size"`      // The size of the log, in bytes.
+	LiveKeys      int     `json:"live_keys"`      // The number of keys in the index.
+	LiveBytes     int64   `json:"live_bytes"`     // The bytes taken by the latest entries of live keys.
+	DeadBytes     int64   `json:"dead_bytes"`     // The bytes that compacting would reclaim.
+	GarbageRatio  float64 `json:"garbage_ratio"`  // The fraction of the log taken by dead bytes.
+	Tombstones    int     `json:"tombstones"`     // The tombstones in the log, which compacting removes.
+
+	Compactions  uint64    `json:"compactions"`   // The number of times the log has been compacted.
+	Compaction   Histogram `json:"compaction"`    // How long compactions took.
+	ReadLatency  Histogram `json:"read_latency"`  // How long each Read, Get and Scan took.
+	WriteLatency Histogram `json:"write_latency"` // How long each Write, Delete and WriteBatch took.
+
+	Buckets []BucketStats `json:"buckets,omitempty"` // The named buckets, in order of name.
+}
+
+// Stats returns the DB's counters and gauges. The gauges are kept up to date as the log is written, so
+// Stats reads none of it; use Verify to check the whole log.
+func (d *DB) Stats() (*Stats, error) {
+	u, err := d.DBFile.Usage()
+	if err != nil {
+		return nil, err
+	}
+	m := d.metrics
+	s := &Stats{
+		Reads:         atomic.LoadUint64(&m.reads),
+		Scans:         atomic.LoadUint64(&m.scans),
+		Writes:        atomic.LoadUint64(&m.writes),
+		Deletes:       atomic.LoadUint64(&m.deletes),
+		Errors:        atomic.LoadUint64(&m.errors),
+		BytesAppended: u.Appended,
+		FileSize:      u.Size,
+		LiveKeys:      u.Keys,
+		LiveBytes:     u.LiveBytes,
+		DeadBytes:     u.DeadBytes(),
+		GarbageRatio:  u.GarbageRatio(),
+		Tombstones:    u.Tombstones,
+		Compaction:    m.compaction.snapshot(),
+		ReadLatency:   m.readLatency.snapshot(),
+		WriteLatency:  m.writeLatency.snapshot(),
+	}
+	s.Compactions = s.Compaction.Count
+
+	for _, b := range d.DBFile.Buckets() {
+		n := &Namespace{db: d, bucket: b, metrics: m.bucket(b.Name())}
+		bs, err := n.Stats()
+		if err == file.ErrNoBucket {
+			continue // Dropped since it was listed.
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.Buckets = append(s.Buckets, *bs)
+	}
+	return s, nil
+}
+
+// String summarizes the Stats, one per line.
+func (s *Stats) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "reads:         %d (%d scans)\n", s.Reads, s.Scans)
+	fmt.Fprintf(&sb, "writes:        %d (%d deletes)\n", s.Writes, s.Deletes)
+	fmt.Fprintf(&sb, "errors:        %d\n", s.Errors)
+	fmt.Fprintf(&sb, "appended:      %d bytes\n", s.BytesAppended)
+	fmt.Fprintf(&sb, "size:          %d bytes\n", s.FileSize)
+	fmt.Fprintf(&sb, "live keys:     %d (%d bytes)\n", s.LiveKeys, s.LiveBytes)
+	fmt.Fprintf(&sb, "garbage:       %d bytes (%.1f%%)\n", s.DeadBytes, s.GarbageRatio*100)
+	fmt.Fprintf(&sb, "tombstones:    %d\n", s.Tombstones)
+	fmt.Fprintf(&sb, "compactions:   %d (%s)\n", s.Compactions, seconds(s.Compaction.Sum))
+	fmt.Fprintf(&sb, "read latency:  %s mean\n", mean(s.ReadLatency))
+	fmt.Fprintf(&sb, "write latency: %s mean\n", mean(s.WriteLatency))
+	for _, b := range s.Buckets {
+		fmt.Fprintf(&sb, "bucket %q: %d keys (%d bytes), %d reads, %d writes\n", b.Name, b.LiveKeys, b.LiveBytes,
+			b.Reads, b.Writes)
+	}
+	return sb.String()
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func mean(h Histogram) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return seconds(h.Sum / float64(h.Count))
+}
+
+// failed counts err as an error, unless it is nil or file.ErrNotFound.
+func (m *metrics) failed(err error) {
+	if err != nil && err != file.ErrNotFound {
+		count(&m.errors, 1)
+	}
+}
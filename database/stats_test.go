@@ -0,0 +1,60 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats_CountsOperations(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	db.Write("a", "1")
+	db.Write("a", "2")
+	db.Delete("a")
+	db.WriteBatch(file.NewBatch().Write("b", "1").Write("c", "1").Delete("c"))
+	db.Read("a")
+	db.Get("b")
+	db.Scan("", "", 0)
+
+	s, err := db.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), s.Writes)
+	assert.Equal(t, uint64(2), s.Deletes)
+	assert.Equal(t, uint64(2), s.Reads)
+	assert.Equal(t, uint64(1), s.Scans)
+	assert.Equal(t, uint64(0), s.Errors)
+	assert.Equal(t, uint64(3), s.ReadLatency.Count)
+	assert.Equal(t, uint64(4), s.WriteLatency.Count)
+	assert.Len(t, s.ReadLatency.Buckets, len(database.LatencyBuckets))
+	last := s.WriteLatency.Buckets[len(s.WriteLatency.Buckets)-1]
+	assert.LessOrEqual(t, last.Count, s.WriteLatency.Count)
+
+	assert.Equal(t, 1, s.LiveKeys)
+	assert.Equal(t, s.FileSize, s.BytesAppended)
+	assert.Equal(t, s.FileSize-s.LiveBytes, s.DeadBytes)
+	assert.True(t, s.GarbageRatio > 0.5)
+	assert.Equal(t, 2, s.Tombstones)
+}
+
+func TestStats_CountsCompactions(t *testing.T) {
+	db, cleanup := SetupDBForTests()
+	defer cleanup()
+
+	db.Write("a", "1")
+	db.Write("a", "2")
+	_, err := db.Compact()
+	require.NoError(t, err)
+
+	s, err := db.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), s.Compactions)
+	assert.Equal(t, uint64(1), s.Compaction.Count)
+	assert.Equal(t, int64(0), s.DeadBytes)
+	assert.Equal(t, 0.0, s.GarbageRatio)
+	assert.Contains(t, s.String(), "compactions:   1")
+}
@@ -0,0 +1,492 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrNotBackup is returned when restoring from data that is not a backup, or is a truncated one.
+	ErrNotBackup = errors.New("not a backup")
+
+	// ErrChecksum is returned when restoring a backup whose content does not match its checksum.
+	ErrChecksum = errors.New("backup checksum does not match")
+
+	// ErrBrokenChain is returned when an incremental backup does not continue from the backup before it,
+	// or when an incremental backup is requested from a backup of a different log.
+	ErrBrokenChain = errors.New("backup does not continue from the previous backup")
+
+	// ErrNoRestorePoint is returned when a restore is limited to a point that the backups cannot
+	// recreate: a time before the base backup was taken, or an offset within a compacted base backup.
+	ErrNoRestorePoint = errors.New("backups cannot be restored to that point")
+)
+
+// backupMagic begins every backup.
+const backupMagic = "DBBACKUP"
+
+const (
+	// backupVersion is the version of the backups Backup writes. Version 1 backups, which are always
+	// full backups, can still be restored.
+	backupVersion = 2
+
+	// backupCompacted is set in a backup's flags if it holds only live entries.
+	backupCompacted byte = 1
+)
+
+// A backupPrefix begins every version of backup header.
+type backupPrefix struct {
+	Magic   [8]byte
+	Version byte
+	Flags   byte
+}
+
+// A backupHeaderV1 follows the prefix in a version 1 backup.
+type backupHeaderV1 struct {
+	Offset int64
+	Length int64
+}
+
+// A backupHeader follows the prefix in a backup, and precedes the log. The log is followed by a SHA-256
+// checksum of the prefix, header and log together.
+type backupHeader struct {
+	Start   int64    // The offset in the source DBFile that the backup starts from; 0 for a base backup.
+	Offset  int64    // The offset in the source DBFile that the backup was taken up to.
+	Length  int64    // The length of the log in the backup.
+	Time    int64    // When the backup was taken, in Unix nanoseconds.
+	Segment [32]byte // The generation of the log the backup was taken from.
+	Parent  [32]byte // The checksum of the backup this one continues from; zero for a base backup.
+}
+
+// A BackupInfo describes a backup. A base backup holds a DBFile's log from the beginning; an incremental
+// backup holds only what was appended after the backup it continues from, its parent.
+type BackupInfo struct {
+	Start     int64     `json:"start"`            // The offset in the source DBFile that the backup starts from.
+	Offset    int64     `json:"offset"`           // The offset in the source DBFile that the backup was taken up to.
+	Bytes     int64     `json:"bytes"`            // The length of the log in the backup.
+	Compacted bool      `json:"compacted"`        // Whether the backup holds only the live entries.
+	Time      time.Time `json:"time"`             // When the backup was taken.
+	Segment   string    `json:"segment"`          // The generation of the log the backup was taken from; see DBFile.Generation.
+	Parent    string    `json:"parent,omitempty"` // The checksum of the parent backup, in hex.
+	Checksum  string    `json:"checksum"`         // The backup's SHA-256 checksum, in hex.
+}
+
+// Incremental reports whether the backup continues from another.
+func (b *BackupInfo) Incremental() bool {
+	return b.Parent != ""
+}
+
+// String summarizes the backup in a single line.
+func (b *BackupInfo) String() string {
+	kind := "full"
+	switch {
+	case b.Compacted:
+		kind = "compacted"
+	case b.Incremental():
+		kind = "incremental"
+	}
+	return fmt.Sprintf("%s backup of %d bytes from offset %d to %d, sha256 %s", kind, b.Bytes, b.Start, b.Offset, b.Checksum)
+}
+
+// A BackupOption is an optional setting you may provide to Backup.
+type BackupOption func(*backupOptions)
+
+type backupOptions struct {
+	compacted bool
+	parent    *BackupInfo
+}
+
+// Compacted is a BackupOption that leaves out overwritten, deleted and expired entries, so that the
+// backup holds only the latest entry for each live key. A compacted backup can only be a base backup.
+func Compacted(o *backupOptions) {
+	o.compacted = true
+}
+
+// Since is a BackupOption that makes an incremental backup, holding only the entries appended since the
+// parent backup was taken. Restoring it requires the parent, and the parent's own parents, back to a
+// base backup.
+func Since(parent *BackupInfo) BackupOption {
+	return func(o *backupOptions) {
+		o.parent = parent
+	}
+}
+
+// Backup writes a consistent copy of the DBFile to w. The copy holds every entry committed when Backup
+// was called and nothing after, so it may be taken while the DBFile is being written to. It is framed
+// with a header and a checksum so that Restore can detect a damaged copy. It returns ErrBrokenChain if
+// asked to continue from a backup of a different log, including a backup taken before the log was
+// compacted.
+func (d *DBFile) Backup(w io.Writer, option ...BackupOption) (*BackupInfo, error) {
+	var opts backupOptions
+	for _, o := range option {
+		o(&opts)
+	}
+	if opts.compacted && opts.parent != nil {
+		return nil, errors.New("a compacted backup cannot be incremental")
+	}
+
+	if d.follow {
+		d.Refresh()
+	}
+
+	// Everything before the current offset is complete and will never change, so the lock is only
+	// needed while taking the snapshot, not while copying it.
+	d.mu.RLock()
+	src, release := d.acquire()
+	end, generation := d.Offset, d.currentGeneration()
+	var offsets []int64
+	if opts.compacted {
+		offsets = d.liveOffsets()
+	}
+	d.mu.RUnlock()
+	defer release()
+
+	if end > 0 && generation == "" {
+		return nil, errors.New("cannot identify the log's generation")
+	}
+	header := backupHeader{Offset: end, Time: time.Now().UnixNano()}
+	hex.Decode(header.Segment[:], []byte(generation))
+	var err error
+	ranges := []Range{{0, end}}
+	switch {
+	case opts.compacted:
+		if ranges, err = liveRanges(src, end, offsets); err != nil {
+			return nil, err
+		}
+	case opts.parent != nil:
+		parent, err := hex.DecodeString(opts.parent.Checksum)
+		if err != nil || len(parent) != sha256.Size {
+			return nil, ErrBrokenChain
+		}
+		if (opts.parent.Segment != generation && opts.parent.Offset > 0) || opts.parent.Offset > end {
+			return nil, ErrBrokenChain
+		}
+		copy(header.Parent[:], parent)
+		header.Start = opts.parent.Offset
+		ranges = []Range{{header.Start, end}}
+	}
+	for _, r := range ranges {
+		header.Length += r.Len()
+	}
+
+	prefix := backupPrefix{Version: backupVersion}
+	copy(prefix.Magic[:], backupMagic)
+	if opts.compacted {
+		prefix.Flags |= backupCompacted
+	}
+
+	sum := sha256.New()
+	bw := bufio.NewWriterSize(w, BufferSize)
+	out := io.MultiWriter(bw, sum)
+	if err := binary.Write(out, binary.LittleEndian, prefix); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(out, binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+	for _, r := range ranges {
+		if _, err := io.Copy(out, io.NewSectionReader(src, r.Start, r.Len())); err != nil {
+			return nil, err
+		}
+	}
+	checksum := sum.Sum(nil)
+	if _, err := bw.Write(checksum); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	return infoFrom(prefix, header, checksum), nil
+}
+
+// segmentOf identifies the log in r by a checksum of its first entry, which stays the same however much
+// the log grows. An empty log has a zero segment. Since Compact begins the log with a new, random entry,
+// a compacted log never has the segment it had before; see Generation.
+func segmentOf(r io.ReaderAt, size int64) ([32]byte, error) {
+	var segment [32]byte
+	if size == 0 {
+		return segment, nil
+	}
+
+	var entry DBFileEntry
+	n, err := decodeAt(r, 0, size, &entry)
+	if err != nil {
+		return segment, err
+	}
+	first := make([]byte, n)
+	if _, err := r.ReadAt(first, 0); err != nil {
+		return segment, err
+	}
+	return sha256.Sum256(first), nil
+}
+
+// liveRanges returns the ranges occupied by the entries at offsets that have not expired, in the order
+// they appear in the file.
+func liveRanges(r io.ReaderAt, size int64, offsets []int64) ([]Range, error) {
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	var (
+		ranges []Range
+		entry  DBFileEntry
+		now    = time.Now()
+	)
+	for _, offset := range offsets {
+		n, err := decodeAt(r, offset, size, &entry)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Expired(now) {
+			continue
+		}
+		ranges = append(ranges, Range{offset, offset + int64(n)})
+	}
+	return ranges, nil
+}
+
+func infoFrom(prefix backupPrefix, header backupHeader, checksum []byte) *BackupInfo {
+	info := &BackupInfo{
+		Start:     header.Start,
+		Offset:    header.Offset,
+		Bytes:     header.Length,
+		Compacted: prefix.Flags&backupCompacted != 0,
+		Segment:   hex.EncodeToString(header.Segment[:]),
+		Checksum:  hex.EncodeToString(checksum),
+	}
+	if header.Time != 0 {
+		info.Time = time.Unix(0, header.Time)
+	}
+	if header.Parent != [32]byte{} {
+		info.Parent = hex.EncodeToString(header.Parent[:])
+	}
+	return info
+}
+
+// readHeader reads a backup's prefix and header, of any version, from r.
+func readHeader(r io.Reader) (backupPrefix, backupHeader, error) {
+	var (
+		prefix backupPrefix
+		header backupHeader
+	)
+	if err := binary.Read(r, binary.LittleEndian, &prefix); err != nil {
+		return prefix, header, ErrNotBackup
+	}
+	if string(prefix.Magic[:]) != backupMagic {
+		return prefix, header, ErrNotBackup
+	}
+
+	switch prefix.Version {
+	case 1:
+		var v1 backupHeaderV1
+		if err := binary.Read(r, binary.LittleEndian, &v1); err != nil {
+			return prefix, header, ErrNotBackup
+		}
+		header.Offset, header.Length = v1.Offset, v1.Length
+	case backupVersion:
+		if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+			return prefix, header, ErrNotBackup
+		}
+	default:
+		return prefix, header, ErrNotBackup
+	}
+
+	if header.Length < 0 {
+		return prefix, header, ErrNotBackup
+	}
+	return prefix, header, nil
+}
+
+// ReadBackupInfo describes the backup in r without restoring it, so that it can be given to Since. It
+// does not verify the backup's checksum.
+func ReadBackupInfo(r io.ReadSeeker) (*BackupInfo, error) {
+	prefix, header, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.Seek(header.Length, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+	checksum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, checksum); err != nil {
+		return nil, ErrNotBackup
+	}
+	return infoFrom(prefix, header, checksum), nil
+}
+
+// A RestoreOption is an optional setting you may provide to RestoreChain.
+type RestoreOption func(*restoreOptions)
+
+type restoreOptions struct {
+	offset int64
+	time   time.Time
+}
+
+// UntilOffset is a RestoreOption that restores the log only up to the given offset in the source
+// DBFile, leaving out any entry that extends beyond it.
+func UntilOffset(offset int64) RestoreOption {
+	return func(o *restoreOptions) {
+		o.offset = offset
+	}
+}
+
+// UntilTime is a RestoreOption that restores only the backups taken at or before t. Entries do not
+// record when they were written, so the database can be restored as it was when any one of its backups
+// was taken, but not as it was between backups.
+func UntilTime(t time.Time) RestoreOption {
+	return func(o *restoreOptions) {
+		o.time = t
+	}
+}
+
+// Restore reads a backup written by Backup from r and writes its log to a new file at path, which must
+// not already exist. It returns ErrNotBackup if r does not hold a complete backup, ErrChecksum if the
+// backup has been damaged, and ErrCorrupt if its entries cannot be decoded. If Restore fails, it removes
+// the file.
+func Restore(r io.Reader, path string) (*BackupInfo, error) {
+	return RestoreChain(path, []io.Reader{r})
+}
+
+// RestoreChain is like Restore, but restores a base backup followed by the incremental backups that
+// continue from it, in order. Options may limit the restore to a point in time or an offset, in which
+// case restoring stops at that point. It returns ErrBrokenChain if a backup does not
+// continue from the one before it. The returned BackupInfo describes the restored log as a whole.
+func RestoreChain(path string, backups []io.Reader, option ...RestoreOption) (info *BackupInfo, err error) {
+	opts := restoreOptions{offset: -1}
+	for _, o := range option {
+		o(&opts)
+	}
+
+	out, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	var (
+		size   int64 // How much has been restored to out.
+		result *BackupInfo
+	)
+	for i, r := range backups {
+		b, err := restoreOne(out, size, r)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			if b.Incremental() {
+				return nil, ErrBrokenChain
+			}
+			if !opts.time.IsZero() && b.Time.After(opts.time) {
+				return nil, ErrNoRestorePoint
+			}
+			result = b
+		} else {
+			if !continues(result, b) {
+				return nil, ErrBrokenChain
+			}
+			if !opts.time.IsZero() && b.Time.After(opts.time) {
+				break
+			}
+			result.Offset, result.Time, result.Segment, result.Checksum = b.Offset, b.Time, b.Segment, b.Checksum
+		}
+
+		if opts.offset >= 0 && opts.offset < b.Offset {
+			if b.Compacted || opts.offset < b.Start {
+				return nil, ErrNoRestorePoint
+			}
+			end := lastBoundary(out, size, size+b.Bytes, size+opts.offset-b.Start)
+			result.Offset = b.Start + end - size
+			size = end
+			break
+		}
+		size += b.Bytes
+	}
+
+	if result == nil {
+		return nil, ErrNotBackup
+	}
+	if err := out.Truncate(size); err != nil {
+		return nil, err
+	}
+	result.Bytes = size
+	if err := out.Sync(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// continues reports whether b is an incremental backup that continues from parent. A backup of an empty
+// log has no segment, so any backup of the same log can continue from it.
+func continues(parent, b *BackupInfo) bool {
+	return !b.Compacted && b.Parent == parent.Checksum && b.Start == parent.Offset &&
+		(b.Segment == parent.Segment || parent.Offset == 0)
+}
+
+// restoreOne appends the log in the backup in r to out, which already holds size bytes, and checks it.
+func restoreOne(out *os.File, size int64, r io.Reader) (*BackupInfo, error) {
+	sum := sha256.New()
+	in := io.TeeReader(bufio.NewReaderSize(r, BufferSize), sum)
+
+	prefix, header, err := readHeader(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := out.Seek(size, io.SeekStart); err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriterSize(out, BufferSize)
+	if _, err := io.CopyN(w, in, header.Length); err != nil {
+		if err == io.EOF {
+			err = ErrNotBackup
+		}
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	want := sum.Sum(nil)
+	got := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(in, got); err != nil {
+		return nil, ErrNotBackup
+	}
+	if !bytes.Equal(want, got) {
+		return nil, ErrChecksum
+	}
+
+	log := io.NewSectionReader(out, size, header.Length)
+	if corrupt := scanEntries(log, header.Length, func(int64, int, DBFileEntry) {}); len(corrupt) > 0 {
+		return nil, ErrCorrupt
+	}
+	return infoFrom(prefix, header, want), nil
+}
+
+// lastBoundary returns the offset of the last entry boundary in r, between start and end, that is no
+// later than limit.
+func lastBoundary(r io.ReaderAt, start, end, limit int64) int64 {
+	var entry DBFileEntry
+	offset := start
+	for offset < end {
+		n, err := decodeAt(r, offset, end, &entry)
+		if err != nil || offset+int64(n) > limit {
+			break
+		}
+		offset += int64(n)
+	}
+	return offset
+}
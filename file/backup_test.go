@@ -0,0 +1,280 @@
+package file_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const restoreTestDat = "restore_test.dat"
+
+func RestoreTestDat(t *testing.T, backup []byte) (*file.BackupInfo, error) {
+	os.Remove(restoreTestDat)
+	t.Cleanup(func() { os.Remove(restoreTestDat) })
+	return file.Restore(bytes.NewReader(backup), restoreTestDat)
+}
+
+func TestBackup_RestoresToCopyOfLog(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.DeleteEntry("a")
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+
+	buf := new(bytes.Buffer)
+	info, err := d.Backup(buf)
+	require.NoError(t, err)
+	assert.Equal(t, d.CurrentOffset(), info.Offset)
+	assert.Equal(t, d.CurrentOffset(), info.Bytes)
+	assert.False(t, info.Compacted)
+
+	// Writes after the backup was taken are not in it.
+	d.WriteEntry(file.NewEntry("c", file.Value("3")))
+
+	restored, err := RestoreTestDat(t, buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, info, restored)
+
+	want, err := ioutil.ReadFile(d.File.Name())
+	require.NoError(t, err)
+	got, err := ioutil.ReadFile(restoreTestDat)
+	require.NoError(t, err)
+	assert.Equal(t, want[:info.Offset], got)
+}
+
+func TestBackup_CompactedHoldsOnlyLiveEntries(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(file.NewEntry("a", file.Value("2")))
+	d.WriteEntry(file.NewEntry("b", file.Value("x")))
+	d.DeleteEntry("b")
+	d.WriteEntry(file.NewEntry("c", file.Value("3")))
+	d.WriteEntry(file.NewEntry("gone", file.Value("x"), file.TTL(time.Millisecond)))
+	time.Sleep(5 * time.Millisecond)
+
+	buf := new(bytes.Buffer)
+	info, err := d.Backup(buf, file.Compacted)
+	require.NoError(t, err)
+	assert.True(t, info.Compacted)
+
+	_, err = RestoreTestDat(t, buf.Bytes())
+	require.NoError(t, err)
+	got, err := ioutil.ReadFile(restoreTestDat)
+	require.NoError(t, err)
+	assert.Equal(t, EncodeEntries(
+		file.NewEntry("a", file.Value("2")),
+		file.NewEntry("c", file.Value("3")),
+	), got)
+}
+
+func TestRestore_RejectsDamagedBackups(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+
+	buf := new(bytes.Buffer)
+	_, err := d.Backup(buf)
+	require.NoError(t, err)
+	backup := buf.Bytes()
+
+	flipped := append([]byte(nil), backup...)
+	flipped[len(flipped)-33] ^= 0xff
+	_, err = RestoreTestDat(t, flipped)
+	assert.Equal(t, file.ErrChecksum, err)
+	assert.NoFileExists(t, restoreTestDat)
+
+	_, err = RestoreTestDat(t, backup[:len(backup)-1])
+	assert.Equal(t, file.ErrNotBackup, err)
+	assert.NoFileExists(t, restoreTestDat)
+
+	_, err = RestoreTestDat(t, []byte("not a backup at all"))
+	assert.Equal(t, file.ErrNotBackup, err)
+}
+
+func TestRestore_WillNotOverwrite(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	buf := new(bytes.Buffer)
+	_, err := d.Backup(buf)
+	require.NoError(t, err)
+
+	_, err = file.Restore(buf, d.File.Name())
+	assert.True(t, os.IsExist(err))
+}
+
+func TakeBackup(t *testing.T, d *file.DBFile, option ...file.BackupOption) ([]byte, *file.BackupInfo) {
+	buf := new(bytes.Buffer)
+	info, err := d.Backup(buf, option...)
+	require.NoError(t, err)
+	return buf.Bytes(), info
+}
+
+func RestoreTestChain(t *testing.T, backups [][]byte, option ...file.RestoreOption) (*file.BackupInfo, error) {
+	os.Remove(restoreTestDat)
+	t.Cleanup(func() { os.Remove(restoreTestDat) })
+
+	var readers []io.Reader
+	for _, b := range backups {
+		readers = append(readers, bytes.NewReader(b))
+	}
+	return file.RestoreChain(restoreTestDat, readers, option...)
+}
+
+func ReadRestoreTestDat(t *testing.T) []byte {
+	got, err := ioutil.ReadFile(restoreTestDat)
+	require.NoError(t, err)
+	return got
+}
+
+func TestBackup_IncrementalHoldsOnlyNewEntries(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	_, base := TakeBackup(t, d)
+
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+	inc, info := TakeBackup(t, d, file.Since(base))
+	assert.True(t, info.Incremental())
+	assert.Equal(t, base.Offset, info.Start)
+	assert.Equal(t, d.CurrentOffset(), info.Offset)
+	assert.Equal(t, d.CurrentOffset()-base.Offset, info.Bytes)
+	assert.Equal(t, base.Checksum, info.Parent)
+	assert.Equal(t, base.Segment, info.Segment)
+
+	read, err := file.ReadBackupInfo(bytes.NewReader(inc))
+	require.NoError(t, err)
+	assert.Equal(t, info.Checksum, read.Checksum)
+	assert.Equal(t, info.Start, read.Start)
+}
+
+func TestRestoreChain_ReplaysBaseAndIncrements(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	base, baseInfo := TakeBackup(t, d)
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+	inc1, info1 := TakeBackup(t, d, file.Since(baseInfo))
+	d.DeleteEntry("a")
+	inc2, info2 := TakeBackup(t, d, file.Since(info1))
+
+	info, err := RestoreTestChain(t, [][]byte{base, inc1, inc2})
+	require.NoError(t, err)
+	assert.Equal(t, d.CurrentOffset(), info.Offset)
+	assert.Equal(t, info2.Checksum, info.Checksum)
+
+	want, err := ioutil.ReadFile(d.File.Name())
+	require.NoError(t, err)
+	assert.Equal(t, want, ReadRestoreTestDat(t))
+}
+
+func TestRestoreChain_StopsAtOffset(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	base, baseInfo := TakeBackup(t, d)
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+	mid := d.CurrentOffset()
+	d.WriteEntry(file.NewEntry("c", file.Value("3")))
+	inc, _ := TakeBackup(t, d, file.Since(baseInfo))
+
+	// An offset partway through an entry restores up to the start of that entry.
+	info, err := RestoreTestChain(t, [][]byte{base, inc}, file.UntilOffset(mid+2))
+	require.NoError(t, err)
+	assert.Equal(t, mid, info.Offset)
+	assert.Equal(t, EncodeEntries(
+		file.NewEntry("a", file.Value("1")),
+		file.NewEntry("b", file.Value("2")),
+	), ReadRestoreTestDat(t))
+
+	compacted, _ := TakeBackup(t, d, file.Compacted)
+	_, err = RestoreTestChain(t, [][]byte{compacted}, file.UntilOffset(mid))
+	assert.Equal(t, file.ErrNoRestorePoint, err)
+}
+
+func TestRestoreChain_StopsAtTime(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	base, baseInfo := TakeBackup(t, d)
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+	inc1, info1 := TakeBackup(t, d, file.Since(baseInfo))
+	time.Sleep(10 * time.Millisecond)
+	d.WriteEntry(file.NewEntry("c", file.Value("3")))
+	inc2, _ := TakeBackup(t, d, file.Since(info1))
+
+	info, err := RestoreTestChain(t, [][]byte{base, inc1, inc2}, file.UntilTime(info1.Time.Add(time.Millisecond)))
+	require.NoError(t, err)
+	assert.Equal(t, info1.Offset, info.Offset)
+	assert.Equal(t, int64(len(ReadRestoreTestDat(t))), info1.Offset)
+
+	_, err = RestoreTestChain(t, [][]byte{base, inc1}, file.UntilTime(baseInfo.Time.Add(-time.Second)))
+	assert.Equal(t, file.ErrNoRestorePoint, err)
+}
+
+func TestRestoreChain_RejectsBrokenChains(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	base, baseInfo := TakeBackup(t, d)
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+	inc1, info1 := TakeBackup(t, d, file.Since(baseInfo))
+	d.WriteEntry(file.NewEntry("c", file.Value("3")))
+	inc2, _ := TakeBackup(t, d, file.Since(info1))
+
+	_, err := RestoreTestChain(t, [][]byte{base, inc2})
+	assert.Equal(t, file.ErrBrokenChain, err)
+	_, err = RestoreTestChain(t, [][]byte{inc1, inc2})
+	assert.Equal(t, file.ErrBrokenChain, err)
+	assert.NoFileExists(t, restoreTestDat)
+}
+
+func TestBackup_SinceBackupOfAnotherLogFails(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+
+	_, err := d.Backup(new(bytes.Buffer), file.Since(&file.BackupInfo{Offset: 1, Segment: "other"}))
+	assert.Equal(t, file.ErrBrokenChain, err)
+}
+
+func TestBackup_SinceBackupBeforeCompactionFails(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	_, base := TakeBackup(t, d)
+	assert.Equal(t, d.Generation(), base.Segment)
+
+	// The first entry survives compaction, but the log's generation does not.
+	_, err := d.Compact()
+	require.NoError(t, err)
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+	_, err = d.Backup(new(bytes.Buffer), file.Since(base))
+	assert.Equal(t, file.ErrBrokenChain, err)
+}
+
+func TestRestore_ReadsVersion1Backups(t *testing.T) {
+	log := EncodeEntries(file.NewEntry("a", file.Value("1")))
+	buf := new(bytes.Buffer)
+	buf.WriteString("DBBACKUP")
+	buf.Write([]byte{1, 0})
+	binary.Write(buf, binary.LittleEndian, []int64{int64(len(log)), int64(len(log))})
+	buf.Write(log)
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:])
+
+	info, err := RestoreTestDat(t, buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(log)), info.Offset)
+	assert.Equal(t, log, ReadRestoreTestDat(t))
+}
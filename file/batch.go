@@ -0,0 +1,37 @@
+package file
+
+// A Batch is a group of writes and deletes that are applied to a DBFile together by WriteBatch.
+type Batch struct {
+	entries []DBFileEntry
+}
+
+// NewBatch creates an empty Batch.
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Write adds a write of the value to the key to the Batch.
+func (b *Batch) Write(key, value string) *Batch {
+	return b.Add(NewEntry(key, Value(value)))
+}
+
+// Delete adds the deletion of the key to the Batch.
+func (b *Batch) Delete(key string) *Batch {
+	return b.Add(NewEntry(key, Deleted))
+}
+
+// Add adds an entry to the Batch.
+func (b *Batch) Add(entry DBFileEntry) *Batch {
+	b.entries = append(b.entries, entry)
+	return b
+}
+
+// Len returns the number of entries in the Batch.
+func (b *Batch) Len() int {
+	return len(b.entries)
+}
+
+// Entries returns the entries in the Batch, in the order they will be written.
+func (b *Batch) Entries() []DBFileEntry {
+	return b.entries
+}
@@ -0,0 +1,43 @@
+package file_test
+
+import (
+	"testing"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatch_CollectsEntriesInOrder(t *testing.T) {
+	b := file.NewBatch().Write("a", "1").Delete("b").Add(file.NewEntry("c", file.Value("3")))
+
+	require.Equal(t, 3, b.Len())
+	assert.True(t, b.Entries()[0].Equals(file.NewEntry("a", file.Value("1"))))
+	assert.True(t, b.Entries()[1].Equals(file.NewEntry("b", file.Deleted)))
+	assert.True(t, b.Entries()[2].Equals(file.NewEntry("c", file.Value("3"))))
+}
+
+func TestWriteBatch_WritesAllEntries(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("b", file.Value("old")))
+
+	err := d.WriteBatch(file.NewBatch().Write("a", "1").Delete("b").Write("c", "3"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "1", d.ReadEntry("a").Value())
+	assert.NotContains(t, d.Index, "b")
+	assert.Equal(t, "3", d.ReadEntry("c").Value())
+	assert.True(t, d.Verify().OK())
+}
+
+func TestWriteBatch_ReadOnlyReturnsErrReadOnly(t *testing.T) {
+	w, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d, err := file.Open(w.File.Name(), file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+
+	assert.Equal(t, file.ErrReadOnly, d.WriteBatch(file.NewBatch().Write("a", "1")))
+}
@@ -0,0 +1,373 @@
+package file
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultBucket is the id of the bucket that entries are in unless they are put in a named bucket.
+const DefaultBucket uint32 = 0
+
+// catalogBucket is the id of the bucket holding the catalog of named buckets. Each live bucket has an
+// entry in it whose key is the bucket's name and whose value is its id; dropping the bucket deletes the
+// entry.
+const catalogBucket uint32 = math.MaxUint32
+
+var (
+	// ErrNoBucket is returned when using a bucket that does not exist, or has been dropped.
+	ErrNoBucket = errors.New("bucket not found")
+
+	// ErrDefaultBucket is returned when attempting to drop the default bucket.
+	ErrDefaultBucket = errors.New("the default bucket cannot be dropped")
+)
+
+// bucketIndexes holds the indexes of a DBFile's named buckets, along with the catalog that names them.
+// The default bucket's index is kept separately, as the DBFile's Index.
+type bucketIndexes struct {
+	indexes map[uint32]DBIndex // The index of each live bucket, by id.
+	ids     map[string]uint32  // The id of each live bucket, by name.
+	catalog DBIndex            // The offset of each live bucket's catalog entry, by name.
+	next    uint32             // The id to give the next bucket created.
+
+	// The offsets of the merge operands to fold into each key's value, in every bucket. A key is present,
+	// even with no offsets, if its value is made up of merge operands.
+	operands map[bucketKey][]int64
+
+	sorted  map[uint32]*sortedKeys // The keys in the index of each bucket, including the default, in order.
+	expires map[bucketKey]int64    // When each indexed key that will expire does, as in DBFileEntry.
+
+	// The bytes taken by the entries that make up each indexed key's value, in every bucket and the
+	// catalog, and their total in each bucket, so that usage need not read them.
+	sizes map[bucketKey]int
+	live  map[uint32]int64
+
+	tombstones map[uint32]int // The number of tombstones in the log for each bucket, and the catalog.
+
+	generation int // The size of the entry marking the log's generation, if the log begins with one.
+}
+
+func newBucketIndexes() *bucketIndexes {
+	return &bucketIndexes{
+		indexes:  make(map[uint32]DBIndex),
+		ids:      make(map[string]uint32),
+		catalog:  make(DBIndex),
+		next:     DefaultBucket + 1,
+		operands: make(map[bucketKey][]int64),
+		sorted:   make(map[uint32]*sortedKeys),
+		expires:  make(map[bucketKey]int64),
+		sizes:    make(map[bucketKey]int),
+		live:     make(map[uint32]int64),
+
+		tombstones: make(map[uint32]int),
+	}
+}
+
+// resize records that the entries making up a key's value take n bytes, or if n is 0, that it has none.
+func (b *bucketIndexes) resize(k bucketKey, n int) {
+	b.live[k.bucket] += int64(n - b.sizes[k])
+	if n == 0 {
+		delete(b.sizes, k)
+	} else {
+		b.sizes[k] = n
+	}
+}
+
+// expiredBytes returns the bytes taken by the entries of the keys in each bucket that have expired, which
+// sizes still counts until they are removed.
+func (b *bucketIndexes) expiredBytes(now time.Time) map[uint32]int64 {
+	expired := make(map[uint32]int64)
+	for k, expires := range b.expires {
+		if expires <= now.UnixNano() {
+			expired[k.bucket] += int64(b.sizes[k])
+		}
+	}
+	return expired
+}
+
+// expired reports whether an indexed key has expired, without reading its entry. A key with merge
+// operands has a value even once the entry they are folded into expires.
+func (b *bucketIndexes) expired(bucket uint32, key string, now time.Time) bool {
+	expires, found := b.expires[bucketKey{bucket, key}]
+	return found && expires <= now.UnixNano()
+}
+
+// sortedKeys returns the sorted keys of the bucket with the given id.
+func (b *bucketIndexes) sortedKeys(bucket uint32) *sortedKeys {
+	if keys, found := b.sorted[bucket]; found {
+		return keys
+	}
+	return new(sortedKeys)
+}
+
+// sort adds a key newly in the index of the bucket with the given id to its sorted keys, or if it is no
+// longer in the index, removes it.
+func (b *bucketIndexes) sort(bucket uint32, key string, indexed bool) {
+	keys, found := b.sorted[bucket]
+	if !found {
+		keys = new(sortedKeys)
+		b.sorted[bucket] = keys
+	}
+	if indexed {
+		keys.add(key)
+	} else {
+		keys.remove(key)
+	}
+}
+
+// update updates the index of the entry's bucket, which is index if it is in the default bucket, given
+// the entry's offset and encoded size. Entries in buckets that have been dropped are ignored.
+func (b *bucketIndexes) update(index DBIndex, entry DBFileEntry, offset int64, size int) {
+	if entry.deleted {
+		b.tombstones[entry.bucket]++
+	}
+	switch entry.bucket {
+	case DefaultBucket:
+		b.apply(index, entry, offset, size)
+	case catalogBucket:
+		b.define(entry, offset, size)
+	case generationBucket:
+		if offset == 0 {
+			b.generation = size
+		}
+	default:
+		if bucket, found := b.indexes[entry.bucket]; found {
+			b.apply(bucket, entry, offset, size)
+		}
+	}
+}
+
+// define applies a catalog entry, which either creates a bucket or, if it is a tombstone, drops one.
+// Dropping a bucket discards its whole index at once; its entries stay in the log until it is compacted.
+func (b *bucketIndexes) define(entry DBFileEntry, offset int64, size int) {
+	if id, found := b.ids[entry.key]; found {
+		delete(b.indexes, id)
+		delete(b.sorted, id)
+		delete(b.live, id)
+		delete(b.ids, entry.key)
+		b.catalog.Remove(entry.key)
+		b.resize(bucketKey{catalogBucket, entry.key}, 0)
+		for k := range b.sizes {
+			if k.bucket == id {
+				delete(b.sizes, k)
+			}
+		}
+		for k := range b.operands {
+			if k.bucket == id {
+				delete(b.operands, k)
+			}
+		}
+		for k := range b.expires {
+			if k.bucket == id {
+				delete(b.expires, k)
+			}
+		}
+	}
+	if entry.deleted {
+		return
+	}
+
+	id, ok := catalogID(entry)
+	if !ok {
+		return
+	}
+	b.indexes[id] = make(DBIndex)
+	b.ids[entry.key] = id
+	b.catalog[entry.key] = offset
+	b.resize(bucketKey{catalogBucket, entry.key}, size)
+	// Ids are never reused while entries with them may remain in the log.
+	if id >= b.next {
+		b.next = id + 1
+	}
+}
+
+// catalogID returns the bucket id a catalog entry defines, and false if it does not define a valid one.
+func catalogID(entry DBFileEntry) (uint32, bool) {
+	id, err := strconv.ParseUint(entry.value, 10, 32)
+	if err != nil || entry.deleted || uint32(id) == DefaultBucket || uint32(id) == catalogBucket {
+		return 0, false
+	}
+	return uint32(id), true
+}
+
+// offsets appends the offset of every live entry in the named buckets and the catalog, and of every merge
+// operand in any bucket, to offsets.
+func (b *bucketIndexes) offsets(offsets []int64) []int64 {
+	for _, offset := range b.catalog {
+		offsets = append(offsets, offset)
+	}
+	for _, index := range b.indexes {
+		for _, offset := range index {
+			offsets = append(offsets, offset)
+		}
+	}
+	for _, operands := range b.operands {
+		offsets = append(offsets, operands...)
+	}
+	return offsets
+}
+
+// keys returns the number of keys in the named buckets.
+func (b *bucketIndexes) keys() int {
+	n := 0
+	for _, index := range b.indexes {
+		n += len(index)
+	}
+	return n
+}
+
+// A Bucket is a namespace within a DBFile. Keys in different buckets are independent of each other, and
+// each bucket has its own index. Entries record the id of their bucket rather than its name, so a
+// bucket's keys take no more space than those in the default bucket.
+type Bucket struct {
+	d    *DBFile
+	id   uint32
+	name string
+}
+
+// Bucket returns the bucket with the given name, creating it if it does not exist. The empty name is
+// the default bucket, which holds the entries written directly to the DBFile. A read-only DBFile cannot
+// create buckets, and returns ErrNoBucket instead.
+func (d *DBFile) Bucket(name string) (*Bucket, error) {
+	if name == "" {
+		return &Bucket{d: d}, nil
+	}
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	id, found := d.buckets.ids[name]
+	d.mu.RUnlock()
+	if found {
+		return &Bucket{d, id, name}, nil
+	}
+	if d.readOnly {
+		return nil, ErrNoBucket
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if id, found := d.buckets.ids[name]; found {
+		return &Bucket{d, id, name}, nil
+	}
+	id = d.buckets.next
+	if err := d.appendEntry(NewEntry(name, Value(strconv.FormatUint(uint64(id), 10)), InBucket(catalogBucket))); err != nil {
+		return nil, err
+	}
+	return &Bucket{d, id, name}, nil
+}
+
+// DropBucket deletes a bucket and every key in it with a single entry, discarding the bucket's index.
+// The space its entries take is reclaimed the next time the DBFile is compacted. Creating a bucket with
+// the same name afterwards creates a new, empty bucket.
+func (d *DBFile) DropBucket(name string) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if name == "" {
+		return ErrDefaultBucket
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, found := d.buckets.ids[name]; !found {
+		return ErrNoBucket
+	}
+	return d.appendEntry(NewEntry(name, Deleted, InBucket(catalogBucket)))
+}
+
+// Buckets returns the DBFile's named buckets, in order of name.
+func (d *DBFile) Buckets() []*Bucket {
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	buckets := make([]*Bucket, 0, len(d.buckets.ids))
+	for name, id := range d.buckets.ids {
+		buckets = append(buckets, &Bucket{d, id, name})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].name < buckets[j].name })
+	return buckets
+}
+
+// indexOf returns the index of a bucket. It returns ErrNoBucket if the bucket has been dropped. The
+// caller must hold the DBFile's lock.
+func (d *DBFile) indexOf(b *Bucket) (DBIndex, error) {
+	if b.id == DefaultBucket {
+		return d.Index, nil
+	}
+	if id, found := d.buckets.ids[b.name]; !found || id != b.id {
+		return nil, ErrNoBucket
+	}
+	return d.buckets.indexes[b.id], nil
+}
+
+// Name returns the bucket's name, which is empty for the default bucket.
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// ID returns the id with which the bucket's entries are recorded.
+func (b *Bucket) ID() uint32 {
+	return b.id
+}
+
+// WriteEntry writes an entry to the bucket. It returns ErrNoBucket if the bucket has been dropped.
+func (b *Bucket) WriteEntry(entry DBFileEntry) (DBFileEntry, error) {
+	entry.bucket = b.id
+	return b.d.writeEntry(b, entry, nil, false)
+}
+
+// Merge writes a merge operand to the bucket and returns the key's value, as DBFile.Merge does for the
+// default bucket.
+func (b *Bucket) Merge(entry DBFileEntry) (DBFileEntry, error) {
+	entry.bucket = b.id
+	return b.d.writeEntry(b, entry, nil, true)
+}
+
+// DeleteEntry deletes the key from the bucket.
+func (b *Bucket) DeleteEntry(key string) (DBFileEntry, error) {
+	return b.WriteEntry(NewEntry(key, Deleted))
+}
+
+// Get retrieves the entry for a key in the bucket, as DBFile.Get does for the default bucket.
+func (b *Bucket) Get(key string) (DBFileEntry, error) {
+	return b.d.get(b, key)
+}
+
+// Scan returns entries from the bucket, as DBFile.Scan does for the default bucket.
+func (b *Bucket) Scan(from, to string, limit int) ([]DBFileEntry, error) {
+	return b.d.scan(b, from, to, limit)
+}
+
+// Usage reports how much of the DBFile's log the bucket's live entries take. Size and Appended are those
+// of the whole DBFile.
+func (b *Bucket) Usage() (Usage, error) {
+	d := b.d
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	index, err := d.indexOf(b)
+	if err != nil {
+		return Usage{}, err
+	}
+	return Usage{
+		Size:       d.Offset,
+		Keys:       len(index),
+		LiveBytes:  d.buckets.live[b.id] - d.buckets.expiredBytes(time.Now())[b.id],
+		Appended:   d.appended,
+		Tombstones: d.buckets.tombstones[b.id],
+	}, nil
+}
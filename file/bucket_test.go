@@ -0,0 +1,129 @@
+package file_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucket_KeysAreIndependent(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	users, err := d.Bucket("users")
+	require.NoError(t, err)
+	assert.NotEqual(t, file.DefaultBucket, users.ID())
+
+	d.WriteEntry(file.NewEntry("a", file.Value("default")))
+	users.WriteEntry(file.NewEntry("a", file.Value("users")))
+	users.WriteEntry(file.NewEntry("b", file.Value("users")))
+
+	entry, err := d.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "default", entry.Value())
+	entry, err = users.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "users", entry.Value())
+	assert.Equal(t, users.ID(), entry.Bucket())
+
+	_, err = d.Get("b")
+	assert.Equal(t, file.ErrNotFound, err)
+	entries, err := users.Scan("", "", 0)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Len(t, d.Index, 1)
+
+	same, err := d.Bucket("users")
+	require.NoError(t, err)
+	assert.Equal(t, users.ID(), same.ID())
+	assert.Equal(t, []*file.Bucket{users}, d.Buckets())
+}
+
+func TestBucket_SurvivesReopening(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	users, _ := d.Bucket("users")
+	users.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.Close()
+
+	d, err := file.Open("file_test.dat", file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+	users, err = d.Bucket("users")
+	require.NoError(t, err)
+	entry, err := users.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", entry.Value())
+
+	_, err = d.Bucket("missing")
+	assert.Equal(t, file.ErrNoBucket, err)
+	assert.True(t, d.Verify().OK())
+}
+
+func TestDropBucket(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	users, _ := d.Bucket("users")
+	users.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(file.NewEntry("a", file.Value("default")))
+	before := d.CurrentOffset()
+
+	require.NoError(t, d.DropBucket("users"))
+	assert.Equal(t, int64(16), d.CurrentOffset()-before, "dropping writes a single catalog entry")
+	assert.Empty(t, d.Buckets())
+	_, err := users.Get("a")
+	assert.Equal(t, file.ErrNoBucket, err)
+	_, err = users.WriteEntry(file.NewEntry("a", file.Value("2")))
+	assert.Equal(t, file.ErrNoBucket, err)
+	assert.Equal(t, file.ErrNoBucket, d.DropBucket("users"))
+	assert.Equal(t, file.ErrDefaultBucket, d.DropBucket(""))
+
+	recreated, err := d.Bucket("users")
+	require.NoError(t, err)
+	assert.NotEqual(t, users.ID(), recreated.ID())
+	_, err = recreated.Get("a")
+	assert.Equal(t, file.ErrNotFound, err)
+	assert.True(t, d.Verify().OK())
+
+	recreated.WriteEntry(file.NewEntry("b", file.Value("2")))
+	_, err = d.Compact()
+	require.NoError(t, err)
+	entry, err := recreated.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", entry.Value())
+	u, err := d.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, u.Size, u.LiveBytes)
+	assert.Equal(t, 2, u.Keys)
+}
+
+func TestBucket_WriteBatchAndText(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	users, _ := d.Bucket("users")
+	require.NoError(t, d.WriteBatch(file.NewBatch().
+		Write("@a", "default").
+		Add(file.NewEntry("a", file.Value("users"), file.InBucket(users.ID())))))
+	err := d.WriteBatch(file.NewBatch().Add(file.NewEntry("a", file.Value("x"), file.InBucket(99))))
+	assert.Equal(t, file.ErrNoBucket, err)
+
+	text := new(bytes.Buffer)
+	_, err = d.WriteText(text)
+	require.NoError(t, err)
+	assert.Contains(t, text.String(), `\@a:default`)
+	assert.Contains(t, text.String(), "@1:a:users")
+
+	entry, err := file.ParseText("@1:a")
+	require.NoError(t, err)
+	assert.True(t, entry.Equals(file.NewEntry("a", file.Deleted, file.InBucket(1))))
+	entry, err = file.ParseText(`\@a:default`)
+	require.NoError(t, err)
+	assert.Equal(t, "@a", entry.Key())
+	assert.Equal(t, file.DefaultBucket, entry.Bucket())
+}
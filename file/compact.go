@@ -0,0 +1,152 @@
+package file
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// A CompactReport describes the outcome of compacting a DBFile.
+type CompactReport struct {
+	Before  int64 `json:"before"`  // The size of the log before compacting, in bytes.
+	After   int64 `json:"after"`   // The size of the log after compacting, in bytes.
+	Entries int   `json:"entries"` // The number of entries kept.
+}
+
+// String summarizes the CompactReport in a single line.
+func (r *CompactReport) String() string {
+	return fmt.Sprintf("compacted %d bytes to %d, keeping %d entries", r.Before, r.After, r.Entries)
+}
+
+// Compact rewrites the DBFile's log so that it holds only the latest entry for each live key, leaving
+// out overwritten, deleted and expired entries, and folding merge operands into their keys' values. The
+// live entries are copied without holding the DBFile's lock; only the entries written in the meantime
+// are copied once it is taken, after which the new log replaces the old one.
+//
+// Compaction changes the offsets of entries, so it begins a new generation of the log; see Generation.
+// Offsets taken before it, such as those of replicas, watchers and backups, do not apply to the compacted
+// log: ReadLog returns ErrCompacted for them and watchers stop with it, replicas must be re-seeded,
+// incremental backups must start from a new base, and other processes following the DBFile must reopen
+// it. The old file is closed once the last reader still using it finishes. It returns ErrReadOnly if the
+// DBFile was opened read-only.
+func (d *DBFile) Compact() (report *CompactReport, err error) {
+	if d.readOnly {
+		return nil, ErrReadOnly
+	}
+	d.compactMu.Lock()
+	defer d.compactMu.Unlock()
+
+	d.mu.RLock()
+	src, end := d.File, d.Offset
+	offsets, folded := d.folded()
+	d.mu.RUnlock()
+
+	start := time.Now()
+	d.compactionStarted(CompactionEvent{Path: src.Name(), Size: end})
+	defer func() {
+		d.compactionEnded(CompactionEvent{Path: src.Name(), Size: end, Report: report, Duration: time.Since(start), Err: err})
+	}()
+
+	ranges, err := liveRanges(src, end, offsets)
+	if err != nil {
+		return nil, err
+	}
+
+	path := src.Name()
+	tmp := path + ".compact"
+	os.Remove(tmp)
+	out, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if out != nil {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	w := bufio.NewWriterSize(out, BufferSize)
+	enc := NewEncoder(w)
+	generation, err := newGeneration()
+	if err != nil {
+		return nil, err
+	}
+	size, err := enc.Encode(generation)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range ranges {
+		n, err := io.Copy(w, io.NewSectionReader(src, r.Start, r.Len()))
+		if err != nil {
+			return nil, err
+		}
+		size += int(n)
+	}
+	// Keys with merge operands are written with their operands folded in, replacing their entries.
+	for _, entry := range folded {
+		n, err := enc.Encode(entry)
+		if err != nil {
+			return nil, err
+		}
+		size += n
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Everything written since the snapshot is newer than what has been copied, so it is kept as it is.
+	tail, err := io.Copy(w, io.NewSectionReader(src, end, d.Offset-end))
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	if err := out.Sync(); err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	out = nil
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	// The compacted file is opened by its final name, which is the name it reports.
+	compacted, err := openFile(path, d.flag())
+	if err != nil {
+		return nil, err
+	}
+	index, buckets := make(DBIndex), newBucketIndexes()
+	offset := indexFrom(compacted, index, buckets, 0)
+
+	report = &CompactReport{Before: d.Offset, After: offset, Entries: len(ranges) + len(folded)}
+	report.Entries += countEntries(io.NewSectionReader(compacted, int64(size), tail))
+
+	d.File, d.Index, d.buckets, d.Offset = compacted, index, buckets, offset
+	d.moveToEnd()
+	d.generationMu.Lock()
+	d.generation = ""
+	d.generationMu.Unlock()
+	d.retire(src)
+	d.broadcast()
+	return report, nil
+}
+
+// countEntries returns the number of complete entries in r.
+func countEntries(r io.Reader) int {
+	dec := NewDecoder(bufio.NewReaderSize(r, BufferSize))
+	count := 0
+	for {
+		var entry DBFileEntry
+		if _, err := dec.Decode(&entry); err != nil {
+			return count
+		}
+		count++
+	}
+}
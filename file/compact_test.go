@@ -0,0 +1,133 @@
+package file_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompact_KeepsOnlyLiveEntries(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(file.NewEntry("b", file.Value("x")))
+	d.WriteEntry(file.NewEntry("a", file.Value("2")))
+	d.DeleteEntry("b")
+	d.WriteEntry(file.NewEntry("c", file.Value("3")))
+	d.WriteEntry(file.NewEntry("gone", file.Value("x"), file.TTL(time.Millisecond)))
+	time.Sleep(5 * time.Millisecond)
+	before := d.CurrentOffset()
+
+	report, err := d.Compact()
+	require.NoError(t, err)
+	assert.Equal(t, before, report.Before)
+	assert.Equal(t, 2, report.Entries)
+
+	want := EncodeEntries(
+		file.NewEntry("a", file.Value("2")),
+		file.NewEntry("c", file.Value("3")),
+	)
+	got, err := ioutil.ReadFile(d.File.Name())
+	require.NoError(t, err)
+	// The compacted log begins with an entry marking its new generation.
+	require.Greater(t, len(got), len(want))
+	assert.Equal(t, want, got[len(got)-len(want):])
+	assert.Equal(t, int64(len(got)), report.After)
+	assert.Equal(t, report.After, d.CurrentOffset())
+
+	assert.Equal(t, "2", d.ReadEntry("a").Value())
+	_, err = d.Get("b")
+	assert.Equal(t, file.ErrNotFound, err)
+
+	// The compacted file is written to, and reopens, like any other.
+	d.WriteEntry(file.NewEntry("d", file.Value("4")))
+	assert.Equal(t, "4", d.ReadEntry("d").Value())
+	reopened, err := file.Open(d.File.Name(), file.ReadOnly)
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.Equal(t, "2", reopened.ReadEntry("a").Value())
+	assert.Equal(t, "4", reopened.ReadEntry("d").Value())
+}
+
+func TestCompact_ReadOnlyReturnsErrReadOnly(t *testing.T) {
+	w, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d, err := file.Open(w.File.Name(), file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+
+	_, err = d.Compact()
+	assert.Equal(t, file.ErrReadOnly, err)
+}
+
+func TestCompact_StartsNewGeneration(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	assert.Empty(t, d.Generation())
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	before := d.Generation()
+	assert.NotEmpty(t, before)
+	d.WriteEntry(file.NewEntry("a", file.Value("2")))
+	assert.Equal(t, before, d.Generation(), "the generation stays the same as the log grows")
+
+	w := d.Watch(context.Background(), "", d.CurrentOffset())
+	defer w.Stop()
+	_, err := d.Compact()
+	require.NoError(t, err)
+	after := d.Generation()
+	assert.NotEqual(t, before, after)
+
+	_, _, err = d.ReadLog(before, 0, 1024)
+	assert.Equal(t, file.ErrCompacted, err)
+	_, _, err = d.ReadLog(after, 0, 1024)
+	assert.NoError(t, err)
+	for range w.Events() {
+	}
+	assert.Equal(t, file.ErrCompacted, w.Err())
+
+	// Compacting again begins yet another generation, even though the live entries are the same.
+	_, err = d.Compact()
+	require.NoError(t, err)
+	assert.NotEqual(t, after, d.Generation())
+	assert.Equal(t, "2", d.ReadEntry("a").Value())
+	assert.True(t, d.Verify().OK())
+}
+
+func TestCompact_KeepsOldFileOpenForReaders(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	for i := 0; i < 100; i++ {
+		d.WriteEntry(file.NewEntry("a", file.Value(strings.Repeat("x", 100))))
+	}
+
+	// A backup still copying the old file when compaction replaces it finishes its copy.
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := d.Backup(w)
+		w.CloseWithError(err)
+		done <- err
+	}()
+	head := make([]byte, 10)
+	_, err := io.ReadFull(r, head)
+	require.NoError(t, err)
+	_, err = d.Compact()
+	require.NoError(t, err)
+	rest, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+
+	info, err := file.ReadBackupInfo(bytes.NewReader(append(head, rest...)))
+	require.NoError(t, err)
+	assert.Greater(t, info.Bytes, int64(100*100))
+}
@@ -0,0 +1,36 @@
+package file
+
+import "errors"
+
+// ErrConditionFailed is returned by WriteIf when a key's current entry does not meet the condition.
+var ErrConditionFailed = errors.New("condition not met")
+
+// A Condition decides whether a conditional write goes ahead, given the key's current entry, and whether
+// there is one. An expired entry counts as no entry at all.
+type Condition func(current DBFileEntry, found bool) bool
+
+// IfAbsent is a Condition that holds if the key does not exist.
+func IfAbsent(current DBFileEntry, found bool) bool {
+	return !found
+}
+
+// IfValue returns a Condition that holds if the key exists and has the given value.
+func IfValue(value string) Condition {
+	return func(current DBFileEntry, found bool) bool {
+		return found && current.value == value
+	}
+}
+
+// WriteIf writes an entry, as WriteEntry does, but only if cond holds for the key's current entry,
+// returning ErrConditionFailed otherwise. The check and the write happen under the DBFile's write lock,
+// so no other write to the DBFile can come between them.
+func (d *DBFile) WriteIf(entry DBFileEntry, cond Condition) (DBFileEntry, error) {
+	return d.writeEntry(nil, entry, cond, false)
+}
+
+// WriteIf writes an entry to the bucket if cond holds for the key's current entry in the bucket, as
+// DBFile.WriteIf does for the default bucket.
+func (b *Bucket) WriteIf(entry DBFileEntry, cond Condition) (DBFileEntry, error) {
+	entry.bucket = b.id
+	return b.d.writeEntry(b, entry, cond, false)
+}
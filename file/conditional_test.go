@@ -0,0 +1,60 @@
+package file_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteIf_ChecksCurrentEntry(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	_, err := d.WriteIf(file.NewEntry("a", file.Value("1")), file.IfAbsent)
+	require.NoError(t, err)
+	offset := d.CurrentOffset()
+	_, err = d.WriteIf(file.NewEntry("a", file.Value("2")), file.IfAbsent)
+	assert.Equal(t, file.ErrConditionFailed, err)
+	_, err = d.WriteIf(file.NewEntry("a", file.Value("2")), file.IfValue("x"))
+	assert.Equal(t, file.ErrConditionFailed, err)
+	assert.Equal(t, offset, d.CurrentOffset(), "a failed condition writes nothing")
+
+	_, err = d.WriteIf(file.NewEntry("a", file.Value("2")), file.IfValue("1"))
+	require.NoError(t, err)
+	entry, _ := d.Get("a")
+	assert.Equal(t, "2", entry.Value())
+
+	_, err = d.WriteIf(file.NewEntry("a", file.Deleted), file.IfValue("2"))
+	require.NoError(t, err)
+	_, err = d.WriteIf(file.NewEntry("a", file.Deleted), file.IfValue(""))
+	assert.Equal(t, file.ErrConditionFailed, err, "a missing key has no value")
+}
+
+func TestWriteIf_ExpiredEntryIsAbsent(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("lock", file.Value("owner"), file.TTL(time.Nanosecond)))
+	time.Sleep(time.Millisecond)
+	_, err := d.WriteIf(file.NewEntry("lock", file.Value("next")), file.IfAbsent)
+	assert.NoError(t, err)
+}
+
+func TestBucket_WriteIf(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	users, _ := d.Bucket("users")
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	_, err := users.WriteIf(file.NewEntry("a", file.Value("1")), file.IfAbsent)
+	require.NoError(t, err, "the key exists only in the default bucket")
+	_, err = users.WriteIf(file.NewEntry("a", file.Value("2")), file.IfValue("1"))
+	require.NoError(t, err)
+	_, err = d.WriteIf(file.NewEntry("a", file.Value("3"), file.InBucket(users.ID())), file.IfValue("2"))
+	require.NoError(t, err)
+	entry, _ := users.Get("a")
+	assert.Equal(t, "3", entry.Value())
+}
@@ -0,0 +1,188 @@
+package file
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// A DumpOption is an optional setting you may provide to Dump.
+type DumpOption func(*dumpOptions)
+
+type dumpOptions struct {
+	start, end  int64
+	prefix      string
+	hex         bool
+	valueLength int
+}
+
+// DumpRange is a DumpOption that dumps only the records that begin in [start, end). An end of 0 or less
+// means the end of the file. Dump assumes a record begins at start; if it does not, the bytes up to the
+// next record are shown as undecodable.
+func DumpRange(start, end int64) DumpOption {
+	return func(o *dumpOptions) {
+		o.start, o.end = start, end
+	}
+}
+
+// DumpKey is a DumpOption that dumps only the records whose keys begin with prefix. Undecodable regions
+// are still shown, since there is no telling which keys they hold.
+func DumpKey(prefix string) DumpOption {
+	return func(o *dumpOptions) {
+		o.prefix = prefix
+	}
+}
+
+// DumpHex is a DumpOption that follows each undecodable region with a hex dump of its bytes.
+func DumpHex(o *dumpOptions) {
+	o.hex = true
+}
+
+// DumpValueLength is a DumpOption that sets the most bytes of each value that Dump shows, PreviewLength
+// by default. A length less than 0 shows whole values.
+func DumpValueLength(n int) DumpOption {
+	return func(o *dumpOptions) {
+		o.valueLength = n
+	}
+}
+
+// A DumpReport describes what Dump found.
+type DumpReport struct {
+	Records     int     `json:"records"` // The records in the range dumped, whether or not they were shown.
+	Shown       int     `json:"shown"`   // The records that matched the key filter.
+	Undecodable []Range `json:"undecodable"`
+}
+
+// String presents the DumpReport as human-readable text.
+func (r *DumpReport) String() string {
+	var bytes int64
+	for _, u := range r.Undecodable {
+		bytes += u.Len()
+	}
+	return fmt.Sprintf("%d records, %d shown; %d undecodable bytes in %d ranges", r.Records, r.Shown, bytes,
+		len(r.Undecodable))
+}
+
+// Dump writes a line of text to w for each record in the DBFile at path, in the order they appear in the
+// file, giving its offset, its encoded length in bytes, its tombstone bit, the length of its key, the key,
+// the length of its value and the value, truncated to PreviewLength bytes, and then its bucket, unless it is
+// in the default bucket. Regions that cannot be decoded are shown too, where they occur. Dump reads the file
+// directly, without opening it as a DBFile, so it can be used on a file that is damaged, or that another
+// process is writing to.
+func Dump(w io.Writer, path string, option ...DumpOption) (*DumpReport, error) {
+	opts := dumpOptions{valueLength: PreviewLength}
+	for _, o := range option {
+		o(&opts)
+	}
+
+	in, err := openFile(path, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size, end := info.Size(), info.Size()
+	if opts.end > 0 && opts.end < end {
+		end = opts.end
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%10s %6s %3s %5s %-24s %6s %s\n", "OFFSET", "LEN", "DEL", "KLEN", "KEY", "VLEN", "VALUE")
+
+	report := &DumpReport{Undecodable: make([]Range, 0)}
+	var entry DBFileEntry
+	for offset := opts.start; offset < end; {
+		n, err := decodeAt(in, offset, size, &entry)
+		if err != nil {
+			next := resync(in, offset+1, size)
+			r := Range{offset, next}
+			report.Undecodable = append(report.Undecodable, r)
+			fmt.Fprintf(bw, "%10d %6d undecodable\n", r.Start, r.Len())
+			if opts.hex {
+				if err := hexDump(bw, io.NewSectionReader(in, r.Start, r.Len()), r.Start); err != nil {
+					return nil, err
+				}
+			}
+			offset = next
+			continue
+		}
+
+		report.Records++
+		if strings.HasPrefix(entry.key, opts.prefix) {
+			report.Shown++
+			dumpRecord(bw, offset, n, entry, opts.valueLength)
+		}
+		offset += int64(n)
+	}
+
+	fmt.Fprintln(bw, report)
+	return report, bw.Flush()
+}
+
+// dumpRecord writes the line describing one record.
+func dumpRecord(w io.Writer, offset int64, n int, entry DBFileEntry, valueLength int) {
+	deleted := 0
+	if entry.deleted {
+		deleted = 1
+	}
+	fmt.Fprintf(w, "%10d %6d %3d %5d %-24q ", offset, n, deleted, len(entry.key), entry.key)
+	if entry.deleted {
+		fmt.Fprintf(w, "%6s", "-")
+	} else {
+		value := entry.value
+		if valueLength >= 0 && len(value) > valueLength {
+			value = value[:valueLength]
+		}
+		fmt.Fprintf(w, "%6d %q", len(entry.value), value)
+		if len(value) < len(entry.value) {
+			io.WriteString(w, "...")
+		}
+	}
+	if expires := entry.Expires(); !expires.IsZero() {
+		fmt.Fprintf(w, " expires %s", expires.Format(time.RFC3339))
+	}
+	switch entry.bucket {
+	case DefaultBucket:
+	case catalogBucket:
+		io.WriteString(w, " catalog")
+	default:
+		fmt.Fprintf(w, " bucket %d", entry.bucket)
+	}
+	if entry.operator != "" {
+		fmt.Fprintf(w, " merge %s", entry.operator)
+	}
+	io.WriteString(w, "\n")
+}
+
+// hexDump writes the bytes in r as lines of 16, each giving the offset in the file of its first byte, the
+// bytes in hex and those that are printable as ASCII, in the manner of hexdump -C.
+func hexDump(w io.Writer, r io.Reader, offset int64) error {
+	line := make([]byte, 16)
+	for {
+		n, err := io.ReadFull(r, line)
+		if n > 0 {
+			fmt.Fprintf(w, "%10s %08x  %-48s |", "", offset, fmt.Sprintf("% x", line[:n]))
+			for _, b := range line[:n] {
+				if b < ' ' || b > '~' {
+					b = '.'
+				}
+				w.Write([]byte{b})
+			}
+			io.WriteString(w, "|\n")
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package file_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDump_ListsEveryRecord(t *testing.T) {
+	long := strings.Repeat("x", file.PreviewLength+10)
+	path, cleanup := WriteVerifyTestDat(t, EncodeEntries(
+		file.NewEntry("a", file.Value("1")),
+		file.NewEntry("b", file.Value(long)),
+		file.NewEntry("a", file.Deleted),
+	))
+	defer cleanup()
+
+	out := new(bytes.Buffer)
+	report, err := file.Dump(out, path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Records)
+	assert.Equal(t, 3, report.Shown)
+	assert.Empty(t, report.Undecodable)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal(t, []string{"OFFSET", "LEN", "DEL", "KLEN", "KEY", "VLEN", "VALUE"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"0", "11", "0", "1", `"a"`, "1", `"1"`}, strings.Fields(lines[1]))
+	assert.Contains(t, lines[2], `"`+long[:file.PreviewLength]+`"...`)
+	assert.Equal(t, []string{"95", "8", "1", "1", `"a"`, "-"}, strings.Fields(lines[3]))
+	assert.Equal(t, "3 records, 3 shown; 0 undecodable bytes in 0 ranges", lines[4])
+}
+
+func TestDump_Options(t *testing.T) {
+	content := EncodeEntries(
+		file.NewEntry("a", file.Value("1")),
+		file.NewEntry("b", file.Value("2")),
+		file.NewEntry("ab", file.Value("3")),
+	)
+	path, cleanup := WriteVerifyTestDat(t, content)
+	defer cleanup()
+
+	out := new(bytes.Buffer)
+	report, err := file.Dump(out, path, file.DumpKey("a"), file.DumpRange(11, 0))
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Records)
+	assert.Equal(t, 1, report.Shown)
+	assert.Contains(t, out.String(), `"ab"`)
+
+	report, err = file.Dump(new(bytes.Buffer), path, file.DumpRange(0, 12))
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Records, "a record beginning before the end of the range is included")
+
+	out.Reset()
+	_, err = file.Dump(out, path, file.DumpValueLength(0))
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), ` 1 ""...`)
+}
+
+func TestDump_ShowsUndecodableRegions(t *testing.T) {
+	garbage := []byte{0x07, 0x00, 0xff, 0xff}
+	content := append(append(EncodeEntries(file.NewEntry("before", file.Value("1"))), garbage...),
+		EncodeEntries(file.NewEntry("after", file.Value("2")))...)
+	path, cleanup := WriteVerifyTestDat(t, content)
+	defer cleanup()
+
+	out := new(bytes.Buffer)
+	report, err := file.Dump(out, path, file.DumpHex)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Records)
+	assert.Equal(t, []file.Range{{16, 20}}, report.Undecodable)
+	assert.Contains(t, out.String(), "        16      4 undecodable\n")
+	assert.Contains(t, out.String(), "00000010  07 00 ff ff")
+	assert.Contains(t, out.String(), "|....|")
+	assert.Contains(t, out.String(), "4 undecodable bytes in 1 ranges")
+}
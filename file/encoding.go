@@ -1,10 +1,26 @@
 package file
 
 import (
+	"bytes"
+	"compress/flate"
 	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
+	"math"
+	"strings"
 )
 
+// MaxLength is the longest key, value or merge operator name an entry may have, in bytes, since each is
+// stored with a 16-bit length.
+const MaxLength = math.MaxInt16
+
+// ErrTooLarge is returned when writing an entry whose key, value or merge operator name is longer than
+// MaxLength. Nothing is written.
+var ErrTooLarge = errors.New("entry is too large")
+
 // An StringEncoderFunc is the signature for a function that can be used to encode a string into its binary
 // format.
 type StringEncoderFunc func(string) (int, error)
@@ -12,45 +28,113 @@ type StringEncoderFunc func(string) (int, error)
 // A BoolEncoderFunc is the signature for a focution that can be used to mark a record as tombstoned.
 type BoolEncoderFunc func(bool) (int, error)
 
+// An Int64EncoderFunc is the signature for a function that can be used to encode an int64 into its binary
+// format.
+type Int64EncoderFunc func(int64) (int, error)
+
+// A Uint32EncoderFunc is the signature for a function that can be used to encode a uint32 into its binary
+// format.
+type Uint32EncoderFunc func(uint32) (int, error)
+
+// The flags that begin each encoded entry. An entry written before flags were introduced began with a
+// bool marking it deleted, which is the same as a flags byte with only flagDeleted set.
+const (
+	flagDeleted    byte = 1 << iota // The entry is a tombstone.
+	flagExpires                     // The entry's expiry time follows the flags.
+	flagBucket                      // The id of the entry's bucket follows the flags and any expiry time.
+	flagCompressed                  // The entry's value is compressed with DEFLATE.
+	flagMerge                       // The entry is a merge operand; its operator's name follows the key.
+	flagChecksum                    // A CRC-32 of the rest of the entry follows its value.
+
+	knownFlags = flagDeleted | flagExpires | flagBucket | flagCompressed | flagMerge | flagChecksum
+)
+
+// checksumSize is the size of the checksum that ends an entry written with flagChecksum.
+const checksumSize = 4
+
 // An Encoder encodes DBFileEntry objects.
 type Encoder struct {
+	w   io.Writer
+	buf bytes.Buffer
 	enc StringEncoderFunc
-	tmb BoolEncoderFunc
+	i64 Int64EncoderFunc
+	u32 Uint32EncoderFunc
 }
 
-// NewEncoder creates a new Encoder that will write entries to a writer.
+// NewEncoder creates a new Encoder that will write entries to a writer. Each entry is written with a
+// single call to the writer's Write method, ending with a checksum of the entry.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{
-		enc: BuildStringEncoderFunc(w),
-		tmb: BuildBoolEncoderFunc(w),
-	}
+	e := &Encoder{w: w}
+	e.enc = BuildStringEncoderFunc(&e.buf)
+	e.i64 = BuildInt64EncoderFunc(&e.buf)
+	e.u32 = BuildUint32EncoderFunc(&e.buf)
+	return e
 }
 
 // Encode encodes a DBFileEntry to a binary format and writes it to the Encoder's underlying writer.
 func (e *Encoder) Encode(entry DBFileEntry) (n int, err error) {
-	var (
-		nT, nK, nV int
-	)
+	// An entry that cannot be encoded is rejected before any of it is written, so that a writer appending
+	// straight to a log never leaves part of one behind.
+	if len(entry.key) > MaxLength || len(entry.value) > MaxLength || len(entry.operator) > MaxLength {
+		return 0, ErrTooLarge
+	}
 
-	nT, err = e.tmb(entry.deleted)
-	if err != nil {
-		return 0, err
+	flags, value := entry.flags()|flagChecksum, entry.value
+	if flags&flagCompressed != 0 {
+		// A value that does not shrink is stored as it is.
+		if compressed, ok := compress(value); ok {
+			value = compressed
+		} else {
+			flags &^= flagCompressed
+		}
 	}
 
-	nK, err = e.enc(entry.key)
-	if err != nil {
-		return 0, err
+	// The entry is built up in a buffer, so that its checksum can be taken and it can be written at once.
+	e.buf.Reset()
+	e.buf.WriteByte(flags)
+
+	if entry.expires != 0 {
+		e.i64(entry.expires)
+	}
+
+	if entry.bucket != DefaultBucket {
+		e.u32(entry.bucket)
+	}
+
+	e.enc(entry.key)
+
+	if flags&flagMerge != 0 {
+		e.enc(entry.operator)
 	}
 
 	// If the record has been deleted, then we don't save the value since that would be a waste of space.
 	if !entry.deleted {
-		nV, err = e.enc(entry.value)
-		if err != nil {
+		e.enc(value)
+	}
+
+	binary.Write(&e.buf, binary.BigEndian, crc32.ChecksumIEEE(e.buf.Bytes()))
+
+	return e.w.Write(e.buf.Bytes())
+}
+
+// BuildInt64EncoderFunc creates an Int64EncoderFunc that will write to a specified io.Writer.
+func BuildInt64EncoderFunc(w io.Writer) Int64EncoderFunc {
+	return func(i int64) (int, error) {
+		if err := binary.Write(w, binary.BigEndian, i); err != nil {
 			return 0, err
 		}
+		return binary.Size(i), nil
 	}
+}
 
-	return nT + nK + nV, nil
+// BuildUint32EncoderFunc creates a Uint32EncoderFunc that will write to a specified io.Writer.
+func BuildUint32EncoderFunc(w io.Writer) Uint32EncoderFunc {
+	return func(i uint32) (int, error) {
+		if err := binary.Write(w, binary.BigEndian, i); err != nil {
+			return 0, err
+		}
+		return binary.Size(i), nil
+	}
 }
 
 // BuildBoolEncoderFunc creates a TombstonerFunc that will write to a specified io.Writer.
@@ -69,6 +153,9 @@ func BuildBoolEncoderFunc(w io.Writer) BoolEncoderFunc {
 func BuildStringEncoderFunc(w io.Writer) StringEncoderFunc {
 	var err error
 	return func(s string) (int, error) {
+		if len(s) > MaxLength {
+			return 0, ErrTooLarge
+		}
 		b := []byte(s)
 		n := int16(binary.Size(b))
 
@@ -98,38 +185,86 @@ type StringDecoderFunc func(s *string) (int, error)
 // A BoolDecoderFunc is the signature of a function that can read the binary format of a bool into a bool.
 type BoolDecoderFunc func(b *bool) (int, error)
 
+// An Int64DecoderFunc is the signature of a function that can read the binary format of an int64 into an
+// int64.
+type Int64DecoderFunc func(i *int64) (int, error)
+
+// A Uint32DecoderFunc is the signature of a function that can read the binary format of a uint32 into a
+// uint32.
+type Uint32DecoderFunc func(i *uint32) (int, error)
+
 // A Decoder can decode DBFileEntry objects from a reader.
 type Decoder struct {
+	r   io.Reader
+	crc hash.Hash32 // The checksum of what the Decoder has read of the current entry.
+	tee io.Reader   // Reads from r, adding what it reads to crc.
 	dec StringDecoderFunc
-	tmb BoolDecoderFunc
+	i64 Int64DecoderFunc
+	u32 Uint32DecoderFunc
 }
 
 // NewDecoder creates a new Decoder that will read from an io.Reader.
 func NewDecoder(r io.Reader) *Decoder {
+	crc := crc32.NewIEEE()
+	tee := io.TeeReader(r, crc)
 	return &Decoder{
-		dec: BuildStringDecoderFunc(r),
-		tmb: BuildBoolDecoderFunc(r),
+		r:   r,
+		crc: crc,
+		tee: tee,
+		dec: BuildStringDecoderFunc(tee),
+		i64: BuildInt64DecoderFunc(tee),
+		u32: BuildUint32DecoderFunc(tee),
 	}
 }
 
-// Decode reads binary data from its io.Reader into a DBFileEntry.
+// Decode reads binary data from its io.Reader into a DBFileEntry. If the entry ends with a checksum that
+// does not match the rest of it, Decode returns ErrCorrupt. Entries written before checksums were
+// introduced have none, and are decoded as they are.
 func (d *Decoder) Decode(entry *DBFileEntry) (int, error) {
 	var (
-		nT, nK, nV int
-		err        error
+		nF, nE, nB, nK, nO, nV, nC int
+		err                        error
+		flags                      [1]byte
 	)
 
-	nT, err = d.tmb(&entry.deleted)
+	d.crc.Reset()
+	nF, err = io.ReadFull(d.tee, flags[:])
 	if err != nil {
 		return 0, err
 	}
+	entry.setFlags(flags[0])
+
+	entry.expires = 0
+	if flags[0]&flagExpires != 0 {
+		nE, err = d.i64(&entry.expires)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	entry.bucket = DefaultBucket
+	if flags[0]&flagBucket != 0 {
+		nB, err = d.u32(&entry.bucket)
+		if err != nil {
+			return 0, err
+		}
+	}
 
 	nK, err = d.dec(&entry.key)
 	if err != nil {
 		return 0, err
 	}
 
+	entry.operator = ""
+	if flags[0]&flagMerge != 0 {
+		nO, err = d.dec(&entry.operator)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	// Tombstoned records have only a key and a deleted bit.
+	entry.value = ""
 	if !entry.deleted {
 		nV, err = d.dec(&entry.value)
 		if err != nil {
@@ -137,7 +272,43 @@ func (d *Decoder) Decode(entry *DBFileEntry) (int, error) {
 		}
 	}
 
-	return nT + nK + nV, nil
+	if flags[0]&flagChecksum != 0 {
+		var checksum uint32
+		if err = binary.Read(d.r, binary.BigEndian, &checksum); err != nil {
+			return 0, err
+		}
+		if checksum != d.crc.Sum32() {
+			return 0, ErrCorrupt
+		}
+		nC = checksumSize
+	}
+	if entry.compressed {
+		if entry.value, err = decompress(entry.value); err != nil {
+			return 0, err
+		}
+	}
+
+	return nF + nE + nB + nK + nO + nV + nC, nil
+}
+
+// BuildInt64DecoderFunc creates a new Int64DecoderFunc that will read from the specified io.Reader.
+func BuildInt64DecoderFunc(r io.Reader) Int64DecoderFunc {
+	return func(i *int64) (int, error) {
+		if err := binary.Read(r, binary.BigEndian, i); err != nil {
+			return 0, err
+		}
+		return binary.Size(*i), nil
+	}
+}
+
+// BuildUint32DecoderFunc creates a new Uint32DecoderFunc that will read from the specified io.Reader.
+func BuildUint32DecoderFunc(r io.Reader) Uint32DecoderFunc {
+	return func(i *uint32) (int, error) {
+		if err := binary.Read(r, binary.BigEndian, i); err != nil {
+			return 0, err
+		}
+		return binary.Size(*i), nil
+	}
 }
 
 // BuildBoolDecoderFunc creates a new BoolDecoderFunc that will read from the specified io.Reader.
@@ -165,6 +336,9 @@ func BuildStringDecoderFunc(r io.Reader) StringDecoderFunc {
 		if err != nil {
 			return 0, err
 		}
+		if n < 0 {
+			return 0, ErrCorrupt
+		}
 
 		v := make([]byte, n)
 		err = binary.Read(r, binary.BigEndian, v)
@@ -177,6 +351,29 @@ func BuildStringDecoderFunc(r io.Reader) StringDecoderFunc {
 	}
 }
 
+// compress returns the value compressed with DEFLATE, and whether that made it any smaller.
+func compress(value string) (string, bool) {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	io.WriteString(w, value)
+	if err := w.Close(); err != nil || buf.Len() >= len(value) {
+		return value, false
+	}
+	return buf.String(), true
+}
+
+// decompress returns a value compressed by compress. A value may be no longer uncompressed than it could
+// be stored without compression.
+func decompress(value string) (string, error) {
+	r := flate.NewReader(strings.NewReader(value))
+	defer r.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(r, MaxLength+1))
+	if err != nil || len(b) > MaxLength {
+		return "", ErrCorrupt
+	}
+	return string(b), nil
+}
+
 // DecodeFrom will read a single DBFileEntry from an io.Reader.
 func DecodeFrom(r io.Reader, d *DBFileEntry) (int, error) {
 	return NewDecoder(r).Decode(d)
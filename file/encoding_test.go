@@ -3,8 +3,8 @@ package file_test
 import (
 	"bytes"
 	"encoding/binary"
-	"io"
 	"testing"
+	"time"
 
 	. "github.com/matthew-burr/db/file"
 	"github.com/stretchr/testify/assert"
@@ -36,9 +36,10 @@ func TestEncode_OnlyAddsKey(t *testing.T) {
 
 	buf = bytes.NewBuffer(buf.Bytes())
 	var (
-		deleted    bool
-		nKey, nVal int16
-		key        []byte
+		deleted  bool
+		nKey     int16
+		key      []byte
+		checksum uint32
 	)
 	err := binary.Read(buf, binary.BigEndian, &deleted)
 	require.NoError(t, err)
@@ -48,8 +49,9 @@ func TestEncode_OnlyAddsKey(t *testing.T) {
 	err = binary.Read(buf, binary.BigEndian, key)
 	require.NoError(t, err)
 
-	err = binary.Read(buf, binary.BigEndian, &nVal)
-	assert.Equal(t, err, io.EOF)
+	err = binary.Read(buf, binary.BigEndian, &checksum)
+	require.NoError(t, err)
+	assert.Zero(t, buf.Len(), "a tombstone ends with its checksum, with no value")
 }
 
 func TestDecodeFrom(t *testing.T) {
@@ -114,3 +116,41 @@ func TestDecode_SetsKeyButNotValue(t *testing.T) {
 		})
 	}
 }
+
+func TestDecode_ReadsExpiry(t *testing.T) {
+	buf := new(bytes.Buffer)
+	want := NewEntry("my_key", Value("my_value"), Expires(time.Unix(1, 2)))
+	wantN, err := EncodeTo(buf, want)
+	require.NoError(t, err)
+	assert.Equal(t, buf.Len(), wantN)
+
+	var got DBFileEntry
+	gotN, err := DecodeFrom(bytes.NewBuffer(buf.Bytes()), &got)
+	require.NoError(t, err)
+	assert.Equal(t, wantN, gotN)
+	assert.True(t, want.Equals(got))
+}
+
+func TestDecode_ClearsPreviousEntry(t *testing.T) {
+	buf := new(bytes.Buffer)
+	EncodeTo(buf, NewEntry("first", Value("value"), Expires(time.Unix(1, 0))))
+	EncodeTo(buf, NewEntry("second", Deleted))
+
+	dec := NewDecoder(bytes.NewBuffer(buf.Bytes()))
+	var got DBFileEntry
+	dec.Decode(&got)
+	_, err := dec.Decode(&got)
+	require.NoError(t, err)
+	assert.True(t, got.Equals(NewEntry("second", Deleted)))
+}
+
+func TestDecode_RejectsMismatchedChecksum(t *testing.T) {
+	buf := new(bytes.Buffer)
+	EncodeTo(buf, NewEntry("my_key", Value("my_value")))
+	b := buf.Bytes()
+	b[len(b)-6] ^= 0xff // A byte of the value, just before the checksum.
+
+	var got DBFileEntry
+	_, err := DecodeFrom(bytes.NewBuffer(b), &got)
+	assert.Equal(t, ErrCorrupt, err)
+}
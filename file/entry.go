@@ -1,9 +1,8 @@
 package file
 
 import (
-	"fmt"
 	"io"
-	"strings"
+	"time"
 )
 
 // An EntryOption is an optional setting you may provide to a DBFileEntry.
@@ -21,9 +20,49 @@ func Deleted(d *DBFileEntry) {
 	d.deleted = true
 }
 
+// Expires is an EntryOption that makes the entry expire at a given time, after which it is treated as
+// though it had been deleted.
+func Expires(t time.Time) EntryOption {
+	return func(d *DBFileEntry) {
+		d.expires = t.UnixNano()
+	}
+}
+
+// TTL is an EntryOption that makes the entry expire once a given duration has passed.
+func TTL(ttl time.Duration) EntryOption {
+	return Expires(time.Now().Add(ttl))
+}
+
+// InBucket is an EntryOption that puts the entry in the bucket with the given id, rather than in the
+// default bucket. See DBFile.Bucket.
+func InBucket(id uint32) EntryOption {
+	return func(d *DBFileEntry) {
+		d.bucket = id
+	}
+}
+
+// Compressed is an EntryOption that stores the entry's value compressed, if that makes it smaller. It
+// makes no difference to the value read back.
+func Compressed(d *DBFileEntry) {
+	d.compressed = true
+}
+
+// MergeOperand is an EntryOption that makes the entry a merge operand: rather than replacing the key's
+// value, its value is folded into the key's value by the named MergeOperator when the key is read. A
+// merge operand never expires; the folded value expires when the value it was folded into does.
+func MergeOperand(operator string) EntryOption {
+	return func(d *DBFileEntry) {
+		d.operator = operator
+	}
+}
+
 // A DBFileEntry is a single entry in a DBFile.
 type DBFileEntry struct {
 	deleted    bool
+	compressed bool
+	expires    int64  // When the entry expires, in nanoseconds since the Unix epoch, or 0 if it never does.
+	bucket     uint32 // The id of the bucket holding the entry; DefaultBucket unless it is in a named bucket.
+	operator   string // The name of the MergeOperator, if the entry is a merge operand.
 	key, value string
 }
 
@@ -38,13 +77,14 @@ func NewEntry(key string, option ...EntryOption) DBFileEntry {
 	return d
 }
 
-// ParseEntry returns a new DBFileEntry from a string of the format key:value.
+// ParseEntry returns a new DBFileEntry from a string in the text format, such as key:value. It panics if
+// the string is not a valid entry; use ParseText to handle that instead.
 func ParseEntry(entry string) DBFileEntry {
-	parts := strings.SplitN(entry, ":", 2)
-	if len(parts) != 2 {
-		panic(fmt.Errorf("corrupt file"))
+	e, err := ParseText(entry)
+	if err != nil {
+		panic(err)
 	}
-	return NewEntry(parts[0], Value(parts[1]))
+	return e
 }
 
 // Key returns the DBFileEntry's key.
@@ -67,18 +107,70 @@ func (d DBFileEntry) Deleted() bool {
 	return d.deleted
 }
 
-// WriteTo writes the DBFileEntry in a key:value format to a writer.
+// Expires returns the time at which the entry expires, or the zero time if it never does.
+func (d DBFileEntry) Expires() time.Time {
+	if d.expires == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, d.expires)
+}
+
+// Bucket returns the id of the bucket the entry is in.
+func (d DBFileEntry) Bucket() uint32 {
+	return d.bucket
+}
+
+// Operator returns the name of the MergeOperator that folds the entry into its key's value, if the entry
+// is a merge operand, or an empty string if it is not.
+func (d DBFileEntry) Operator() string {
+	return d.operator
+}
+
+// Expired returns true if the entry has an expiry time that is not after now.
+func (d DBFileEntry) Expired(now time.Time) bool {
+	return d.expires != 0 && d.expires <= now.UnixNano()
+}
+
+// flags returns the flags with which the entry is encoded.
+func (d DBFileEntry) flags() byte {
+	var f byte
+	if d.deleted {
+		f |= flagDeleted
+	}
+	if d.expires != 0 {
+		f |= flagExpires
+	}
+	if d.bucket != DefaultBucket {
+		f |= flagBucket
+	}
+	if d.compressed && !d.deleted {
+		f |= flagCompressed
+	}
+	if d.operator != "" && !d.deleted {
+		f |= flagMerge
+	}
+	return f
+}
+
+// setFlags sets the fields of the entry that are recorded by flags.
+func (d *DBFileEntry) setFlags(f byte) {
+	d.deleted = f&flagDeleted != 0
+	d.compressed = f&flagCompressed != 0
+}
+
+// WriteTo writes the DBFileEntry in the text format, such as key:value, to a writer.
 func (d DBFileEntry) WriteTo(w io.Writer) (int64, error) {
-	n, err := fmt.Fprintf(w, "%s", d)
+	n, err := io.WriteString(w, d.text())
 	return int64(n), err
 }
 
-// String presents the DBFileEntry as a string
+// String presents the DBFileEntry as a string in the text format, such as key:value.
 func (d DBFileEntry) String() string {
-	return fmt.Sprintf("%s:%s", d.key, d.value)
+	return d.text()
 }
 
 // Equals compares this DBFileEntry to another and returns true if they have the same content.
 func (d DBFileEntry) Equals(other DBFileEntry) bool {
-	return d.deleted == other.deleted && d.key == other.key && d.value == other.value
+	return d.deleted == other.deleted && d.expires == other.expires && d.bucket == other.bucket &&
+		d.operator == other.operator && d.key == other.key && d.value == other.value
 }
@@ -2,6 +2,7 @@ package file_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/matthew-burr/db/file"
 	"github.com/stretchr/testify/assert"
@@ -70,3 +71,22 @@ func TestEquals(t *testing.T) {
 		})
 	}
 }
+
+func TestExpiresOption(t *testing.T) {
+	when := time.Unix(100, 5)
+	entry := file.NewEntry("test", file.Expires(when))
+	assert.True(t, when.Equal(entry.Expires()))
+	assert.False(t, entry.Expired(when.Add(-1)))
+	assert.True(t, entry.Expired(when))
+}
+
+func TestTTLOption(t *testing.T) {
+	entry := file.NewEntry("test", file.TTL(time.Hour))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), entry.Expires(), time.Minute)
+}
+
+func TestExpires_ZeroIfNeverExpires(t *testing.T) {
+	entry := file.NewEntry("test")
+	assert.True(t, entry.Expires().IsZero())
+	assert.False(t, entry.Expired(time.Now()))
+}
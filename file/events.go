@@ -0,0 +1,206 @@
+package file
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Logger receives messages about what a DBFile is doing. Each message comes with alternating keys and
+// values describing it, as with log/slog, whose *slog.Logger satisfies Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NewStdLogger returns a Logger that writes each message to l as a single line, such as
+//
+//	INFO opened path=test.dat keys=12 size=408 duration=1.2ms
+func NewStdLogger(l *log.Logger) Logger {
+	return stdLogger{l}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s stdLogger) Debug(msg string, args ...interface{}) { s.log("DEBUG", msg, args) }
+func (s stdLogger) Info(msg string, args ...interface{})  { s.log("INFO", msg, args) }
+func (s stdLogger) Warn(msg string, args ...interface{})  { s.log("WARN", msg, args) }
+func (s stdLogger) Error(msg string, args ...interface{}) { s.log("ERROR", msg, args) }
+
+func (s stdLogger) log(level, msg string, args []interface{}) {
+	var sb strings.Builder
+	sb.WriteString(level)
+	sb.WriteByte(' ')
+	sb.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		// Like slog, a value without a key is given the key !BADKEY.
+		key, value := "!BADKEY", args[i]
+		if i+1 < len(args) {
+			key, value = fmt.Sprint(args[i]), args[i+1]
+		}
+		v := fmt.Sprint(value)
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&sb, " %s=%s", key, v)
+	}
+	s.l.Print(sb.String())
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// An OpenEvent describes a DBFile that has been opened.
+type OpenEvent struct {
+	Path     string
+	Keys     int           // The number of keys indexed.
+	Size     int64         // The size of the file.
+	Duration time.Duration // How long indexing the file took.
+}
+
+// A RecoveryEvent describes a file that ends in bytes that are not a complete entry, as an interrupted
+// write leaves behind. A reader ignores them, and a writer truncates the file to discard them. If entries
+// follow them, a writer does not open the file at all, but returns ErrCorrupt, since what it wrote after
+// them would be lost; Repair salvages such a file.
+type RecoveryEvent struct {
+	Path      string
+	Offset    int64 // The end of the last entry that could be indexed.
+	Ignored   int64 // The number of bytes after Offset.
+	Truncated bool  // Whether the writer discarded the bytes after Offset.
+}
+
+// A CompactionEvent describes a compaction that is starting or has finished.
+type CompactionEvent struct {
+	Path     string
+	Size     int64          // The size of the log before compacting.
+	Report   *CompactReport // The outcome, once the compaction has finished successfully.
+	Duration time.Duration  // How long the compaction took, once it has finished.
+	Err      error          // Why the compaction failed, if it did.
+}
+
+// A CorruptionEvent describes data in a DBFile that could not be decoded.
+type CorruptionEvent struct {
+	Path  string
+	Range Range // The corrupt bytes, or for a single entry, where it begins.
+	Err   error
+}
+
+// A SlowOperationEvent describes an operation that took longer than the SlowThreshold.
+type SlowOperationEvent struct {
+	Op       string // One of get, scan, write, delete and batch.
+	Key      string // The key, or for scans, the start of the range; empty for batches.
+	Duration time.Duration
+}
+
+// Hooks are functions a DBFile calls when notable events happen, in addition to logging them. Any of
+// them may be nil. They are called synchronously, some while the DBFile's lock is held, so they must
+// return quickly and must not use the DBFile.
+type Hooks struct {
+	Open            func(OpenEvent)
+	Recovery        func(RecoveryEvent)
+	CompactionStart func(CompactionEvent)
+	CompactionEnd   func(CompactionEvent)
+	Corruption      func(CorruptionEvent)
+	SlowOperation   func(SlowOperationEvent)
+}
+
+// LogTo is an OpenOption that sends messages about the DBFile to a Logger. By default they are
+// discarded.
+func LogTo(l Logger) OpenOption {
+	return func(d *DBFile) {
+		d.log = l
+	}
+}
+
+// Notify is an OpenOption that sets the Hooks the DBFile calls. The open event is delivered before Open
+// returns.
+func Notify(h Hooks) OpenOption {
+	return func(d *DBFile) {
+		d.hooks = h
+	}
+}
+
+// SlowThreshold is an OpenOption that reports reads, scans and writes that take at least d as slow
+// operations. By default, no operation is reported.
+func SlowThreshold(d time.Duration) OpenOption {
+	return func(f *DBFile) {
+		f.slow = d
+	}
+}
+
+// logger returns the DBFile's Logger, which discards messages if none was given.
+func (d *DBFile) logger() Logger {
+	if d.log == nil {
+		return nopLogger{}
+	}
+	return d.log
+}
+
+func (d *DBFile) opened(e OpenEvent) {
+	d.logger().Info("opened", "path", e.Path, "keys", e.Keys, "size", e.Size, "duration", e.Duration)
+	if d.hooks.Open != nil {
+		d.hooks.Open(e)
+	}
+}
+
+func (d *DBFile) recovered(e RecoveryEvent) {
+	if e.Truncated {
+		d.logger().Warn("discarded incomplete entry at end of file", "path", e.Path, "offset", e.Offset, "discarded", e.Ignored)
+	} else {
+		d.logger().Warn("ignoring incomplete entry at end of file", "path", e.Path, "offset", e.Offset, "ignored", e.Ignored)
+	}
+	if d.hooks.Recovery != nil {
+		d.hooks.Recovery(e)
+	}
+}
+
+func (d *DBFile) compactionStarted(e CompactionEvent) {
+	d.logger().Info("compaction started", "path", e.Path, "size", e.Size)
+	if d.hooks.CompactionStart != nil {
+		d.hooks.CompactionStart(e)
+	}
+}
+
+func (d *DBFile) compactionEnded(e CompactionEvent) {
+	if e.Err != nil {
+		d.logger().Error("compaction failed", "path", e.Path, "duration", e.Duration, "error", e.Err)
+	} else {
+		d.logger().Info("compaction finished", "path", e.Path, "before", e.Report.Before, "after", e.Report.After,
+			"entries", e.Report.Entries, "duration", e.Duration)
+	}
+	if d.hooks.CompactionEnd != nil {
+		d.hooks.CompactionEnd(e)
+	}
+}
+
+func (d *DBFile) corrupted(r Range, err error) {
+	path := d.File.Name()
+	d.logger().Error("corruption detected", "path", path, "start", r.Start, "end", r.End, "error", err)
+	if d.hooks.Corruption != nil {
+		d.hooks.Corruption(CorruptionEvent{Path: path, Range: r, Err: err})
+	}
+}
+
+// timed reports an operation that began at start as slow if it took at least the SlowThreshold. Use it
+// as defer d.timed(op, key, time.Now()).
+func (d *DBFile) timed(op, key string, start time.Time) {
+	if d.slow <= 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed >= d.slow {
+		d.logger().Warn("slow operation", "op", op, "key", key, "duration", elapsed)
+		if d.hooks.SlowOperation != nil {
+			d.hooks.SlowOperation(SlowOperationEvent{Op: op, Key: key, Duration: elapsed})
+		}
+	}
+}
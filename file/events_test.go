@@ -0,0 +1,133 @@
+package file_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A RecordingLogger keeps each message it receives as a line of text.
+type RecordingLogger struct {
+	Lines []string
+}
+
+func (l *RecordingLogger) record(level, msg string, args []interface{}) {
+	l.Lines = append(l.Lines, strings.TrimSpace(fmt.Sprintln(append([]interface{}{level, msg}, args...)...)))
+}
+
+func (l *RecordingLogger) Debug(msg string, args ...interface{}) { l.record("DEBUG", msg, args) }
+func (l *RecordingLogger) Info(msg string, args ...interface{})  { l.record("INFO", msg, args) }
+func (l *RecordingLogger) Warn(msg string, args ...interface{})  { l.record("WARN", msg, args) }
+func (l *RecordingLogger) Error(msg string, args ...interface{}) { l.record("ERROR", msg, args) }
+
+func TestNotify_OpenAndRecovery(t *testing.T) {
+	good := EncodeEntries(file.NewEntry("a", file.Value("1")))
+	path, cleanup := WriteVerifyTestDat(t, append(append([]byte{}, good...), 0xff, 0xff, 0xff))
+	defer cleanup()
+
+	var (
+		opened    file.OpenEvent
+		recovered file.RecoveryEvent
+	)
+	logger := &RecordingLogger{}
+	d, err := file.Open(path, file.LogTo(logger), file.Notify(file.Hooks{
+		Open:     func(e file.OpenEvent) { opened = e },
+		Recovery: func(e file.RecoveryEvent) { recovered = e },
+	}))
+	require.NoError(t, err)
+	defer d.Close()
+
+	assert.Equal(t, path, opened.Path)
+	assert.Equal(t, 1, opened.Keys)
+	assert.Equal(t, int64(len(good)+3), opened.Size)
+	assert.Equal(t, file.RecoveryEvent{Path: path, Offset: int64(len(good)), Ignored: 3, Truncated: true}, recovered)
+	assert.Equal(t, int64(len(good)), d.CurrentOffset())
+	assert.Contains(t, logger.Lines[len(logger.Lines)-1], "WARN discarded incomplete entry at end of file")
+}
+
+func TestNotify_Corruption(t *testing.T) {
+	var corrupt []file.CorruptionEvent
+	d, cleanup := SetupFileTestDat(file.Notify(file.Hooks{
+		Corruption: func(e file.CorruptionEvent) { corrupt = append(corrupt, e) },
+	}))
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+	// Damage the first entry once it has been indexed, as a failing disk might.
+	_, err := d.File.WriteAt([]byte{0xff}, 0)
+	require.NoError(t, err)
+
+	report := d.Verify()
+	require.Len(t, corrupt, 1)
+	assert.Equal(t, report.CorruptRanges[0], corrupt[0].Range)
+	assert.Equal(t, file.ErrCorrupt, corrupt[0].Err)
+}
+
+func TestNotify_Compaction(t *testing.T) {
+	logger := &RecordingLogger{}
+	var events []file.CompactionEvent
+	record := func(e file.CompactionEvent) { events = append(events, e) }
+	d, cleanup := SetupFileTestDat(file.LogTo(logger), file.Notify(file.Hooks{CompactionStart: record, CompactionEnd: record}))
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(file.NewEntry("a", file.Value("2")))
+	size := d.CurrentOffset()
+
+	report, err := d.Compact()
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, size, events[0].Size)
+	assert.Nil(t, events[0].Report)
+	assert.Equal(t, report, events[1].Report)
+	assert.NoError(t, events[1].Err)
+	assert.Contains(t, logger.Lines, "INFO compaction started path file_test.dat size "+fmt.Sprint(size))
+}
+
+func TestSlowThreshold_ReportsSlowOperations(t *testing.T) {
+	var slow []file.SlowOperationEvent
+	d, cleanup := SetupFileTestDat(file.SlowThreshold(time.Nanosecond), file.Notify(file.Hooks{
+		SlowOperation: func(e file.SlowOperationEvent) { slow = append(slow, e) },
+	}))
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.DeleteEntry("a")
+	d.Get("a")
+	d.WriteBatch(file.NewBatch().Write("b", "2"))
+	d.Scan("b", "", 0)
+
+	var ops []string
+	for _, e := range slow {
+		ops = append(ops, e.Op+" "+e.Key)
+	}
+	assert.Equal(t, []string{"write a", "delete a", "get a", "batch ", "scan b"}, ops)
+}
+
+func TestSlowThreshold_DefaultReportsNothing(t *testing.T) {
+	logger := &RecordingLogger{}
+	d, cleanup := SetupFileTestDat(file.LogTo(logger))
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.Get("a")
+	for _, line := range logger.Lines {
+		assert.NotContains(t, line, "slow")
+	}
+}
+
+func TestNewStdLogger_FormatsKeyValues(t *testing.T) {
+	buf := new(bytes.Buffer)
+	l := file.NewStdLogger(log.New(buf, "", 0))
+
+	l.Info("opened", "path", "a b.dat", "keys", 3)
+	l.Warn("odd", "lonely")
+	l.Error("failed", "error", "")
+	assert.Equal(t, "INFO opened path=\"a b.dat\" keys=3\nWARN odd !BADKEY=lonely\nERROR failed error=\"\"\n", buf.String())
+}
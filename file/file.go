@@ -1,39 +1,149 @@
 package file
 
 import (
-	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"sync"
+	"time"
 )
 
+var (
+	// ErrReadOnly is returned when attempting to modify a DBFile that was opened read-only.
+	ErrReadOnly = errors.New("file is read-only")
+
+	// ErrNotFound is returned when a key is not in the DBFile.
+	ErrNotFound = errors.New("key not found")
+)
+
+// An OpenOption is an optional setting you may provide when opening a DBFile.
+type OpenOption func(*DBFile)
+
+// ReadOnly is an OpenOption that opens the DBFile for reading only. The file must already exist, and
+// any attempt to modify it returns ErrReadOnly.
+func ReadOnly(d *DBFile) {
+	d.readOnly = true
+}
+
+// Follow is an OpenOption for a read-only DBFile that another process is appending to. Before each
+// read, a following DBFile indexes any entries appended since it last looked, so it sees the writer's
+// changes without having to rebuild its index. Follow implies ReadOnly.
+func Follow(d *DBFile) {
+	d.readOnly = true
+	d.follow = true
+}
+
 // A DBFile encapsulates the interaction between the database and the filesystem.
 // It provides key information to help the DB keep track of locations in the file.
+// A DBFile is safe for concurrent use: reads proceed in parallel, while writes and index updates are
+// serialized.
 type DBFile struct {
 	File   *os.File
-	Index  DBIndex
-	Offset int64 // The current offset in the file.
+	Index  DBIndex // The index of the default bucket.
+	Offset int64   // The current offset in the file.
+
+	mu               sync.RWMutex
+	buckets          *bucketIndexes
+	changed          chan struct{} // Closed, and replaced, whenever entries are added to the DBFile.
+	appended         int64         // The bytes appended since the DBFile was opened.
+	readOnly, follow bool
+
+	log   Logger
+	hooks Hooks
+	slow  time.Duration
+
+	sync                     SyncPolicy
+	compressMin              int
+	ttl                      time.Duration
+	compactRatio             float64
+	compactEvery, sinceCheck int64
+	autoCompacting           int32          // Set while an automatic compaction is under way.
+	compactMu                sync.Mutex     // Held while compacting.
+	background               sync.WaitGroup // Automatic compactions under way.
+
+	operators map[string]MergeOperator
+
+	generationMu sync.Mutex // Guards generation, which is found lazily under the read lock.
+	generation   string     // The log's generation, or "" until it is found.
+
+	refMu   sync.Mutex
+	refs    map[*os.File]int  // The readers of each file still being read; see acquire.
+	retired map[*os.File]bool // The files compaction replaced, to close once their readers finish.
 }
 
 // Open opens a file for use as a DBFile.
-func Open(filepath string) *DBFile {
-	file := openFile(filepath)
+func Open(filepath string, option ...OpenOption) (*DBFile, error) {
+	d := &DBFile{}
+	for _, o := range option {
+		o(d)
+	}
+
+	file, err := openFile(filepath, d.flag())
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	d.File = file
+	d.Index = make(DBIndex)
+	d.buckets = newBucketIndexes()
+	d.changed = make(chan struct{})
+	d.Offset = indexFrom(file, d.Index, d.buckets, 0)
+	end := d.Offset
 
-	d := &DBFile{
-		File:  file,
-		Index: BuildIndex(file),
+	// A writer always appends at the end of the file, but a reader stops at the last complete entry so
+	// that it can pick up from there once the rest of the entry has been written.
+	if !d.readOnly {
+		d.moveToEnd()
 	}
 
-	d.moveToEnd()
-	return d
+	size := d.Offset
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
+
+	// Entries a writer appended after an entry cut short would not be indexed the next time the file is
+	// opened, so it discards the partial entry first. If there are entries after the damage, it cannot
+	// discard it without losing them, nor write after it without losing what it writes, so it refuses to
+	// open the file until it is repaired.
+	truncated := false
+	if !d.readOnly && d.Offset > end {
+		n, err := d.truncatePartial(end)
+		if err != nil {
+			file.Close()
+			if errors.Is(err, ErrCorrupt) {
+				d.logger().Error("damaged entries are followed by others; repair the file", "path", filepath, "offset", end)
+				return nil, fmt.Errorf("%s: %w; repair the file to salvage the entries after it", filepath, err)
+			}
+			return nil, err
+		}
+		truncated = n > 0
+	}
+
+	d.opened(OpenEvent{Path: filepath, Keys: len(d.Index) + d.buckets.keys(), Size: size, Duration: time.Since(start)})
+	// A follower may find the writer part way through an entry, which is not a sign of damage.
+	if size > end && !d.follow {
+		d.recovered(RecoveryEvent{Path: filepath, Offset: end, Ignored: size - end, Truncated: truncated})
+	}
+	return d, nil
 }
 
-func openFile(filepath string) *os.File {
-	file, err := os.OpenFile(filepath, os.O_RDWR|os.O_SYNC|os.O_CREATE, 0666)
-	if err != nil {
-		panic(err)
+// flag returns the flags with which the DBFile's underlying file should be opened.
+func (d *DBFile) flag() int {
+	if d.readOnly {
+		return os.O_RDONLY
+	}
+	if d.sync == SyncNever {
+		return os.O_RDWR | os.O_CREATE
 	}
-	return file
+	return os.O_RDWR | os.O_SYNC | os.O_CREATE
+}
+
+func openFile(filepath string, flag int) (*os.File, error) {
+	return os.OpenFile(filepath, flag, 0666)
 }
 
 // moveToEnd moves the DBFile's offset to the end of the file.
@@ -45,90 +155,355 @@ func (d *DBFile) moveToEnd() {
 	d.Offset = offset
 }
 
-// moveToOffset moves the DBFile's offset to a specific position relative to the start of the file.
-func (d *DBFile) moveToOffset(offset int64) {
-	_, err := d.File.Seek(offset, io.SeekStart)
-	if err != nil {
-		panic(err)
-	}
-	d.Offset = offset
-}
-
 // CurrentOffset returns the DBFile's current position in the file.
 func (d *DBFile) CurrentOffset() int64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	return d.Offset
 }
 
+// ReadOnly returns true if the DBFile was opened read-only.
+func (d *DBFile) ReadOnly() bool {
+	return d.readOnly
+}
+
 // WriteEntry writes a new key value pair to the DBFile.
-// It returns the entry updated with the entry's offset
-func (d *DBFile) WriteEntry(entry DBFileEntry) DBFileEntry {
+// It returns the entry updated with the entry's offset. If the entry is in a bucket that does not exist,
+// WriteEntry returns ErrNoBucket.
+func (d *DBFile) WriteEntry(entry DBFileEntry) (DBFileEntry, error) {
+	return d.writeEntry(nil, entry, nil, false)
+}
+
+// writeEntry writes an entry to a bucket, or if b is nil, to the bucket the entry names. Unless cond is
+// nil, the entry is only written if cond holds for the key's current entry. If merged is true, it returns
+// the key's value after the write, with any merge operands folded in, rather than the entry.
+func (d *DBFile) writeEntry(b *Bucket, entry DBFileEntry, cond Condition, merged bool) (DBFileEntry, error) {
+	if d.readOnly {
+		return entry, ErrReadOnly
+	}
+	entry = d.prepare(entry)
+	if !d.mergeable(entry) {
+		return entry, ErrUnknownOperator
+	}
+	op := "write"
+	if entry.deleted {
+		op = "delete"
+	}
+	defer d.timed(op, entry.key, time.Now())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	index := d.Index
+	if b != nil {
+		var err error
+		if index, err = d.indexOf(b); err != nil {
+			return entry, err
+		}
+	} else if !d.writable(entry.bucket) {
+		return entry, ErrNoBucket
+	} else if entry.bucket != DefaultBucket {
+		index = d.buckets.indexes[entry.bucket]
+	}
+	if cond != nil {
+		current, err := d.lookup(index, entry.key)
+		if err != nil && err != ErrNotFound {
+			return entry, err
+		}
+		if !cond(current, err == nil) {
+			return entry, ErrConditionFailed
+		}
+	}
+	var value DBFileEntry
+	if merged {
+		// An operand that cannot be folded would break every read of the key, so it is not written.
+		var err error
+		if value, err = d.merge(index, entry); err != nil {
+			return entry, err
+		}
+	}
+	if err := d.appendEntry(entry); err != nil {
+		return entry, err
+	}
+	d.foldOperands(index, entry)
+	if merged {
+		return value, nil
+	}
+	return entry, nil
+}
+
+// appendEntry writes an entry at the end of the DBFile and indexes it. The caller must hold the DBFile's
+// write lock.
+func (d *DBFile) appendEntry(entry DBFileEntry) error {
 	n, err := EncodeTo(d.File, entry)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	d.Index.Update(entry, d.CurrentOffset())
+	d.buckets.update(d.Index, entry, d.Offset, n)
 	d.Offset += int64(n)
-	return entry
+	d.wrote(n)
+	d.broadcast()
+	return nil
+}
+
+// writable returns true if entries may be written to the bucket with the given id: the default bucket,
+// or a live named bucket. The catalog is only written by Bucket and DropBucket. The caller must hold the
+// DBFile's lock.
+func (d *DBFile) writable(bucket uint32) bool {
+	if bucket == DefaultBucket {
+		return true
+	}
+	_, found := d.buckets.indexes[bucket]
+	return found
 }
 
 // DeleteEntry deletes the entry with the given key from the file.
 // It returns a DBFileEntry object with the deleted entry.
-func (d *DBFile) DeleteEntry(key string) DBFileEntry {
+func (d *DBFile) DeleteEntry(key string) (DBFileEntry, error) {
 	return d.WriteEntry(NewEntry(key, Deleted))
 }
 
+// WriteBatch writes every entry in a Batch to the DBFile with a single write, and only then adds them
+// to the index, so readers see either all of the batch or none of it.
+func (d *DBFile) WriteBatch(b *Batch) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	defer d.timed("batch", "", time.Now())
+
+	raw, entries, sizes, err := d.encodeBatch(b)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, entry := range entries {
+		if !d.writable(entry.bucket) {
+			return ErrNoBucket
+		}
+	}
+	if err := d.write(raw, entries, sizes); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		index := d.Index
+		if entry.bucket != DefaultBucket {
+			index = d.buckets.indexes[entry.bucket]
+		}
+		d.foldOperands(index, entry)
+	}
+	return nil
+}
+
+// EncodeBatch returns the entries in a Batch encoded as WriteBatch would write them, with the DBFile's
+// default TTL and compression applied. Writing them with Append or AppendAt has the same effect as
+// WriteBatch.
+func (d *DBFile) EncodeBatch(b *Batch) ([]byte, error) {
+	raw, _, _, err := d.encodeBatch(b)
+	return raw, err
+}
+
+// encodeBatch prepares and encodes the entries in a Batch, returning the encoded entries along with the
+// prepared entries and their encoded sizes.
+func (d *DBFile) encodeBatch(b *Batch) ([]byte, []DBFileEntry, []int, error) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	entries := make([]DBFileEntry, len(b.entries))
+	sizes := make([]int, len(b.entries))
+	for i, entry := range b.entries {
+		entries[i] = d.prepare(entry)
+		if !d.mergeable(entries[i]) {
+			return nil, nil, nil, ErrUnknownOperator
+		}
+		n, err := enc.Encode(entries[i])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sizes[i] = n
+	}
+	return buf.Bytes(), entries, sizes, nil
+}
+
+// write writes encoded entries to the end of the DBFile with a single write, and then indexes them,
+// given the entries and their encoded sizes. The caller must hold the DBFile's write lock.
+func (d *DBFile) write(raw []byte, entries []DBFileEntry, sizes []int) error {
+	if _, err := d.File.Write(raw); err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		d.buckets.update(d.Index, entry, d.Offset, sizes[i])
+		d.Offset += int64(sizes[i])
+	}
+	d.wrote(len(raw))
+	d.broadcast()
+	return nil
+}
+
 // ReadEntry retrieves the DBFileEntry at the given offset.
 func (d *DBFile) ReadEntry(key string) DBFileEntry {
-	offset, found := d.Index[key]
-	if !found {
+	entry, err := d.Get(key)
+	if err == ErrNotFound {
 		return NewEntry(key, Value("<not found>"))
 	}
+	if err != nil {
+		panic(err)
+	}
+	return entry
+}
 
-	d.moveToOffset(offset)
-	defer d.moveToEnd()
+// Get retrieves the DBFileEntry for a key. If the key is not in the DBFile, or its entry has expired, Get
+// returns ErrNotFound.
+func (d *DBFile) Get(key string) (DBFileEntry, error) {
+	return d.get(&Bucket{d: d}, key)
+}
+
+func (d *DBFile) get(b *Bucket, key string) (DBFileEntry, error) {
+	defer d.timed("get", key, time.Now())
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	index, err := d.indexOf(b)
+	if err != nil {
+		return NewEntry(key), err
+	}
+	return d.lookup(index, key)
+}
+
+// lookup reads a key's entry from the log, given the index of its bucket, folding in any merge operands.
+// If the key is not in the index, or its entry has expired, lookup returns ErrNotFound. The caller must
+// hold the DBFile's lock.
+func (d *DBFile) lookup(index DBIndex, key string) (DBFileEntry, error) {
+	offset, found := index[key]
+	if !found {
+		return NewEntry(key), ErrNotFound
+	}
 
 	entry := DBFileEntry{}
-	_, err := DecodeFrom(d.File, &entry)
+	if _, err := DecodeFrom(d.sectionFrom(offset), &entry); err != nil {
+		d.corrupted(Range{offset, offset}, err)
+		return entry, err
+	}
+	return d.fold(entry, time.Now())
+}
+
+// Scan returns up to limit entries whose keys fall in the range [from, to), in key order, skipping any
+// that have expired. An empty to leaves the range unbounded, as does a limit less than one.
+func (d *DBFile) Scan(from, to string, limit int) ([]DBFileEntry, error) {
+	return d.scan(&Bucket{d: d}, from, to, limit)
+}
+
+func (d *DBFile) scan(b *Bucket, from, to string, limit int) ([]DBFileEntry, error) {
+	defer d.timed("scan", from, time.Now())
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	index, err := d.indexOf(b)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	entries := make([]DBFileEntry, 0)
+	d.buckets.sortedKeys(b.id).each(from, to, func(key string) bool {
+		if limit > 0 && len(entries) == limit {
+			return false
+		}
 
-	return entry
+		var entry DBFileEntry
+		if entry, err = d.lookup(index, key); err == ErrNotFound {
+			err = nil
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
 }
 
-// Close closes the file.
+// sectionFrom returns a reader over the DBFile's content beginning at offset. Reading from it does not
+// move the file's own position, so it is safe to use alongside writes.
+func (d *DBFile) sectionFrom(offset int64) io.Reader {
+	return io.NewSectionReader(d.File, offset, math.MaxInt64-offset)
+}
+
+// Refresh indexes any complete entries that have been appended to the file since the DBFile last indexed
+// it, decoding only the new entries rather than the whole file. Readers of a file that another process
+// writes to can use Refresh to catch up with the writer; a DBFile opened with Follow does so before every
+// read.
+func (d *DBFile) Refresh() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	offset := indexFrom(d.sectionFrom(d.Offset), d.Index, d.buckets, d.Offset)
+	if offset != d.Offset {
+		d.Offset = offset
+		d.broadcast()
+	}
+}
+
+// Close closes the file, once any automatic compaction has finished. Unless every write has already been
+// flushed, it flushes them first.
 func (d *DBFile) Close() {
+	d.background.Wait()
+	if d.sync != SyncAlways && !d.readOnly {
+		d.File.Sync()
+	}
 	d.File.Close()
 }
 
 // Reindex rebuilds the index for the DBFile.
+// The bulk of the file is indexed without holding the DBFile's lock, so reads and writes can continue
+// while it runs; only the entries written in the meantime are indexed once the lock is taken.
 func (d *DBFile) Reindex() {
-	file := openFile(d.File.Name())
-	d.Index = BuildIndex(file)
+	d.mu.RLock()
+	end := d.Offset
+
+	src, release := d.acquire()
+	d.mu.RUnlock()
+
+	index, buckets := make(DBIndex), newBucketIndexes()
+	offset := indexFrom(io.NewSectionReader(src, 0, end), index, buckets, 0)
+	release()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.File != src {
+		// The log was compacted in the meantime, so the offsets indexed do not apply to it.
+		index, buckets, offset = make(DBIndex), newBucketIndexes(), 0
+	}
+	offset = indexFrom(d.sectionFrom(offset), index, buckets, offset)
+	if d.readOnly {
+		d.Offset = offset
+	}
+	d.Index, d.buckets = index, buckets
 }
 
-// Debug provides some information about the DBFile.
-func (d *DBFile) Debug(w io.Writer, key string) {
-	rdr := bufio.NewReaderSize(
-		openFile(d.File.Name()),
-		BufferSize,
-	)
-	dec := NewDecoder(rdr)
-	totalCount, entryCount := 0, 0
-	entry := &DBFileEntry{}
-	for _, err := dec.Decode(entry); err == nil; _, err = dec.Decode(entry) {
-		totalCount++
-		if entry.key == key {
-			entryCount++
-		}
+// liveOffsets returns the offset of every live entry in the DBFile, in every bucket. The caller must hold
+// the DBFile's lock.
+func (d *DBFile) liveOffsets() []int64 {
+	offsets := make([]int64, 0, len(d.Index)+len(d.buckets.catalog))
+	for _, offset := range d.Index {
+		offsets = append(offsets, offset)
 	}
+	return d.buckets.offsets(offsets)
+}
 
-	fmt.Fprintf(os.Stdout, `
-DBFile Info
------------
-Current Offset: %d
-Key Occurrences: %d
-Total Entry Count: %d
-`, d.CurrentOffset(), entryCount, totalCount)
-	d.Index.Debug(w, key)
+// Debug writes what the DBFile holds for a key, as found by Inspect, to w as text.
+func (d *DBFile) Debug(w io.Writer, key string) {
+	io.WriteString(w, d.Inspect(key).String())
 }
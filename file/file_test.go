@@ -1,17 +1,25 @@
 package file_test
 
 import (
+	"bytes"
+	"errors"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/matthew-burr/db/file"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func SetupFileTestDat() (*file.DBFile, func()) {
+func SetupFileTestDat(option ...file.OpenOption) (*file.DBFile, func()) {
 	filepath := "file_test.dat"
-	d := file.Open(filepath)
+	d, err := file.Open(filepath, option...)
+	if err != nil {
+		panic(err)
+	}
 	return d, func() { d.File.Close(); os.Remove(filepath) }
 }
 
@@ -33,7 +41,8 @@ func TestDeleteEntry_ReturnsDeletedDBFileEntry(t *testing.T) {
 	defer cleanup()
 
 	key := "test"
-	got := d.DeleteEntry(key)
+	got, err := d.DeleteEntry(key)
+	require.NoError(t, err)
 	assert.True(t, got.Deleted())
 	assert.Equal(t, key, got.Key())
 }
@@ -75,3 +84,252 @@ func TestReadEntry_ReturnsNotFound(t *testing.T) {
 	assert.Equal(t, "foo", entry.Key())
 	assert.Equal(t, "<not found>", entry.Value())
 }
+
+func TestOpen_ReadOnlyRequiresExistingFile(t *testing.T) {
+	_, err := file.Open("missing.dat", file.ReadOnly)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat("missing.dat")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestOpen_DiscardsEntryCutShort(t *testing.T) {
+	complete := EncodeEntries(file.NewEntry("a", file.Value("1")))
+	partial := EncodeEntries(file.NewEntry("b", file.Value("2")))[:5]
+	path, cleanup := WriteVerifyTestDat(t, append(append([]byte{}, complete...), partial...))
+	defer cleanup()
+
+	r, err := file.Open(path, file.ReadOnly)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(complete)), r.CurrentOffset())
+	r.Close()
+	info, _ := os.Stat(path)
+	assert.Equal(t, int64(len(complete)+len(partial)), info.Size(), "a reader leaves the file as it is")
+
+	d, err := file.Open(path)
+	require.NoError(t, err)
+	defer d.Close()
+	assert.Equal(t, int64(len(complete)), d.CurrentOffset())
+	d.WriteEntry(file.NewEntry("c", file.Value("3")))
+	d.Close()
+
+	d, err = file.Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, "3", d.ReadEntry("c").Value())
+	assert.True(t, d.Verify().OK())
+	d.Close()
+}
+
+func TestOpen_WriterRefusesLogDamagedBeforeItsEnd(t *testing.T) {
+	content := append(append(EncodeEntries(file.NewEntry("a", file.Value("1"))), 0xff, 0xff),
+		EncodeEntries(file.NewEntry("b", file.Value("2")))...)
+	path, cleanup := WriteVerifyTestDat(t, content)
+	defer cleanup()
+	defer os.Remove("repaired.dat")
+
+	_, err := file.Open(path)
+	assert.True(t, errors.Is(err, file.ErrCorrupt))
+	info, _ := os.Stat(path)
+	assert.Equal(t, int64(len(content)), info.Size(), "the entries after the damage are kept for Repair")
+
+	r, err := file.Open(path, file.ReadOnly)
+	require.NoError(t, err)
+	assert.Equal(t, "1", r.ReadEntry("a").Value())
+	r.Close()
+
+	_, err = file.Repair(path, "repaired.dat")
+	require.NoError(t, err)
+	d, err := file.Open("repaired.dat")
+	require.NoError(t, err)
+	defer d.Close()
+	assert.Equal(t, "2", d.ReadEntry("b").Value())
+}
+
+func TestWriteEntry_ReadOnlyReturnsErrReadOnly(t *testing.T) {
+	w, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d, err := file.Open(w.File.Name(), file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+
+	_, err = d.WriteEntry(file.NewEntry("test", file.Value("entry")))
+	assert.Equal(t, file.ErrReadOnly, err)
+
+	_, err = d.DeleteEntry("test")
+	assert.Equal(t, file.ErrReadOnly, err)
+	assert.Equal(t, int64(0), d.CurrentOffset())
+}
+
+func TestReadEntry_FollowSeesAppendedEntries(t *testing.T) {
+	w, cleanup := SetupFileTestDat()
+	defer cleanup()
+	w.WriteEntry(file.NewEntry("first", file.Value("1")))
+
+	d, err := file.Open(w.File.Name(), file.Follow)
+	require.NoError(t, err)
+	defer d.Close()
+	require.Equal(t, "1", d.ReadEntry("first").Value())
+
+	w.WriteEntry(file.NewEntry("second", file.Value("2")))
+	w.DeleteEntry("first")
+
+	assert.Equal(t, "2", d.ReadEntry("second").Value())
+	assert.Equal(t, "<not found>", d.ReadEntry("first").Value())
+	assert.Equal(t, w.CurrentOffset(), d.CurrentOffset())
+}
+
+func TestReadEntry_FollowWaitsForCompleteEntries(t *testing.T) {
+	w, cleanup := SetupFileTestDat()
+	defer cleanup()
+	w.WriteEntry(file.NewEntry("first", file.Value("1")))
+	end := w.CurrentOffset()
+
+	// Simulate a writer that has only written part of an entry.
+	buf := new(bytes.Buffer)
+	file.EncodeTo(buf, file.NewEntry("second", file.Value("2")))
+	partial := buf.Bytes()[:buf.Len()-1]
+	w.File.Write(partial)
+
+	d, err := file.Open(w.File.Name(), file.Follow)
+	require.NoError(t, err)
+	defer d.Close()
+	assert.Equal(t, end, d.CurrentOffset())
+	assert.Equal(t, "<not found>", d.ReadEntry("second").Value())
+
+	w.File.Write(buf.Bytes()[len(partial):])
+	assert.Equal(t, "2", d.ReadEntry("second").Value())
+}
+
+func TestRefresh_IndexesOnlyNewEntries(t *testing.T) {
+	w, cleanup := SetupFileTestDat()
+	defer cleanup()
+	w.WriteEntry(file.NewEntry("old", file.Value("1")))
+
+	d, err := file.Open(w.File.Name(), file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+
+	w.WriteEntry(file.NewEntry("new", file.Value("2")))
+	require.NotContains(t, d.Index, "new")
+
+	// If Refresh started over from the beginning of the file, it would put "old" back.
+	d.Index.Remove("old")
+	d.Refresh()
+	assert.Contains(t, d.Index, "new")
+	assert.NotContains(t, d.Index, "old")
+	assert.Equal(t, w.CurrentOffset(), d.CurrentOffset())
+}
+
+func TestReindex_RebuildsIndex(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("keep", file.Value("1")))
+	d.WriteEntry(file.NewEntry("delete", file.Value("2")))
+	d.DeleteEntry("delete")
+
+	want := d.Index
+	d.Index = file.DBIndex{"bogus": 1}
+	d.Reindex()
+	assert.Equal(t, want, d.Index)
+
+	d.WriteEntry(file.NewEntry("after", file.Value("3")))
+	assert.Equal(t, "3", d.ReadEntry("after").Value())
+}
+
+func TestReindex_AllowsConcurrentReads(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	for i := 0; i < 100; i++ {
+		d.WriteEntry(file.NewEntry("key", file.Value(strconv.Itoa(i))))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			d.Reindex()
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "99", d.ReadEntry("key").Value())
+	}
+	<-done
+}
+
+func TestGet_ReturnsErrNotFound(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	_, err := d.Get("foo")
+	assert.Equal(t, file.ErrNotFound, err)
+
+	d.WriteEntry(file.NewEntry("foo", file.Value("bar")))
+	got, err := d.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", got.Value())
+}
+
+func TestScan_ReturnsEntriesInRange(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	for _, k := range []string{"d", "b", "a", "c"} {
+		d.WriteEntry(file.NewEntry(k, file.Value(k+k)))
+	}
+
+	got, err := d.Scan("b", "d", 0)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, "bb", got[0].Value())
+	assert.Equal(t, "cc", got[1].Value())
+
+	got, err = d.Scan("", "", 3)
+	require.NoError(t, err)
+	assert.Len(t, got, 3)
+	assert.Equal(t, "a", got[0].Key())
+}
+
+func TestGet_ExpiredEntryIsNotFound(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("short", file.Value("lived"), file.TTL(10*time.Millisecond)))
+	d.WriteEntry(file.NewEntry("long", file.Value("lived"), file.TTL(time.Hour)))
+	_, err := d.Get("short")
+	require.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	_, err = d.Get("short")
+	assert.Equal(t, file.ErrNotFound, err)
+
+	got, err := d.Scan("", "", 0)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "long", got[0].Key())
+
+	assert.True(t, d.Verify().OK())
+	d.Reindex()
+	assert.NotContains(t, d.Index, "short")
+}
+
+func TestWriteEntry_RejectsTooLargeEntries(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	offset := d.CurrentOffset()
+	_, err := d.WriteEntry(file.NewEntry("big", file.Value(strings.Repeat("x", file.MaxLength+1))))
+	assert.Equal(t, file.ErrTooLarge, err)
+	_, err = d.WriteEntry(file.NewEntry(strings.Repeat("k", file.MaxLength+1), file.Value("1")))
+	assert.Equal(t, file.ErrTooLarge, err)
+	err = d.WriteBatch(file.NewBatch().Write("b", "1").Write("big", strings.Repeat("x", file.MaxLength+1)))
+	assert.Equal(t, file.ErrTooLarge, err)
+	assert.Equal(t, offset, d.CurrentOffset(), "nothing is written")
+
+	_, err = d.WriteEntry(file.NewEntry("max", file.Value(strings.Repeat("x", file.MaxLength))))
+	require.NoError(t, err)
+	entry, err := d.Get("max")
+	require.NoError(t, err)
+	assert.Len(t, entry.Value(), file.MaxLength)
+	assert.True(t, d.Verify().OK())
+}
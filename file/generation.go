@@ -0,0 +1,92 @@
+package file
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math"
+	"os"
+)
+
+// ErrCompacted is returned when reading a DBFile's log from an offset taken from an earlier generation of
+// the log, which compaction has since replaced.
+var ErrCompacted = errors.New("the log has been compacted since the offset was taken")
+
+// generationBucket is the id of the bucket holding the entry that begins every compacted log. Its key is
+// generationKey and its value is random, so that no two generations of a log begin with the same entry.
+// The bucket is never indexed.
+const (
+	generationBucket uint32 = math.MaxUint32 - 1
+	generationKey           = "generation"
+)
+
+// newGeneration returns the entry that begins a new generation of a log.
+func newGeneration() (DBFileEntry, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return DBFileEntry{}, err
+	}
+	return NewEntry(generationKey, Value(hex.EncodeToString(id[:])), InBucket(generationBucket)), nil
+}
+
+// Generation identifies the DBFile's log, in hex, by a checksum of its first entry. It stays the same as
+// the log grows, but compaction, which changes the offsets of entries, begins the log with a new, random
+// entry, and so a new generation. Offsets taken from one generation do not apply to another. An empty
+// log has the empty generation, which any generation may follow.
+func (d *DBFile) Generation() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.currentGeneration()
+}
+
+// currentGeneration returns the generation of the DBFile's log. The caller must hold the DBFile's lock.
+func (d *DBFile) currentGeneration() string {
+	d.generationMu.Lock()
+	defer d.generationMu.Unlock()
+	if d.generation == "" && d.Offset > 0 {
+		if segment, err := segmentOf(d.File, d.Offset); err == nil {
+			d.generation = hex.EncodeToString(segment[:])
+		}
+	}
+	return d.generation
+}
+
+// acquire returns the DBFile's current file, holding a reference to it that keeps it open, even once
+// compaction replaces it, until the returned function is called. The caller must hold the DBFile's lock.
+func (d *DBFile) acquire() (*os.File, func()) {
+	f := d.File
+	d.refMu.Lock()
+	if d.refs == nil {
+		d.refs = make(map[*os.File]int)
+	}
+	d.refs[f]++
+	d.refMu.Unlock()
+
+	return f, func() {
+		d.refMu.Lock()
+		defer d.refMu.Unlock()
+		d.refs[f]--
+		if d.refs[f] == 0 {
+			delete(d.refs, f)
+			if d.retired[f] {
+				delete(d.retired, f)
+				f.Close()
+			}
+		}
+	}
+}
+
+// retire closes a file that compaction has replaced, or if readers still hold references to it, has the
+// last of them close it.
+func (d *DBFile) retire(f *os.File) {
+	d.refMu.Lock()
+	defer d.refMu.Unlock()
+	if d.refs[f] == 0 {
+		f.Close()
+		return
+	}
+	if d.retired == nil {
+		d.retired = make(map[*os.File]bool)
+	}
+	d.retired[f] = true
+}
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"sort"
+	"time"
 )
 
 const (
@@ -14,27 +16,33 @@ const (
 // A DBIndex is a map of keys to their offset in the DBFile.
 type DBIndex map[string]int64
 
-// BuildIndex builds a new index of a DBFile.
+// BuildIndex builds a new index of the default bucket of a DBFile.
 func BuildIndex(rdr io.Reader) DBIndex {
 	index := make(DBIndex)
+	indexFrom(rdr, index, newBucketIndexes(), 0)
+	return index
+}
 
+// indexFrom updates index, which is the default bucket's, and the indexes of the named buckets with the
+// entries read from rdr, treating the first of them as being at offset. It returns the offset just past
+// the last complete entry it read.
+func indexFrom(rdr io.Reader, index DBIndex, buckets *bucketIndexes, offset int64) int64 {
 	// Benchmarking shows that using a buffered reader is much faster,
 	// and 8KB seems to be the optimal size.
 	dec := NewDecoder(bufio.NewReaderSize(rdr, BufferSize))
-	offset := int64(0)
 	entry := DBFileEntry{}
 	for n, err := dec.Decode(&entry); err == nil; n, err = dec.Decode(&entry) {
-		index.Update(entry, offset)
+		buckets.update(index, entry, offset, n)
 		offset += int64(n)
 	}
 
-	return index
+	return offset
 }
 
 // Update updates the index with a DBFileEntry by adding or setting the key to the offset, or by removing
-// the key, if the entry has been deleted.
+// the key, if the entry has been deleted or has already expired.
 func (d DBIndex) Update(entry DBFileEntry, offset int64) {
-	if entry.deleted {
+	if entry.deleted || entry.Expired(time.Now()) {
 		d.Remove(entry.key)
 		return
 	}
@@ -46,6 +54,33 @@ func (d DBIndex) Remove(key string) {
 	delete(d, key)
 }
 
+// Keys returns the keys in the index that fall in the range [from, to), in sorted order. An empty to
+// leaves the range unbounded.
+func (d DBIndex) Keys(from, to string) []string {
+	keys := make([]string, 0)
+	for key := range d {
+		if key >= from && (to == "" || key < to) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PrefixRange returns the range of keys, suitable for Keys or Scan, that begin with prefix.
+func PrefixRange(prefix string) (from, to string) {
+	// The end of the range is the smallest string greater than every string with the prefix: the prefix
+	// with its last byte that can be incremented, incremented.
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return prefix, string(b[:i+1])
+		}
+	}
+	return prefix, ""
+}
+
 // Compress compresses the content of a reader into a writer and delivers an index of the newly compressed data.
 // It compresses content by writing only unique entries into the destination and removing any deleted entries.
 func (d DBIndex) Compress(w io.Writer, r io.ReadSeeker) DBIndex {
@@ -53,7 +53,10 @@ func BuildBigFile(size, count int, filepath string) *file.DBFile {
 		file.Value(strings.Repeat("x", size)),
 	)
 
-	d := file.Open(filepath)
+	d, err := file.Open(filepath)
+	if err != nil {
+		panic(err)
+	}
 	for i := 0; i < count; i++ {
 		d.WriteEntry(entry)
 	}
@@ -190,3 +193,29 @@ func TestCompress_ReturnsIndex(t *testing.T) {
 	want := int64(0)
 	assert.Equal(t, want, got)
 }
+
+func TestKeys_ReturnsSortedKeysInRange(t *testing.T) {
+	idx := file.DBIndex{"c": 0, "a": 0, "b": 0, "d": 0}
+	assert.Equal(t, []string{"b", "c"}, idx.Keys("b", "d"))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, idx.Keys("", ""))
+	assert.Equal(t, []string{}, idx.Keys("e", ""))
+}
+
+func TestPrefixRange(t *testing.T) {
+	tt := []struct {
+		prefix, from, to string
+	}{
+		{"abc", "abc", "abd"},
+		{"a\xff", "a\xff", "b"},
+		{"\xff\xff", "\xff\xff", ""},
+		{"", "", ""},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.prefix, func(t *testing.T) {
+			from, to := file.PrefixRange(tc.prefix)
+			assert.Equal(t, tc.from, from)
+			assert.Equal(t, tc.to, to)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package file
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PreviewLength is the most bytes of a value an Inspection includes.
+const PreviewLength = 64
+
+// An Occurrence is one of the entries for a key in a DBFile's log.
+type Occurrence struct {
+	Offset      int64      `json:"offset"`
+	Size        int        `json:"size"` // The length of the encoded entry, in bytes.
+	Deleted     bool       `json:"deleted"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	Value       string     `json:"value"`              // The value, truncated to PreviewLength bytes.
+	ValueLength int        `json:"value_length"`       // The length of the whole value.
+	Operator    string     `json:"operator,omitempty"` // The MergeOperator, if the entry is a merge operand.
+}
+
+// An Inspection describes everything a DBFile holds for a key in the default bucket: each entry for it in the log and what the
+// index says about it.
+type Inspection struct {
+	Key         string       `json:"key"`
+	Occurrences []Occurrence `json:"occurrences"` // In the order they appear in the log.
+	Indexed     bool         `json:"indexed"`
+	IndexOffset int64        `json:"index_offset"` // The offset the index holds for the key, or -1.
+
+	// IndexAtLatest is true if the index points at the last occurrence of the key, or if the key ends
+	// with merge operands, at the first of the entries they are folded with. A key whose last occurrence
+	// is a tombstone, or has expired, is not normally indexed at all.
+	IndexAtLatest bool `json:"index_at_latest"`
+
+	Size          int64   `json:"size"`           // The size of the log.
+	Entries       int     `json:"entries"`        // The number of entries in the log, for every key.
+	IndexedKeys   int     `json:"indexed_keys"`   // The number of keys in the index.
+	CorruptRanges []Range `json:"corrupt_ranges"` // Parts of the log that could not be decoded.
+}
+
+// Inspect walks the whole log, collecting every entry for a key, and compares them with the index. It is
+// meant for investigating problems, since its cost grows with the size of the log.
+func (d *DBFile) Inspect(key string) *Inspection {
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	in := &Inspection{
+		Key:         key,
+		Occurrences: make([]Occurrence, 0),
+		IndexOffset: -1,
+		Size:        d.Offset,
+		IndexedKeys: len(d.Index),
+	}
+	in.CorruptRanges = scanEntries(d.File, d.Offset, func(offset int64, n int, entry DBFileEntry) {
+		in.Entries++
+		if entry.bucket != DefaultBucket || entry.key != key {
+			return
+		}
+		o := Occurrence{Offset: offset, Size: n, Deleted: entry.deleted, Value: entry.value, ValueLength: len(entry.value),
+			Operator: entry.operator}
+		if len(o.Value) > PreviewLength {
+			o.Value = o.Value[:PreviewLength]
+		}
+		if expires := entry.Expires(); !expires.IsZero() {
+			o.Expires = &expires
+		}
+		in.Occurrences = append(in.Occurrences, o)
+	})
+
+	if offset, found := d.Index[key]; found {
+		in.Indexed, in.IndexOffset = true, offset
+		head := len(in.Occurrences) - 1
+		for head > 0 && in.Occurrences[head].Operator != "" && !in.Occurrences[head-1].Deleted {
+			head--
+		}
+		in.IndexAtLatest = head >= 0 && in.Occurrences[head].Offset == offset
+	}
+	return in
+}
+
+// String presents the Inspection as human-readable text.
+func (in *Inspection) String() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, `
+DBFile Info
+-----------
+Current Offset: %d
+Key Occurrences: %d
+Total Entry Count: %d
+Corrupt Ranges: %d
+
+DBIndex Info
+------------
+Key Found: %v
+Key Offset: %d
+Points At Latest: %v
+Total Entry Count: %d
+`, in.Size, len(in.Occurrences), in.Entries, len(in.CorruptRanges), in.Indexed, in.IndexOffset, in.IndexAtLatest,
+		in.IndexedKeys)
+
+	if len(in.Occurrences) > 0 {
+		fmt.Fprintf(b, "\nOccurrences of %q\n", in.Key)
+		for _, o := range in.Occurrences {
+			fmt.Fprintf(b, "  offset %d: %d bytes, ", o.Offset, o.Size)
+			if o.Deleted {
+				b.WriteString("tombstone")
+			} else {
+				if o.Operator != "" {
+					fmt.Fprintf(b, "%s operand, ", o.Operator)
+				}
+				fmt.Fprintf(b, "value %q", o.Value)
+				if o.ValueLength > len(o.Value) {
+					fmt.Fprintf(b, "... (%d bytes)", o.ValueLength)
+				}
+			}
+			if o.Expires != nil {
+				fmt.Fprintf(b, ", expires %s", o.Expires.Format(time.RFC3339))
+			}
+			if in.Indexed && o.Offset == in.IndexOffset {
+				b.WriteString(" <- indexed")
+			}
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,68 @@
+package file_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect_ListsEveryOccurrence(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	long := strings.Repeat("x", file.PreviewLength+10)
+
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+	d.WriteEntry(file.NewEntry("a", file.Value(long)))
+	latest := d.CurrentOffset()
+	d.WriteEntry(file.NewEntry("a", file.Value("3")))
+
+	in := d.Inspect("a")
+	require.Len(t, in.Occurrences, 3)
+	assert.Equal(t, file.Occurrence{Offset: 0, Size: 11, Value: "1", ValueLength: 1}, in.Occurrences[0])
+	assert.Equal(t, long[:file.PreviewLength], in.Occurrences[1].Value)
+	assert.Equal(t, len(long), in.Occurrences[1].ValueLength)
+	assert.Equal(t, latest, in.Occurrences[2].Offset)
+	assert.True(t, in.Indexed)
+	assert.Equal(t, latest, in.IndexOffset)
+	assert.True(t, in.IndexAtLatest)
+	assert.Equal(t, 4, in.Entries)
+	assert.Equal(t, 2, in.IndexedKeys)
+}
+
+func TestInspect_DetectsStaleIndex(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(file.NewEntry("a", file.Value("2")))
+	d.Index["a"] = 0
+
+	in := d.Inspect("a")
+	assert.True(t, in.Indexed)
+	assert.False(t, in.IndexAtLatest)
+	assert.Contains(t, in.String(), "Points At Latest: false")
+	assert.Contains(t, in.String(), "offset 0: 11 bytes, value \"1\" <- indexed")
+}
+
+func TestInspect_Tombstone(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.DeleteEntry("a")
+
+	in := d.Inspect("a")
+	require.Len(t, in.Occurrences, 2)
+	assert.True(t, in.Occurrences[1].Deleted)
+	assert.False(t, in.Indexed)
+	assert.Equal(t, int64(-1), in.IndexOffset)
+	assert.Contains(t, in.String(), "tombstone")
+
+	missing := d.Inspect("nope")
+	assert.Empty(t, missing.Occurrences)
+	assert.False(t, missing.IndexAtLatest)
+}
@@ -0,0 +1,179 @@
+package file
+
+import (
+	"sort"
+	"time"
+)
+
+// maxKeyBlock is the most keys a sortedKeys keeps in one block before splitting it.
+const maxKeyBlock = 512
+
+// sortedKeys keeps the keys of an index in sorted order, so that scanning a range of them need not sort
+// the whole index. The keys are held in a list of sorted blocks, each no longer than maxKeyBlock, so that
+// adding or removing a key only moves the keys in its block.
+type sortedKeys struct {
+	blocks [][]string
+	n      int
+}
+
+// block returns the index of the block that holds key, or would if it were added.
+func (s *sortedKeys) block(key string) int {
+	i := sort.Search(len(s.blocks), func(i int) bool {
+		b := s.blocks[i]
+		return b[len(b)-1] >= key
+	})
+	if i == len(s.blocks) && i > 0 {
+		i--
+	}
+	return i
+}
+
+// add adds a key, which must not already be present.
+func (s *sortedKeys) add(key string) {
+	s.n++
+	if len(s.blocks) == 0 {
+		s.blocks = [][]string{{key}}
+		return
+	}
+	i := s.block(key)
+	b := s.blocks[i]
+	j := sort.SearchStrings(b, key)
+	b = append(b, "")
+	copy(b[j+1:], b[j:])
+	b[j] = key
+	s.blocks[i] = b
+
+	if len(b) > maxKeyBlock {
+		half := len(b) / 2
+		upper := append([]string(nil), b[half:]...)
+		s.blocks[i] = b[:half:half]
+		s.blocks = append(s.blocks, nil)
+		copy(s.blocks[i+2:], s.blocks[i+1:])
+		s.blocks[i+1] = upper
+	}
+}
+
+// remove removes a key, if it is present.
+func (s *sortedKeys) remove(key string) {
+	if len(s.blocks) == 0 {
+		return
+	}
+	i := s.block(key)
+	b := s.blocks[i]
+	j := sort.SearchStrings(b, key)
+	if j == len(b) || b[j] != key {
+		return
+	}
+	s.n--
+	if len(b) == 1 {
+		s.blocks = append(s.blocks[:i], s.blocks[i+1:]...)
+		return
+	}
+	s.blocks[i] = append(b[:j], b[j+1:]...)
+}
+
+// each calls fn with each key in the range [from, to), in sorted order, until fn returns false. An empty
+// to leaves the range unbounded.
+func (s *sortedKeys) each(from, to string, fn func(key string) bool) {
+	if len(s.blocks) == 0 {
+		return
+	}
+	for i := s.block(from); i < len(s.blocks); i++ {
+		b := s.blocks[i]
+		for j := sort.SearchStrings(b, from); j < len(b); j++ {
+			if to != "" && b[j] >= to {
+				return
+			}
+			if !fn(b[j]) {
+				return
+			}
+		}
+	}
+}
+
+// at calls fn with each key from the one at position, counting from zero, onwards, in sorted order,
+// until fn returns false.
+func (s *sortedKeys) at(position int, fn func(key string) bool) {
+	for _, b := range s.blocks {
+		if position >= len(b) {
+			position -= len(b)
+			continue
+		}
+		for _, key := range b[position:] {
+			if !fn(key) {
+				return
+			}
+		}
+		position = 0
+	}
+}
+
+// len returns the number of keys.
+func (s *sortedKeys) len() int {
+	return s.n
+}
+
+// Len returns the number of keys in the DBFile's index, without reading their entries. It includes any
+// that have expired but have not been removed.
+func (d *DBFile) Len() int {
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.Index)
+}
+
+// Keys returns up to limit keys in the range [from, to), in key order, skipping any that have expired,
+// without reading their entries. An empty to leaves the range unbounded, as does a limit less than one.
+func (d *DBFile) Keys(from, to string, limit int) []string {
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	keys, now := make([]string, 0), time.Now()
+	d.buckets.sortedKeys(DefaultBucket).each(from, to, func(key string) bool {
+		if limit > 0 && len(keys) == limit {
+			return false
+		}
+		if !d.buckets.expired(DefaultBucket, key, now) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// KeysAt returns the keys in the index from the one at position, counting from zero in key order, up to
+// n of them, skipping any that have expired, along with the position that follows them, or 0 if there
+// are no keys after them. It suits paging through keys by position, as cursors do; keys written or
+// deleted between calls may shift the positions, so that keys are missed or repeated.
+func (d *DBFile) KeysAt(position, n int) (keys []string, next int) {
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	sorted, now := d.buckets.sortedKeys(DefaultBucket), time.Now()
+	keys, next = make([]string, 0), position
+	sorted.at(position, func(key string) bool {
+		if next-position == n {
+			return false
+		}
+		next++
+		if !d.buckets.expired(DefaultBucket, key, now) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if next >= sorted.len() {
+		next = 0
+	}
+	return keys, next
+}
@@ -0,0 +1,70 @@
+package file_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeys_StaySortedAsKeysComeAndGo(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	// Enough keys, written out of order, to fill several blocks.
+	var want []string
+	for i := 0; i < 1200; i++ {
+		key := fmt.Sprintf("key%04d", (i*7907)%1200)
+		d.WriteEntry(file.NewEntry(key, file.Value("v")))
+		if (i*7907)%1200%3 != 0 {
+			want = append(want, key)
+		}
+	}
+	for i := 0; i < 1200; i += 3 {
+		d.DeleteEntry(fmt.Sprintf("key%04d", i))
+	}
+	sort.Strings(want)
+
+	assert.Equal(t, want, d.Keys("", "", 0))
+	assert.Equal(t, len(want), d.Len())
+	assert.Equal(t, want[10:15], d.Keys(want[10], "", 5))
+	from, to := file.PrefixRange("key01")
+	assert.Equal(t, want[sort.SearchStrings(want, from):sort.SearchStrings(want, to)], d.Keys(from, to, 0))
+
+	entries, err := d.Scan(want[100], want[103], 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, want[100], entries[0].Key())
+
+	var paged []string
+	for position := 0; ; {
+		var keys []string
+		keys, position = d.KeysAt(position, 100)
+		paged = append(paged, keys...)
+		if position == 0 {
+			break
+		}
+	}
+	assert.Equal(t, want, paged)
+}
+
+func TestKeys_SkipsExpiredKeys(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("a", file.Value("1"), file.TTL(time.Millisecond)))
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+	d.WriteEntry(file.NewEntry("c", file.Value("3"), file.TTL(time.Millisecond)))
+	d.WriteEntry(file.NewEntry("c", file.Value("1"), file.MergeOperand(file.Counter.Name())))
+	time.Sleep(5 * time.Millisecond)
+
+	assert.Equal(t, []string{"b", "c"}, d.Keys("", "", 0), "a key with merge operands outlives its value")
+	keys, next := d.KeysAt(0, 2)
+	assert.Equal(t, []string{"b"}, keys)
+	assert.Equal(t, 2, next)
+	assert.Equal(t, 3, d.Len(), "expired keys are counted until they are removed")
+}
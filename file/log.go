@@ -0,0 +1,183 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrOffsetOutOfRange is returned when reading a DBFile's log from beyond its end.
+	ErrOffsetOutOfRange = errors.New("offset is beyond the end of the file")
+
+	// ErrOffsetMoved is returned by AppendAt when the DBFile does not end at the expected offset.
+	ErrOffsetMoved = errors.New("the file does not end at the expected offset")
+)
+
+// broadcast wakes everything waiting on the channel returned by Changed. The caller must hold the
+// DBFile's write lock.
+func (d *DBFile) broadcast() {
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// Changed returns a channel that is closed the next time entries are added to the DBFile. To wait for
+// entries beyond a known offset without missing any, get the channel before checking CurrentOffset.
+func (d *DBFile) Changed() <-chan struct{} {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.changed
+}
+
+// ReadLog returns the raw, encoded entries in the DBFile from offset onwards, up to max bytes, along with
+// the offset at which the DBFile currently ends. The returned bytes always end on an entry boundary
+// unless max cuts them short. The offset is taken to be from the given generation of the log, as
+// returned by Generation; if the log has been compacted since, ReadLog returns ErrCompacted. Pass "" for
+// an offset taken from an empty log, or known to be from the current generation. If offset is beyond the
+// end of the DBFile, ReadLog returns ErrOffsetOutOfRange.
+func (d *DBFile) ReadLog(generation string, offset int64, max int) ([]byte, int64, error) {
+	raw, end, _, err := d.readLog(generation, offset, max)
+	return raw, end, err
+}
+
+// readLog is ReadLog, also returning the generation of the log that was read.
+func (d *DBFile) readLog(generation string, offset int64, max int) ([]byte, int64, string, error) {
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	end, current := d.Offset, d.currentGeneration()
+	src, release := d.acquire()
+	d.mu.RUnlock()
+	defer release()
+
+	if generation != "" && generation != current {
+		return nil, end, current, ErrCompacted
+	}
+	if offset > end {
+		return nil, end, current, ErrOffsetOutOfRange
+	}
+
+	n := end - offset
+	if n > int64(max) {
+		n = int64(max)
+	}
+	buf := make([]byte, n)
+	if _, err := src.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return nil, end, current, err
+	}
+	return buf, end, current, nil
+}
+
+// Append appends raw, encoded entries, such as those returned by another DBFile's ReadLog, to the end of
+// the DBFile and indexes them. If raw ends with an incomplete entry, that entry is left out; Append
+// returns the number of bytes it consumed so the caller can supply the rest of the entry later.
+func (d *DBFile) Append(raw []byte) (int, error) {
+	return d.append(raw, -1)
+}
+
+// AppendAt is like Append, but only appends raw if the DBFile ends at offset, as it does once every entry
+// before offset has been written, returning ErrOffsetMoved otherwise. It lets a writer tell whether
+// anything else was appended since it last checked CurrentOffset.
+func (d *DBFile) AppendAt(raw []byte, offset int64) (int, error) {
+	return d.append(raw, offset)
+}
+
+// append appends raw, if the DBFile ends at offset, or if offset is less than 0, anywhere.
+func (d *DBFile) append(raw []byte, offset int64) (int, error) {
+	if d.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	var (
+		entries []DBFileEntry
+		sizes   []int
+		n       int
+	)
+	dec := NewDecoder(bytes.NewReader(raw))
+	for {
+		var entry DBFileEntry
+		size, err := dec.Decode(&entry)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		entries = append(entries, entry)
+		sizes = append(sizes, size)
+		n += size
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if offset >= 0 && d.Offset != offset {
+		return 0, ErrOffsetMoved
+	}
+	if err := d.write(raw[:n], entries, sizes); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Truncate discards the entries in the DBFile from offset onwards, along with anything incomplete after
+// them, and rebuilds the index. offset must be the start of an entry, or the end of the last complete
+// one. It is meant for recovering from a write that was cut short, before anything else reads the
+// DBFile; readers and watchers holding offsets past the new end will find them out of range.
+func (d *DBFile) Truncate(offset int64) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if offset < 0 || offset > d.Offset {
+		return ErrOffsetOutOfRange
+	}
+	index, buckets := make(DBIndex), newBucketIndexes()
+	if end := indexFrom(io.NewSectionReader(d.File, 0, offset), index, buckets, 0); end != offset {
+		return fmt.Errorf("offset %d is not on an entry boundary", offset)
+	}
+	if err := d.File.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := d.File.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	d.Offset, d.Index, d.buckets = offset, index, buckets
+	d.generationMu.Lock()
+	d.generation = ""
+	d.generationMu.Unlock()
+	return nil
+}
+
+// truncatePartial discards the bytes from end, the end of the last complete entry, to the end of the file,
+// if they are the start of an entry cut short, or any other damage with no entries after it, and returns
+// how many it discarded. If entries follow the damage, it discards nothing and returns ErrCorrupt. The
+// caller must be opening the DBFile.
+func (d *DBFile) truncatePartial(end int64) (int64, error) {
+	if end == d.Offset {
+		return 0, nil
+	}
+	var entry DBFileEntry
+	_, err := decodeAt(d.File, end, d.Offset, &entry)
+	if err != io.EOF && err != io.ErrUnexpectedEOF && resync(d.File, end+1, d.Offset) != d.Offset {
+		return 0, fmt.Errorf("%w at offset %d", ErrCorrupt, end)
+	}
+	if err := d.File.Truncate(end); err != nil {
+		return 0, err
+	}
+	if _, err := d.File.Seek(end, io.SeekStart); err != nil {
+		return 0, err
+	}
+	discarded := d.Offset - end
+	d.Offset = end
+	return discarded, nil
+}
@@ -0,0 +1,101 @@
+package file_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLog_ReturnsRawEntries(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	mid := d.CurrentOffset()
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+
+	raw, end, err := d.ReadLog("", mid, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, d.CurrentOffset(), end)
+	assert.Equal(t, EncodeEntries(file.NewEntry("b", file.Value("2"))), raw)
+
+	raw, _, err = d.ReadLog("", 0, 3)
+	require.NoError(t, err)
+	assert.Len(t, raw, 3)
+
+	_, _, err = d.ReadLog("", end+1, 1024)
+	assert.Equal(t, file.ErrOffsetOutOfRange, err)
+}
+
+func TestAppend_WritesAndIndexesCompleteEntries(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	raw := EncodeEntries(
+		file.NewEntry("a", file.Value("1")),
+		file.NewEntry("b", file.Value("2")),
+	)
+	n, err := d.Append(raw[:len(raw)-1])
+	require.NoError(t, err)
+	assert.Equal(t, "1", d.ReadEntry("a").Value())
+	assert.NotContains(t, d.Index, "b")
+
+	m, err := d.Append(raw[n:])
+	require.NoError(t, err)
+	assert.Equal(t, len(raw), n+m)
+	assert.Equal(t, "2", d.ReadEntry("b").Value())
+	assert.Equal(t, int64(len(raw)), d.CurrentOffset())
+}
+
+func TestAppend_ReadOnlyReturnsErrReadOnly(t *testing.T) {
+	w, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d, err := file.Open(w.File.Name(), file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+
+	_, err = d.Append(EncodeEntries(file.NewEntry("a", file.Value("1"))))
+	assert.Equal(t, file.ErrReadOnly, err)
+}
+
+func TestChanged_ClosedOnWrite(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	changed := d.Changed()
+	select {
+	case <-changed:
+		t.Fatal("closed before any write")
+	default:
+	}
+
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("not closed by write")
+	}
+}
+
+func TestTruncate_DiscardsEntriesFromOffset(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	mid := d.CurrentOffset()
+	d.WriteEntry(file.NewEntry("a", file.Value("2")))
+	d.WriteEntry(file.NewEntry("b", file.Value("3")))
+
+	assert.Error(t, d.Truncate(mid+1), "not an entry boundary")
+	require.NoError(t, d.Truncate(mid))
+	assert.Equal(t, mid, d.CurrentOffset())
+	assert.Equal(t, "1", d.ReadEntry("a").Value())
+	_, err := d.Get("b")
+	assert.Equal(t, file.ErrNotFound, err)
+
+	d.WriteEntry(file.NewEntry("c", file.Value("4")))
+	assert.Equal(t, "4", d.ReadEntry("c").Value())
+	assert.True(t, d.Verify().OK())
+}
@@ -0,0 +1,279 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ErrUnknownOperator is returned when writing or reading a merge operand whose MergeOperator the DBFile
+// was not opened with.
+var ErrUnknownOperator = errors.New("unknown merge operator")
+
+// MaxOperands is the number of merge operands a key may gather before the write that adds the last of
+// them also writes the key's value with them folded in, so that no read folds more than this many.
+const MaxOperands = 64
+
+// A MergeOperator folds merge operands into a key's value. Rather than reading a key's value, changing it
+// and writing it back, a writer may append a small operand, written with the MergeOperand EntryOption;
+// the operands are folded into the value when the key is read, and for good when the DBFile is
+// compacted. A MergeOperator must be deterministic, since the same operands may be folded many times.
+type MergeOperator interface {
+	// Name returns the name by which merge operands refer to the MergeOperator.
+	Name() string
+
+	// Merge returns the result of applying operands, oldest first, to a key's value. If the key has no
+	// value, because it does not exist, has been deleted or has expired, exists is false.
+	Merge(key, value string, exists bool, operands []string) (string, error)
+}
+
+// Counter is a MergeOperator for counters, whose values and operands are decimal integers. Each operand
+// is added to the value, which is zero if the key has none. Every DBFile can fold Counter's operands.
+var Counter MergeOperator = counter{}
+
+type counter struct{}
+
+func (counter) Name() string {
+	return "counter"
+}
+
+func (counter) Merge(key, value string, exists bool, operands []string) (string, error) {
+	var total int64
+	if exists {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%q is not a counter: %v", key, err)
+		}
+		total = n
+	}
+	for _, operand := range operands {
+		n, err := strconv.ParseInt(operand, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("bad counter operand for %q: %v", key, err)
+		}
+		if (n > 0 && total > math.MaxInt64-n) || (n < 0 && total < math.MinInt64-n) {
+			return "", fmt.Errorf("counter %q overflows", key)
+		}
+		total += n
+	}
+	return strconv.FormatInt(total, 10), nil
+}
+
+// MergeOperators is an OpenOption that lets the DBFile write and fold merge operands for the given
+// MergeOperators, as well as for Counter.
+func MergeOperators(op ...MergeOperator) OpenOption {
+	return func(d *DBFile) {
+		if d.operators == nil {
+			d.operators = make(map[string]MergeOperator)
+		}
+		for _, o := range op {
+			d.operators[o.Name()] = o
+		}
+	}
+}
+
+// Merge writes a merge operand, as WriteEntry does, and returns the key's value with the operand folded
+// in. The operand is folded into the key's current value under the same lock as the write, before it is
+// written, so that no other write comes between them. If it cannot be folded, for instance because a
+// counter would overflow, Merge returns the error folding it and writes nothing.
+func (d *DBFile) Merge(entry DBFileEntry) (DBFileEntry, error) {
+	return d.writeEntry(nil, entry, nil, true)
+}
+
+// Fold returns the key's value with a merge operand folded in, as Merge does, without writing the
+// operand. It returns the error folding it if it cannot be folded.
+func (d *DBFile) Fold(entry DBFileEntry) (DBFileEntry, error) {
+	entry = d.prepare(entry)
+	if !d.mergeable(entry) {
+		return entry, ErrUnknownOperator
+	}
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.writable(entry.bucket) {
+		return entry, ErrNoBucket
+	}
+	index := d.Index
+	if entry.bucket != DefaultBucket {
+		index = d.buckets.indexes[entry.bucket]
+	}
+	return d.merge(index, entry)
+}
+
+// merge returns the key's value with entry, a merge operand, folded in, without writing it. The caller
+// must hold the DBFile's lock and pass the index of the entry's bucket.
+func (d *DBFile) merge(index DBIndex, entry DBFileEntry) (DBFileEntry, error) {
+	current, err := d.lookup(index, entry.key)
+	if err != nil && err != ErrNotFound {
+		return current, err
+	}
+	result, exists := NewEntry(entry.key, InBucket(entry.bucket)), err == nil
+	if exists {
+		result.value, result.expires = current.value, current.expires
+	}
+	value, err := d.operator(entry.operator).Merge(entry.key, result.value, exists, []string{entry.value})
+	if err != nil {
+		return result, err
+	}
+	result.value = value
+	return result, nil
+}
+
+// operator returns the MergeOperator with the given name, or nil if the DBFile does not have one.
+func (d *DBFile) operator(name string) MergeOperator {
+	if op, found := d.operators[name]; found {
+		return op
+	}
+	if name == Counter.Name() {
+		return Counter
+	}
+	return nil
+}
+
+// mergeable returns false if the entry is a merge operand for a MergeOperator the DBFile does not have.
+func (d *DBFile) mergeable(entry DBFileEntry) bool {
+	return entry.operator == "" || d.operator(entry.operator) != nil
+}
+
+// apply updates index, the index of the entry's bucket, with an entry. The index points at the first of
+// the entries that make up a key's value: its latest ordinary entry, or if it has none, its earliest merge
+// operand since. Later merge operands are added to the key's operands instead.
+func (b *bucketIndexes) apply(index DBIndex, entry DBFileEntry, offset int64, size int) {
+	k := bucketKey{entry.bucket, entry.key}
+	_, existed := index[entry.key]
+	if entry.operator == "" && entry.expires != 0 && !entry.deleted {
+		b.expires[k] = entry.expires
+	} else {
+		delete(b.expires, k)
+	}
+	if entry.operator == "" || entry.deleted {
+		delete(b.operands, k)
+		index.Update(entry, offset)
+		_, found := index[entry.key]
+		if !found {
+			delete(b.expires, k)
+			size = 0
+		}
+		b.resize(k, size)
+		if found != existed {
+			b.sort(entry.bucket, entry.key, found)
+		}
+		return
+	}
+	if !existed {
+		// The key is tracked even without later operands, so that compaction finds it to fold.
+		index[entry.key] = offset
+		b.operands[k] = nil
+		b.resize(k, size)
+		b.sort(entry.bucket, entry.key, true)
+		return
+	}
+	b.operands[k] = append(b.operands[k], offset)
+	b.resize(k, b.sizes[k]+size)
+}
+
+// fold returns a key's value, given the entry the index points at, folding in any merge operands. The
+// caller must hold the DBFile's lock.
+func (d *DBFile) fold(head DBFileEntry, now time.Time) (DBFileEntry, error) {
+	offsets, merged := d.buckets.operands[bucketKey{head.bucket, head.key}]
+	if !merged {
+		if head.Expired(now) {
+			return NewEntry(head.key), ErrNotFound
+		}
+		return head, nil
+	}
+
+	operands := make([]DBFileEntry, 0, len(offsets)+1)
+	result := NewEntry(head.key, InBucket(head.bucket))
+	exists := false
+	if head.operator != "" {
+		operands = append(operands, head)
+	} else if !head.Expired(now) {
+		result.value, result.expires, exists = head.value, head.expires, true
+	}
+	for _, offset := range offsets {
+		var operand DBFileEntry
+		if _, err := DecodeFrom(d.sectionFrom(offset), &operand); err != nil {
+			d.corrupted(Range{offset, offset}, err)
+			return result, err
+		}
+		operands = append(operands, operand)
+	}
+
+	// Consecutive operands for the same operator are folded together.
+	for len(operands) > 0 {
+		name := operands[0].operator
+		op := d.operator(name)
+		if op == nil {
+			return result, fmt.Errorf("%w: %q", ErrUnknownOperator, name)
+		}
+		var run []string
+		for len(operands) > 0 && operands[0].operator == name {
+			run = append(run, operands[0].value)
+			operands = operands[1:]
+		}
+		value, err := op.Merge(result.key, result.value, exists, run)
+		if err != nil {
+			return result, err
+		}
+		result.value, exists = value, true
+	}
+	return result, nil
+}
+
+// foldOperands writes the value of the entry's key, with its merge operands folded in, once the entry
+// gives it MaxOperands operands. The value replaces the operands in the index, and compaction drops them.
+// If the operands cannot be folded, they are left for reads to report. Entries copied from another log
+// with Append are not folded, since the log must stay a copy. The caller must hold the DBFile's write
+// lock and pass the index of the entry's bucket.
+func (d *DBFile) foldOperands(index DBIndex, entry DBFileEntry) {
+	if entry.operator == "" || len(d.buckets.operands[bucketKey{entry.bucket, entry.key}]) < MaxOperands {
+		return
+	}
+	if value, err := d.lookup(index, entry.key); err == nil {
+		d.appendEntry(value)
+	}
+}
+
+// folded returns the offsets of the live entries in the DBFile that compaction copies as they are, and
+// the entries that replace the keys whose merge operands it folds. Keys whose operands cannot be folded
+// are copied as they are. The caller must hold the DBFile's lock.
+func (d *DBFile) folded() ([]int64, []DBFileEntry) {
+	var entries []DBFileEntry
+	merged := make(map[int64]bool)
+	now := time.Now()
+	for k, offsets := range d.buckets.operands {
+		index := d.Index
+		if k.bucket != DefaultBucket {
+			index = d.buckets.indexes[k.bucket]
+		}
+		var head DBFileEntry
+		if _, err := DecodeFrom(d.sectionFrom(index[k.key]), &head); err != nil {
+			continue
+		}
+		entry, err := d.fold(head, now)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+		merged[index[k.key]] = true
+		for _, offset := range offsets {
+			merged[offset] = true
+		}
+	}
+
+	live := d.liveOffsets()
+	offsets := live[:0]
+	for _, offset := range live {
+		if !merged[offset] {
+			offsets = append(offsets, offset)
+		}
+	}
+	return offsets, entries
+}
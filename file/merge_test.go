@@ -0,0 +1,232 @@
+package file_test
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// appender is a MergeOperator that appends each operand to the value, separated by commas.
+type appender struct{}
+
+func (appender) Name() string {
+	return "append"
+}
+
+func (appender) Merge(key, value string, exists bool, operands []string) (string, error) {
+	if exists {
+		operands = append([]string{value}, operands...)
+	}
+	return strings.Join(operands, ","), nil
+}
+
+func counterOperand(key, delta string) file.DBFileEntry {
+	return file.NewEntry(key, file.Value(delta), file.MergeOperand(file.Counter.Name()))
+}
+
+func TestMerge_FoldsOperandsOnRead(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d.WriteEntry(counterOperand("hits", "1"))
+	d.WriteEntry(counterOperand("hits", "2"))
+	entry, err := d.Get("hits")
+	require.NoError(t, err)
+	assert.Equal(t, "3", entry.Value(), "operands with no value start from zero")
+
+	d.WriteEntry(file.NewEntry("hits", file.Value("10")))
+	d.WriteEntry(counterOperand("hits", "-4"))
+	entry, err = d.Get("hits")
+	require.NoError(t, err)
+	assert.Equal(t, "6", entry.Value())
+	entries, err := d.Scan("", "", 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "6", entries[0].Value())
+
+	d.DeleteEntry("hits")
+	d.WriteEntry(counterOperand("hits", "5"))
+	entry, _ = d.Get("hits")
+	assert.Equal(t, "5", entry.Value(), "a tombstone discards earlier operands")
+	d.Close()
+
+	d, err = file.Open("file_test.dat", file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+	entry, err = d.Get("hits")
+	require.NoError(t, err)
+	assert.Equal(t, "5", entry.Value())
+	assert.True(t, d.Verify().OK())
+	assert.True(t, d.Inspect("hits").IndexAtLatest)
+}
+
+func TestMerge_CompactFoldsOperands(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("a", file.Value("100")))
+	for i := 0; i < 10; i++ {
+		d.WriteEntry(counterOperand("a", "1"))
+		d.WriteEntry(counterOperand("b", "2"))
+	}
+	d.WriteEntry(file.NewEntry("c", file.Value("plain")))
+
+	report, err := d.Compact()
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Entries)
+	for key, want := range map[string]string{"a": "110", "b": "20", "c": "plain"} {
+		entry, err := d.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, want, entry.Value(), key)
+		assert.Empty(t, entry.Operator(), key)
+	}
+	assert.True(t, d.Verify().OK())
+
+	d.WriteEntry(counterOperand("a", "1"))
+	entry, _ := d.Get("a")
+	assert.Equal(t, "111", entry.Value())
+}
+
+func TestMergeOperators_RegistersOperators(t *testing.T) {
+	d, cleanup := SetupFileTestDat(file.MergeOperators(appender{}))
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("tags", file.Value("a")))
+	d.WriteEntry(file.NewEntry("tags", file.Value("b"), file.MergeOperand("append")))
+	require.NoError(t, d.WriteBatch(file.NewBatch().Add(file.NewEntry("tags", file.Value("c"), file.MergeOperand("append")))))
+	entry, err := d.Get("tags")
+	require.NoError(t, err)
+	assert.Equal(t, "a,b,c", entry.Value())
+
+	_, err = d.WriteEntry(file.NewEntry("tags", file.Value("d"), file.MergeOperand("missing")))
+	assert.Equal(t, file.ErrUnknownOperator, err)
+	d.Close()
+
+	// Without the operator, the key cannot be read, and compaction leaves its operands as they are.
+	d, err = file.Open("file_test.dat")
+	require.NoError(t, err)
+	defer d.Close()
+	_, err = d.Get("tags")
+	assert.True(t, errors.Is(err, file.ErrUnknownOperator))
+	_, err = d.Compact()
+	require.NoError(t, err)
+	assert.Len(t, d.Inspect("tags").Occurrences, 3)
+}
+
+func TestMerge_NotACounter(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("a", file.Value("x")))
+	d.WriteEntry(counterOperand("a", "1"))
+	_, err := d.Get("a")
+	assert.Error(t, err)
+}
+
+func TestMerge_WritesNothingIfTheOperandCannotBeFolded(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("name", file.Value("x")))
+	d.WriteEntry(file.NewEntry("hits", file.Value("9223372036854775800")))
+	before := d.CurrentOffset()
+
+	_, err := d.Merge(counterOperand("name", "1"))
+	assert.Error(t, err)
+	_, err = d.Merge(counterOperand("hits", "8"))
+	assert.Error(t, err)
+	_, err = d.Fold(counterOperand("hits", "8"))
+	assert.Error(t, err)
+	assert.Equal(t, before, d.CurrentOffset(), "neither operand is written")
+
+	entry, err := d.Merge(counterOperand("hits", "7"))
+	require.NoError(t, err)
+	assert.Equal(t, "9223372036854775807", entry.Value())
+	entry, err = d.Get("hits")
+	require.NoError(t, err)
+	assert.Equal(t, "9223372036854775807", entry.Value())
+}
+
+func TestCounter_Overflows(t *testing.T) {
+	_, err := file.Counter.Merge("a", "9223372036854775800", true, []string{"7"})
+	assert.NoError(t, err)
+	_, err = file.Counter.Merge("a", "9223372036854775800", true, []string{"8"})
+	assert.Error(t, err)
+	_, err = file.Counter.Merge("a", "-9223372036854775800", true, []string{"-9"})
+	assert.Error(t, err)
+}
+
+func TestMerge_ReturnsFoldedValue(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	entry, err := d.Merge(counterOperand("hits", "2"))
+	require.NoError(t, err)
+	assert.Equal(t, "2", entry.Value())
+	entry, err = d.Merge(counterOperand("hits", "3"))
+	require.NoError(t, err)
+	assert.Equal(t, "5", entry.Value())
+}
+
+func TestMerge_FoldsValuePastMaxOperands(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	for i := 0; i < file.MaxOperands+1; i++ {
+		d.WriteEntry(counterOperand("hits", "1"))
+	}
+	folded := 0
+	for _, o := range d.Inspect("hits").Occurrences {
+		if o.Operator == "" {
+			folded++
+			assert.Equal(t, strconv.Itoa(file.MaxOperands+1), o.Value)
+		}
+	}
+	assert.Equal(t, 1, folded, "the value is written once the key has MaxOperands operands after its first")
+	d.WriteEntry(counterOperand("hits", "1"))
+	assert.Equal(t, strconv.Itoa(file.MaxOperands+2), d.ReadEntry("hits").Value())
+	assert.True(t, d.Verify().OK())
+}
+
+func TestMerge_InBucket(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	users, _ := d.Bucket("users")
+	users.WriteEntry(counterOperand("logins", "1"))
+	users.WriteEntry(counterOperand("logins", "1"))
+	d.WriteEntry(counterOperand("logins", "5"))
+	entry, err := users.Get("logins")
+	require.NoError(t, err)
+	assert.Equal(t, "2", entry.Value())
+
+	require.NoError(t, d.DropBucket("users"))
+	users, _ = d.Bucket("users")
+	users.WriteEntry(counterOperand("logins", "1"))
+	entry, _ = users.Get("logins")
+	assert.Equal(t, "1", entry.Value())
+	entry, _ = d.Get("logins")
+	assert.Equal(t, "5", entry.Value())
+}
+
+func TestMerge_Text(t *testing.T) {
+	entry := file.NewEntry("+a", file.Value("1"), file.MergeOperand("counter"))
+	assert.Equal(t, "+counter:+a:1", entry.String())
+	parsed, err := file.ParseText(entry.String())
+	require.NoError(t, err)
+	assert.True(t, entry.Equals(parsed))
+
+	parsed, err = file.ParseText("@2:+counter:a:1")
+	require.NoError(t, err)
+	assert.True(t, parsed.Equals(file.NewEntry("a", file.Value("1"), file.MergeOperand("counter"), file.InBucket(2))))
+
+	parsed, err = file.ParseText(`\+a:1`)
+	require.NoError(t, err)
+	assert.Equal(t, "+a", parsed.Key())
+	assert.Empty(t, parsed.Operator())
+}
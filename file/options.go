@@ -0,0 +1,110 @@
+package file
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// A SyncPolicy determines when a DBFile's writes reach stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes every write to stable storage before it returns. It is the default.
+	SyncAlways SyncPolicy = iota
+
+	// SyncNever leaves flushing writes to the operating system, which is much faster, but a crash of
+	// the machine may lose the latest writes. DBFile.Sync flushes them on demand, and Close flushes them
+	// before closing the file.
+	SyncNever
+)
+
+// Sync is an OpenOption that sets the DBFile's SyncPolicy.
+func Sync(policy SyncPolicy) OpenOption {
+	return func(d *DBFile) {
+		d.sync = policy
+	}
+}
+
+// Compress is an OpenOption that stores the values of entries of at least min bytes compressed, when
+// that makes them smaller. Reading a compressed value returns it as it was written, and a DBFile opened
+// without the option still reads them.
+func Compress(min int) OpenOption {
+	return func(d *DBFile) {
+		d.compressMin = min
+	}
+}
+
+// DefaultTTL is an OpenOption that makes entries written without an expiry time expire once ttl has
+// passed.
+func DefaultTTL(ttl time.Duration) OpenOption {
+	return func(d *DBFile) {
+		d.ttl = ttl
+	}
+}
+
+// AutoCompact is an OpenOption that checks the DBFile's Usage each time another every bytes have been
+// appended to it, and compacts it in the background if the garbage ratio is at least ratio.
+func AutoCompact(ratio float64, every int64) OpenOption {
+	return func(d *DBFile) {
+		d.compactRatio, d.compactEvery = ratio, every
+	}
+}
+
+// prepare applies the DBFile's default TTL and compression to an entry about to be written. Merge
+// operands never expire.
+func (d *DBFile) prepare(entry DBFileEntry) DBFileEntry {
+	if entry.deleted {
+		return entry
+	}
+	if entry.operator != "" {
+		entry.expires = 0
+	} else if d.ttl > 0 && entry.expires == 0 {
+		entry.expires = time.Now().Add(d.ttl).UnixNano()
+	}
+	if d.compressMin > 0 && len(entry.value) >= d.compressMin {
+		entry.compressed = true
+	}
+	return entry
+}
+
+// wrote records that n bytes have been appended to the DBFile, starting an automatic compaction if one
+// is due. The caller must hold the DBFile's write lock.
+func (d *DBFile) wrote(n int) {
+	d.appended += int64(n)
+	if d.compactEvery <= 0 {
+		return
+	}
+	d.sinceCheck += int64(n)
+	if d.sinceCheck < d.compactEvery {
+		return
+	}
+	d.sinceCheck = 0
+	d.background.Add(1)
+	go func() {
+		defer d.background.Done()
+		d.autoCompact()
+	}()
+}
+
+// autoCompact compacts the DBFile if its garbage ratio has reached the AutoCompact ratio, unless an
+// automatic compaction is already under way.
+func (d *DBFile) autoCompact() {
+	if !atomic.CompareAndSwapInt32(&d.autoCompacting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&d.autoCompacting, 0)
+
+	u, err := d.Usage()
+	if err != nil || u.GarbageRatio() < d.compactRatio {
+		return
+	}
+	// Failures are reported through the DBFile's Logger and Hooks.
+	d.Compact()
+}
+
+// Sync flushes the DBFile's writes to stable storage. It is only needed with the SyncNever policy.
+func (d *DBFile) Sync() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.File.Sync()
+}
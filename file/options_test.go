@@ -0,0 +1,118 @@
+package file_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompress_ShrinksLargeValues(t *testing.T) {
+	d, cleanup := SetupFileTestDat(file.Compress(64))
+	defer cleanup()
+
+	large := strings.Repeat("compressible ", 100)
+	d.WriteEntry(file.NewEntry("small", file.Value("tiny")))
+	before := d.CurrentOffset()
+	d.WriteEntry(file.NewEntry("large", file.Value(large)))
+	assert.Less(t, d.CurrentOffset()-before, int64(len(large)))
+
+	entry, err := d.Get("large")
+	require.NoError(t, err)
+	assert.Equal(t, large, entry.Value())
+	d.Close()
+
+	d, err = file.Open("file_test.dat", file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+	entry, err = d.Get("large")
+	require.NoError(t, err)
+	assert.Equal(t, large, entry.Value())
+	assert.True(t, d.Verify().OK())
+}
+
+func TestDefaultTTL_ExpiresEntries(t *testing.T) {
+	d, cleanup := SetupFileTestDat(file.DefaultTTL(time.Hour))
+	defer cleanup()
+
+	entry, err := d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), entry.Expires(), time.Minute)
+
+	d.WriteEntry(file.NewEntry("b", file.Value("1"), file.TTL(time.Nanosecond)))
+	time.Sleep(time.Millisecond)
+	_, err = d.Get("b")
+	assert.Equal(t, file.ErrNotFound, err, "an entry's own expiry time takes precedence")
+}
+
+func TestSyncNever_FlushesOnClose(t *testing.T) {
+	d, cleanup := SetupFileTestDat(file.Sync(file.SyncNever))
+	defer cleanup()
+
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	require.NoError(t, d.Sync())
+	d.Close()
+
+	d, err := file.Open("file_test.dat", file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+	entry, err := d.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", entry.Value())
+}
+
+func TestAutoCompact_CompactsInBackground(t *testing.T) {
+	// A trigger is skipped while another compaction is under way, so how many run, and how large the log
+	// ends up, depends on timing; but the first trigger finds the log mostly garbage, so one always runs.
+	ended := make(chan file.CompactionEvent, 20)
+	d, cleanup := SetupFileTestDat(file.AutoCompact(0.5, 100), file.Notify(file.Hooks{
+		CompactionEnd: func(e file.CompactionEvent) { ended <- e },
+	}))
+	defer cleanup()
+
+	for i := 0; i < 20; i++ {
+		d.WriteEntry(file.NewEntry("a", file.Value(strconv.Itoa(i))))
+	}
+	d.Close()
+	close(ended)
+
+	compactions := 0
+	for e := range ended {
+		compactions++
+		require.NoError(t, e.Err)
+		assert.NotNil(t, e.Report)
+	}
+	assert.NotZero(t, compactions)
+
+	d, err := file.Open("file_test.dat", file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+	assert.Equal(t, "19", d.ReadEntry("a").Value())
+	assert.True(t, d.Verify().OK())
+}
+
+func TestAppendAt_ReturnsErrOffsetMoved(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	raw, err := d.EncodeBatch(file.NewBatch().Write("a", "1"))
+	require.NoError(t, err)
+
+	offset := d.CurrentOffset()
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+	_, err = d.AppendAt(raw, offset)
+	assert.Equal(t, file.ErrOffsetMoved, err)
+	_, err = d.Get("a")
+	assert.Equal(t, file.ErrNotFound, err)
+
+	n, err := d.AppendAt(raw, d.CurrentOffset())
+	require.NoError(t, err)
+	assert.Equal(t, len(raw), n)
+	entry, err := d.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", entry.Value())
+}
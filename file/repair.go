@@ -0,0 +1,79 @@
+package file
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// A RepairReport describes what Repair recovered from a damaged DBFile.
+type RepairReport struct {
+	Entries       int     `json:"entries"`
+	Bytes         int64   `json:"bytes"`
+	SkippedBytes  int64   `json:"skipped_bytes"`
+	SkippedRanges []Range `json:"skipped_ranges"`
+
+	// Damaged is where the damaged file was kept, when a database was repaired in place.
+	Damaged string `json:"damaged,omitempty"`
+}
+
+// String presents the RepairReport as human-readable text.
+func (r *RepairReport) String() string {
+	s := fmt.Sprintf("recovered %d entries (%d bytes); skipped %d bytes in %d corrupt ranges",
+		r.Entries, r.Bytes, r.SkippedBytes, len(r.SkippedRanges))
+	if r.Damaged != "" {
+		s += fmt.Sprintf("; damaged file kept as %s", r.Damaged)
+	}
+	return s
+}
+
+// Repair salvages what it can from a damaged DBFile at src, writing every entry it can decode, in order,
+// to a new file at dst. Where src cannot be decoded, Repair skips ahead to the next valid entry rather
+// than giving up, so only the entries in the damaged ranges are lost. An entry whose checksum does not
+// match is treated as damaged, and every entry written to dst has a checksum, including those recovered
+// from before checksums were introduced. Since each corrupt range holds at least part of one entry, the
+// number of SkippedRanges is a lower bound on the number of entries lost.
+func Repair(src, dst string) (*RepairReport, error) {
+	in, err := openFile(src, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	w := bufio.NewWriterSize(out, BufferSize)
+	enc := NewEncoder(w)
+	report := &RepairReport{}
+
+	var encodeErr error
+	report.SkippedRanges = scanEntries(in, info.Size(), func(offset int64, n int, entry DBFileEntry) {
+		if encodeErr != nil {
+			return
+		}
+		n, encodeErr = enc.Encode(entry)
+		report.Entries++
+		report.Bytes += int64(n)
+	})
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+
+	for _, r := range report.SkippedRanges {
+		report.SkippedBytes += r.Len()
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return report, out.Sync()
+}
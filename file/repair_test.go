@@ -0,0 +1,68 @@
+package file_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair_RecoversEntriesAfterCorruption(t *testing.T) {
+	before := EncodeEntries(file.NewEntry("before", file.Value("1")))
+	garbage := []byte{0x07, 0x00, 0xff, 0xff}
+	after := EncodeEntries(
+		file.NewEntry("after", file.Value("2")),
+		file.NewEntry("before", file.Deleted),
+	)
+	content := append(append(append([]byte{}, before...), garbage...), after...)
+	path, cleanup := WriteVerifyTestDat(t, content)
+	defer cleanup()
+	defer os.Remove("repaired.dat")
+
+	report, err := file.Repair(path, "repaired.dat")
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Entries)
+	assert.Equal(t, int64(len(garbage)), report.SkippedBytes)
+	assert.Len(t, report.SkippedRanges, 1)
+
+	d, err := file.Open("repaired.dat", file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+	assert.Equal(t, "2", d.ReadEntry("after").Value())
+	assert.NotContains(t, d.Index, "before")
+	assert.True(t, d.Verify().OK())
+}
+
+func TestRepair_DoesNotOverwriteDestination(t *testing.T) {
+	path, cleanup := WriteVerifyTestDat(t, EncodeEntries(file.NewEntry("a", file.Value("1"))))
+	defer cleanup()
+
+	_, err := file.Repair(path, path)
+	assert.True(t, os.IsExist(err))
+}
+
+func TestRepair_SkipsEntriesWhoseChecksumDoesNotMatch(t *testing.T) {
+	content := EncodeEntries(
+		file.NewEntry("a", file.Value("1")),
+		file.NewEntry("b", file.Value("2")),
+		file.NewEntry("c", file.Value("3")),
+	)
+	damaged := EncodeEntries(file.NewEntry("a", file.Value("1")))
+	content[len(damaged)+5] ^= 0xff // The value of "b", which still decodes, but not to what was written.
+	path, cleanup := WriteVerifyTestDat(t, content)
+	defer cleanup()
+	defer os.Remove("repaired.dat")
+
+	report, err := file.Repair(path, "repaired.dat")
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Entries)
+	assert.Equal(t, []file.Range{{int64(len(damaged)), int64(2 * len(damaged))}}, report.SkippedRanges)
+
+	d, err := file.Open("repaired.dat", file.ReadOnly)
+	require.NoError(t, err)
+	defer d.Close()
+	assert.Equal(t, "3", d.ReadEntry("c").Value())
+	assert.NotContains(t, d.Index, "b")
+}
@@ -0,0 +1,90 @@
+package file
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrCorrupt is returned when data in a DBFile cannot be a valid entry.
+var ErrCorrupt = errors.New("corrupt entry")
+
+// A Range is a span of bytes in a DBFile, from Start up to but not including End.
+type Range struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Len returns the number of bytes in the Range.
+func (r Range) Len() int64 {
+	return r.End - r.Start
+}
+
+// decodeAt decodes the entry at offset, reading no further than size. Unlike a Decoder, it insists that
+// no unknown flags are set, so it can be used to test whether an entry plausibly begins at offset.
+func decodeAt(r io.ReaderAt, offset, size int64, entry *DBFileEntry) (int, error) {
+	flag := make([]byte, 1)
+	if _, err := r.ReadAt(flag, offset); err != nil {
+		return 0, err
+	}
+	if flag[0]&^knownFlags != 0 {
+		return 0, ErrCorrupt
+	}
+
+	return DecodeFrom(io.NewSectionReader(r, offset, size-offset), entry)
+}
+
+// checksummed reports whether the entry at offset ends with a checksum, which decodeAt has verified if it
+// decoded the entry.
+func checksummed(r io.ReaderAt, offset int64) bool {
+	flag := make([]byte, 1)
+	_, err := r.ReadAt(flag, offset)
+	return err == nil && flag[0]&flagChecksum != 0
+}
+
+// resync searches forward from offset for the next position at which a valid entry begins. The format
+// has no sync markers, but an entry whose checksum matches is all but certain to be one, rather than a
+// fragment of a value. Entries written before checksums were introduced have none, so such a position
+// only counts if the entry there is followed either by another valid entry or by the end of the data,
+// which makes a mistake unlikely. If there is no such position, resync returns size.
+func resync(r io.ReaderAt, offset, size int64) int64 {
+	var entry DBFileEntry
+	for ; offset < size; offset++ {
+		n, err := decodeAt(r, offset, size, &entry)
+		if err != nil {
+			continue
+		}
+		next := offset + int64(n)
+		if next == size || checksummed(r, offset) {
+			return offset
+		}
+		if _, err := decodeAt(r, next, size, &entry); err == nil {
+			return offset
+		}
+	}
+	return size
+}
+
+// scanEntries walks the first size bytes of r, calling fn with the offset, encoded length and content of
+// each entry it decodes. Rather than stopping at the first region that cannot be decoded, it skips ahead
+// to the next valid entry, and returns the ranges it skipped.
+func scanEntries(r io.ReaderAt, size int64, fn func(offset int64, n int, entry DBFileEntry)) []Range {
+	var (
+		corrupt []Range
+		entry   DBFileEntry
+	)
+
+	for offset := int64(0); offset < size; {
+		n, err := decodeAt(r, offset, size, &entry)
+		if err != nil {
+			next := resync(r, offset+1, size)
+			corrupt = append(corrupt, Range{offset, next})
+			offset = next
+			continue
+		}
+
+		fn(offset, n, entry)
+		offset += int64(n)
+	}
+
+	return corrupt
+}
@@ -0,0 +1,309 @@
+package file
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An EntryEncoder writes entries in some format. An Encoder writes the binary format stored in a DBFile,
+// and a TextEncoder writes the text format.
+type EntryEncoder interface {
+	Encode(entry DBFileEntry) (int, error)
+}
+
+// An EntryDecoder reads entries in some format. A Decoder reads the binary format stored in a DBFile, and
+// a TextDecoder reads the text format.
+type EntryDecoder interface {
+	Decode(entry *DBFileEntry) (int, error)
+}
+
+// TextTimeLayout is the layout of expiry times in the text format. Unlike RFC 3339, it has no colons,
+// which separate the fields of an entry.
+const TextTimeLayout = "20060102T150405.999999999Z"
+
+// A SyntaxError describes a line of text that is not a valid entry. It wraps ErrCorrupt.
+type SyntaxError struct {
+	Line int // The line number, counting from 1, or 0 if the text was not read from a TextDecoder.
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line == 0 {
+		return "invalid entry: " + e.Msg
+	}
+	return fmt.Sprintf("line %d: invalid entry: %s", e.Line, e.Msg)
+}
+
+// Unwrap returns ErrCorrupt.
+func (e *SyntaxError) Unwrap() error {
+	return ErrCorrupt
+}
+
+// text returns the entry in the text format, which is one of
+//
+//	key:value              an entry
+//	key:value:expires      an entry that expires, with the time in TextTimeLayout
+//	key                    a tombstone
+//	+operator:key:operand  a merge operand
+//
+// An entry in a named bucket begins with @ and the bucket's id, as in @1:key:value. Backslashes, colons,
+// newlines and carriage returns in the key and value are escaped with a backslash, as \\, \:, \n and \r,
+// as is an @ at the start of a key in the default bucket, as \@, and a + at the start of a key that is not
+// a merge operand's, as \+.
+func (d DBFileEntry) text() string {
+	var sb strings.Builder
+	if d.bucket != DefaultBucket {
+		fmt.Fprintf(&sb, "@%d:", d.bucket)
+	} else if strings.HasPrefix(d.key, "@") {
+		sb.WriteByte('\\')
+	}
+	if d.operator != "" && !d.deleted {
+		sb.WriteByte('+')
+		escapeText(&sb, d.operator)
+		sb.WriteByte(':')
+	} else if strings.HasPrefix(d.key, "+") {
+		sb.WriteByte('\\')
+	}
+	escapeText(&sb, d.key)
+	if d.deleted {
+		return sb.String()
+	}
+	sb.WriteByte(':')
+	escapeText(&sb, d.value)
+	if d.expires != 0 {
+		sb.WriteByte(':')
+		sb.WriteString(d.Expires().UTC().Format(TextTimeLayout))
+	}
+	return sb.String()
+}
+
+func escapeText(sb *strings.Builder, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', ':':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+// splitText splits a line of text at its unescaped colons, and unescapes each field.
+func splitText(line string) ([]string, error) {
+	var (
+		fields []string
+		sb     strings.Builder
+	)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; c {
+		case ':':
+			fields = append(fields, sb.String())
+			sb.Reset()
+		case '\\':
+			i++
+			if i == len(line) {
+				return nil, &SyntaxError{Msg: "line ends with a backslash"}
+			}
+			switch e := line[i]; e {
+			case '\\', ':', '@', '+':
+				sb.WriteByte(e)
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			default:
+				return nil, &SyntaxError{Msg: fmt.Sprintf("unknown escape \\%c", e)}
+			}
+		case '\n', '\r':
+			return nil, &SyntaxError{Msg: "unescaped line break"}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return append(fields, sb.String()), nil
+}
+
+// ParseText parses an entry in the text format written by a TextEncoder. Unlike ParseEntry, it returns a
+// SyntaxError, rather than panicking, if the text is not a valid entry.
+func ParseText(line string) (DBFileEntry, error) {
+	fields, err := splitText(line)
+	if err != nil {
+		return DBFileEntry{}, err
+	}
+
+	var bucket uint64
+	rest := line
+	if strings.HasPrefix(line, "@") {
+		// Text written before buckets were introduced may have keys beginning with an unescaped @.
+		if bucket, err = strconv.ParseUint(fields[0][1:], 10, 32); err == nil {
+			if len(fields) == 1 {
+				return DBFileEntry{}, &SyntaxError{Msg: "missing key"}
+			}
+			rest, fields = line[len(fields[0])+1:], fields[1:]
+		}
+	}
+
+	// A merge operand's operator follows any bucket, marked with a + that is not escaped.
+	var operator string
+	if strings.HasPrefix(rest, "+") && len(fields) == 3 {
+		operator, fields = fields[0][1:], fields[1:]
+		if operator == "" {
+			return DBFileEntry{}, &SyntaxError{Msg: "missing merge operator"}
+		}
+	}
+
+	entry := NewEntry(fields[0], InBucket(uint32(bucket)), MergeOperand(operator))
+	if operator != "" {
+		entry.value = fields[1]
+		return entry, nil
+	}
+	switch len(fields) {
+	case 1:
+		entry.deleted = true
+	case 3:
+		t, err := time.Parse(TextTimeLayout, fields[2])
+		if err != nil {
+			return DBFileEntry{}, &SyntaxError{Msg: fmt.Sprintf("bad expiry time %q", fields[2])}
+		}
+		entry.expires = t.UnixNano()
+		fallthrough
+	case 2:
+		entry.value = fields[1]
+	default:
+		return DBFileEntry{}, &SyntaxError{Msg: "too many fields"}
+	}
+	return entry, nil
+}
+
+// A TextEncoder writes entries in a human-readable text format, one entry per line. The format is
+// described by ParseText.
+type TextEncoder struct {
+	w io.Writer
+}
+
+// NewTextEncoder creates a new TextEncoder that will write entries to a writer.
+func NewTextEncoder(w io.Writer) *TextEncoder {
+	return &TextEncoder{w: w}
+}
+
+// Encode writes an entry as a line of text.
+func (e *TextEncoder) Encode(entry DBFileEntry) (int, error) {
+	return io.WriteString(e.w, entry.text()+"\n")
+}
+
+// A TextDecoder reads entries written by a TextEncoder. Blank lines are skipped.
+type TextDecoder struct {
+	r    *bufio.Reader
+	line int
+}
+
+// NewTextDecoder creates a new TextDecoder that will read from an io.Reader.
+func NewTextDecoder(r io.Reader) *TextDecoder {
+	return &TextDecoder{r: bufio.NewReaderSize(r, BufferSize)}
+}
+
+// Decode reads the next entry. It returns the number of bytes it read, including any blank lines it
+// skipped, io.EOF when there are no more entries, and a SyntaxError if a line is not a valid entry.
+func (d *TextDecoder) Decode(entry *DBFileEntry) (int, error) {
+	n := 0
+	for {
+		line, err := d.r.ReadString('\n')
+		n += len(line)
+		if line == "" && err != nil {
+			return n, err
+		}
+		d.line++
+
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			continue
+		}
+
+		e, perr := ParseText(line)
+		if perr != nil {
+			perr.(*SyntaxError).Line = d.line
+			return n, perr
+		}
+		*entry = e
+		return n, nil
+	}
+}
+
+// Transcode copies every entry from dec to enc, for instance to convert a DBFile's log to text or back,
+// and returns how many it copied.
+func Transcode(enc EntryEncoder, dec EntryDecoder) (int, error) {
+	count := 0
+	for {
+		var entry DBFileEntry
+		_, err := dec.Decode(&entry)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if _, err := enc.Encode(entry); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// WriteText writes the DBFile's whole log, including overwritten entries and tombstones, to w in the text
+// format, and returns how many entries it wrote. Like Backup, it copies the entries committed when it
+// was called, and may be used while the DBFile is being written to.
+func (d *DBFile) WriteText(w io.Writer) (int, error) {
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	src, release := d.acquire()
+	end := d.Offset
+	d.mu.RUnlock()
+	defer release()
+
+	bw := bufio.NewWriterSize(w, BufferSize)
+	count, err := Transcode(NewTextEncoder(bw), NewDecoder(bufio.NewReaderSize(io.NewSectionReader(src, 0, end), BufferSize)))
+	if err != nil {
+		return count, err
+	}
+	return count, bw.Flush()
+}
+
+// ReadText creates a new DBFile log at path, which must not already exist, from entries in the text
+// format read from r, and returns how many entries it wrote. If r holds a line that is not a valid entry,
+// ReadText returns its SyntaxError and removes the file.
+func ReadText(r io.Reader, path string) (count int, err error) {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	w := bufio.NewWriterSize(out, BufferSize)
+	if count, err = Transcode(NewEncoder(w), NewTextDecoder(r)); err != nil {
+		return count, err
+	}
+	if err = w.Flush(); err != nil {
+		return count, err
+	}
+	return count, out.Sync()
+}
@@ -0,0 +1,144 @@
+package file_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextEncoder_EscapesAndMarksEntries(t *testing.T) {
+	expires := time.Date(2026, 10, 19, 10, 30, 0, 500, time.UTC)
+	buf := new(bytes.Buffer)
+	enc := file.NewTextEncoder(buf)
+	enc.Encode(file.NewEntry("a", file.Value("1")))
+	enc.Encode(file.NewEntry("k:ey", file.Value("multi\r\nline \\ value")))
+	enc.Encode(file.NewEntry("gone", file.Deleted))
+	enc.Encode(file.NewEntry("ttl", file.Value("x"), file.Expires(expires)))
+
+	assert.Equal(t, `a:1
+k\:ey:multi\r\nline \\ value
+gone
+ttl:x:20261019T103000.0000005Z
+`, buf.String())
+}
+
+func TestTextDecoder_RoundTripsEncoder(t *testing.T) {
+	entries := []file.DBFileEntry{
+		file.NewEntry("a", file.Value("1")),
+		file.NewEntry("k:ey\\", file.Value("multi\r\nline:\x00\xff")),
+		file.NewEntry("", file.Value("empty key")),
+		file.NewEntry("b", file.Value("")),
+		file.NewEntry("gone", file.Deleted),
+		file.NewEntry("ttl", file.Value("x"), file.Expires(time.Now())),
+	}
+	buf := new(bytes.Buffer)
+	enc := file.NewTextEncoder(buf)
+	for _, e := range entries {
+		_, err := enc.Encode(e)
+		require.NoError(t, err)
+	}
+	size := buf.Len()
+
+	dec := file.NewTextDecoder(buf)
+	total := 0
+	for _, want := range entries {
+		var got file.DBFileEntry
+		n, err := dec.Decode(&got)
+		require.NoError(t, err)
+		total += n
+		assert.True(t, want.Equals(got), "want %v, got %v", want, got)
+	}
+	var entry file.DBFileEntry
+	_, err := dec.Decode(&entry)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, size, total)
+}
+
+func TestTextDecoder_ReportsLineOfBadEntry(t *testing.T) {
+	dec := file.NewTextDecoder(strings.NewReader("a:1\r\n\nb:2:3:4\n"))
+	var entry file.DBFileEntry
+	_, err := dec.Decode(&entry)
+	require.NoError(t, err)
+	assert.Equal(t, "1", entry.Value())
+
+	_, err = dec.Decode(&entry)
+	var serr *file.SyntaxError
+	require.True(t, errors.As(err, &serr))
+	assert.Equal(t, 3, serr.Line)
+	assert.True(t, errors.Is(err, file.ErrCorrupt))
+}
+
+func TestParseText(t *testing.T) {
+	tt := []struct {
+		line    string
+		want    file.DBFileEntry
+		wantErr bool
+	}{
+		{"key:value", file.NewEntry("key", file.Value("value")), false},
+		{"key:", file.NewEntry("key", file.Value("")), false},
+		{"key", file.NewEntry("key", file.Deleted), false},
+		{`a\:b:c\nd`, file.NewEntry("a:b", file.Value("c\nd")), false},
+		{"k:v:20261019T103000Z", file.NewEntry("k", file.Value("v"), file.Expires(time.Date(2026, 10, 19, 10, 30, 0, 0, time.UTC))), false},
+		{"k:v:tomorrow", file.DBFileEntry{}, true},
+		{"k:v:20261019T103000Z:extra", file.DBFileEntry{}, true},
+		{`k:\q`, file.DBFileEntry{}, true},
+		{`k:v\`, file.DBFileEntry{}, true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.line, func(t *testing.T) {
+			got, err := file.ParseText(tc.line)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tc.want.Equals(got), "want %v, got %v", tc.want, got)
+		})
+	}
+}
+
+func TestParseEntry_PanicsOnBadEntry(t *testing.T) {
+	assert.Panics(t, func() { file.ParseEntry(`k:\q`) })
+}
+
+func TestWriteText_ReadTextRebuildsLog(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(file.NewEntry("a", file.Value("2:two")))
+	d.DeleteEntry("a")
+	d.WriteEntry(file.NewEntry("b", file.Value("x"), file.TTL(time.Hour)))
+
+	buf := new(bytes.Buffer)
+	n, err := d.WriteText(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	os.Remove(restoreTestDat)
+	defer os.Remove(restoreTestDat)
+	n, err = file.ReadText(buf, restoreTestDat)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	want, err := ioutil.ReadFile(d.File.Name())
+	require.NoError(t, err)
+	got, err := ioutil.ReadFile(restoreTestDat)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestReadText_RemovesFileOnBadLine(t *testing.T) {
+	os.Remove(restoreTestDat)
+	_, err := file.ReadText(strings.NewReader("a:1\nb:\\x\n"), restoreTestDat)
+	assert.True(t, errors.Is(err, file.ErrCorrupt))
+	assert.NoFileExists(t, restoreTestDat)
+}
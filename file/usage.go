@@ -0,0 +1,52 @@
+package file
+
+import "time"
+
+// A Usage describes how the space in a DBFile's log is used.
+type Usage struct {
+	Size      int64 // The size of the log, in bytes.
+	Keys      int   // The number of keys in the index, including any that have expired but not been removed.
+	LiveBytes int64 // The bytes taken by the latest entries of keys that have not expired.
+	Appended  int64 // The bytes appended to the log since the DBFile was opened.
+
+	// The number of tombstones in the log, which compacting the DBFile would remove.
+	Tombstones int
+}
+
+// DeadBytes returns the bytes taken by overwritten, deleted and expired entries, which compacting the
+// DBFile would reclaim.
+func (u Usage) DeadBytes() int64 {
+	return u.Size - u.LiveBytes
+}
+
+// GarbageRatio returns the fraction of the log taken by dead bytes.
+func (u Usage) GarbageRatio() float64 {
+	if u.Size == 0 {
+		return 0
+	}
+	return float64(u.DeadBytes()) / float64(u.Size)
+}
+
+// Usage reports how the space in the DBFile's log is used. It is kept up to date as entries are indexed,
+// so it reads no entries; only keys that will expire are checked, to leave out those that have.
+func (d *DBFile) Usage() (Usage, error) {
+	if d.follow {
+		d.Refresh()
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	// The entry marking the log's generation is not garbage, though no key refers to it.
+	u := Usage{Size: d.Offset, Keys: len(d.Index) + d.buckets.keys(), LiveBytes: int64(d.buckets.generation), Appended: d.appended}
+	for _, n := range d.buckets.live {
+		u.LiveBytes += n
+	}
+	for _, n := range d.buckets.expiredBytes(time.Now()) {
+		u.LiveBytes -= n
+	}
+	for _, n := range d.buckets.tombstones {
+		u.Tombstones += n
+	}
+	return u, nil
+}
@@ -0,0 +1,85 @@
+package file_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsage_CountsLiveAndDeadBytes(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	live := file.NewEntry("a", file.Value("2"))
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(live)
+	d.WriteBatch(file.NewBatch().Write("b", "x").Delete("b"))
+	d.WriteEntry(file.NewEntry("gone", file.Value("x"), file.TTL(50*time.Millisecond)))
+	time.Sleep(60 * time.Millisecond)
+
+	u, err := d.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, d.CurrentOffset(), u.Size)
+	assert.Equal(t, u.Size, u.Appended)
+	assert.Equal(t, 2, u.Keys)
+	assert.Equal(t, 1, u.Tombstones)
+	assert.Equal(t, int64(len(EncodeEntries(live))), u.LiveBytes)
+	assert.Equal(t, u.Size-u.LiveBytes, u.DeadBytes())
+	assert.InDelta(t, float64(u.DeadBytes())/float64(u.Size), u.GarbageRatio(), 1e-9)
+}
+
+func TestUsage_EmptyFile(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	u, err := d.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, file.Usage{}, u)
+	assert.Equal(t, 0.0, u.GarbageRatio())
+}
+
+func TestUsage_MatchesVerifyAsTheLogChanges(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	check := func(step string) {
+		u, err := d.Usage()
+		require.NoError(t, err)
+		r := d.Verify()
+		assert.Equal(t, r.LiveBytes, u.LiveBytes, step)
+		assert.Equal(t, r.Tombstones, u.Tombstones, step)
+	}
+
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(file.NewEntry("a", file.Value("longer value")))
+	d.DeleteEntry("a")
+	d.DeleteEntry("never written")
+	check("writes and deletes")
+
+	d.WriteEntry(counterOperand("hits", "1"))
+	d.WriteEntry(counterOperand("hits", "2"))
+	d.WriteEntry(file.NewEntry("n", file.Value("5")))
+	d.WriteEntry(counterOperand("n", "1"))
+	check("merge operands")
+
+	users, err := d.Bucket("users")
+	require.NoError(t, err)
+	users.WriteEntry(file.NewEntry("u", file.Value("x")))
+	users.WriteEntry(file.NewEntry("u", file.Value("y")))
+	bu, err := users.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(EncodeEntries(file.NewEntry("u", file.Value("y"), file.InBucket(users.ID()))))), bu.LiveBytes)
+	check("buckets")
+
+	require.NoError(t, d.DropBucket("users"))
+	check("dropped bucket")
+
+	_, err = d.Compact()
+	require.NoError(t, err)
+	check("compacted")
+	d.Reindex()
+	check("reindexed")
+}
@@ -0,0 +1,249 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An IndexError describes an index entry that does not agree with the content of the DBFile.
+type IndexError struct {
+	Bucket  uint32 `json:"bucket,omitempty"` // The id of the key's bucket.
+	Key     string `json:"key"`
+	Offset  int64  `json:"offset"`
+	Problem string `json:"problem"`
+}
+
+// A Report describes the consistency of a DBFile, as determined by Verify.
+type Report struct {
+	Path               string       `json:"path"`
+	Size               int64        `json:"size"`
+	Entries            int          `json:"entries"`
+	Tombstones         int          `json:"tombstones"`
+	LiveKeys           int          `json:"live_keys"`
+	LiveBytes          int64        `json:"live_bytes"`
+	GarbageRatio       float64      `json:"garbage_ratio"`
+	OrphanedTombstones []string     `json:"orphaned_tombstones"`
+	CorruptRanges      []Range      `json:"corrupt_ranges"`
+	IndexErrors        []IndexError `json:"index_errors"`
+}
+
+// OK returns true if the Report found no corruption and no index errors. Orphaned tombstones and garbage
+// are wasteful, but not errors.
+func (r *Report) OK() bool {
+	return len(r.CorruptRanges) == 0 && len(r.IndexErrors) == 0
+}
+
+// String presents the Report as human-readable text.
+func (r *Report) String() string {
+	b := new(strings.Builder)
+	status := "OK"
+	if !r.OK() {
+		status = "ERRORS FOUND"
+	}
+
+	fmt.Fprintf(b, `
+Check %s: %s
+-----------
+File Size: %d
+Entries: %d
+Tombstones: %d
+Orphaned Tombstones: %d
+Live Keys: %d
+Live Bytes: %d
+Garbage Ratio: %.2f
+Corrupt Ranges: %d
+Index Errors: %d
+`, r.Path, status, r.Size, r.Entries, r.Tombstones, len(r.OrphanedTombstones), r.LiveKeys, r.LiveBytes,
+		r.GarbageRatio, len(r.CorruptRanges), len(r.IndexErrors))
+
+	for _, c := range r.CorruptRanges {
+		fmt.Fprintf(b, "  corrupt: bytes %d-%d (%d bytes)\n", c.Start, c.End, c.Len())
+	}
+	for _, e := range r.IndexErrors {
+		if e.Bucket != DefaultBucket {
+			fmt.Fprintf(b, "  index: bucket %d key %q at offset %d: %s\n", e.Bucket, e.Key, e.Offset, e.Problem)
+			continue
+		}
+		fmt.Fprintf(b, "  index: key %q at offset %d: %s\n", e.Key, e.Offset, e.Problem)
+	}
+	return b.String()
+}
+
+// Verify checks the consistency of the DBFile at filepath. It walks the whole file, validating that every
+// entry decodes, and compares what it finds with the index that opening the file would build.
+func Verify(filepath string) (*Report, error) {
+	file, err := openFile(filepath, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	index, buckets := make(DBIndex), newBucketIndexes()
+	indexFrom(io.NewSectionReader(file, 0, info.Size()), index, buckets, 0)
+	return verify(filepath, file, info.Size(), index, buckets), nil
+}
+
+// Verify checks the consistency of the DBFile's content and of its in-memory index.
+func (d *DBFile) Verify() *Report {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	report := verify(d.File.Name(), d.File, d.Offset, d.Index, d.buckets)
+	for _, r := range report.CorruptRanges {
+		d.corrupted(r, ErrCorrupt)
+	}
+	return report
+}
+
+// A bucketKey identifies a key in a bucket.
+type bucketKey struct {
+	bucket uint32
+	key    string
+}
+
+// verify builds a Report on the first size bytes of r, checking that index, the default bucket's, and the
+// indexes of the named buckets point at the latest entry for every live key.
+func verify(path string, r io.ReaderAt, size int64, index DBIndex, buckets *bucketIndexes) *Report {
+	report := &Report{Path: path, Size: size}
+
+	type occurrence struct {
+		offset int64
+		n      int
+	}
+	latest := make(map[bucketKey]occurrence)
+	orphans := make(map[string]bool)
+	live := map[uint32]bool{DefaultBucket: true, catalogBucket: true}
+	now := time.Now()
+
+	report.CorruptRanges = scanEntries(r, size, func(offset int64, n int, entry DBFileEntry) {
+		report.Entries++
+		k := bucketKey{entry.bucket, entry.key}
+		if entry.bucket == catalogBucket {
+			// Redefining or dropping a bucket discards everything in it.
+			var old DBFileEntry
+			if o, found := latest[k]; found {
+				if _, err := decodeAt(r, o.offset, size, &old); err == nil {
+					if id, ok := catalogID(old); ok {
+						delete(live, id)
+						for other := range latest {
+							if other.bucket == id {
+								delete(latest, other)
+							}
+						}
+					}
+				}
+			}
+			if id, ok := catalogID(entry); ok {
+				live[id] = true
+			}
+		}
+		if entry.bucket == generationBucket && offset == 0 {
+			// The entry marking the log's generation is not garbage, though no key refers to it.
+			report.LiveBytes += int64(n)
+			return
+		}
+		if !live[entry.bucket] {
+			return
+		}
+		if entry.deleted {
+			report.Tombstones++
+			if _, found := latest[k]; !found {
+				orphans[entry.key] = true
+			}
+			delete(latest, k)
+			return
+		}
+		if o, found := latest[k]; found && entry.operator != "" {
+			// The index points at the first entry of a key with merge operands, not its latest.
+			latest[k] = occurrence{o.offset, o.n + n}
+			return
+		}
+		if entry.Expired(now) {
+			delete(latest, k)
+			return
+		}
+		latest[k] = occurrence{offset, n}
+	})
+
+	indexOf := func(bucket uint32) DBIndex {
+		switch bucket {
+		case DefaultBucket:
+			return index
+		case catalogBucket:
+			return buckets.catalog
+		}
+		return buckets.indexes[bucket]
+	}
+
+	for k, o := range latest {
+		if k.bucket != catalogBucket {
+			report.LiveKeys++
+		}
+		report.LiveBytes += int64(o.n)
+
+		offset, found := indexOf(k.bucket)[k.key]
+		switch {
+		case !found:
+			report.addIndexError(k, o.offset, "missing from index")
+		case offset != o.offset:
+			report.addIndexError(k, offset, fmt.Sprintf("index points at an older entry; latest is at %d", o.offset))
+		}
+	}
+
+	all := map[uint32]DBIndex{DefaultBucket: index, catalogBucket: buckets.catalog}
+	for id, bucket := range buckets.indexes {
+		all[id] = bucket
+	}
+	var entry DBFileEntry
+	for id, bucket := range all {
+		for key, offset := range bucket {
+			k := bucketKey{id, key}
+			if _, found := latest[k]; found {
+				continue
+			}
+			if _, err := decodeAt(r, offset, size, &entry); err != nil {
+				report.addIndexError(k, offset, "offset does not hold a valid entry")
+				continue
+			}
+			if entry.key != key || entry.bucket != id {
+				report.addIndexError(k, offset, fmt.Sprintf("offset holds key %q in bucket %d", entry.key, entry.bucket))
+				continue
+			}
+			// An index is allowed to hold keys that have expired since they were indexed.
+			if !entry.deleted && entry.Expired(now) {
+				continue
+			}
+			report.addIndexError(k, offset, "key has been deleted")
+		}
+	}
+
+	for key := range orphans {
+		report.OrphanedTombstones = append(report.OrphanedTombstones, key)
+	}
+	sort.Strings(report.OrphanedTombstones)
+	sort.Slice(report.IndexErrors, func(i, j int) bool {
+		a, b := report.IndexErrors[i], report.IndexErrors[j]
+		if a.Bucket != b.Bucket {
+			return a.Bucket < b.Bucket
+		}
+		return a.Key < b.Key
+	})
+
+	if size > 0 {
+		report.GarbageRatio = float64(size-report.LiveBytes) / float64(size)
+	}
+	return report
+}
+
+func (r *Report) addIndexError(k bucketKey, offset int64, problem string) {
+	r.IndexErrors = append(r.IndexErrors, IndexError{k.bucket, k.key, offset, problem})
+}
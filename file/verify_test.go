@@ -0,0 +1,140 @@
+package file_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func WriteVerifyTestDat(t *testing.T, content []byte) (filepath string, cleanup func()) {
+	filepath = "verify_test.dat"
+	require.NoError(t, ioutil.WriteFile(filepath, content, 0666))
+	return filepath, func() { os.Remove(filepath) }
+}
+
+func EncodeEntries(entry ...file.DBFileEntry) []byte {
+	buf := new(bytes.Buffer)
+	for _, e := range entry {
+		file.EncodeTo(buf, e)
+	}
+	return buf.Bytes()
+}
+
+func TestVerify_CleanFile(t *testing.T) {
+	path, cleanup := WriteVerifyTestDat(t, EncodeEntries(
+		file.NewEntry("a", file.Value("1")),
+		file.NewEntry("b", file.Value("2")),
+	))
+	defer cleanup()
+
+	report, err := file.Verify(path)
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 2, report.Entries)
+	assert.Equal(t, 2, report.LiveKeys)
+	assert.Equal(t, report.Size, report.LiveBytes)
+	assert.Equal(t, 0.0, report.GarbageRatio)
+}
+
+func TestVerify_CountsGarbageAndOrphanedTombstones(t *testing.T) {
+	content := EncodeEntries(
+		file.NewEntry("a", file.Value("1")),
+		file.NewEntry("a", file.Value("2")),
+		file.NewEntry("never", file.Deleted),
+	)
+	path, cleanup := WriteVerifyTestDat(t, content)
+	defer cleanup()
+
+	report, err := file.Verify(path)
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 1, report.Tombstones)
+	assert.Equal(t, []string{"never"}, report.OrphanedTombstones)
+	assert.Equal(t, 1, report.LiveKeys)
+	assert.Greater(t, report.GarbageRatio, 0.5)
+}
+
+func TestVerify_ReportsCorruptRangeAndKeysMissingFromIndex(t *testing.T) {
+	before := EncodeEntries(file.NewEntry("before", file.Value("1")))
+	garbage := []byte{0xff, 0xfe, 0xfd}
+	after := EncodeEntries(
+		file.NewEntry("after", file.Value("2")),
+		file.NewEntry("last", file.Value("3")),
+	)
+	content := append(append(append([]byte{}, before...), garbage...), after...)
+	path, cleanup := WriteVerifyTestDat(t, content)
+	defer cleanup()
+
+	report, err := file.Verify(path)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	require.Len(t, report.CorruptRanges, 1)
+	assert.Equal(t, file.Range{Start: int64(len(before)), End: int64(len(before) + len(garbage))}, report.CorruptRanges[0])
+	assert.Equal(t, 3, report.Entries)
+
+	// Opening the file stops indexing at the corruption, so the later keys are unreachable.
+	require.Len(t, report.IndexErrors, 2)
+	assert.Equal(t, "after", report.IndexErrors[0].Key)
+	assert.Equal(t, "last", report.IndexErrors[1].Key)
+}
+
+func TestVerify_ReportsTornTail(t *testing.T) {
+	content := EncodeEntries(file.NewEntry("a", file.Value("1")), file.NewEntry("b", file.Value("2")))
+	path, cleanup := WriteVerifyTestDat(t, content[:len(content)-1])
+	defer cleanup()
+
+	report, err := file.Verify(path)
+	require.NoError(t, err)
+	require.Len(t, report.CorruptRanges, 1)
+	assert.Equal(t, int64(len(content)-1), report.CorruptRanges[0].End)
+	assert.Equal(t, 1, report.Entries)
+}
+
+func TestDBFileVerify_ChecksIndex(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(file.NewEntry("a", file.Value("2")))
+	d.WriteEntry(file.NewEntry("b", file.Value("3")))
+	require.True(t, d.Verify().OK())
+
+	d.Index["a"] = 0
+	d.Index["b"] = 1
+	d.Index["c"] = 0
+	report := d.Verify()
+	require.Len(t, report.IndexErrors, 3)
+	assert.Equal(t, "a", report.IndexErrors[0].Key)
+	assert.Equal(t, "b", report.IndexErrors[1].Key)
+	assert.Equal(t, "c", report.IndexErrors[2].Key)
+}
+
+func TestReport_MarshalsToJSON(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+
+	b, err := json.Marshal(d.Verify())
+	require.NoError(t, err)
+
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, float64(1), got["live_keys"])
+	assert.Contains(t, got, "corrupt_ranges")
+}
+
+func TestDebug_WritesToWriter(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+
+	buf := new(bytes.Buffer)
+	d.Debug(buf, "a")
+	assert.Contains(t, buf.String(), "Key Occurrences: 1")
+	assert.Contains(t, buf.String(), "Key Found: true")
+}
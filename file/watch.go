@@ -0,0 +1,150 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// watchChunk is the most log a Watcher reads at once.
+	watchChunk = 64 * 1024
+
+	// FollowPollInterval is how often a Watcher on a DBFile opened with Follow checks for entries
+	// written by another process, since it is not told about them.
+	FollowPollInterval = 100 * time.Millisecond
+)
+
+// An Event describes a change made to a key. If the change was a merge operand, as Merge and increments
+// write, Operator names its MergeOperator and Value is the operand rather than the key's new value; a
+// consumer that tracks the key's value folds the operand into it with the MergeOperator, as a read does.
+type Event struct {
+	Key      string
+	Value    string
+	Operator string // The name of the MergeOperator for a merge operand, or "" for an ordinary change.
+	Deleted  bool
+	Expires  time.Time // When the new value expires, or the zero Time if it never does.
+	Offset   int64     // The offset of the entry that made the change.
+	Next     int64     // The offset just past the entry; pass it to Watch to resume after this event.
+}
+
+// A Watcher delivers an Event for every change made to keys with a given prefix. Changes are delivered
+// in the order they were committed.
+type Watcher struct {
+	events chan Event
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+// Watch returns a Watcher for the changes made to keys in the default bucket with the given prefix,
+// starting with the entry at offset from. Passing 0 replays the whole log; passing CurrentOffset watches only for new changes.
+// Passing an Event's Next resumes where that event left off. The Watcher stops when ctx is done, when
+// Stop is called, or when it fails, for instance because from is beyond the end of the DBFile, or with
+// ErrCompacted because the DBFile was compacted, which changes the offsets of its entries.
+func (d *DBFile) Watch(ctx context.Context, prefix string, from int64) *Watcher {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &Watcher{
+		events: make(chan Event),
+		cancel: cancel,
+	}
+	generation := d.Generation()
+	go func() {
+		defer close(w.events)
+		w.setErr(d.watch(ctx, prefix, generation, from, w.events))
+	}()
+	return w
+}
+
+// Events returns the channel on which the Watcher delivers events. It is closed when the Watcher stops.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Stop stops the Watcher. Events that have not yet been received are discarded.
+func (w *Watcher) Stop() {
+	w.cancel()
+}
+
+// Err returns the error that made the Watcher stop, or nil if it was stopped or its context ended. It
+// should be called after the Events channel is closed.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *Watcher) setErr(err error) {
+	w.mu.Lock()
+	w.err = err
+	w.mu.Unlock()
+}
+
+// watch tails the log from offset, taken from the given generation of the log, sending the changes to
+// keys with the prefix to events until ctx is done or the log cannot be read. If the log is compacted
+// while it is being watched, watch returns ErrCompacted.
+func (d *DBFile) watch(ctx context.Context, prefix string, generation string, offset int64, events chan<- Event) error {
+	var pending []byte
+	for {
+		changed := d.Changed()
+		raw, end, current, err := d.readLog(generation, offset+int64(len(pending)), watchChunk)
+		if err != nil {
+			return err
+		}
+		generation = current
+
+		// A chunk may end partway through an entry; hold on to the start of it until the rest is read.
+		pending = append(pending, raw...)
+		dec := NewDecoder(bytes.NewReader(pending))
+		consumed := 0
+		for {
+			var entry DBFileEntry
+			n, err := dec.Decode(&entry)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			consumed += n
+
+			if entry.bucket == DefaultBucket && strings.HasPrefix(entry.key, prefix) {
+				event := Event{
+					Key:      entry.key,
+					Value:    entry.value,
+					Operator: entry.operator,
+					Deleted:  entry.deleted,
+					Expires:  entry.Expires(),
+					Offset:   offset,
+					Next:     offset + int64(n),
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			offset += int64(n)
+		}
+		pending = append(pending[:0], pending[consumed:]...)
+
+		if offset+int64(len(pending)) < end {
+			continue
+		}
+
+		var poll <-chan time.Time
+		if d.follow {
+			poll = time.After(FollowPollInterval)
+		}
+		select {
+		case <-changed:
+		case <-poll:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
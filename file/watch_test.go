@@ -0,0 +1,119 @@
+package file_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func NextEvent(t *testing.T, w *file.Watcher) file.Event {
+	select {
+	case e, ok := <-w.Events():
+		require.True(t, ok, "watcher stopped: %v", w.Err())
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+	return file.Event{}
+}
+
+func TestWatch_DeliversNewChangesWithPrefix(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("user:old", file.Value("0")))
+
+	w := d.Watch(context.Background(), "user:", d.CurrentOffset())
+	defer w.Stop()
+
+	start := d.CurrentOffset()
+	d.WriteEntry(file.NewEntry("user:1", file.Value("a")))
+	d.WriteEntry(file.NewEntry("other", file.Value("b")))
+	d.DeleteEntry("user:1")
+
+	e := NextEvent(t, w)
+	assert.Equal(t, file.Event{Key: "user:1", Value: "a", Offset: start, Next: e.Next}, e)
+	e = NextEvent(t, w)
+	assert.Equal(t, "user:1", e.Key)
+	assert.True(t, e.Deleted)
+	assert.Equal(t, d.CurrentOffset(), e.Next)
+}
+
+func TestWatch_DeliversMergeOperandsWithTheirOperator(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("hits", file.Value("10")))
+
+	w := d.Watch(context.Background(), "", d.CurrentOffset())
+	defer w.Stop()
+	d.Merge(counterOperand("hits", "2"))
+
+	e := NextEvent(t, w)
+	assert.Equal(t, "counter", e.Operator)
+	assert.Equal(t, "2", e.Value)
+	value, err := file.Counter.Merge(e.Key, "10", true, []string{e.Value})
+	require.NoError(t, err)
+	assert.Equal(t, d.ReadEntry("hits").Value(), value)
+}
+
+func TestWatch_ResumesFromOffset(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+	d.WriteEntry(file.NewEntry("a", file.Value("1")))
+	d.WriteEntry(file.NewEntry("b", file.Value("2")))
+
+	w := d.Watch(context.Background(), "", 0)
+	first := NextEvent(t, w)
+	assert.Equal(t, "a", first.Key)
+	w.Stop()
+
+	w = d.Watch(context.Background(), "", first.Next)
+	defer w.Stop()
+	assert.Equal(t, "b", NextEvent(t, w).Key)
+	d.WriteBatch(file.NewBatch().Write("c", "3"))
+	assert.Equal(t, "c", NextEvent(t, w).Key)
+}
+
+func TestWatch_StopsWithContext(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := d.Watch(ctx, "", 0)
+	cancel()
+
+	select {
+	case _, ok := <-w.Events():
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("watcher did not stop")
+	}
+	assert.NoError(t, w.Err())
+}
+
+func TestWatch_OffsetBeyondEndFails(t *testing.T) {
+	d, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	w := d.Watch(context.Background(), "", 100)
+	for range w.Events() {
+	}
+	assert.Equal(t, file.ErrOffsetOutOfRange, w.Err())
+}
+
+func TestWatch_FollowSeesOtherWriter(t *testing.T) {
+	w, cleanup := SetupFileTestDat()
+	defer cleanup()
+
+	d, err := file.Open(w.File.Name(), file.Follow)
+	require.NoError(t, err)
+	defer d.Close()
+
+	watcher := d.Watch(context.Background(), "", d.CurrentOffset())
+	defer watcher.Stop()
+	w.WriteEntry(file.NewEntry("a", file.Value("1")))
+	assert.Equal(t, "a", NextEvent(t, watcher).Key)
+}
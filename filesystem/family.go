@@ -0,0 +1,357 @@
+package filesystem
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/matthew-burr/db/file"
+)
+
+// ErrNoFamily is returned when using a column family that was not opened.
+var ErrNoFamily = errors.New("column family not found")
+
+// familyName matches the names a column family may have.
+var familyName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// FileOptions is an Option that opens the database's default column family, which is kept in its .dat
+// file, with the given file options, such as file.Sync and file.Compress.
+func FileOptions(option ...file.OpenOption) Option {
+	return func(d *DBFileSystem) {
+		d.fileOptions = append(d.fileOptions, option...)
+	}
+}
+
+// Family is an Option that opens a column family alongside the database's default one. A column family
+// is a separate set of keys kept in a log of its own, <dbName>.<name>.dat, and opened with its own file
+// options, such as file.Sync, file.Compress, file.AutoCompact and file.DefaultTTL, in addition to those
+// that apply to the whole database, such as ReadOnly. A family's name may hold letters, digits, _ and -.
+// Families are not recorded anywhere; each must be named every time the database is opened.
+func Family(name string, option ...file.OpenOption) Option {
+	return func(d *DBFileSystem) {
+		d.familySpecs = append(d.familySpecs, familySpec{name, option})
+	}
+}
+
+type familySpec struct {
+	name    string
+	options []file.OpenOption
+}
+
+// familyPath returns the path of a column family's log.
+func familyPath(dbName, name string) string {
+	if name == "" {
+		return dbName + ".dat"
+	}
+	return dbName + "." + name + ".dat"
+}
+
+// openFamilies opens the column families named by Family options, and then, unless the database is
+// read-only, applies any family batch that a crash interrupted. The batch log is only created once a
+// FamilyBatch is first written.
+func (d *DBFileSystem) openFamilies(dbName string) error {
+	d.families = map[string]*file.DBFile{"": d.File}
+	for _, spec := range d.familySpecs {
+		if !familyName.MatchString(spec.name) {
+			return fmt.Errorf("invalid column family name %q", spec.name)
+		}
+		if _, found := d.families[spec.name]; found {
+			return fmt.Errorf("column family %q is opened twice", spec.name)
+		}
+		option := append(append([]file.OpenOption{}, d.fileOptions...), spec.options...)
+		f, err := file.Open(familyPath(dbName, spec.name), option...)
+		if err != nil {
+			return err
+		}
+		d.families[spec.name] = f
+	}
+	if d.readOnly {
+		return nil
+	}
+
+	d.batchPath = dbName + ".batch"
+	batchLog, err := os.OpenFile(d.batchPath, os.O_RDWR, 0666)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d.batchLog = batchLog
+	return d.recoverFamilyBatch()
+}
+
+// Family returns the DBFile holding a column family, or for the empty name, the default family, which is
+// the DBFileSystem's File. It returns ErrNoFamily if the family was not opened.
+func (d *DBFileSystem) Family(name string) (*file.DBFile, error) {
+	f, found := d.families[name]
+	if !found {
+		return nil, ErrNoFamily
+	}
+	return f, nil
+}
+
+// Families returns the names of the column families that were opened, not including the default family.
+func (d *DBFileSystem) Families() []string {
+	names := make([]string, 0, len(d.families)-1)
+	for name := range d.families {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// A FamilyBatch groups batches of writes and deletes for several column families so that
+// WriteFamilyBatch can apply them together, atomically if the process crashes.
+type FamilyBatch struct {
+	names   []string
+	batches map[string]*file.Batch
+}
+
+// NewFamilyBatch creates an empty FamilyBatch.
+func NewFamilyBatch() *FamilyBatch {
+	return &FamilyBatch{batches: make(map[string]*file.Batch)}
+}
+
+// Family returns the batch of writes and deletes for a column family, to which entries may be added.
+// The empty name is the default family.
+func (b *FamilyBatch) Family(name string) *file.Batch {
+	batch, found := b.batches[name]
+	if !found {
+		batch = file.NewBatch()
+		b.batches[name] = batch
+		b.names = append(b.names, name)
+	}
+	return batch
+}
+
+// familyWrite is one column family's part of a FamilyBatch, as recorded in the batch log.
+type familyWrite struct {
+	name   string
+	offset int64  // Where the entries are, or are to be, appended to the family's log.
+	raw    []byte // The encoded entries.
+	done   bool
+}
+
+// WriteFamilyBatch writes the batches for each column family in a FamilyBatch. Each family's batch is
+// applied as WriteBatch applies a batch, and the families' batches are applied atomically with respect to
+// crashes: the whole FamilyBatch is first recorded in the database's batch log, so that if the process
+// crashes part way through applying it, opening the database applies the rest. Concurrent calls are
+// applied one at a time.
+//
+// The families' batches are not applied atomically with respect to concurrent readers, though. Each
+// family's batch becomes visible as soon as it is applied, so a reader may see one family's writes before
+// another's.
+func (d *DBFileSystem) WriteFamilyBatch(b *FamilyBatch) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	if d.node != nil {
+		return ErrNotReplicated
+	}
+
+	writes := make([]*familyWrite, 0, len(b.names))
+	for _, name := range b.names {
+		f, err := d.Family(name)
+		if err != nil {
+			return err
+		}
+		raw, err := f.EncodeBatch(b.batches[name])
+		if err != nil {
+			return err
+		}
+		writes = append(writes, &familyWrite{name: name, raw: raw})
+	}
+
+	d.batchMu.Lock()
+	defer d.batchMu.Unlock()
+
+	if d.batchLog == nil {
+		batchLog, err := os.OpenFile(d.batchPath, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			return err
+		}
+		d.batchLog = batchLog
+	}
+
+	// Each family's entries are appended only where the log says they are, so that recovery can tell
+	// whether they were. If another write lands there first, the log is rewritten for the families that
+	// remain.
+	for remaining := len(writes); remaining > 0; {
+		for _, w := range writes {
+			if !w.done {
+				w.offset = d.families[w.name].CurrentOffset()
+			}
+		}
+		if err := d.logFamilyBatch(writes); err != nil {
+			return err
+		}
+		for _, w := range writes {
+			if w.done {
+				continue
+			}
+			_, err := d.families[w.name].AppendAt(w.raw, w.offset)
+			if err == file.ErrOffsetMoved {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			w.done = true
+			remaining--
+		}
+	}
+	return d.clearFamilyBatch()
+}
+
+// batchMagic begins every record in the batch log.
+var batchMagic = [8]byte{'D', 'B', 'B', 'A', 'T', 'C', 'H', '1'}
+
+// logFamilyBatch replaces the content of the batch log with a record of writes, and flushes it to stable
+// storage. The record is
+//
+//	magic, count (uint32), then for each family: name (uint16 length and bytes), offset (int64), entries
+//	(uint32 length and bytes), and finally a SHA-256 checksum of everything before it
+func (d *DBFileSystem) logFamilyBatch(writes []*familyWrite) error {
+	buf := new(bytes.Buffer)
+	buf.Write(batchMagic[:])
+	binary.Write(buf, binary.BigEndian, uint32(len(writes)))
+	for _, w := range writes {
+		binary.Write(buf, binary.BigEndian, uint16(len(w.name)))
+		buf.WriteString(w.name)
+		binary.Write(buf, binary.BigEndian, w.offset)
+		binary.Write(buf, binary.BigEndian, uint32(len(w.raw)))
+		buf.Write(w.raw)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:])
+
+	if err := d.batchLog.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := d.batchLog.WriteAt(buf.Bytes(), 0); err != nil {
+		return err
+	}
+	return d.batchLog.Sync()
+}
+
+// clearFamilyBatch empties the batch log once its record has been applied.
+func (d *DBFileSystem) clearFamilyBatch() error {
+	if err := d.batchLog.Truncate(0); err != nil {
+		return err
+	}
+	return d.batchLog.Sync()
+}
+
+// recoverFamilyBatch applies the family batch recorded in the batch log, if there is one, to each family
+// whose log does not already hold it, at the offset the record gives, after discarding any part of it
+// that was written before a crash. A record that is incomplete was never committed, so it is discarded.
+func (d *DBFileSystem) recoverFamilyBatch() error {
+	content, err := ioutil.ReadAll(io.NewSectionReader(d.batchLog, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+	writes, ok := parseFamilyBatch(content)
+	if !ok {
+		return d.clearFamilyBatch()
+	}
+
+	for _, w := range writes {
+		f, err := d.Family(w.name)
+		if err != nil {
+			return fmt.Errorf("the batch log holds a write to column family %q, which was not opened", w.name)
+		}
+		end := f.CurrentOffset()
+		if end < w.offset {
+			return fmt.Errorf("column family %q ends at %d, before its write in the batch log at %d", w.name, end, w.offset)
+		}
+		tail, _, err := f.ReadLog("", w.offset, len(w.raw))
+		if err != nil {
+			return err
+		}
+		switch {
+		case bytes.Equal(tail, w.raw):
+			// The write was applied.
+			continue
+		case end-w.offset < int64(len(w.raw)) && bytes.HasPrefix(w.raw, tail):
+			// The write was cut short, or never began; replace whatever of it was written.
+			if err := f.Truncate(w.offset); err != nil {
+				return err
+			}
+		default:
+			// Another write took the offset first, and the batch log was not rewritten before the crash,
+			// so the write was never applied; it goes at the end of the family's log instead.
+			w.offset = end
+		}
+		if n, err := f.AppendAt(w.raw, w.offset); err != nil {
+			return err
+		} else if n != len(w.raw) {
+			return fmt.Errorf("the batch log's write to column family %q is incomplete", w.name)
+		}
+	}
+	return d.clearFamilyBatch()
+}
+
+// parseFamilyBatch parses a record written by logFamilyBatch, returning false if it is incomplete or
+// damaged.
+func parseFamilyBatch(content []byte) ([]*familyWrite, bool) {
+	if len(content) < len(batchMagic)+sha256.Size {
+		return nil, false
+	}
+	body, sum := content[:len(content)-sha256.Size], content[len(content)-sha256.Size:]
+	if want := sha256.Sum256(body); !bytes.Equal(sum, want[:]) || !bytes.HasPrefix(body, batchMagic[:]) {
+		return nil, false
+	}
+
+	r := bytes.NewReader(body[len(batchMagic):])
+	var count uint32
+	if binary.Read(r, binary.BigEndian, &count) != nil {
+		return nil, false
+	}
+	writes := make([]*familyWrite, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var (
+			nameLen uint16
+			w       familyWrite
+			rawLen  uint32
+		)
+		if binary.Read(r, binary.BigEndian, &nameLen) != nil {
+			return nil, false
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, false
+		}
+		w.name = string(name)
+		if binary.Read(r, binary.BigEndian, &w.offset) != nil || binary.Read(r, binary.BigEndian, &rawLen) != nil {
+			return nil, false
+		}
+		w.raw = make([]byte, rawLen)
+		if _, err := io.ReadFull(r, w.raw); err != nil {
+			return nil, false
+		}
+		writes = append(writes, &w)
+	}
+	return writes, true
+}
+
+// closeFamilies closes the column families other than the default, and the batch log.
+func (d *DBFileSystem) closeFamilies() {
+	for name, f := range d.families {
+		if name != "" {
+			f.Close()
+		}
+	}
+	if d.batchLog != nil {
+		d.batchLog.Close()
+	}
+}
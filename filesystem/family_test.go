@@ -0,0 +1,186 @@
+package filesystem_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func SetupFamilies(option ...filesystem.Option) (*filesystem.DBFileSystem, func()) {
+	option = append([]filesystem.Option{
+		filesystem.Family("logs", file.Sync(file.SyncNever), file.Compress(64)),
+		filesystem.Family("config"),
+	}, option...)
+	fs, err := filesystem.Init("family_test", option...)
+	if err != nil {
+		panic(err)
+	}
+	return fs, func() {
+		fs.Close()
+		for _, name := range []string{"family_test.dat", "family_test.logs.dat", "family_test.config.dat", "family_test.lock", "family_test.batch"} {
+			os.Remove(name)
+		}
+	}
+}
+
+func TestFamily_KeepsKeysInSeparateFiles(t *testing.T) {
+	fs, cleanup := SetupFamilies()
+	defer cleanup()
+
+	logs, err := fs.Family("logs")
+	require.NoError(t, err)
+	config, err := fs.Family("config")
+	require.NoError(t, err)
+	def, err := fs.Family("")
+	require.NoError(t, err)
+	assert.Same(t, fs.File, def)
+	_, err = fs.Family("missing")
+	assert.Equal(t, filesystem.ErrNoFamily, err)
+	assert.Equal(t, []string{"config", "logs"}, fs.Families())
+
+	large := strings.Repeat("log line ", 50)
+	logs.WriteEntry(file.NewEntry("a", file.Value(large)))
+	config.WriteEntry(file.NewEntry("a", file.Value("on")))
+
+	assert.Equal(t, "family_test.logs.dat", logs.File.Name())
+	assert.Less(t, logs.CurrentOffset(), int64(len(large)), "the logs family compresses its values")
+	_, err = fs.GetEntry("a")
+	assert.Equal(t, file.ErrNotFound, err)
+	entry, err := config.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "on", entry.Value())
+}
+
+func TestInit_RejectsBadFamilyNames(t *testing.T) {
+	for _, name := range []string{"", "a.b", "../a"} {
+		_, err := filesystem.Init("family_test", filesystem.Family(name))
+		assert.Error(t, err, name)
+	}
+	_, err := filesystem.Init("family_test", filesystem.Family("a"), filesystem.Family("a"))
+	assert.Error(t, err)
+	os.Remove("family_test.dat")
+	os.Remove("family_test.a.dat")
+	os.Remove("family_test.lock")
+}
+
+func TestWriteFamilyBatch_WritesEveryFamily(t *testing.T) {
+	fs, cleanup := SetupFamilies()
+	defer cleanup()
+
+	b := filesystem.NewFamilyBatch()
+	b.Family("logs").Write("a", "1")
+	b.Family("config").Write("b", "2").Delete("c")
+	b.Family("").Write("d", "3")
+	require.NoError(t, fs.WriteFamilyBatch(b))
+
+	for name, key := range map[string]string{"logs": "a", "config": "b", "": "d"} {
+		f, _ := fs.Family(name)
+		_, err := f.Get(key)
+		assert.NoError(t, err, name)
+	}
+	info, err := os.Stat("family_test.batch")
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "the batch log is cleared once the batch is applied")
+
+	b = filesystem.NewFamilyBatch()
+	b.Family("missing").Write("a", "1")
+	assert.Equal(t, filesystem.ErrNoFamily, fs.WriteFamilyBatch(b))
+}
+
+func TestInit_RecoversInterruptedFamilyBatch(t *testing.T) {
+	fs, cleanup := SetupFamilies()
+	logs, _ := fs.Family("logs")
+	config, _ := fs.Family("config")
+	logs.WriteEntry(file.NewEntry("before", file.Value("x")))
+	logsOffset := logs.CurrentOffset()
+
+	b := filesystem.NewFamilyBatch()
+	b.Family("logs").Write("a", "1")
+	b.Family("config").Write("b", "2")
+	require.NoError(t, fs.WriteFamilyBatch(b))
+	logsRaw, _, _ := logs.ReadLog("", logsOffset, 1024)
+	configRaw, _, _ := config.ReadLog("", 0, 1024)
+	fs.Close()
+
+	// Simulate a crash after the batch was logged and applied to the logs family, but not the config
+	// family.
+	require.NoError(t, os.Truncate("family_test.config.dat", 0))
+	record := batchRecord(map[string]int64{"logs": logsOffset, "config": 0}, map[string][]byte{"logs": logsRaw, "config": configRaw})
+	require.NoError(t, ioutil.WriteFile("family_test.batch", record, 0666))
+
+	fs, _ = filesystem.Init("family_test", filesystem.Family("logs"), filesystem.Family("config"))
+	logs, _ = fs.Family("logs")
+	config, _ = fs.Family("config")
+	entry, err := config.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", entry.Value())
+	assert.Equal(t, logsOffset+int64(len(logsRaw)), logs.CurrentOffset(), "the logs family is not written twice")
+	fs.Close()
+
+	// A damaged record was never committed, so it is discarded.
+	require.NoError(t, ioutil.WriteFile("family_test.batch", record[:len(record)-1], 0666))
+	fs, cleanup = SetupFamilies()
+	defer cleanup()
+	config, _ = fs.Family("config")
+	assert.Equal(t, int64(len(configRaw)), config.CurrentOffset())
+}
+
+func TestInit_ReplacesTornFamilyBatchWrite(t *testing.T) {
+	fs, cleanup := SetupFamilies()
+	defer cleanup()
+	logs, _ := fs.Family("logs")
+	logs.WriteEntry(file.NewEntry("before", file.Value("x")))
+	logsOffset := logs.CurrentOffset()
+
+	b := filesystem.NewFamilyBatch()
+	b.Family("logs").Write("a", "1")
+	b.Family("logs").Write("b", "2")
+	require.NoError(t, fs.WriteFamilyBatch(b))
+	logsRaw, _, _ := logs.ReadLog("", logsOffset, 1024)
+	fs.Close()
+
+	// Simulate a crash part way through writing the batch to the logs family.
+	require.NoError(t, os.Truncate("family_test.logs.dat", logsOffset+int64(len(logsRaw))-3))
+	record := batchRecord(map[string]int64{"logs": logsOffset}, map[string][]byte{"logs": logsRaw})
+	require.NoError(t, ioutil.WriteFile("family_test.batch", record, 0666))
+
+	fs, err := filesystem.Init("family_test", filesystem.Family("logs"), filesystem.Family("config"))
+	require.NoError(t, err)
+	logs, _ = fs.Family("logs")
+	assert.Equal(t, logsOffset+int64(len(logsRaw)), logs.CurrentOffset(), "the torn write is replaced, not followed")
+	raw, _, _ := logs.ReadLog("", logsOffset, 1024)
+	assert.Equal(t, logsRaw, raw)
+	for key, want := range map[string]string{"before": "x", "a": "1", "b": "2"} {
+		entry, err := logs.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, want, entry.Value())
+	}
+	assert.True(t, logs.Verify().OK())
+}
+
+// batchRecord encodes a record of a family batch as WriteFamilyBatch logs it, given the offset at which
+// each family's entries are appended and the entries themselves.
+func batchRecord(offsets map[string]int64, raw map[string][]byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString("DBBATCH1")
+	binary.Write(buf, binary.BigEndian, uint32(len(offsets)))
+	for name, offset := range offsets {
+		binary.Write(buf, binary.BigEndian, uint16(len(name)))
+		buf.WriteString(name)
+		binary.Write(buf, binary.BigEndian, offset)
+		binary.Write(buf, binary.BigEndian, uint32(len(raw[name])))
+		buf.Write(raw[name])
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
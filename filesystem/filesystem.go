@@ -1,38 +1,383 @@
 package filesystem
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/raft"
 )
 
+// ErrReadOnly is returned when attempting to modify a database that was opened read-only.
+var ErrReadOnly = file.ErrReadOnly
+
+// An Option is an optional setting you may provide when initializing a DBFileSystem.
+type Option func(*DBFileSystem)
+
+// ReadOnly is an Option that opens the DBFileSystem for reading only. A read-only DBFileSystem takes a
+// shared lock, so any number of readers may open the same database, but not while a writer has it open.
+// The database must already exist.
+func ReadOnly(d *DBFileSystem) {
+	d.readOnly = true
+	d.fileOptions = append(d.fileOptions, file.ReadOnly)
+}
+
+// Follow is an Option that opens the DBFileSystem read-only and keeps its index up to date as another
+// process appends to the database. Because the writer holds an exclusive lock, a following DBFileSystem
+// does not lock the database at all; it relies on the file being append-only instead.
+func Follow(d *DBFileSystem) {
+	d.readOnly = true
+	d.follow = true
+	d.fileOptions = append(d.fileOptions, file.Follow)
+}
+
+// LogTo is an Option that sends messages about the database, such as compactions and any corruption
+// found, to a Logger. See file.LogTo.
+func LogTo(l file.Logger) Option {
+	return func(d *DBFileSystem) {
+		d.fileOptions = append(d.fileOptions, file.LogTo(l))
+	}
+}
+
+// Notify is an Option that sets the Hooks called when notable events happen to the database. See
+// file.Notify.
+func Notify(h file.Hooks) Option {
+	return func(d *DBFileSystem) {
+		d.fileOptions = append(d.fileOptions, file.Notify(h))
+	}
+}
+
+// SlowThreshold is an Option that reports reads and writes taking at least threshold as slow operations,
+// to the Logger and Hooks. See file.SlowThreshold.
+func SlowThreshold(threshold time.Duration) Option {
+	return func(d *DBFileSystem) {
+		d.fileOptions = append(d.fileOptions, file.SlowThreshold(threshold))
+	}
+}
+
+// MergeOperators is an Option that lets the database write and fold merge operands for the given
+// MergeOperators, as well as for file.Counter. See file.MergeOperators.
+func MergeOperators(op ...file.MergeOperator) Option {
+	return func(d *DBFileSystem) {
+		d.fileOptions = append(d.fileOptions, file.MergeOperators(op...))
+	}
+}
+
 // A DBFileSystem is the interface between the DB and underlying DBFile's.
 type DBFileSystem struct {
 	File *file.DBFile
+
+	readOnly, follow bool
+	fileOptions      []file.OpenOption
+	lock             *lockFile
+
+	familySpecs []familySpec
+	families    map[string]*file.DBFile
+	batchPath   string
+	batchLog    *os.File
+	batchMu     sync.Mutex
+
+	raft *raftSpec
+	node *raft.Node
 }
 
-func Init(dbName string) *DBFileSystem {
-	return &DBFileSystem{
-		File: file.Open(dbName + ".dat"),
+// Init locks the database and opens its DBFile, along with those of any column families. If another process
+// holds a conflicting lock on the database, Init returns ErrLocked.
+func Init(dbName string, option ...Option) (*DBFileSystem, error) {
+	d := &DBFileSystem{}
+	for _, o := range option {
+		o(d)
+	}
+
+	if !d.follow {
+		lock, err := acquireLock(dbName+".lock", d.readOnly)
+		if err != nil {
+			return nil, err
+		}
+		d.lock = lock
 	}
+
+	f, err := file.Open(dbName+".dat", d.fileOptions...)
+	if err != nil {
+		d.lock.Release()
+		return nil, err
+	}
+
+	d.File = f
+	if err := d.openFamilies(dbName); err != nil {
+		d.Close()
+		return nil, err
+	}
+	if err := d.startRaft(); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// ReadOnly returns true if the DBFileSystem was opened read-only.
+func (d *DBFileSystem) ReadOnly() bool {
+	return d.readOnly
 }
 
-func (d *DBFileSystem) WriteEntry(entry file.DBFileEntry) file.DBFileEntry {
+func (d *DBFileSystem) WriteEntry(entry file.DBFileEntry) (file.DBFileEntry, error) {
+	if d.node != nil {
+		return entry, d.propose(file.NewBatch().Add(entry))
+	}
 	return d.File.WriteEntry(entry)
 }
 
+// MergeEntry writes a merge operand and returns the key's value with it folded in. See file.DBFile.Merge.
+// In a replicated database, the operand is folded into the key's current value before it is proposed,
+// and not proposed if that fails, but the value returned is read once the operand is applied, so it may
+// include operands written after it; a write applied in between may still leave an operand that cannot
+// be folded.
+func (d *DBFileSystem) MergeEntry(entry file.DBFileEntry) (file.DBFileEntry, error) {
+	if d.node != nil {
+		if _, err := d.File.Fold(entry); err != nil {
+			return entry, err
+		}
+		if err := d.propose(file.NewBatch().Add(entry)); err != nil {
+			return entry, err
+		}
+		return d.File.Get(entry.Key())
+	}
+	return d.File.Merge(entry)
+}
+
+// WriteIf writes an entry if a condition holds for the key's current entry. See file.DBFile.WriteIf.
+func (d *DBFileSystem) WriteIf(entry file.DBFileEntry, cond file.Condition) (file.DBFileEntry, error) {
+	if d.node != nil {
+		return entry, ErrNotReplicated
+	}
+	return d.File.WriteIf(entry, cond)
+}
+
 func (d *DBFileSystem) ReadEntry(key string) file.DBFileEntry {
 	return d.File.ReadEntry(key)
 }
 
-func (d *DBFileSystem) DeleteEntry(key string) file.DBFileEntry {
+// GetEntry retrieves the entry for a key, returning file.ErrNotFound if there isn't one.
+func (d *DBFileSystem) GetEntry(key string) (file.DBFileEntry, error) {
+	return d.File.Get(key)
+}
+
+// Len returns the number of keys in the index. See file.DBFile.Len.
+func (d *DBFileSystem) Len() int {
+	return d.File.Len()
+}
+
+// Keys returns up to limit keys in the range [from, to), in key order. See file.DBFile.Keys.
+func (d *DBFileSystem) Keys(from, to string, limit int) []string {
+	return d.File.Keys(from, to, limit)
+}
+
+// KeysAt returns up to n keys from a position in key order. See file.DBFile.KeysAt.
+func (d *DBFileSystem) KeysAt(position, n int) ([]string, int) {
+	return d.File.KeysAt(position, n)
+}
+
+// Scan returns up to limit entries with keys in the range [from, to), in key order.
+func (d *DBFileSystem) Scan(from, to string, limit int) ([]file.DBFileEntry, error) {
+	return d.File.Scan(from, to, limit)
+}
+
+// WriteBatch writes all the entries in a batch together.
+func (d *DBFileSystem) WriteBatch(b *file.Batch) error {
+	if d.node != nil {
+		return d.propose(b)
+	}
+	return d.File.WriteBatch(b)
+}
+
+// Refresh indexes any entries appended to the database by another process since it was last indexed.
+func (d *DBFileSystem) Refresh() {
+	d.File.Refresh()
+}
+
+// Watch returns a Watcher for changes to keys with the prefix, starting from the given offset. See
+// file.DBFile.Watch.
+func (d *DBFileSystem) Watch(ctx context.Context, prefix string, from int64) *file.Watcher {
+	return d.File.Watch(ctx, prefix, from)
+}
+
+// Backup writes a consistent, checksummed copy of the database to w. See file.DBFile.Backup.
+func (d *DBFileSystem) Backup(w io.Writer, option ...file.BackupOption) (*file.BackupInfo, error) {
+	return d.File.Backup(w, option...)
+}
+
+// WriteText writes the database's whole log to w in the text format. See file.DBFile.WriteText.
+func (d *DBFileSystem) WriteText(w io.Writer) (int, error) {
+	return d.File.WriteText(w)
+}
+
+// Compact rewrites the database's log to hold only its live entries. See file.DBFile.Compact.
+func (d *DBFileSystem) Compact() (*file.CompactReport, error) {
+	return d.File.Compact()
+}
+
+// Usage reports how the space in the database's log is used. See file.DBFile.Usage.
+func (d *DBFileSystem) Usage() (file.Usage, error) {
+	return d.File.Usage()
+}
+
+func (d *DBFileSystem) DeleteEntry(key string) (file.DBFileEntry, error) {
+	if d.node != nil {
+		entry := file.NewEntry(key, file.Deleted)
+		return entry, d.propose(file.NewBatch().Add(entry))
+	}
 	return d.File.DeleteEntry(key)
 }
 
+// Close stops the database's raft.Node, if it is replicated, closes the DBFile, and those of any column
+// families, and releases the database's lock.
 func (d *DBFileSystem) Close() {
+	if d.node != nil {
+		d.node.Stop()
+	}
+	d.closeFamilies()
 	d.File.Close()
+	d.lock.Release()
+}
+
+// Verify checks the consistency of the database's file and index.
+func (d *DBFileSystem) Verify() *file.Report {
+	return d.File.Verify()
 }
 
 func (d *DBFileSystem) Debug(w io.Writer, key string) {
 	d.File.Debug(w, key)
 }
+
+// Inspect reports every entry for a key and what the index holds for it. See file.DBFile.Inspect.
+func (d *DBFileSystem) Inspect(key string) *file.Inspection {
+	return d.File.Inspect(key)
+}
+
+// Bucket returns the bucket with the given name, creating it if need be. See file.DBFile.Bucket.
+// A replicated database cannot create buckets, and returns ErrNotReplicated instead.
+func (d *DBFileSystem) Bucket(name string) (*file.Bucket, error) {
+	if d.node != nil && name != "" {
+		for _, b := range d.File.Buckets() {
+			if b.Name() == name {
+				return b, nil
+			}
+		}
+		return nil, ErrNotReplicated
+	}
+	return d.File.Bucket(name)
+}
+
+// DropBucket deletes a bucket and every key in it. See file.DBFile.DropBucket.
+func (d *DBFileSystem) DropBucket(name string) error {
+	if d.node != nil {
+		return ErrNotReplicated
+	}
+	return d.File.DropBucket(name)
+}
+
+// Buckets returns the database's named buckets, in order of name.
+func (d *DBFileSystem) Buckets() []*file.Bucket {
+	return d.File.Buckets()
+}
+
+// Repair salvages a damaged database, replacing its file with one containing every entry that could be
+// recovered. The damaged file is kept alongside it with a .corrupt suffix, or if a file kept by an earlier
+// repair already has that name, with .corrupt.1, .corrupt.2 and so on; the report's Damaged names it.
+// Repair locks the database while it works, so it returns ErrLocked if the database is open.
+func Repair(dbName string) (*file.RepairReport, error) {
+	lock, err := acquireLock(dbName+".lock", false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	dat, repaired := dbName+".dat", dbName+".dat.repair"
+	os.Remove(repaired)
+
+	report, err := file.Repair(dat, repaired)
+	if err != nil {
+		os.Remove(repaired)
+		return nil, err
+	}
+
+	corrupt, err := unusedName(dbName + ".dat.corrupt")
+	if err != nil {
+		os.Remove(repaired)
+		return nil, err
+	}
+	if err := os.Rename(dat, corrupt); err != nil {
+		return nil, err
+	}
+	report.Damaged = corrupt
+	return report, os.Rename(repaired, dat)
+}
+
+// unusedName returns name if no file has it, or otherwise name with the first of .1, .2 and so on that
+// no file has.
+func unusedName(name string) (string, error) {
+	candidate := name
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s.%d", name, i)
+	}
+}
+
+// Restore creates a database from a backup written by Backup. The database must not already exist. The
+// backup is validated in full before it is moved into place, so a damaged backup never leaves behind a
+// partial database.
+func Restore(r io.Reader, dbName string) (*file.BackupInfo, error) {
+	return RestoreChain(dbName, []io.Reader{r})
+}
+
+// RestoreChain is like Restore, but restores a base backup followed by incremental backups, optionally
+// stopping at a point in time or an offset. See file.RestoreChain.
+func RestoreChain(dbName string, backups []io.Reader, option ...file.RestoreOption) (*file.BackupInfo, error) {
+	var info *file.BackupInfo
+	err := create(dbName, "restore", func(path string) (err error) {
+		info, err = file.RestoreChain(path, backups, option...)
+		return err
+	})
+	return info, err
+}
+
+// LoadText creates a database from entries in the text format, such as those written by WriteText. The
+// database must not already exist. See file.ReadText.
+func LoadText(r io.Reader, dbName string) (int, error) {
+	var count int
+	err := create(dbName, "load", func(path string) (err error) {
+		count, err = file.ReadText(r, path)
+		return err
+	})
+	return count, err
+}
+
+// create creates a new database's file by calling fn with a temporary path, which fn must create, and
+// moving the file into place if fn succeeds. It holds the database's lock while it runs, and fails if
+// the database already exists.
+func create(dbName, op string, fn func(path string) error) error {
+	lock, err := acquireLock(dbName+".lock", false)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	dat, tmp := dbName+".dat", dbName+".dat."+op
+	if _, err := os.Stat(dat); err == nil {
+		return &os.PathError{Op: op, Path: dat, Err: os.ErrExist}
+	}
+	os.Remove(tmp)
+
+	if err := fn(tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dat)
+}
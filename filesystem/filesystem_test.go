@@ -8,26 +8,98 @@ import (
 	"github.com/matthew-burr/db/file"
 	"github.com/matthew-burr/db/filesystem"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func SetupTestFileSystem() (fs *filesystem.DBFileSystem, cleanup func()) {
-	fs = filesystem.Init("test")
+func SetupTestFileSystem(option ...filesystem.Option) (fs *filesystem.DBFileSystem, cleanup func()) {
+	// Read-only file systems need the database to exist already.
+	fs, err := filesystem.Init("test")
+	if err != nil {
+		panic(err)
+	}
+	if len(option) > 0 {
+		fs.Close()
+		fs, err = filesystem.Init("test", option...)
+		if err != nil {
+			panic(err)
+		}
+	}
 	cleanup = func() {
 		fs.Close()
 		os.Remove("test.dat")
+		os.Remove("test.lock")
 	}
 	return
 }
 
 func TestInit_OpensFile(t *testing.T) {
-	fs := filesystem.Init("test")
+	fs, c := SetupTestFileSystem()
+	defer c()
 
 	assert.Equal(t, "test.dat", fs.File.File.Name())
 }
 
+func TestInit_CreatesLockFile(t *testing.T) {
+	_, c := SetupTestFileSystem()
+	defer c()
+
+	_, err := os.Stat("test.lock")
+	assert.NoError(t, err)
+}
+
+func TestInit_ReadOnlyDoesNotCreateLockFile(t *testing.T) {
+	fs, c := SetupTestFileSystem()
+	fs.Close()
+	defer c()
+	require.NoError(t, os.Remove("test.lock"))
+
+	fs, err := filesystem.Init("test", filesystem.ReadOnly)
+	require.NoError(t, err)
+	fs.Close()
+	_, err = os.Stat("test.lock")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInit_ReturnsErrLockedIfLocked(t *testing.T) {
+	tt := []struct {
+		name          string
+		first, second []filesystem.Option
+		want          error
+	}{
+		{"Writer blocks writer", nil, nil, filesystem.ErrLocked},
+		{"Writer blocks reader", nil, []filesystem.Option{filesystem.ReadOnly}, filesystem.ErrLocked},
+		{"Reader blocks writer", []filesystem.Option{filesystem.ReadOnly}, nil, filesystem.ErrLocked},
+		{"Reader allows reader", []filesystem.Option{filesystem.ReadOnly}, []filesystem.Option{filesystem.ReadOnly}, nil},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, c := SetupTestFileSystem(tc.first...)
+			defer c()
+
+			fs, err := filesystem.Init("test", tc.second...)
+			if err == nil {
+				fs.Close()
+			}
+			assert.Equal(t, tc.want, err)
+		})
+	}
+}
+
+func TestClose_ReleasesLock(t *testing.T) {
+	fs, c := SetupTestFileSystem()
+	fs.Close()
+	defer c()
+
+	fs, err := filesystem.Init("test")
+	require.NoError(t, err)
+	fs.Close()
+}
+
 func TestClose_ClosesFile(t *testing.T) {
-	fs := filesystem.Init("test")
+	fs, c := SetupTestFileSystem()
 	fs.Close()
+	defer c()
 
 	err := fs.File.File.Close()
 	assert.True(t, errors.Is(err, os.ErrClosed))
@@ -49,10 +121,20 @@ func TestWriteEntry_ReturnsWrittenEntry(t *testing.T) {
 	defer c()
 
 	want := file.NewEntry("test", file.Value("foo"))
-	got := fs.WriteEntry(want)
+	got, err := fs.WriteEntry(want)
+	require.NoError(t, err)
 	assert.True(t, want.Equals(got))
 }
 
+func TestWriteEntry_ReadOnlyReturnsErrReadOnly(t *testing.T) {
+	fs, c := SetupTestFileSystem(filesystem.ReadOnly)
+	defer c()
+
+	_, err := fs.WriteEntry(file.NewEntry("test", file.Value("foo")))
+	assert.Equal(t, filesystem.ErrReadOnly, err)
+	assert.NotContains(t, fs.File.Index, "test")
+}
+
 func TestReadEntry_ReadsEntry(t *testing.T) {
 	fs, c := SetupTestFileSystem()
 	defer c()
@@ -72,3 +154,80 @@ func TestDeleteEntry_DeletesEntry(t *testing.T) {
 	fs.DeleteEntry("test")
 	assert.NotContains(t, fs.File.Index, "test")
 }
+
+func TestDeleteEntry_ReadOnlyReturnsErrReadOnly(t *testing.T) {
+	fs, c := SetupTestFileSystem(filesystem.ReadOnly)
+	defer c()
+
+	_, err := fs.DeleteEntry("test")
+	assert.Equal(t, filesystem.ErrReadOnly, err)
+}
+
+func TestFollow_DoesNotTakeLock(t *testing.T) {
+	w, c := SetupTestFileSystem()
+	defer c()
+
+	fs, err := filesystem.Init("test", filesystem.Follow)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	w.WriteEntry(file.NewEntry("test", file.Value("follow")))
+	assert.Equal(t, "follow", fs.ReadEntry("test").Value())
+
+	_, err = fs.WriteEntry(file.NewEntry("test", file.Value("write")))
+	assert.Equal(t, filesystem.ErrReadOnly, err)
+}
+
+func TestRepair_ReplacesDamagedFile(t *testing.T) {
+	fs, c := SetupTestFileSystem()
+	defer c()
+	defer os.Remove("test.dat.corrupt")
+	fs.WriteEntry(file.NewEntry("first", file.Value("1")))
+	fs.File.File.Write([]byte{0xff})
+	fs.WriteEntry(file.NewEntry("second", file.Value("2")))
+	fs.Close()
+
+	report, err := filesystem.Repair("test")
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Entries)
+	assert.Equal(t, int64(1), report.SkippedBytes)
+
+	_, err = os.Stat("test.dat.corrupt")
+	assert.NoError(t, err)
+
+	fs, err = filesystem.Init("test")
+	require.NoError(t, err)
+	defer fs.Close()
+	assert.Equal(t, "2", fs.ReadEntry("second").Value())
+}
+
+func TestRepair_KeepsEarlierDamagedFiles(t *testing.T) {
+	fs, c := SetupTestFileSystem()
+	defer c()
+	defer os.Remove("test.dat.corrupt")
+	defer os.Remove("test.dat.corrupt.1")
+	fs.WriteEntry(file.NewEntry("first", file.Value("1")))
+	fs.Close()
+
+	report, err := filesystem.Repair("test")
+	require.NoError(t, err)
+	assert.Equal(t, "test.dat.corrupt", report.Damaged)
+
+	report, err = filesystem.Repair("test")
+	require.NoError(t, err)
+	assert.Equal(t, "test.dat.corrupt.1", report.Damaged)
+	assert.Contains(t, report.String(), "damaged file kept as test.dat.corrupt.1")
+
+	for _, name := range []string{"test.dat.corrupt", "test.dat.corrupt.1"} {
+		_, err := os.Stat(name)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestRepair_ReturnsErrLockedIfOpen(t *testing.T) {
+	_, c := SetupTestFileSystem()
+	defer c()
+
+	_, err := filesystem.Repair("test")
+	assert.Equal(t, filesystem.ErrLocked, err)
+}
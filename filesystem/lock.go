@@ -0,0 +1,56 @@
+package filesystem
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrLocked is returned when another process already holds the lock on a database.
+var ErrLocked = errors.New("database is locked by another process")
+
+// A lockFile is an advisory lock held on a database's LOCK file for as long as the database is open.
+type lockFile struct {
+	f *os.File
+}
+
+// acquireLock opens the lock file at filepath and takes an advisory lock on it. An exclusive lock is
+// taken unless shared is true. If the lock is held elsewhere in a conflicting mode, acquireLock returns
+// ErrLocked rather than waiting.
+//
+// Only an exclusive lock creates the lock file; a shared lock opens it read-only, so that a database can
+// be opened read-only where it cannot be written. If there is no lock file, no writer has the database
+// open, and acquireLock returns a nil lock, which is safe to release.
+func acquireLock(filepath string, shared bool) (*lockFile, error) {
+	flag := os.O_RDWR | os.O_CREATE
+	if shared {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(filepath, flag, 0666)
+	if shared && os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := flock(f, shared); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &lockFile{f: f}, nil
+}
+
+// Release releases the lock. The lock file itself is left in place, since removing it would allow
+// another process to lock a different file of the same name while this one is still being unlocked.
+func (l *lockFile) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	err := funlock(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
@@ -0,0 +1,17 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package filesystem
+
+import "os"
+
+// flock is a no-op on platforms without flock(2); databases opened there are not protected against
+// being opened twice.
+func flock(f *os.File, shared bool) error {
+	return nil
+}
+
+// funlock is a no-op on platforms without flock(2).
+func funlock(f *os.File) error {
+	return nil
+}
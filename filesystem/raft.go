@@ -0,0 +1,65 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/raft"
+)
+
+// ErrNotReplicated is returned by a replicated database for operations that cannot be replicated, such
+// as conditional writes, column family batches and creating or dropping buckets.
+var ErrNotReplicated = errors.New("operation cannot be replicated")
+
+// raftWriteTimeout is how long a write to a replicated database waits to be committed.
+const raftWriteTimeout = 10 * time.Second
+
+// Raft is an Option that replicates the database's default column family with a Raft cluster. Init
+// creates a raft.Node with the given id, peers, transport and options for the family's DBFile, and starts
+// it; Close stops it. Writes, deletes and batches, including merge operands, are then proposed to the
+// cluster and return once they are committed and applied, or raft.ErrNotLeader on a node that is not the
+// leader. Reads are served from the local DBFile. Operations that cannot be replicated return
+// ErrNotReplicated. The node's state is kept in a file alongside the database; see raft.Node.
+func Raft(id string, peers []string, transport raft.Transport, option ...raft.Option) Option {
+	return func(d *DBFileSystem) {
+		d.raft = &raftSpec{id, peers, transport, option}
+	}
+}
+
+type raftSpec struct {
+	id        string
+	peers     []string
+	transport raft.Transport
+	options   []raft.Option
+}
+
+// startRaft creates and starts the Node named by the Raft option, if there is one.
+func (d *DBFileSystem) startRaft() error {
+	if d.raft == nil {
+		return nil
+	}
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	node, err := raft.NewNode(d.raft.id, d.raft.peers, d.File, d.raft.transport, d.raft.options...)
+	if err != nil {
+		return err
+	}
+	node.Start()
+	d.node = node
+	return nil
+}
+
+// Node returns the raft.Node replicating the database, or nil if it is not replicated.
+func (d *DBFileSystem) Node() *raft.Node {
+	return d.node
+}
+
+// propose replicates a batch through the database's Node.
+func (d *DBFileSystem) propose(b *file.Batch) error {
+	ctx, cancel := context.WithTimeout(context.Background(), raftWriteTimeout)
+	defer cancel()
+	return d.node.ProposeBatch(ctx, b)
+}
@@ -0,0 +1,68 @@
+package filesystem_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/filesystem"
+	"github.com/matthew-burr/db/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRaft_ReplicatesWrites(t *testing.T) {
+	net := raft.NewNetwork()
+	ids := []string{"r0", "r1", "r2"}
+	dbs := make(map[string]*filesystem.DBFileSystem)
+	for _, id := range ids {
+		fs, err := filesystem.Init(id+"_raft_test", filesystem.Raft(id, ids, net.Transport(id),
+			raft.ElectionTimeout(50*time.Millisecond), raft.HeartbeatInterval(10*time.Millisecond)))
+		require.NoError(t, err)
+		net.Join(fs.Node())
+		dbs[id] = fs
+	}
+	defer func() {
+		for id, fs := range dbs {
+			fs.Close()
+			for _, suffix := range []string{".dat", ".dat.raft", ".lock"} {
+				os.Remove(id + "_raft_test" + suffix)
+			}
+		}
+	}()
+
+	var leader *filesystem.DBFileSystem
+	require.Eventually(t, func() bool {
+		for _, fs := range dbs {
+			if state, _ := fs.Node().State(); state == raft.Leader {
+				leader = fs
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 5*time.Millisecond)
+
+	_, err := leader.WriteEntry(file.NewEntry("a", file.Value("1")))
+	require.NoError(t, err)
+	require.NoError(t, leader.WriteBatch(file.NewBatch().Write("b", "2").Delete("a")))
+	_, err = leader.WriteEntry(file.NewEntry("hits", file.Value("5"), file.MergeOperand(file.Counter.Name())))
+	require.NoError(t, err)
+
+	for id, fs := range dbs {
+		fs := fs
+		assert.Eventually(t, func() bool { return fs.ReadEntry("hits").Value() == "5" }, 5*time.Second, 5*time.Millisecond, id)
+		assert.Equal(t, "2", fs.ReadEntry("b").Value(), id)
+		_, err := fs.GetEntry("a")
+		assert.Equal(t, file.ErrNotFound, err, id)
+		if fs != leader {
+			_, err := fs.WriteEntry(file.NewEntry("c", file.Value("3")))
+			assert.Equal(t, raft.ErrNotLeader, err, id)
+		}
+	}
+
+	_, err = leader.WriteIf(file.NewEntry("b", file.Value("3")), file.IfValue("2"))
+	assert.Equal(t, filesystem.ErrNotReplicated, err)
+	_, err = leader.Bucket("users")
+	assert.Equal(t, filesystem.ErrNotReplicated, err, "buckets are not replicated")
+}
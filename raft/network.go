@@ -0,0 +1,113 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrUnreachable is returned by a Network's transports when the node being called is not on the network
+// or is cut off from the caller by a partition.
+var ErrUnreachable = errors.New("node is unreachable")
+
+// A Network connects Nodes in the same process, delivering their RPCs by calling each other directly.
+// It can be partitioned to simulate network failures, which makes it useful for testing a cluster.
+type Network struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	group map[string]int // The partition each node is in; nodes in different partitions cannot talk.
+}
+
+// NewNetwork creates an empty Network.
+func NewNetwork() *Network {
+	return &Network{
+		nodes: make(map[string]*Node),
+		group: make(map[string]int),
+	}
+}
+
+// Transport returns a Transport that sends RPCs over the network on behalf of the node with the given id.
+func (n *Network) Transport(id string) Transport {
+	return &networkTransport{net: n, from: id}
+}
+
+// Join adds a node to the network so that other nodes can reach it.
+func (n *Network) Join(node *Node) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes[node.ID()] = node
+}
+
+// Leave removes a node from the network, as if it had crashed.
+func (n *Network) Leave(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.nodes, id)
+}
+
+// Partition splits the network so that nodes can only reach other nodes in the same group. Nodes not
+// named in any group are put together in a group of their own.
+func (n *Network) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.group = make(map[string]int)
+	for i, g := range groups {
+		for _, id := range g {
+			n.group[id] = i + 1
+		}
+	}
+}
+
+// Heal removes any partitions, so that every node can reach every other.
+func (n *Network) Heal() {
+	n.Partition()
+}
+
+// route returns the node to deliver an RPC from one node to another, or ErrUnreachable.
+func (n *Network) route(from, to string) (*Node, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	node, ok := n.nodes[to]
+	if !ok || n.group[from] != n.group[to] {
+		return nil, ErrUnreachable
+	}
+	return node, nil
+}
+
+type networkTransport struct {
+	net  *Network
+	from string
+}
+
+func (t *networkTransport) RequestVote(ctx context.Context, to string, args RequestVoteArgs) (RequestVoteReply, error) {
+	node, err := t.net.route(t.from, to)
+	if err != nil {
+		return RequestVoteReply{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return RequestVoteReply{}, err
+	}
+	return node.HandleRequestVote(args), nil
+}
+
+func (t *networkTransport) AppendEntries(ctx context.Context, to string, args AppendEntriesArgs) (AppendEntriesReply, error) {
+	node, err := t.net.route(t.from, to)
+	if err != nil {
+		return AppendEntriesReply{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return AppendEntriesReply{}, err
+	}
+	return node.HandleAppendEntries(args), nil
+}
+
+func (t *networkTransport) InstallSnapshot(ctx context.Context, to string, args InstallSnapshotArgs) (InstallSnapshotReply, error) {
+	node, err := t.net.route(t.from, to)
+	if err != nil {
+		return InstallSnapshotReply{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return InstallSnapshotReply{}, err
+	}
+	return node.HandleInstallSnapshot(args), nil
+}
@@ -0,0 +1,1085 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+)
+
+var (
+	// ErrNotLeader is returned when a write is made to a node that is not the leader. Leader reports the
+	// node the write should be sent to instead, if it is known.
+	ErrNotLeader = errors.New("node is not the leader")
+
+	// ErrLost is returned when a write was accepted by a leader that lost its leadership before the write
+	// was committed, so the write was discarded.
+	ErrLost = errors.New("write was lost to a change of leader")
+
+	// ErrStopped is returned by a node that has been stopped.
+	ErrStopped = errors.New("node has been stopped")
+)
+
+// A State is the role a node is currently playing in the cluster.
+type State int
+
+// The states a node may be in.
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return "unknown"
+}
+
+// A LogEntry is an entry in the replicated log. Its Command is one or more DBFileEntries encoded in the
+// same format used by a DBFile, or nil for the no-op a new leader appends to commit entries from earlier
+// terms.
+type LogEntry struct {
+	Term    uint64
+	Index   uint64
+	Command []byte
+}
+
+// RequestVoteArgs are the arguments to a RequestVote RPC.
+type RequestVoteArgs struct {
+	Term         uint64
+	CandidateID  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// RequestVoteReply is the reply to a RequestVote RPC.
+type RequestVoteReply struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+// AppendEntriesArgs are the arguments to an AppendEntries RPC.
+type AppendEntriesArgs struct {
+	Term         uint64
+	LeaderID     string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []LogEntry
+	LeaderCommit uint64
+}
+
+// AppendEntriesReply is the reply to an AppendEntries RPC. When Success is false because the follower's
+// log does not match, ConflictIndex is where the leader should resume sending entries.
+type AppendEntriesReply struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64
+}
+
+// InstallSnapshotArgs are the arguments to an InstallSnapshot RPC, which a leader sends to a follower
+// that needs entries the leader has already discarded from its log. Data holds every live entry in the
+// leader's DBFile, encoded in the same format used by a DBFile, once the entries up to LastIncludedIndex
+// have been applied to it.
+type InstallSnapshotArgs struct {
+	Term              uint64
+	LeaderID          string
+	LastIncludedIndex uint64
+	LastIncludedTerm  uint64
+	Data              []byte
+}
+
+// InstallSnapshotReply is the reply to an InstallSnapshot RPC.
+type InstallSnapshotReply struct {
+	Term uint64
+}
+
+// A Transport delivers RPCs from a node to its peers.
+type Transport interface {
+	RequestVote(ctx context.Context, to string, args RequestVoteArgs) (RequestVoteReply, error)
+	AppendEntries(ctx context.Context, to string, args AppendEntriesArgs) (AppendEntriesReply, error)
+	InstallSnapshot(ctx context.Context, to string, args InstallSnapshotArgs) (InstallSnapshotReply, error)
+}
+
+const (
+	// DefaultElectionTimeout is the shortest time a follower waits to hear from a leader before standing
+	// for election. The actual timeout is randomized between this and twice this.
+	DefaultElectionTimeout = 150 * time.Millisecond
+
+	// DefaultHeartbeatInterval is how often a leader sends AppendEntries to followers when it has no new
+	// entries for them.
+	DefaultHeartbeatInterval = 50 * time.Millisecond
+
+	// DefaultSnapshotThreshold is how many applied entries a node keeps in its log before discarding them.
+	DefaultSnapshotThreshold = 1000
+)
+
+// stateBucket is the bucket of a node's DBFile in which it records the index and term of the last log
+// entry it applied, under appliedKey.
+const (
+	stateBucket = "raft"
+	appliedKey  = "applied"
+)
+
+// An Option is an optional setting you may provide to a Node.
+type Option func(*Node)
+
+// ElectionTimeout is an Option that sets the node's minimum election timeout.
+func ElectionTimeout(d time.Duration) Option {
+	return func(n *Node) {
+		n.electionTimeout = d
+	}
+}
+
+// HeartbeatInterval is an Option that sets how often the node sends heartbeats while it is leader.
+func HeartbeatInterval(d time.Duration) Option {
+	return func(n *Node) {
+		n.heartbeatInterval = d
+	}
+}
+
+// SnapshotThreshold is an Option that sets how many applied entries the node keeps in its log before
+// discarding them.
+func SnapshotThreshold(entries int) Option {
+	return func(n *Node) {
+		n.snapshotThreshold = entries
+	}
+}
+
+// A Node is a member of a Raft cluster that replicates writes to a DBFile. Writes are made through the
+// leader and acknowledged only once a majority of the cluster has them; every node, leader or follower,
+// then applies them to its own DBFile, from which reads are served. Only the DBFile's default bucket is
+// replicated.
+//
+// A Node keeps its term, vote and log in a state file alongside its DBFile, named after it with the
+// suffix .raft, and flushes every change to stable storage before replying to an RPC or sending one, so
+// a node that restarts resumes where it left off. It records the last entry it applied in the DBFile
+// itself, in the bucket named raft, in the same write as the entry, so no entry is applied twice. The
+// DBFile also serves as the node's snapshot: once enough entries have been applied, they are discarded
+// from the log, and a follower that needs them is sent the leader's DBFile instead.
+type Node struct {
+	id        string
+	peers     []string
+	transport Transport
+	file      *file.DBFile
+	bucket    *file.Bucket
+	store     *storage
+
+	electionTimeout   time.Duration
+	heartbeatInterval time.Duration
+	snapshotThreshold int
+
+	// applyMu is held while entries or a snapshot are applied to the DBFile, and while a snapshot is
+	// taken of it, so that the DBFile and lastApplied agree. It is taken before mu.
+	applyMu sync.Mutex
+
+	mu            sync.Mutex
+	state         State
+	term          uint64
+	votedFor      string
+	leader        string
+	log           []LogEntry // log[0] holds the index and term of the last entry discarded, or zeroes.
+	commitIndex   uint64
+	lastApplied   uint64
+	nextIndex     map[string]uint64
+	matchIndex    map[string]uint64
+	snapshotting  map[string]bool // The peers being sent a snapshot.
+	lastHeard     time.Time
+	timeout       time.Duration
+	lastHeartbeat time.Time
+	applied       chan struct{} // Closed, and replaced, whenever entries are applied or the state changes.
+	commit        chan struct{} // Signalled whenever the commit index advances.
+	err           error
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewNode creates a Node with the given id that replicates writes to f along with its peers, reaching
+// them through transport, and restores the state it had when it last ran from its state file. Call Start
+// to have it take part in the cluster.
+func NewNode(id string, peers []string, f *file.DBFile, transport Transport, option ...Option) (*Node, error) {
+	n := &Node{
+		id:                id,
+		transport:         transport,
+		file:              f,
+		electionTimeout:   DefaultElectionTimeout,
+		heartbeatInterval: DefaultHeartbeatInterval,
+		snapshotThreshold: DefaultSnapshotThreshold,
+		applied:           make(chan struct{}),
+		commit:            make(chan struct{}, 1),
+		stop:              make(chan struct{}),
+	}
+	for _, p := range peers {
+		if p != id {
+			n.peers = append(n.peers, p)
+		}
+	}
+	for _, o := range option {
+		o(n)
+	}
+
+	bucket, err := f.Bucket(stateBucket)
+	if err != nil {
+		return nil, err
+	}
+	n.bucket = bucket
+	index, term, err := n.lastAppliedEntry()
+	if err != nil {
+		return nil, err
+	}
+
+	n.store, n.term, n.votedFor, n.log, err = openStorage(f.File.Name() + ".raft")
+	if err != nil {
+		return nil, err
+	}
+	if index < n.log[0].Index {
+		n.store.close()
+		return nil, fmt.Errorf("%s holds entries up to %d, but its log discarded entries up to %d", f.File.Name(), index, n.log[0].Index)
+	}
+	if index > n.lastIndex() || n.termAt(index) != term {
+		// A snapshot was applied to the DBFile, but the node stopped before it recorded so in its log.
+		n.log = []LogEntry{{Index: index, Term: term}}
+		if err := n.store.rewrite(n.term, n.votedFor, n.log); err != nil {
+			n.store.close()
+			return nil, err
+		}
+	}
+	n.commitIndex, n.lastApplied = index, index
+	n.resetElectionTimer()
+	return n, nil
+}
+
+// lastAppliedEntry returns the index and term of the last log entry applied to the node's DBFile.
+func (n *Node) lastAppliedEntry() (index, term uint64, err error) {
+	entry, err := n.bucket.Get(appliedKey)
+	if err == file.ErrNotFound {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := fmt.Sscanf(entry.Value(), "%d %d", &index, &term); err != nil {
+		return 0, 0, fmt.Errorf("bad record of the last entry applied: %v", err)
+	}
+	return index, term, nil
+}
+
+// appliedEntry returns the entry that records the last log entry applied to the node's DBFile.
+func (n *Node) appliedEntry(index, term uint64) file.DBFileEntry {
+	return file.NewEntry(appliedKey, file.Value(fmt.Sprintf("%d %d", index, term)), file.InBucket(n.bucket.ID()))
+}
+
+// ID returns the node's id.
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Start starts the node's election and heartbeat timers, and the applying of committed entries to its
+// DBFile.
+func (n *Node) Start() {
+	n.wg.Add(2)
+	go n.run()
+	go n.applyLoop()
+	n.signalCommit()
+}
+
+// Stop stops the node and closes its state file. It does not close the node's DBFile.
+func (n *Node) Stop() {
+	close(n.stop)
+	n.wg.Wait()
+
+	n.mu.Lock()
+	n.fail(ErrStopped)
+	n.store.close()
+	n.mu.Unlock()
+}
+
+// Err returns the error that stopped the node from taking part in the cluster, if any: ErrStopped once
+// it has been stopped, or a failure to write its state file or to apply committed entries to its
+// DBFile. A node that fails stops replying to RPCs, so the rest of the cluster carries on without it.
+func (n *Node) Err() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.err
+}
+
+// State returns the node's current state and term.
+func (n *Node) State() (State, uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state, n.term
+}
+
+// Leader returns the id of the node this node believes to be leader, or "" if it does not know.
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// CommitIndex returns the index of the last log entry the node knows to be committed.
+func (n *Node) CommitIndex() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.commitIndex
+}
+
+// Write replicates a write of the value to the key, returning once it has been committed and applied
+// to this node's DBFile. It returns ErrNotLeader if this node is not the leader.
+func (n *Node) Write(ctx context.Context, key, value string) (file.DBFileEntry, error) {
+	entry := file.NewEntry(key, file.Value(value))
+	return entry, n.Propose(ctx, entry)
+}
+
+// Delete replicates the deletion of the key, returning once it has been committed and applied to this
+// node's DBFile. It returns ErrNotLeader if this node is not the leader.
+func (n *Node) Delete(ctx context.Context, key string) (file.DBFileEntry, error) {
+	entry := file.NewEntry(key, file.Deleted)
+	return entry, n.Propose(ctx, entry)
+}
+
+// Read reads a key from this node's DBFile. Reads from a follower, or from a leader that has just lost
+// its leadership, may not reflect the latest committed writes.
+func (n *Node) Read(key string) file.DBFileEntry {
+	return n.file.ReadEntry(key)
+}
+
+// Propose replicates an entry, returning once it has been committed and applied to this node's DBFile.
+func (n *Node) Propose(ctx context.Context, entry file.DBFileEntry) error {
+	return n.ProposeBatch(ctx, file.NewBatch().Add(entry))
+}
+
+// ProposeBatch replicates the entries in a batch, which every node applies together, returning once they
+// have been committed and applied to this node's DBFile. The entries are prepared as this node's DBFile
+// prepares those it writes, applying its default TTL. Entries in buckets other than the default bucket
+// cannot be replicated.
+func (n *Node) ProposeBatch(ctx context.Context, b *file.Batch) error {
+	for _, entry := range b.Entries() {
+		if entry.Bucket() != file.DefaultBucket {
+			return fmt.Errorf("cannot replicate %q: only the default bucket is replicated", entry.Key())
+		}
+	}
+	cmd, err := n.file.EncodeBatch(b)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	if n.err != nil {
+		n.mu.Unlock()
+		return n.err
+	}
+	if n.state != Leader {
+		n.mu.Unlock()
+		return ErrNotLeader
+	}
+	e := LogEntry{Term: n.term, Index: n.lastIndex() + 1, Command: cmd}
+	n.log = append(n.log, e)
+	n.store.saveEntries([]LogEntry{e})
+	err = n.persist()
+	if err == nil {
+		// A cluster of one commits the entry as soon as it is stored.
+		n.advanceCommitIndex()
+	}
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	n.broadcastAppendEntries()
+
+	index, term := e.Index, e.Term
+	for {
+		n.mu.Lock()
+		applied := n.applied
+		switch {
+		case n.lastApplied >= index:
+			ok := n.termAt(index) == term
+			if index < n.log[0].Index {
+				// The entry has been discarded since it was applied, so if the node has been leader
+				// throughout, the entry applied was this one.
+				ok = n.term == term
+			}
+			n.mu.Unlock()
+			if !ok {
+				return ErrLost
+			}
+			return nil
+		case n.err != nil:
+			err := n.err
+			n.mu.Unlock()
+			return err
+		case n.term != term || n.state != Leader:
+			n.mu.Unlock()
+			return ErrLost
+		}
+		n.mu.Unlock()
+
+		select {
+		case <-applied:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.stop:
+			return ErrStopped
+		}
+	}
+}
+
+// run drives the node's timers until it is stopped.
+func (n *Node) run() {
+	defer n.wg.Done()
+
+	tick := time.NewTicker(n.heartbeatInterval / 5)
+	defer tick.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-tick.C:
+		}
+
+		n.mu.Lock()
+		state := n.state
+		electionDue := state != Leader && n.err == nil && time.Since(n.lastHeard) >= n.timeout
+		heartbeatDue := state == Leader && time.Since(n.lastHeartbeat) >= n.heartbeatInterval
+		n.mu.Unlock()
+
+		switch {
+		case electionDue:
+			n.startElection()
+		case heartbeatDue:
+			n.broadcastAppendEntries()
+		}
+	}
+}
+
+// startElection makes the node a candidate and asks its peers for their votes.
+func (n *Node) startElection() {
+	n.mu.Lock()
+	n.state = Candidate
+	n.term++
+	n.votedFor = n.id
+	n.leader = ""
+	n.store.saveState(n.term, n.votedFor)
+	n.resetElectionTimer()
+	args := RequestVoteArgs{
+		Term:         n.term,
+		CandidateID:  n.id,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.termAt(n.lastIndex()),
+	}
+	n.notify()
+	err := n.persist()
+	n.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	votes := 1
+	if n.isMajority(votes) {
+		n.becomeLeader(args.Term)
+		return
+	}
+
+	for _, peer := range n.peers {
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+			defer cancel()
+			reply, err := n.transport.RequestVote(ctx, peer, args)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			if reply.Term > n.term {
+				n.stepDown(reply.Term)
+				n.mu.Unlock()
+				return
+			}
+			if !reply.VoteGranted || n.state != Candidate || n.term != args.Term {
+				n.mu.Unlock()
+				return
+			}
+			votes++
+			won := n.isMajority(votes)
+			n.mu.Unlock()
+
+			if won {
+				n.becomeLeader(args.Term)
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader makes the node leader for term, if it is still a candidate in that term.
+func (n *Node) becomeLeader(term uint64) {
+	n.mu.Lock()
+	if n.state != Candidate || n.term != term {
+		n.mu.Unlock()
+		return
+	}
+	n.state = Leader
+	n.leader = n.id
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.snapshotting = make(map[string]bool)
+	for _, p := range n.peers {
+		n.nextIndex[p] = n.lastIndex() + 1
+	}
+
+	// A leader may only count replicas of entries from its own term towards committing them, so it
+	// appends a no-op to commit any entries left over from earlier terms.
+	noop := LogEntry{Term: n.term, Index: n.lastIndex() + 1}
+	n.log = append(n.log, noop)
+	n.store.saveEntries([]LogEntry{noop})
+	if n.persist() != nil {
+		n.mu.Unlock()
+		return
+	}
+	n.advanceCommitIndex()
+	n.notify()
+	n.mu.Unlock()
+
+	n.broadcastAppendEntries()
+}
+
+// broadcastAppendEntries sends each peer the entries it is missing, or a heartbeat if it has them all.
+func (n *Node) broadcastAppendEntries() {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return
+	}
+	n.lastHeartbeat = time.Now()
+	n.mu.Unlock()
+
+	for _, peer := range n.peers {
+		go n.replicateTo(peer)
+	}
+}
+
+// replicateTo sends a single AppendEntries RPC to peer and handles its reply.
+func (n *Node) replicateTo(peer string) {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return
+	}
+	next := n.nextIndex[peer]
+	if next <= n.log[0].Index {
+		// The peer needs entries that have been discarded from the log.
+		term, busy := n.term, n.snapshotting[peer]
+		n.snapshotting[peer] = true
+		n.mu.Unlock()
+		if !busy {
+			n.sendSnapshot(peer, term)
+		}
+		return
+	}
+	args := AppendEntriesArgs{
+		Term:         n.term,
+		LeaderID:     n.id,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.termAt(next - 1),
+		Entries:      append([]LogEntry(nil), n.entriesFrom(next)...),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+	defer cancel()
+	reply, err := n.transport.AppendEntries(ctx, peer, args)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if reply.Term > n.term {
+		n.stepDown(reply.Term)
+		return
+	}
+	if n.state != Leader || n.term != args.Term {
+		return
+	}
+
+	if reply.Success {
+		match := args.PrevLogIndex + uint64(len(args.Entries))
+		if match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+		}
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.advanceCommitIndex()
+		return
+	}
+
+	if reply.ConflictIndex >= 1 && reply.ConflictIndex < n.nextIndex[peer] {
+		n.nextIndex[peer] = reply.ConflictIndex
+	} else if n.nextIndex[peer] > 1 {
+		n.nextIndex[peer]--
+	}
+	go n.replicateTo(peer)
+}
+
+// sendSnapshot sends peer a snapshot of the leader's DBFile, once the entries the peer needs have been
+// discarded from the log.
+func (n *Node) sendSnapshot(peer string, term uint64) {
+	defer func() {
+		n.mu.Lock()
+		delete(n.snapshotting, peer)
+		n.mu.Unlock()
+	}()
+
+	args, err := n.snapshot(term)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*n.electionTimeout)
+	defer cancel()
+	reply, err := n.transport.InstallSnapshot(ctx, peer, args)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if reply.Term > n.term {
+		n.stepDown(reply.Term)
+		return
+	}
+	if n.state != Leader || n.term != args.Term {
+		return
+	}
+	if args.LastIncludedIndex > n.matchIndex[peer] {
+		n.matchIndex[peer] = args.LastIncludedIndex
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.advanceCommitIndex()
+}
+
+// snapshot returns the arguments to an InstallSnapshot RPC holding the live entries in the node's
+// DBFile, as of the last entry applied to it.
+func (n *Node) snapshot(term uint64) (InstallSnapshotArgs, error) {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	entries, err := n.file.Scan("", "", 0)
+	if err != nil {
+		return InstallSnapshotArgs{}, err
+	}
+	buf := new(bytes.Buffer)
+	for _, entry := range entries {
+		if _, err := file.EncodeTo(buf, entry); err != nil {
+			return InstallSnapshotArgs{}, err
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return InstallSnapshotArgs{
+		Term:              term,
+		LeaderID:          n.id,
+		LastIncludedIndex: n.lastApplied,
+		LastIncludedTerm:  n.termAt(n.lastApplied),
+		Data:              buf.Bytes(),
+	}, nil
+}
+
+// HandleRequestVote handles a RequestVote RPC from a candidate.
+func (n *Node) HandleRequestVote(args RequestVoteArgs) RequestVoteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.err != nil {
+		return RequestVoteReply{Term: n.term}
+	}
+	if args.Term > n.term {
+		n.stepDown(args.Term)
+	}
+	reply := RequestVoteReply{Term: n.term}
+	if args.Term < n.term {
+		return reply
+	}
+
+	lastTerm := n.termAt(n.lastIndex())
+	upToDate := args.LastLogTerm > lastTerm ||
+		(args.LastLogTerm == lastTerm && args.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == args.CandidateID) && upToDate {
+		n.votedFor = args.CandidateID
+		n.store.saveState(n.term, n.votedFor)
+		n.resetElectionTimer()
+		reply.VoteGranted = true
+	}
+	if n.persist() != nil {
+		return RequestVoteReply{Term: reply.Term}
+	}
+	return reply
+}
+
+// HandleAppendEntries handles an AppendEntries RPC from a leader.
+func (n *Node) HandleAppendEntries(args AppendEntriesArgs) AppendEntriesReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.err != nil {
+		return AppendEntriesReply{Term: n.term}
+	}
+	if args.Term > n.term || (args.Term == n.term && n.state == Candidate) {
+		n.stepDown(args.Term)
+	}
+	reply := AppendEntriesReply{Term: n.term}
+	if args.Term < n.term {
+		return reply
+	}
+
+	n.leader = args.LeaderID
+	n.resetElectionTimer()
+
+	if base := n.log[0].Index; args.PrevLogIndex < base {
+		// Entries up to the snapshot are committed, so they match the leader's; skip them.
+		for len(args.Entries) > 0 && args.Entries[0].Index <= base {
+			args.Entries = args.Entries[1:]
+		}
+		args.PrevLogIndex, args.PrevLogTerm = base, n.log[0].Term
+	}
+	if args.PrevLogIndex > n.lastIndex() {
+		reply.ConflictIndex = n.lastIndex() + 1
+		return n.reply(reply)
+	}
+	if n.termAt(args.PrevLogIndex) != args.PrevLogTerm {
+		// Skip back over the whole conflicting term rather than one entry at a time.
+		conflictTerm := n.termAt(args.PrevLogIndex)
+		i := args.PrevLogIndex
+		for i > n.log[0].Index+1 && n.termAt(i-1) == conflictTerm {
+			i--
+		}
+		reply.ConflictIndex = i
+		return n.reply(reply)
+	}
+
+	for i, e := range args.Entries {
+		if e.Index <= n.lastIndex() {
+			if n.termAt(e.Index) == e.Term {
+				continue
+			}
+			n.log = n.log[:e.Index-n.log[0].Index]
+			n.store.truncate(e.Index)
+		}
+		n.log = append(n.log, args.Entries[i:]...)
+		n.store.saveEntries(args.Entries[i:])
+		break
+	}
+
+	reply.Success = true
+	reply = n.reply(reply)
+	if reply.Success && args.LeaderCommit > n.commitIndex {
+		commit := args.PrevLogIndex + uint64(len(args.Entries))
+		if args.LeaderCommit < commit {
+			commit = args.LeaderCommit
+		}
+		if commit > n.commitIndex {
+			n.commitIndex = commit
+			n.signalCommit()
+		}
+	}
+	return reply
+}
+
+// reply flushes any changes to the node's state to stable storage before it replies to an AppendEntries
+// RPC, failing the reply if it cannot. The caller must hold the node's lock.
+func (n *Node) reply(reply AppendEntriesReply) AppendEntriesReply {
+	if n.persist() != nil {
+		return AppendEntriesReply{Term: reply.Term}
+	}
+	return reply
+}
+
+// HandleInstallSnapshot handles an InstallSnapshot RPC from a leader, replacing the content of the node's
+// DBFile with the snapshot unless it has already applied the entries the snapshot holds.
+func (n *Node) HandleInstallSnapshot(args InstallSnapshotArgs) InstallSnapshotReply {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	if n.err != nil {
+		defer n.mu.Unlock()
+		return InstallSnapshotReply{Term: n.term}
+	}
+	if args.Term > n.term || (args.Term == n.term && n.state == Candidate) {
+		n.stepDown(args.Term)
+	}
+	reply := InstallSnapshotReply{Term: n.term}
+	stale := args.Term < n.term || args.LastIncludedIndex <= n.lastApplied
+	if args.Term == n.term {
+		n.leader = args.LeaderID
+		n.resetElectionTimer()
+	}
+	err := n.persist()
+	n.mu.Unlock()
+	if err != nil || stale {
+		return reply
+	}
+
+	err = n.restore(args)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err != nil {
+		n.fail(err)
+		return reply
+	}
+	index := args.LastIncludedIndex
+	last := LogEntry{Index: index, Term: args.LastIncludedTerm}
+	if index <= n.lastIndex() && n.termAt(index) == args.LastIncludedTerm {
+		// The log agrees with the snapshot, so the entries that follow it are kept.
+		n.log = append([]LogEntry{last}, n.entriesFrom(index+1)...)
+	} else {
+		n.log = []LogEntry{last}
+	}
+	n.lastApplied = index
+	if n.commitIndex < index {
+		n.commitIndex = index
+	}
+	if err := n.store.rewrite(n.term, n.votedFor, n.log); err != nil {
+		n.fail(err)
+	}
+	n.notify()
+	return reply
+}
+
+// restore replaces the content of the node's DBFile with a snapshot, in a single batch that also records
+// the last entry the snapshot includes. The caller must hold applyMu.
+func (n *Node) restore(args InstallSnapshotArgs) error {
+	b := file.NewBatch()
+	keep := make(map[string]bool)
+	dec := file.NewDecoder(bytes.NewReader(args.Data))
+	for {
+		var entry file.DBFileEntry
+		if _, err := dec.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("bad snapshot: %v", err)
+		}
+		keep[entry.Key()] = true
+		b.Add(entry)
+	}
+
+	existing, err := n.file.Scan("", "", 0)
+	if err != nil {
+		return err
+	}
+	for _, entry := range existing {
+		if !keep[entry.Key()] {
+			b.Delete(entry.Key())
+		}
+	}
+	b.Add(n.appliedEntry(args.LastIncludedIndex, args.LastIncludedTerm))
+	return n.file.WriteBatch(b)
+}
+
+// advanceCommitIndex commits the latest entry from the current term that a majority of the cluster has.
+// The caller must hold the node's lock.
+func (n *Node) advanceCommitIndex() {
+	for i := n.lastIndex(); i > n.commitIndex && n.termAt(i) == n.term; i-- {
+		count := 1
+		for _, p := range n.peers {
+			if n.matchIndex[p] >= i {
+				count++
+			}
+		}
+		if n.isMajority(count) {
+			n.commitIndex = i
+			n.signalCommit()
+			n.broadcastCommit()
+			return
+		}
+	}
+}
+
+// broadcastCommit lets followers know about a new commit index without waiting for the next heartbeat.
+// The caller must hold the node's lock.
+func (n *Node) broadcastCommit() {
+	for _, peer := range n.peers {
+		go n.replicateTo(peer)
+	}
+}
+
+// signalCommit wakes the goroutine that applies committed entries.
+func (n *Node) signalCommit() {
+	select {
+	case n.commit <- struct{}{}:
+	default:
+	}
+}
+
+// applyLoop applies committed entries to the node's DBFile as they are committed, and discards them from
+// the log once there are enough of them, until the node is stopped or fails.
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.commit:
+		}
+
+		err := n.applyCommitted()
+		if err == nil {
+			err = n.compactLog()
+		}
+		if err != nil {
+			n.mu.Lock()
+			n.fail(err)
+			n.mu.Unlock()
+			return
+		}
+	}
+}
+
+// applyCommitted applies the committed entries that have not been applied yet to the node's DBFile, in a
+// single batch that also records the last of them. The DBFile is written without holding the node's
+// lock.
+func (n *Node) applyCommitted() error {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	if n.lastApplied >= n.commitIndex {
+		n.mu.Unlock()
+		return nil
+	}
+	entries := append([]LogEntry(nil), n.entriesFrom(n.lastApplied + 1)[:n.commitIndex-n.lastApplied]...)
+	n.mu.Unlock()
+
+	b := file.NewBatch()
+	for _, e := range entries {
+		dec := file.NewDecoder(bytes.NewReader(e.Command))
+		for {
+			var entry file.DBFileEntry
+			if _, err := dec.Decode(&entry); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("cannot apply log entry %d: %v", e.Index, err)
+			}
+			b.Add(entry)
+		}
+	}
+	last := entries[len(entries)-1]
+	b.Add(n.appliedEntry(last.Index, last.Term))
+	if err := n.file.WriteBatch(b); err != nil {
+		return fmt.Errorf("cannot apply log entries %d to %d: %v", entries[0].Index, last.Index, err)
+	}
+
+	n.mu.Lock()
+	n.lastApplied = last.Index
+	n.notify()
+	n.mu.Unlock()
+	return nil
+}
+
+// compactLog discards the entries that have been applied from the log, once there are more than the
+// snapshot threshold of them. The DBFile, which serves as the snapshot, is flushed to stable storage
+// first, so that it holds every entry discarded.
+func (n *Node) compactLog() error {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	n.mu.Lock()
+	due := n.lastApplied-n.log[0].Index > uint64(n.snapshotThreshold)
+	n.mu.Unlock()
+	if !due {
+		return nil
+	}
+	if err := n.file.Sync(); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	last := LogEntry{Index: n.lastApplied, Term: n.termAt(n.lastApplied)}
+	n.log = append([]LogEntry{last}, n.entriesFrom(last.Index+1)...)
+	return n.store.rewrite(n.term, n.votedFor, n.log)
+}
+
+// persist flushes changes to the node's term, vote and log to stable storage. If it cannot, the node
+// fails. The caller must hold the node's lock.
+func (n *Node) persist() error {
+	if err := n.store.flush(); err != nil {
+		n.fail(err)
+		return err
+	}
+	return nil
+}
+
+// fail stops the node from taking part in the cluster after an error. The caller must hold the node's
+// lock.
+func (n *Node) fail(err error) {
+	if n.err == nil {
+		n.err = err
+	}
+	n.state = Follower
+	n.leader = ""
+	n.notify()
+}
+
+// stepDown makes the node a follower in term. The caller must hold the node's lock.
+func (n *Node) stepDown(term uint64) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leader = ""
+		n.store.saveState(n.term, n.votedFor)
+	}
+	if n.state != Follower {
+		n.state = Follower
+		n.resetElectionTimer()
+	}
+	n.notify()
+}
+
+// notify wakes writers waiting in Propose. The caller must hold the node's lock.
+func (n *Node) notify() {
+	close(n.applied)
+	n.applied = make(chan struct{})
+}
+
+// resetElectionTimer restarts the election timeout with a new random duration. The caller must hold the
+// node's lock.
+func (n *Node) resetElectionTimer() {
+	n.lastHeard = time.Now()
+	n.timeout = n.electionTimeout + time.Duration(rand.Int63n(int64(n.electionTimeout)))
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+// termAt returns the term of the entry at index, or 0 if the log does not have it.
+func (n *Node) termAt(index uint64) uint64 {
+	if index < n.log[0].Index || index > n.lastIndex() {
+		return 0
+	}
+	return n.log[index-n.log[0].Index].Term
+}
+
+// entriesFrom returns the entries in the log from index onwards, which must not have been discarded.
+func (n *Node) entriesFrom(index uint64) []LogEntry {
+	return n.log[index-n.log[0].Index:]
+}
+
+func (n *Node) isMajority(votes int) bool {
+	return votes*2 > len(n.peers)+1
+}
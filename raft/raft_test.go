@@ -0,0 +1,345 @@
+package raft_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/raft"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A Cluster is a set of Nodes running in-process on a shared Network.
+type Cluster struct {
+	t     *testing.T
+	Net   *raft.Network
+	Nodes map[string]*raft.Node
+	Files map[string]*file.DBFile
+
+	ids     []string
+	options []raft.Option
+}
+
+func SetupCluster(t *testing.T, size int, option ...raft.Option) (c *Cluster, cleanup func()) {
+	c = &Cluster{
+		t:     t,
+		Net:   raft.NewNetwork(),
+		Nodes: make(map[string]*raft.Node),
+		Files: make(map[string]*file.DBFile),
+	}
+
+	var ids []string
+	for i := 0; i < size; i++ {
+		ids = append(ids, fmt.Sprintf("n%d", i))
+	}
+	c.ids = ids
+	c.options = append([]raft.Option{
+		raft.ElectionTimeout(50 * time.Millisecond),
+		raft.HeartbeatInterval(10 * time.Millisecond),
+	}, option...)
+	for _, id := range ids {
+		os.Remove(id + "_raft_test.dat")
+		os.Remove(id + "_raft_test.dat.raft")
+		c.open(id)
+	}
+	for _, node := range c.Nodes {
+		node.Start()
+	}
+
+	return c, func() {
+		for id, node := range c.Nodes {
+			c.Net.Leave(id)
+			node.Stop()
+			c.Files[id].Close()
+			os.Remove(id + "_raft_test.dat")
+			os.Remove(id + "_raft_test.dat.raft")
+		}
+	}
+}
+
+// open opens a node's DBFile and creates the node, restoring its state from its state file.
+func (c *Cluster) open(id string) {
+	f, err := file.Open(id + "_raft_test.dat")
+	require.NoError(c.t, err)
+	node, err := raft.NewNode(id, c.ids, f, c.Net.Transport(id), c.options...)
+	require.NoError(c.t, err)
+	c.Net.Join(node)
+	c.Nodes[id], c.Files[id] = node, f
+}
+
+// Restart stops a node, as if it had crashed, and starts it again from what it left on disk.
+func (c *Cluster) Restart(id string) {
+	c.Net.Leave(id)
+	c.Nodes[id].Stop()
+	c.Files[id].Close()
+	c.open(id)
+	c.Nodes[id].Start()
+}
+
+// Leader waits for exactly one of the given nodes to be leader, and returns it.
+func (c *Cluster) Leader(ids ...string) *raft.Node {
+	if len(ids) == 0 {
+		for id := range c.Nodes {
+			ids = append(ids, id)
+		}
+	}
+
+	var leader *raft.Node
+	require.Eventually(c.t, func() bool {
+		leader = nil
+		var term uint64
+		for _, id := range ids {
+			state, t := c.Nodes[id].State()
+			if state != raft.Leader {
+				continue
+			}
+			if leader != nil && t == term {
+				return false
+			}
+			if leader == nil || t > term {
+				leader, term = c.Nodes[id], t
+			}
+		}
+		return leader != nil
+	}, 5*time.Second, 5*time.Millisecond)
+	return leader
+}
+
+// Others returns the ids of every node but the given one.
+func (c *Cluster) Others(id string) []string {
+	var others []string
+	for other := range c.Nodes {
+		if other != id {
+			others = append(others, other)
+		}
+	}
+	return others
+}
+
+func Eventually(t *testing.T, cond func() bool) {
+	assert.Eventually(t, cond, 5*time.Second, 5*time.Millisecond)
+}
+
+func Timeout(t *testing.T, d time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestCluster_ElectsOneLeader(t *testing.T) {
+	c, cleanup := SetupCluster(t, 3)
+	defer cleanup()
+
+	leader := c.Leader()
+	for _, id := range c.Others(leader.ID()) {
+		Eventually(t, func() bool { return c.Nodes[id].Leader() == leader.ID() })
+	}
+}
+
+func TestCluster_ReplicatesWritesToEveryNode(t *testing.T) {
+	c, cleanup := SetupCluster(t, 3)
+	defer cleanup()
+
+	leader := c.Leader()
+	_, err := leader.Write(Timeout(t, time.Second), "a", "1")
+	require.NoError(t, err)
+	_, err = leader.Write(Timeout(t, time.Second), "b", "2")
+	require.NoError(t, err)
+	_, err = leader.Delete(Timeout(t, time.Second), "a")
+	require.NoError(t, err)
+
+	assert.Equal(t, "2", leader.Read("b").Value())
+	for _, f := range c.Files {
+		f := f
+		Eventually(t, func() bool { return f.ReadEntry("b").Value() == "2" })
+		_, err := f.Get("a")
+		assert.Equal(t, file.ErrNotFound, err)
+	}
+}
+
+func TestNode_WriteToFollowerReturnsErrNotLeader(t *testing.T) {
+	c, cleanup := SetupCluster(t, 3)
+	defer cleanup()
+
+	leader := c.Leader()
+	follower := c.Nodes[c.Others(leader.ID())[0]]
+
+	_, err := follower.Write(Timeout(t, time.Second), "a", "1")
+	assert.Equal(t, raft.ErrNotLeader, err)
+	Eventually(t, func() bool { return follower.Leader() == leader.ID() })
+}
+
+func TestCluster_MajorityElectsNewLeaderWhenLeaderIsPartitioned(t *testing.T) {
+	c, cleanup := SetupCluster(t, 3)
+	defer cleanup()
+
+	old := c.Leader()
+	_, err := old.Write(Timeout(t, time.Second), "before", "1")
+	require.NoError(t, err)
+
+	majority := c.Others(old.ID())
+	c.Net.Partition([]string{old.ID()}, majority)
+
+	// The old leader cannot reach a majority, so it cannot commit.
+	_, err = old.Write(Timeout(t, 100*time.Millisecond), "lost", "x")
+	assert.Error(t, err)
+
+	leader := c.Leader(majority...)
+	assert.NotEqual(t, old.ID(), leader.ID())
+	_, err = leader.Write(Timeout(t, time.Second), "during", "2")
+	require.NoError(t, err)
+
+	c.Net.Heal()
+
+	// The old leader steps down, discards its uncommitted write and catches up with the new leader.
+	Eventually(t, func() bool {
+		state, _ := old.State()
+		return state == raft.Follower && old.Read("during").Value() == "2"
+	})
+	for id, f := range c.Files {
+		assert.Equal(t, "1", f.ReadEntry("before").Value(), id)
+		_, err := f.Get("lost")
+		assert.Equal(t, file.ErrNotFound, err, id)
+	}
+}
+
+func TestCluster_MinorityCannotElectLeader(t *testing.T) {
+	c, cleanup := SetupCluster(t, 3)
+	defer cleanup()
+
+	leader := c.Leader()
+	follower := c.Others(leader.ID())[0]
+	c.Net.Partition([]string{follower}, c.Others(follower))
+
+	time.Sleep(300 * time.Millisecond)
+	state, _ := c.Nodes[follower].State()
+	assert.NotEqual(t, raft.Leader, state)
+
+	// The isolated node has been campaigning in ever higher terms. When the partition heals it
+	// disrupts the cluster, but the cluster settles on a leader and keeps working.
+	c.Net.Heal()
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		if _, err = c.Leader().Write(Timeout(t, time.Second), "a", "1"); err == nil {
+			break
+		}
+	}
+	require.NoError(t, err)
+	for _, f := range c.Files {
+		f := f
+		Eventually(t, func() bool { return f.ReadEntry("a").Value() == "1" })
+	}
+}
+
+func TestNode_RejoinsAfterCrash(t *testing.T) {
+	c, cleanup := SetupCluster(t, 3)
+	defer cleanup()
+
+	leader := c.Leader()
+	crashed := c.Others(leader.ID())[0]
+	c.Net.Leave(crashed)
+
+	_, err := leader.Write(Timeout(t, time.Second), "a", "1")
+	require.NoError(t, err)
+	_, err = c.Files[crashed].Get("a")
+	assert.Equal(t, file.ErrNotFound, err)
+
+	c.Net.Join(c.Nodes[crashed])
+	Eventually(t, func() bool { return c.Files[crashed].ReadEntry("a").Value() == "1" })
+}
+
+func TestNode_RestartKeepsItsState(t *testing.T) {
+	c, cleanup := SetupCluster(t, 3)
+	defer cleanup()
+
+	leader := c.Leader()
+	follower := c.Others(leader.ID())[0]
+	for i := 0; i < 3; i++ {
+		require.NoError(t, leader.Propose(Timeout(t, time.Second), file.NewEntry("hits", file.Value("1"), file.MergeOperand(file.Counter.Name()))))
+	}
+	Eventually(t, func() bool { return c.Files[follower].ReadEntry("hits").Value() == "3" })
+	_, term := c.Nodes[follower].State()
+
+	c.Restart(follower)
+	_, restarted := c.Nodes[follower].State()
+	assert.GreaterOrEqual(t, restarted, term, "the term survives a restart")
+	assert.Equal(t, leader.CommitIndex(), c.Nodes[follower].CommitIndex(), "applied entries are not applied again")
+	assert.Equal(t, "3", c.Files[follower].ReadEntry("hits").Value())
+
+	leader = c.Leader()
+	require.NoError(t, leader.Propose(Timeout(t, time.Second), file.NewEntry("hits", file.Value("1"), file.MergeOperand(file.Counter.Name()))))
+	for id, f := range c.Files {
+		f := f
+		Eventually(t, func() bool { return f.ReadEntry("hits").Value() == "4" })
+		assert.NoError(t, c.Nodes[id].Err(), id)
+	}
+}
+
+func TestCluster_SendsSnapshotToLaggingFollower(t *testing.T) {
+	c, cleanup := SetupCluster(t, 3, raft.SnapshotThreshold(5))
+	defer cleanup()
+
+	leader := c.Leader()
+	lagging := c.Others(leader.ID())[0]
+	_, err := leader.Write(Timeout(t, time.Second), "gone", "x")
+	require.NoError(t, err)
+	Eventually(t, func() bool { return c.Files[lagging].ReadEntry("gone").Value() == "x" })
+	c.Net.Leave(lagging)
+
+	for i := 0; i < 20; i++ {
+		_, err := leader.Write(Timeout(t, time.Second), fmt.Sprintf("k%02d", i), strconv.Itoa(i))
+		require.NoError(t, err)
+	}
+	_, err = leader.Delete(Timeout(t, time.Second), "gone")
+	require.NoError(t, err)
+	info, err := os.Stat(leader.ID() + "_raft_test.dat.raft")
+	require.NoError(t, err)
+	assert.Less(t, info.Size(), int64(10*40), "applied entries are discarded from the log")
+
+	c.Net.Join(c.Nodes[lagging])
+	f := c.Files[lagging]
+	Eventually(t, func() bool { return f.ReadEntry("k19").Value() == "19" })
+	_, err = f.Get("gone")
+	assert.Equal(t, file.ErrNotFound, err, "the snapshot replaces the follower's content")
+
+	// The follower keeps up with later writes, and restarts from the snapshot.
+	_, err = leader.Write(Timeout(t, time.Second), "after", "1")
+	require.NoError(t, err)
+	Eventually(t, func() bool { return f.ReadEntry("after").Value() == "1" })
+	c.Restart(lagging)
+	assert.Equal(t, "1", c.Files[lagging].ReadEntry("after").Value())
+	assert.NoError(t, c.Nodes[lagging].Err())
+}
+
+func TestNewNode_DiscardsDamagedStateRecords(t *testing.T) {
+	c, cleanup := SetupCluster(t, 1)
+	defer cleanup()
+
+	node := c.Leader()
+	_, err := node.Write(Timeout(t, time.Second), "a", "1")
+	require.NoError(t, err)
+	commit := node.CommitIndex()
+	c.Net.Leave(node.ID())
+	node.Stop()
+	c.Files[node.ID()].Close()
+
+	// A record cut short by a crash is discarded.
+	state, err := os.OpenFile(node.ID()+"_raft_test.dat.raft", os.O_APPEND|os.O_WRONLY, 0666)
+	require.NoError(t, err)
+	state.Write([]byte{'E', 0, 0, 0, 40, 1, 2, 3})
+	state.Close()
+
+	c.open(node.ID())
+	node = c.Nodes[node.ID()]
+	node.Start()
+	assert.Equal(t, commit, node.CommitIndex())
+	node = c.Leader()
+	_, err = node.Write(Timeout(t, time.Second), "b", "2")
+	require.NoError(t, err)
+	assert.Equal(t, "1", node.Read("a").Value())
+}
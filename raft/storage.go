@@ -0,0 +1,215 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+)
+
+// The kinds of record in a node's state file.
+const (
+	recordState    byte = 'V' // The node's term and vote: term (uint64), then the id it voted for.
+	recordEntry    byte = 'E' // An entry appended to the log: term (uint64), index (uint64), then its command.
+	recordTruncate byte = 'T' // The log was cut short: index (uint64) of the first entry discarded.
+	recordSnapshot byte = 'S' // The log was compacted: index and term (uint64) of the last entry discarded.
+)
+
+// storage keeps a node's term, vote and log in its state file, so that they survive a restart. Changes are
+// appended to the file as records and buffered until flush writes them and flushes them to stable storage.
+// Each record is
+//
+//	kind (1 byte), length of payload (uint32), payload, CRC-32 of kind and payload (uint32)
+//
+// A record cut short or damaged by a crash is discarded, along with anything after it, when the file is
+// opened; since every record is flushed before the node acts on it, none of them had been relied upon.
+type storage struct {
+	path string
+	f    *os.File
+	buf  bytes.Buffer
+}
+
+// openStorage opens the state file at path, creating it if it does not exist, and returns the term, vote
+// and log recorded in it. The log's first entry holds the index and term of the last entry discarded by
+// compaction, or zeroes.
+func openStorage(path string) (s *storage, term uint64, votedFor string, log []LogEntry, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, "", nil, err
+	}
+
+	log = []LogEntry{{}}
+	good := 0
+	for good < len(content) {
+		kind, payload, n, ok := parseRecord(content[good:])
+		if !ok {
+			break
+		}
+		switch kind {
+		case recordState:
+			term = binary.BigEndian.Uint64(payload)
+			votedFor = string(payload[8:])
+		case recordEntry:
+			e := LogEntry{Term: binary.BigEndian.Uint64(payload), Index: binary.BigEndian.Uint64(payload[8:])}
+			if len(payload) > 16 {
+				e.Command = append([]byte(nil), payload[16:]...)
+			}
+			if e.Index > log[0].Index && e.Index-log[0].Index <= uint64(len(log)) {
+				log = append(log[:e.Index-log[0].Index], e)
+			}
+		case recordTruncate:
+			if index := binary.BigEndian.Uint64(payload); index > log[0].Index && index-log[0].Index < uint64(len(log)) {
+				log = log[:index-log[0].Index]
+			}
+		case recordSnapshot:
+			last := LogEntry{Index: binary.BigEndian.Uint64(payload), Term: binary.BigEndian.Uint64(payload[8:])}
+			if last.Index-log[0].Index < uint64(len(log)) {
+				log = append([]LogEntry{last}, log[last.Index-log[0].Index+1:]...)
+			} else {
+				log = []LogEntry{last}
+			}
+		}
+		good += n
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, 0, "", nil, err
+	}
+	if good < len(content) {
+		if err := f.Truncate(int64(good)); err != nil {
+			f.Close()
+			return nil, 0, "", nil, err
+		}
+	}
+	if _, err := f.Seek(int64(good), 0); err != nil {
+		f.Close()
+		return nil, 0, "", nil, err
+	}
+	return &storage{path: path, f: f}, term, votedFor, log, nil
+}
+
+// parseRecord parses the record at the start of b, returning its kind, its payload and its length, or
+// false if it is incomplete or damaged.
+func parseRecord(b []byte) (kind byte, payload []byte, n int, ok bool) {
+	if len(b) < 5 {
+		return 0, nil, 0, false
+	}
+	size := int(binary.BigEndian.Uint32(b[1:]))
+	n = 5 + size + 4
+	if size > len(b) || n > len(b) {
+		return 0, nil, 0, false
+	}
+	sum := crc32.NewIEEE()
+	sum.Write(b[:1])
+	sum.Write(b[5 : 5+size])
+	if sum.Sum32() != binary.BigEndian.Uint32(b[5+size:]) {
+		return 0, nil, 0, false
+	}
+
+	kind, payload = b[0], b[5:5+size]
+	minimum := map[byte]int{recordState: 8, recordEntry: 16, recordTruncate: 8, recordSnapshot: 16}[kind]
+	if minimum == 0 || len(payload) < minimum {
+		return 0, nil, 0, false
+	}
+	return kind, payload, n, true
+}
+
+// record adds a record to the buffer.
+func record(buf *bytes.Buffer, kind byte, payload []byte) {
+	var header [5]byte
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	buf.Write(header[:])
+	buf.Write(payload)
+	sum := crc32.NewIEEE()
+	sum.Write(header[:1])
+	sum.Write(payload)
+	binary.Write(buf, binary.BigEndian, sum.Sum32())
+}
+
+func stateRecord(buf *bytes.Buffer, term uint64, votedFor string) {
+	payload := make([]byte, 8, 8+len(votedFor))
+	binary.BigEndian.PutUint64(payload, term)
+	record(buf, recordState, append(payload, votedFor...))
+}
+
+func entryRecord(buf *bytes.Buffer, e LogEntry) {
+	payload := make([]byte, 16, 16+len(e.Command))
+	binary.BigEndian.PutUint64(payload, e.Term)
+	binary.BigEndian.PutUint64(payload[8:], e.Index)
+	record(buf, recordEntry, append(payload, e.Command...))
+}
+
+// saveState records the node's term and vote.
+func (s *storage) saveState(term uint64, votedFor string) {
+	stateRecord(&s.buf, term, votedFor)
+}
+
+// saveEntries records entries appended to the log.
+func (s *storage) saveEntries(entries []LogEntry) {
+	for _, e := range entries {
+		entryRecord(&s.buf, e)
+	}
+}
+
+// truncate records that the log entries from index onwards were discarded.
+func (s *storage) truncate(index uint64) {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, index)
+	record(&s.buf, recordTruncate, payload)
+}
+
+// flush writes the buffered records to the state file and flushes it to stable storage.
+func (s *storage) flush() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	if _, err := s.f.Write(s.buf.Bytes()); err != nil {
+		return err
+	}
+	s.buf.Reset()
+	return s.f.Sync()
+}
+
+// rewrite replaces the state file with one holding just the given term, vote and log, dropping the
+// records of entries the log no longer has. The new file is flushed to stable storage and then renamed
+// over the old one, so a crash leaves one or the other.
+func (s *storage) rewrite(term uint64, votedFor string, log []LogEntry) error {
+	buf := new(bytes.Buffer)
+	snapshot := make([]byte, 16)
+	binary.BigEndian.PutUint64(snapshot, log[0].Index)
+	binary.BigEndian.PutUint64(snapshot[8:], log[0].Term)
+	record(buf, recordSnapshot, snapshot)
+	stateRecord(buf, term, votedFor)
+	for _, e := range log[1:] {
+		entryRecord(buf, e)
+	}
+
+	tmp, err := os.Create(s.path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		tmp.Close()
+		return err
+	}
+	s.f.Close()
+	s.f = tmp
+	s.buf.Reset()
+	return nil
+}
+
+// close closes the state file, discarding any records that were not flushed.
+func (s *storage) close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,496 @@
+// Package repl implements the interactive command line for a database.
+package repl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+)
+
+// ErrQuit is returned by Exec for the quit command.
+var ErrQuit = errors.New("quit")
+
+// DefaultKeysLimit is how many keys the keys command lists when it is not given a limit.
+const DefaultKeysLimit = 100
+
+// countPage is how many entries the count command reads from the index at a time.
+const countPage = 1000
+
+// A command is one of the REPL's commands.
+type command struct {
+	name    string
+	aliases []string
+	usage   string
+	summary string
+	help    string // Further detail shown by help <command>, if any.
+	run     func(r *REPL, args []string) error
+}
+
+// A REPL reads commands, one per line, and executes them against a DB.
+type REPL struct {
+	db    *database.DB
+	in    io.Reader
+	out   io.Writer
+	batch *file.Batch
+}
+
+// New creates a REPL that reads commands from in and writes their results to out.
+func New(db *database.DB, in io.Reader, out io.Writer) *REPL {
+	return &REPL{db: db, in: in, out: out}
+}
+
+// Run prompts for and executes commands until the input ends or the quit command is given. Errors from
+// commands are written to out rather than stopping Run, which only returns an error if the input cannot
+// be read. A batch that has not been committed when Run returns is discarded.
+func (r *REPL) Run() error {
+	s := bufio.NewScanner(r.in)
+	r.prompt()
+	for s.Scan() {
+		err := r.Exec(s.Text())
+		if err == ErrQuit {
+			break
+		}
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+		}
+		r.prompt()
+	}
+	if r.batch != nil {
+		fmt.Fprintf(r.out, "discarded a batch of %d uncommitted changes\n", r.batch.Len())
+		r.batch = nil
+	}
+	return s.Err()
+}
+
+// A ScriptError reports the command that stopped a script.
+type ScriptError struct {
+	Line int // The line number of the command, counting from 1.
+	Err  error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the command's error.
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// RunScript executes commands like Run, but without prompting, for running a file of commands. It stops
+// at the first command that fails, returning a ScriptError. A batch that is still open at the end of the
+// script is discarded, and reported as an error, rather than committed.
+func (r *REPL) RunScript() error {
+	s := bufio.NewScanner(r.in)
+	line := 0
+	for s.Scan() {
+		line++
+		err := r.Exec(s.Text())
+		if err == ErrQuit {
+			break
+		}
+		if err != nil {
+			r.batch = nil
+			return &ScriptError{Line: line, Err: err}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if r.batch != nil {
+		r.batch = nil
+		return &ScriptError{Line: line, Err: errors.New("script ended without committing its batch")}
+	}
+	return nil
+}
+
+func (r *REPL) prompt() {
+	if r.batch != nil {
+		fmt.Fprint(r.out, "batch> ")
+		return
+	}
+	fmt.Fprint(r.out, "> ")
+}
+
+// Exec executes a single command line. Blank lines, and comments beginning with #, are ignored. It returns
+// ErrQuit for the quit command.
+func (r *REPL) Exec(line string) error {
+	if strings.HasPrefix(strings.TrimSpace(line), "#") {
+		return nil
+	}
+	args, err := Split(line)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	c := lookup(args[0])
+	if c == nil {
+		return fmt.Errorf("unknown command %q; try 'help'", args[0])
+	}
+	return c.run(r, args[1:])
+}
+
+var commands []*command
+
+func init() {
+	// The table is built in init because the help command refers to it.
+	commands = []*command{
+		{name: "quit", aliases: []string{"q"}, usage: "quit", summary: "Quits the application",
+			run: func(r *REPL, args []string) error { return ErrQuit }},
+		{name: "write", aliases: []string{"w"}, usage: "write <key> <value> [<ttl>]", summary: "Writes the value to the key",
+			help: "The optional ttl is a duration, such as 90s or 1h30m, after which the key expires.",
+			run:  (*REPL).write},
+		{name: "read", aliases: []string{"r"}, usage: "read <key>", summary: "Returns the value for key",
+			run: (*REPL).read},
+		{name: "delete", aliases: []string{"d"}, usage: "delete <key>", summary: "Deletes the key from the database",
+			run: (*REPL).delete},
+		{name: "exists", usage: "exists <key>", summary: "Reports whether the key exists",
+			run: (*REPL).exists},
+		{name: "ttl", usage: "ttl <key>", summary: "Returns how long until the key expires",
+			run: (*REPL).ttl},
+		{name: "keys", aliases: []string{"scan"}, usage: "keys <prefix> [<limit>]", summary: "Lists the keys that begin with prefix",
+			help: fmt.Sprintf("Keys are listed in order, at most limit of them (%d by default). Use \"\" as the prefix to list every key. Keys that need quoting are shown quoted.", DefaultKeysLimit),
+			run:  (*REPL).keys},
+		{name: "count", usage: "count [<prefix>]", summary: "Counts the keys that begin with prefix",
+			run: (*REPL).count},
+		{name: "stats", usage: "stats", summary: "Shows the database's size, garbage and activity",
+			help: "The counts of reads, writes and so on start from zero when the database is opened.",
+			run:  (*REPL).stats},
+		{name: "compact", usage: "compact", summary: "Rewrites the database without its garbage",
+			help: "Overwritten, deleted and expired entries are removed. Offsets taken before compacting, such as those of replicas and incremental backups, no longer apply afterwards.",
+			run:  (*REPL).compact},
+		{name: "begin", usage: "begin", summary: "Starts a batch",
+			help: "Until commit or abort, writes and deletes are queued rather than applied, and are then applied together. Reads see only committed changes.",
+			run:  (*REPL).begin},
+		{name: "commit", usage: "commit", summary: "Applies the changes queued since begin",
+			run: (*REPL).commit},
+		{name: "abort", aliases: []string{"rollback"}, usage: "abort", summary: "Discards the changes queued since begin",
+			run: (*REPL).abort},
+		{name: "debug", usage: "debug [--json] <key>", summary: "Shows every entry for the key and what the index holds",
+			help: fmt.Sprintf("Values are shown truncated to %d bytes. The whole log is read, so this may be slow for a large database.", file.PreviewLength),
+			run:  (*REPL).debug},
+		{name: "check", usage: "check [--json]", summary: "Checks the database for corruption",
+			run: (*REPL).check},
+		{name: "reindex", usage: "reindex", summary: "Rebuilds the database index",
+			help: "The index is rebuilt from the database file, dropping deleted keys. It is only needed if the index is suspected to be wrong; check reports index errors.",
+			run:  (*REPL).reindex},
+		{name: "help", aliases: []string{"?"}, usage: "help [<command>]", summary: "Lists the commands, or describes one",
+			run: (*REPL).help},
+	}
+}
+
+// lookup returns the command with the given name or alias, or nil if there isn't one.
+func lookup(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+		for _, a := range c.aliases {
+			if a == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// usage returns the error for a command given the wrong arguments.
+func usage(name string) error {
+	return fmt.Errorf("usage: %s", lookup(name).usage)
+}
+
+func (r *REPL) write(args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return usage("write")
+	}
+	var option []file.EntryOption
+	if len(args) == 3 {
+		ttl, err := time.ParseDuration(args[2])
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid ttl %q; try a duration such as 90s", args[2])
+		}
+		option = append(option, file.TTL(ttl))
+	}
+	if r.batch != nil {
+		r.batch.Add(file.NewEntry(args[0], append([]file.EntryOption{file.Value(args[1])}, option...)...))
+		fmt.Fprintf(r.out, "queued (%d)\n", r.batch.Len())
+		return nil
+	}
+	if _, err := r.db.Write(args[0], args[1], option...); err != nil {
+		return err
+	}
+	fmt.Fprintln(r.out, "written")
+	return nil
+}
+
+func (r *REPL) read(args []string) error {
+	if len(args) != 1 {
+		return usage("read")
+	}
+	entry := r.db.Read(args[0])
+	fmt.Fprintf(r.out, "%s: %s\n", entry.Key(), entry.Value())
+	return nil
+}
+
+func (r *REPL) delete(args []string) error {
+	if len(args) != 1 {
+		return usage("delete")
+	}
+	if r.batch != nil {
+		r.batch.Delete(args[0])
+		fmt.Fprintf(r.out, "queued (%d)\n", r.batch.Len())
+		return nil
+	}
+	if _, err := r.db.Delete(args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintln(r.out, "deleted")
+	return nil
+}
+
+func (r *REPL) exists(args []string) error {
+	if len(args) != 1 {
+		return usage("exists")
+	}
+	_, err := r.db.Get(args[0])
+	switch err {
+	case nil:
+		fmt.Fprintln(r.out, "true")
+	case file.ErrNotFound:
+		fmt.Fprintln(r.out, "false")
+	default:
+		return err
+	}
+	return nil
+}
+
+func (r *REPL) ttl(args []string) error {
+	if len(args) != 1 {
+		return usage("ttl")
+	}
+	entry, err := r.db.Get(args[0])
+	if err != nil {
+		return err
+	}
+	expires := entry.Expires()
+	if expires.IsZero() {
+		fmt.Fprintln(r.out, "no expiry")
+		return nil
+	}
+	fmt.Fprintf(r.out, "%s (at %s)\n", time.Until(expires).Round(time.Second), expires.Format(time.RFC3339))
+	return nil
+}
+
+func (r *REPL) keys(args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return usage("keys")
+	}
+	limit := DefaultKeysLimit
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid limit %q", args[1])
+		}
+		limit = n
+	}
+
+	from, to := file.PrefixRange(args[0])
+	// One more than the limit is read to tell whether there are more keys.
+	entries, err := r.db.Scan(from, to, limit+1)
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		if i == limit {
+			fmt.Fprintln(r.out, "...")
+			break
+		}
+		fmt.Fprintln(r.out, quote(entry.Key()))
+	}
+	return nil
+}
+
+func (r *REPL) count(args []string) error {
+	if len(args) > 1 {
+		return usage("count")
+	}
+	prefix := ""
+	if len(args) == 1 {
+		prefix = args[0]
+	}
+
+	from, to := file.PrefixRange(prefix)
+	count := 0
+	for {
+		entries, err := r.db.Scan(from, to, countPage)
+		if err != nil {
+			return err
+		}
+		count += len(entries)
+		if len(entries) < countPage {
+			break
+		}
+		from = entries[len(entries)-1].Key() + "\x00"
+	}
+	fmt.Fprintln(r.out, count)
+	return nil
+}
+
+func (r *REPL) stats(args []string) error {
+	if len(args) != 0 {
+		return usage("stats")
+	}
+	stats, err := r.db.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(r.out, stats)
+	return nil
+}
+
+func (r *REPL) compact(args []string) error {
+	if len(args) != 0 {
+		return usage("compact")
+	}
+	report, err := r.db.Compact()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(r.out, report)
+	return nil
+}
+
+func (r *REPL) begin(args []string) error {
+	if len(args) != 0 {
+		return usage("begin")
+	}
+	if r.batch != nil {
+		return errors.New("a batch is already open; commit or abort it first")
+	}
+	r.batch = file.NewBatch()
+	fmt.Fprintln(r.out, "batch started")
+	return nil
+}
+
+func (r *REPL) commit(args []string) error {
+	if len(args) != 0 {
+		return usage("commit")
+	}
+	if r.batch == nil {
+		return errors.New("no batch is open; start one with begin")
+	}
+	b := r.batch
+	if err := r.db.WriteBatch(b); err != nil {
+		return err
+	}
+	r.batch = nil
+	fmt.Fprintf(r.out, "committed %d changes\n", b.Len())
+	return nil
+}
+
+func (r *REPL) abort(args []string) error {
+	if len(args) != 0 {
+		return usage("abort")
+	}
+	if r.batch == nil {
+		return errors.New("no batch is open")
+	}
+	fmt.Fprintf(r.out, "discarded %d changes\n", r.batch.Len())
+	r.batch = nil
+	return nil
+}
+
+func (r *REPL) debug(args []string) error {
+	asJSON := len(args) == 2 && args[0] == "--json"
+	if !asJSON && (len(args) != 1 || args[0] == "--json") {
+		return usage("debug")
+	}
+	if !asJSON {
+		r.db.Debug(r.out, args[0])
+		return nil
+	}
+	b, err := json.MarshalIndent(r.db.Inspect(args[1]), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(r.out, string(b))
+	return nil
+}
+
+func (r *REPL) check(args []string) error {
+	if len(args) > 1 || (len(args) == 1 && args[0] != "--json") {
+		return usage("check")
+	}
+	report := r.db.Verify()
+	if len(args) == 1 {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(r.out, string(b))
+		return nil
+	}
+	fmt.Fprint(r.out, report)
+	return nil
+}
+
+func (r *REPL) reindex(args []string) error {
+	if len(args) != 0 {
+		return usage("reindex")
+	}
+	r.db.DBFile.File.Reindex()
+	fmt.Fprintln(r.out, "reindexed")
+	return nil
+}
+
+func (r *REPL) help(args []string) error {
+	if len(args) > 1 {
+		return usage("help")
+	}
+	if len(args) == 1 {
+		c := lookup(args[0])
+		if c == nil {
+			return fmt.Errorf("unknown command %q; try 'help'", args[0])
+		}
+		fmt.Fprintf(r.out, "usage: %s\n%s.\n", c.usage, c.summary)
+		if len(c.aliases) > 0 {
+			fmt.Fprintf(r.out, "Also: %s.\n", strings.Join(c.aliases, ", "))
+		}
+		if c.help != "" {
+			fmt.Fprintln(r.out, c.help)
+		}
+		return nil
+	}
+
+	fmt.Fprintln(r.out, "Command Help:")
+	for _, c := range commands {
+		fmt.Fprintf(r.out, "  %-28s : %s\n", c.usage, c.summary)
+	}
+	fmt.Fprintln(r.out, "Arguments containing spaces may be quoted, as in write \"my key\" 'my value'.")
+	return nil
+}
+
+// quote returns s as it would be typed as an argument: unchanged if it can be, and otherwise in double
+// quotes.
+func quote(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, c := range s {
+		if unicode.IsSpace(c) || !unicode.IsPrint(c) || strings.ContainsRune(`"'\`, c) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
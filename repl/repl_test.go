@@ -0,0 +1,175 @@
+package repl_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/repl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func SetupREPL(t *testing.T) (*database.DB, *bytes.Buffer, *repl.REPL) {
+	db, err := database.Init("repl_test")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Shutdown()
+		os.Remove("repl_test.dat")
+		os.Remove("repl_test.lock")
+	})
+	out := &bytes.Buffer{}
+	return db, out, repl.New(db, strings.NewReader(""), out)
+}
+
+// Exec runs a command, returning its output.
+func Exec(t *testing.T, r *repl.REPL, out *bytes.Buffer, line string) string {
+	out.Reset()
+	require.NoError(t, r.Exec(line), line)
+	return out.String()
+}
+
+func TestExec_QuotedArguments(t *testing.T) {
+	db, out, r := SetupREPL(t)
+
+	Exec(t, r, out, `write "my key" 'my value'`)
+	assert.Equal(t, "my value", db.Read("my key").Value())
+	assert.Equal(t, "my key: my value\n", Exec(t, r, out, `read "my key"`))
+}
+
+func TestExec_ExistsAndTTL(t *testing.T) {
+	_, out, r := SetupREPL(t)
+
+	Exec(t, r, out, "write a 1")
+	Exec(t, r, out, "write b 2 1h")
+	assert.Equal(t, "true\n", Exec(t, r, out, "exists a"))
+	assert.Equal(t, "false\n", Exec(t, r, out, "exists c"))
+	assert.Equal(t, "no expiry\n", Exec(t, r, out, "ttl a"))
+	assert.True(t, strings.HasPrefix(Exec(t, r, out, "ttl b"), "1h0m0s (at "))
+	assert.Error(t, r.Exec("write c 3 soon"))
+}
+
+func TestExec_KeysAndCount(t *testing.T) {
+	_, out, r := SetupREPL(t)
+
+	for _, line := range []string{"write user:1 a", "write user:2 b", `write "user:3 x" c`, "write item:1 d"} {
+		Exec(t, r, out, line)
+	}
+	assert.Equal(t, "user:1\nuser:2\n\"user:3 x\"\n", Exec(t, r, out, "scan user:"))
+	assert.Equal(t, "user:1\nuser:2\n...\n", Exec(t, r, out, "keys user: 2"))
+	assert.Equal(t, "3\n", Exec(t, r, out, "count user:"))
+	assert.Equal(t, "4\n", Exec(t, r, out, "count"))
+}
+
+func TestExec_Batch(t *testing.T) {
+	db, out, r := SetupREPL(t)
+	Exec(t, r, out, "write gone x")
+
+	Exec(t, r, out, "begin")
+	assert.Equal(t, "queued (1)\n", Exec(t, r, out, "write a 1"))
+	assert.Equal(t, "queued (2)\n", Exec(t, r, out, "delete gone"))
+	assert.Equal(t, "false\n", Exec(t, r, out, "exists a"))
+	assert.Error(t, r.Exec("begin"))
+	assert.Equal(t, "committed 2 changes\n", Exec(t, r, out, "commit"))
+	assert.Equal(t, "1", db.Read("a").Value())
+	assert.Equal(t, "false\n", Exec(t, r, out, "exists gone"))
+
+	Exec(t, r, out, "begin")
+	Exec(t, r, out, "write b 2")
+	assert.Equal(t, "discarded 1 changes\n", Exec(t, r, out, "rollback"))
+	assert.Equal(t, "false\n", Exec(t, r, out, "exists b"))
+	assert.Error(t, r.Exec("commit"))
+}
+
+func TestExec_StatsAndCompact(t *testing.T) {
+	db, out, r := SetupREPL(t)
+	Exec(t, r, out, "write a 1")
+	Exec(t, r, out, "write a 2")
+	Exec(t, r, out, "write b 3")
+	Exec(t, r, out, "delete b")
+
+	assert.Contains(t, Exec(t, r, out, "stats"), "live keys:     1 ")
+	assert.Contains(t, Exec(t, r, out, "compact"), "keeping 1 entries")
+	stats := Exec(t, r, out, "stats")
+	assert.Contains(t, stats, "garbage:       0 bytes (0.0%)")
+	assert.Contains(t, stats, "compactions:   1 ")
+	assert.Equal(t, "2", db.Read("a").Value())
+}
+
+func TestExec_Help(t *testing.T) {
+	_, out, r := SetupREPL(t)
+
+	help := Exec(t, r, out, "help")
+	for _, name := range []string{"keys", "count", "stats", "compact", "begin", "commit", "exists", "ttl", "reindex"} {
+		assert.Contains(t, help, name)
+	}
+	assert.Contains(t, Exec(t, r, out, "help scan"), "usage: keys <prefix> [<limit>]")
+	assert.Error(t, r.Exec("help nope"))
+}
+
+func TestExec_Errors(t *testing.T) {
+	_, _, r := SetupREPL(t)
+
+	assert.EqualError(t, r.Exec("write a"), "usage: write <key> <value> [<ttl>]")
+	assert.Equal(t, repl.ErrUnterminated, r.Exec(`write "a b`))
+	assert.EqualError(t, r.Exec("frobnicate"), `unknown command "frobnicate"; try 'help'`)
+	assert.Equal(t, repl.ErrQuit, r.Exec("q"))
+	assert.NoError(t, r.Exec("   "))
+}
+
+func TestRun_ReportsErrorsAndQuits(t *testing.T) {
+	db, err := database.Init("repl_test")
+	require.NoError(t, err)
+	defer func() {
+		db.Shutdown()
+		os.Remove("repl_test.dat")
+		os.Remove("repl_test.lock")
+	}()
+
+	out := &bytes.Buffer{}
+	in := strings.NewReader("write a 1\nwrite b\nbegin\nwrite c 3\nquit\nwrite d 4\n")
+	require.NoError(t, repl.New(db, in, out).Run())
+
+	assert.Equal(t, "> written\n> usage: write <key> <value> [<ttl>]\n> batch started\nbatch> queued (1)\nbatch> discarded a batch of 1 uncommitted changes\n", out.String())
+	assert.Equal(t, "<not found>", db.Read("c").Value())
+	assert.Equal(t, "<not found>", db.Read("d").Value())
+}
+
+func TestRunScript_StopsAtFirstError(t *testing.T) {
+	db, out, _ := SetupREPL(t)
+
+	err := repl.New(db, strings.NewReader("write a 1\n# a comment\nbegin\nwrite b 2\ncommit\nread a\nwrite c\nwrite d 4\n"), out).RunScript()
+	var serr *repl.ScriptError
+	require.True(t, errors.As(err, &serr))
+	assert.Equal(t, 7, serr.Line)
+	assert.Equal(t, "written\nbatch started\nqueued (1)\ncommitted 1 changes\na: 1\n", out.String())
+	assert.Equal(t, "2", db.Read("b").Value())
+	assert.Equal(t, "<not found>", db.Read("d").Value())
+}
+
+func TestRunScript_RejectsUncommittedBatch(t *testing.T) {
+	db, out, _ := SetupREPL(t)
+
+	err := repl.New(db, strings.NewReader("begin\nwrite a 1\n"), out).RunScript()
+	assert.EqualError(t, err, "line 2: script ended without committing its batch")
+	assert.Equal(t, "<not found>", db.Read("a").Value())
+	assert.NoError(t, repl.New(db, strings.NewReader("write a 1\nquit\nwrite b\n"), out).RunScript())
+}
+
+func TestExec_DebugJSON(t *testing.T) {
+	_, out, r := SetupREPL(t)
+	Exec(t, r, out, "write a 1")
+	Exec(t, r, out, "write a 2")
+
+	var in file.Inspection
+	require.NoError(t, json.Unmarshal([]byte(Exec(t, r, out, "debug --json a")), &in))
+	assert.Len(t, in.Occurrences, 2)
+	assert.True(t, in.IndexAtLatest)
+	assert.Contains(t, Exec(t, r, out, "debug a"), "Key Occurrences: 2")
+	assert.Error(t, r.Exec("debug --json"))
+}
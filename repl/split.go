@@ -0,0 +1,87 @@
+package repl
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrUnterminated is returned by Split when a line ends inside a quoted argument or after a backslash.
+var ErrUnterminated = errors.New("unterminated quote or escape")
+
+// Split splits a command line into arguments the way a shell would. Arguments are separated by spaces or
+// tabs, and may be quoted to include them:
+//
+//	"double quotes"  allow the escapes \", \\, \n, \r and \t
+//	'single quotes'  are taken literally, and cannot contain a single quote
+//	a\ b             outside quotes, a backslash escapes the character after it
+//
+// Quoted and unquoted text may be joined into one argument, and "" is an empty argument.
+func Split(line string) ([]string, error) {
+	var (
+		args   []string
+		sb     strings.Builder
+		inArg  bool
+		quote  byte
+		escape bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case escape:
+			if quote == '"' {
+				switch c {
+				case 'n':
+					c = '\n'
+				case 'r':
+					c = '\r'
+				case 't':
+					c = '\t'
+				case '"', '\\':
+				default:
+					// Like a shell, keep the backslash before a character it does not escape.
+					sb.WriteByte('\\')
+				}
+			}
+			sb.WriteByte(c)
+			escape = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				sb.WriteByte(c)
+			}
+		case quote == '"':
+			switch c {
+			case '"':
+				quote = 0
+			case '\\':
+				escape = true
+			default:
+				sb.WriteByte(c)
+			}
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, sb.String())
+				sb.Reset()
+				inArg = false
+			}
+		default:
+			inArg = true
+			switch c {
+			case '\'', '"':
+				quote = c
+			case '\\':
+				escape = true
+			default:
+				sb.WriteByte(c)
+			}
+		}
+	}
+	if quote != 0 || escape {
+		return nil, ErrUnterminated
+	}
+	if inArg {
+		args = append(args, sb.String())
+	}
+	return args, nil
+}
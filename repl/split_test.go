@@ -0,0 +1,38 @@
+package repl_test
+
+import (
+	"testing"
+
+	"github.com/matthew-burr/db/repl"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"  write  a   b ", []string{"write", "a", "b"}},
+		{"write \"my key\" 'my value'", []string{"write", "my key", "my value"}},
+		{`write a\ b c`, []string{"write", "a b", "c"}},
+		{`write "say \"hi\"\n" x`, []string{"write", "say \"hi\"\n", "x"}},
+		{`write 'c:\path' "a\qb"`, []string{"write", `c:\path`, `a\qb`}},
+		{`write "" x`, []string{"write", "", "x"}},
+		{`write pre"fix "suffix`, []string{"write", "prefix suffix"}},
+		{"write\ta\tb", []string{"write", "a", "b"}},
+	}
+	for _, tt := range tests {
+		got, err := repl.Split(tt.line)
+		if assert.NoError(t, err, tt.line) {
+			assert.Equal(t, tt.want, got, tt.line)
+		}
+	}
+}
+
+func TestSplit_Unterminated(t *testing.T) {
+	for _, line := range []string{`write "a`, `write 'a`, `write a\`, `write "a\"`} {
+		_, err := repl.Split(line)
+		assert.Equal(t, repl.ErrUnterminated, err, line)
+	}
+}
@@ -0,0 +1,281 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+)
+
+const (
+	// OffsetHeader is the response header in which a primary reports the offset at which its log ends.
+	OffsetHeader = "X-Primary-Offset"
+
+	// GenerationHeader is the response header in which a primary reports the generation of its log. See
+	// file.DBFile.Generation.
+	GenerationHeader = "X-Primary-Generation"
+
+	// MaxChunk is the largest number of bytes of log a primary sends in one response.
+	MaxChunk = 1 << 20
+
+	// DefaultWait is how long a primary holds a request open waiting for new entries when the replica
+	// has already caught up.
+	DefaultWait = 30 * time.Second
+)
+
+// ErrDiverged is returned by a Replica whose log is not a copy of the primary's, because the replica was
+// seeded from a different database or the primary's log was compacted since the replica copied from it.
+// The replica must be re-seeded.
+var ErrDiverged = errors.New("replica's log has diverged from the primary's")
+
+// Handler returns an http.Handler that streams a primary's log to replicas. A replica requests
+// GET ?offset=N&generation=G and receives the raw, encoded entries from offset N onwards, with the
+// primary's current end offset in the OffsetHeader header and its log's generation in the
+// GenerationHeader header. If there are no entries past N yet, the request waits, up to the duration
+// given by the wait parameter or DefaultWait, for some to be written. If the log has been compacted since
+// generation G, whose offsets no longer apply, the response is 409 Conflict; a replica with an empty log
+// may leave the generation out.
+func Handler(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if err != nil || offset < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+
+		wait := DefaultWait
+		if s := r.URL.Query().Get("wait"); s != "" {
+			if wait, err = time.ParseDuration(s); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		f := db.DBFile.File
+		generation := r.URL.Query().Get("generation")
+		timeout := time.NewTimer(wait)
+		defer timeout.Stop()
+		for {
+			changed := f.Changed()
+			w.Header().Set(GenerationHeader, f.Generation())
+			raw, end, err := f.ReadLog(generation, offset, MaxChunk)
+			if err == file.ErrCompacted {
+				w.Header().Set(OffsetHeader, strconv.FormatInt(end, 10))
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err == file.ErrOffsetOutOfRange {
+				w.Header().Set(OffsetHeader, strconv.FormatInt(end, 10))
+				http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if len(raw) > 0 {
+				w.Header().Set(OffsetHeader, strconv.FormatInt(end, 10))
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Write(raw)
+				return
+			}
+
+			select {
+			case <-changed:
+				continue
+			case <-timeout.C:
+			case <-r.Context().Done():
+			}
+			w.Header().Set(OffsetHeader, strconv.FormatInt(end, 10))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	})
+}
+
+// An Option is an optional setting you may provide to a Replica.
+type Option func(*Replica)
+
+// Wait is an Option that sets how long the primary should hold each request open waiting for new
+// entries.
+func Wait(d time.Duration) Option {
+	return func(r *Replica) {
+		r.wait = d
+	}
+}
+
+// RetryInterval is an Option that sets how long a Replica waits before trying again after failing to
+// reach the primary.
+func RetryInterval(d time.Duration) Option {
+	return func(r *Replica) {
+		r.retry = d
+	}
+}
+
+// A Replica keeps a DB up to date with a primary by tailing the primary's log and appending the same
+// bytes to its own. Because the replica's log is a byte-for-byte copy of the primary's, the end of its
+// own file is the offset from which to resume, even after a restart, and its own log's generation is the
+// primary's. Nothing else should write to a replica's DB, nor compact it.
+type Replica struct {
+	db      *database.DB
+	primary string
+	client  *http.Client
+	wait    time.Duration
+	retry   time.Duration
+
+	mu            sync.Mutex
+	primaryOffset int64
+	lastContact   time.Time
+	lastErr       error
+}
+
+// NewReplica creates a Replica that copies the log served by Handler at primaryURL into db.
+func NewReplica(db *database.DB, primaryURL string, option ...Option) *Replica {
+	r := &Replica{
+		db:      db,
+		primary: primaryURL,
+		client:  &http.Client{},
+		wait:    DefaultWait,
+		retry:   time.Second,
+	}
+	for _, o := range option {
+		o(r)
+	}
+	return r
+}
+
+// Run tails the primary until ctx is done or the replica finds that it has diverged from the primary.
+// Other errors, such as the primary being unreachable, are retried. Copying resumes after the last
+// complete entry in the replica's log, since opening the DB discards any partial one a crash left behind.
+func (r *Replica) Run(ctx context.Context) error {
+	var pending []byte
+	for {
+		raw, err := r.fetch(ctx, r.db.DBFile.File.Generation(), r.offset()+int64(len(pending)))
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == ErrDiverged {
+			return err
+		}
+		if err != nil {
+			r.setErr(err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(r.retry):
+			}
+			continue
+		}
+
+		// A chunk may end partway through an entry; hold on to the start of it until the rest arrives.
+		pending = append(pending, raw...)
+		n, err := r.db.DBFile.File.Append(pending)
+		if err != nil {
+			return err
+		}
+		pending = append(pending[:0], pending[n:]...)
+		r.setErr(nil)
+	}
+}
+
+// fetch requests the primary's log from offset, in the given generation.
+func (r *Replica) fetch(ctx context.Context, generation string, offset int64) ([]byte, error) {
+	q := url.Values{}
+	q.Set("offset", strconv.FormatInt(offset, 10))
+	if generation != "" {
+		q.Set("generation", generation)
+	}
+	q.Set("wait", r.wait.String())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.primary+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	case http.StatusRequestedRangeNotSatisfiable, http.StatusConflict:
+		return nil, ErrDiverged
+	default:
+		return nil, fmt.Errorf("primary returned %s", resp.Status)
+	}
+
+	primaryOffset, err := strconv.ParseInt(resp.Header.Get(OffsetHeader), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("primary sent a bad %s header: %v", OffsetHeader, err)
+	}
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.primaryOffset = primaryOffset
+	r.lastContact = time.Now()
+	r.mu.Unlock()
+	return raw, nil
+}
+
+func (r *Replica) offset() int64 {
+	return r.db.DBFile.File.CurrentOffset()
+}
+
+func (r *Replica) setErr(err error) {
+	r.mu.Lock()
+	r.lastErr = err
+	r.mu.Unlock()
+}
+
+// A Status describes how far a Replica is behind its primary.
+type Status struct {
+	Offset        int64     `json:"offset"`         // The offset the replica has copied up to.
+	PrimaryOffset int64     `json:"primary_offset"` // The primary's offset when it was last contacted.
+	Lag           int64     `json:"lag"`            // The number of bytes the replica is behind.
+	LastContact   time.Time `json:"last_contact"`   // When the primary was last contacted successfully.
+	LastError     string    `json:"last_error,omitempty"`
+}
+
+// Status reports the Replica's replication lag.
+func (r *Replica) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := Status{
+		Offset:        r.offset(),
+		PrimaryOffset: r.primaryOffset,
+		LastContact:   r.lastContact,
+	}
+	if s.PrimaryOffset > s.Offset {
+		s.Lag = s.PrimaryOffset - s.Offset
+	}
+	if r.lastErr != nil {
+		s.LastError = r.lastErr.Error()
+	}
+	return s
+}
+
+// Lag returns the number of bytes of the primary's log that the Replica has yet to copy, as of the last
+// time it contacted the primary.
+func (r *Replica) Lag() int64 {
+	return r.Status().Lag
+}
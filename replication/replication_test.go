@@ -0,0 +1,206 @@
+package replication_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/replication"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func SetupDB(t *testing.T, name string) (db *database.DB, cleanup func()) {
+	db, err := database.Init(name)
+	require.NoError(t, err)
+	return db, func() {
+		db.Shutdown()
+		os.Remove(name + ".dat")
+		os.Remove(name + ".lock")
+	}
+}
+
+func SetupPrimary(t *testing.T) (db *database.DB, srv *httptest.Server, cleanup func()) {
+	db, c := SetupDB(t, "primary_test")
+	srv = httptest.NewServer(replication.Handler(db))
+	return db, srv, func() {
+		srv.Close()
+		c()
+	}
+}
+
+func Eventually(t *testing.T, cond func() bool) {
+	assert.Eventually(t, cond, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestHandler_ReturnsLogFromOffset(t *testing.T) {
+	db, srv, cleanup := SetupPrimary(t)
+	defer cleanup()
+	db.Write("a", "1")
+	end := db.DBFile.File.CurrentOffset()
+
+	resp, err := http.Get(srv.URL + "?offset=0")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, strconv.FormatInt(end, 10), resp.Header.Get(replication.OffsetHeader))
+
+	resp, err = http.Get(srv.URL + "?offset=" + strconv.FormatInt(end, 10) + "&wait=10ms")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "?offset=" + strconv.FormatInt(end+1, 10))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+}
+
+func TestReplica_CopiesPrimary(t *testing.T) {
+	primary, srv, cleanup := SetupPrimary(t)
+	defer cleanup()
+	primary.Write("before", "1")
+
+	db, c := SetupDB(t, "replica_test")
+	defer c()
+	r := replication.NewReplica(db, srv.URL, replication.Wait(50*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+
+	Eventually(t, func() bool { return db.Read("before").Value() == "1" })
+
+	primary.Write("after", "2")
+	primary.Delete("before")
+	Eventually(t, func() bool { return db.Read("after").Value() == "2" })
+	Eventually(t, func() bool { return db.Read("before").Value() == "<not found>" })
+	Eventually(t, func() bool { return r.Lag() == 0 })
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Equal(t, primary.DBFile.File.CurrentOffset(), r.Status().Offset)
+}
+
+func TestReplica_ResumesAfterRestart(t *testing.T) {
+	primary, srv, cleanup := SetupPrimary(t)
+	defer cleanup()
+	primary.Write("first", "1")
+
+	db, c := SetupDB(t, "replica_test")
+	defer c()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- replication.NewReplica(db, srv.URL, replication.Wait(50*time.Millisecond)).Run(ctx) }()
+	Eventually(t, func() bool { return db.Read("first").Value() == "1" })
+	cancel()
+	<-done
+	db.Shutdown()
+
+	primary.Write("second", "2")
+
+	db, err := database.Init("replica_test")
+	require.NoError(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- replication.NewReplica(db, srv.URL, replication.Wait(50*time.Millisecond)).Run(ctx) }()
+	Eventually(t, func() bool { return db.Read("second").Value() == "2" })
+	cancel()
+	<-done
+
+	assert.True(t, db.Verify().OK())
+	assert.Equal(t, primary.DBFile.File.CurrentOffset(), db.DBFile.File.CurrentOffset())
+	db.Shutdown()
+}
+
+func TestReplica_DiscardsPartialEntryOnRestart(t *testing.T) {
+	primary, srv, cleanup := SetupPrimary(t)
+	defer cleanup()
+	primary.Write("first", "1")
+
+	db, c := SetupDB(t, "replica_test")
+	defer c()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- replication.NewReplica(db, srv.URL, replication.Wait(50*time.Millisecond)).Run(ctx) }()
+	Eventually(t, func() bool { return db.Read("first").Value() == "1" })
+	cancel()
+	<-done
+	db.Shutdown()
+
+	// A crash part way through appending the next entry leaves the start of it behind.
+	primary.Write("second", "2")
+	raw, _, err := primary.DBFile.File.ReadLog("", db.DBFile.File.CurrentOffset(), 5)
+	require.NoError(t, err)
+	f, err := os.OpenFile("replica_test.dat", os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	f.Write(raw)
+	f.Close()
+
+	db, err = database.Init("replica_test")
+	require.NoError(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- replication.NewReplica(db, srv.URL, replication.Wait(50*time.Millisecond)).Run(ctx) }()
+	primary.Write("third", "3")
+	Eventually(t, func() bool { return db.Read("third").Value() == "3" })
+	cancel()
+	require.NoError(t, <-done)
+
+	assert.Equal(t, "2", db.Read("second").Value())
+	assert.True(t, db.Verify().OK())
+	assert.Equal(t, primary.DBFile.File.CurrentOffset(), db.DBFile.File.CurrentOffset())
+	db.Shutdown()
+}
+
+func TestReplica_StopsWhenDiverged(t *testing.T) {
+	_, srv, cleanup := SetupPrimary(t)
+	defer cleanup()
+
+	db, c := SetupDB(t, "replica_test")
+	defer c()
+	db.Write("only", "here")
+
+	err := replication.NewReplica(db, srv.URL).Run(context.Background())
+	assert.Equal(t, replication.ErrDiverged, err)
+}
+
+func TestReplica_StopsWhenPrimaryIsCompacted(t *testing.T) {
+	primary, srv, cleanup := SetupPrimary(t)
+	defer cleanup()
+	primary.Write("a", "1")
+
+	db, c := SetupDB(t, "replica_test")
+	defer c()
+	r := replication.NewReplica(db, srv.URL, replication.Wait(50*time.Millisecond))
+	done := make(chan error)
+	go func() { done <- r.Run(context.Background()) }()
+	Eventually(t, func() bool { return db.Read("a").Value() == "1" })
+
+	primary.Write("a", "2")
+	_, err := primary.DBFile.Compact()
+	require.NoError(t, err)
+	select {
+	case err := <-done:
+		assert.Equal(t, replication.ErrDiverged, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("replica did not stop")
+	}
+}
+
+func TestReplica_ReportsErrorsWhilePrimaryIsDown(t *testing.T) {
+	db, c := SetupDB(t, "replica_test")
+	defer c()
+
+	r := replication.NewReplica(db, "http://127.0.0.1:1", replication.RetryInterval(time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+
+	Eventually(t, func() bool { return r.Status().LastError != "" })
+	cancel()
+	assert.NoError(t, <-done)
+}
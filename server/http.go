@@ -0,0 +1,397 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/replication"
+)
+
+const (
+	// DefaultLimit is the number of entries a listing returns when the request does not specify a limit.
+	DefaultLimit = 100
+
+	// MaxLimit is the largest number of entries a single listing will return.
+	MaxLimit = 1000
+
+	// ShutdownTimeout is how long Serve waits for in-flight requests to finish when shutting down.
+	ShutdownTimeout = 5 * time.Second
+
+	// MaxBodyBytes is the largest body a batch request may have. A value written on its own may be no
+	// longer than file.MaxLength.
+	MaxBodyBytes = 16 << 20
+)
+
+// An Entry is the JSON form of a DBFileEntry.
+type Entry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// A Listing is the response to a request to list keys. If there are more entries than were returned,
+// Next holds the key to pass as the after parameter to fetch the next page.
+type Listing struct {
+	Entries []Entry `json:"entries"`
+	Next    string  `json:"next,omitempty"`
+}
+
+// An Op is a single write or delete within a batch request.
+type Op struct {
+	Op    string `json:"op"` // Either "put" or "delete".
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// A BatchRequest is the body of a request to apply several operations together.
+type BatchRequest struct {
+	Ops []Op `json:"ops"`
+}
+
+// A GetRequest is the body of a request to read several keys at once.
+type GetRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// A GetResponse holds the entries found for a GetRequest and the keys that were not found.
+type GetResponse struct {
+	Entries []Entry  `json:"entries"`
+	Missing []string `json:"missing"`
+}
+
+// An Error is the body of every unsuccessful response.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error codes returned by the server.
+const (
+	CodeNotFound   = "not_found"
+	CodeReadOnly   = "read_only"
+	CodeBadRequest = "bad_request"
+	CodeTooLarge   = "too_large"
+	CodeConflict   = "conflict"
+	CodeInternal   = "internal"
+)
+
+// A handler serves the HTTP API for a DB.
+type handler struct {
+	db *database.DB
+}
+
+// NewHandler returns an http.Handler that exposes the DB over HTTP:
+//
+//	GET    /keys/{key}  reads a key's value
+//	PUT    /keys/{key}  writes the request body to a key
+//	DELETE /keys/{key}  deletes a key
+//	GET    /keys        lists entries, filtered by prefix or by start and end, a page at a time
+//	POST   /batch       applies a BatchRequest
+//	POST   /batch/get   reads the keys in a GetRequest
+//	GET    /replication streams the log to replicas; see replication.Handler
+//
+// A {key} is escaped as by url.PathEscape, so it may contain any character, including "/".
+func NewHandler(db *database.DB) http.Handler {
+	h := &handler{db: db}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys/", h.serveKey)
+	mux.HandleFunc("/keys", h.serveList)
+	mux.HandleFunc("/batch", h.serveBatch)
+	mux.HandleFunc("/batch/get", h.serveBatchGet)
+	mux.Handle("/replication", replication.Handler(db))
+
+	// Keys may hold anything, including "//" and "/../", which the mux would clean out of the path and
+	// redirect, so key requests bypass it.
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.EscapedPath(), "/keys/") {
+			h.serveKey(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Serve serves the DB's HTTP API on addr until ctx is done. It then stops accepting requests, waits for
+// those in flight to finish, and shuts down the DB.
+func Serve(ctx context.Context, addr string, db *database.DB) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return ServeListener(ctx, l, db)
+}
+
+// ServeListener is like Serve, but accepts connections on an existing listener. Requests still running
+// after ShutdownTimeout, such as replicas' long polls, have their connections closed, and the DB is shut
+// down once they have returned.
+func ServeListener(ctx context.Context, l net.Listener, db *database.DB) error {
+	requests := &tracker{Handler: NewHandler(db)}
+	srv := &http.Server{Handler: requests}
+	defer db.Shutdown()
+	defer requests.close()
+
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(l) }()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Closing the connections cancels the requests' contexts, which ends them.
+		srv.Close()
+		return err
+	}
+	return nil
+}
+
+// A tracker serves requests with a Handler until it is closed, so that the DB is not shut down under
+// requests that are still running.
+type tracker struct {
+	http.Handler
+	mu     sync.RWMutex // Read-locked while serving each request.
+	closed bool
+}
+
+func (t *tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		writeJSON(w, http.StatusServiceUnavailable, Error{Code: CodeInternal, Message: "server is shutting down"})
+		return
+	}
+	t.Handler.ServeHTTP(w, r)
+}
+
+// close waits for the requests being served to return, and refuses any that arrive afterwards.
+func (t *tracker) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+}
+
+func (h *handler) serveKey(w http.ResponseWriter, r *http.Request) {
+	// The key is decoded from the path as sent, so that an escaped "/" is part of the key.
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/keys/"))
+	if err != nil {
+		writeError(w, badRequest(err))
+		return
+	}
+	if key == "" {
+		h.serveList(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entry, err := h.db.Get(key)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write([]byte(entry.Value()))
+	case http.MethodPut:
+		value, err := readBody(w, r, file.MaxLength)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if _, err := h.db.Write(key, string(value)); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if _, err := h.db.Delete(key); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func (h *handler) serveList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	q := r.URL.Query()
+	from, to := q.Get("start"), q.Get("end")
+	if prefix := q.Get("prefix"); prefix != "" {
+		from, to = file.PrefixRange(prefix)
+	}
+	if after := q.Get("after"); after >= from && after != "" {
+		from = after + "\x00"
+	}
+
+	limit := DefaultLimit
+	if l := q.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			writeError(w, badRequest(errors.New("limit must be a positive integer")))
+			return
+		}
+		limit = n
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	// Ask for one more than the limit to learn whether there is another page.
+	entries, err := h.db.Scan(from, to, limit+1)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	listing := Listing{Entries: make([]Entry, 0, len(entries))}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		listing.Next = entries[limit-1].Key()
+	}
+	for _, e := range entries {
+		listing.Entries = append(listing.Entries, Entry{e.Key(), e.Value()})
+	}
+	writeJSON(w, http.StatusOK, listing)
+}
+
+func (h *handler) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	body, err := readBody(w, r, MaxBodyBytes)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req BatchRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, badRequest(err))
+		return
+	}
+
+	b := file.NewBatch()
+	for _, op := range req.Ops {
+		switch op.Op {
+		case "put":
+			b.Write(op.Key, op.Value)
+		case "delete":
+			b.Delete(op.Key)
+		default:
+			writeError(w, badRequest(errors.New("unknown op "+strconv.Quote(op.Op))))
+			return
+		}
+	}
+
+	if err := h.db.WriteBatch(b); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) serveBatchGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	body, err := readBody(w, r, MaxBodyBytes)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req GetRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, badRequest(err))
+		return
+	}
+
+	resp := GetResponse{Entries: make([]Entry, 0, len(req.Keys)), Missing: make([]string, 0)}
+	for _, key := range req.Keys {
+		entry, err := h.db.Get(key)
+		switch {
+		case err == file.ErrNotFound:
+			resp.Missing = append(resp.Missing, key)
+		case err != nil:
+			writeError(w, err)
+			return
+		default:
+			resp.Entries = append(resp.Entries, Entry{entry.Key(), entry.Value()})
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// readBody reads a request's body, returning file.ErrTooLarge if it is longer than limit bytes.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	// MaxBytesReader fails once it has returned limit bytes and finds more.
+	if err != nil && int64(len(body)) == limit {
+		return nil, file.ErrTooLarge
+	}
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	return body, nil
+}
+
+// A requestError is an error caused by a malformed request.
+type requestError struct {
+	error
+}
+
+func badRequest(err error) error {
+	return requestError{err}
+}
+
+// writeError responds with the status code and Error body that correspond to err, or to the error it
+// wraps.
+func writeError(w http.ResponseWriter, err error) {
+	status, code := http.StatusInternalServerError, CodeInternal
+	var reqErr requestError
+	switch {
+	case errors.Is(err, file.ErrNotFound), errors.Is(err, file.ErrNoBucket):
+		status, code = http.StatusNotFound, CodeNotFound
+	case errors.Is(err, file.ErrReadOnly):
+		status, code = http.StatusForbidden, CodeReadOnly
+	case errors.Is(err, file.ErrTooLarge):
+		status, code = http.StatusRequestEntityTooLarge, CodeTooLarge
+	case errors.Is(err, file.ErrConditionFailed):
+		status, code = http.StatusConflict, CodeConflict
+	case errors.Is(err, file.ErrUnknownOperator), errors.As(err, &reqErr):
+		status, code = http.StatusBadRequest, CodeBadRequest
+	}
+	writeJSON(w, status, Error{Code: code, Message: err.Error()})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, Error{Code: CodeBadRequest, Message: "method not allowed"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
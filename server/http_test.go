@@ -0,0 +1,246 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+	"github.com/matthew-burr/db/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func SetupTestServer(t *testing.T) (db *database.DB, srv *httptest.Server, cleanup func()) {
+	db, err := database.Init("server_test")
+	require.NoError(t, err)
+	srv = httptest.NewServer(server.NewHandler(db))
+	return db, srv, func() {
+		srv.Close()
+		db.Shutdown()
+		os.Remove("server_test.dat")
+		os.Remove("server_test.lock")
+	}
+}
+
+func Do(t *testing.T, method, url, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(b)
+}
+
+func TestKey_PutGetDelete(t *testing.T) {
+	_, srv, c := SetupTestServer(t)
+	defer c()
+
+	resp, _ := Do(t, http.MethodPut, srv.URL+"/keys/hello", "world")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, body := Do(t, http.MethodGet, srv.URL+"/keys/hello", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "world", body)
+
+	resp, _ = Do(t, http.MethodDelete, srv.URL+"/keys/hello", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, body = Do(t, http.MethodGet, srv.URL+"/keys/hello", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	var e server.Error
+	require.NoError(t, json.Unmarshal([]byte(body), &e))
+	assert.Equal(t, server.CodeNotFound, e.Code)
+}
+
+func TestKey_MethodNotAllowed(t *testing.T) {
+	_, srv, c := SetupTestServer(t)
+	defer c()
+
+	resp, _ := Do(t, http.MethodPost, srv.URL+"/keys/hello", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestList_PaginatesByPrefix(t *testing.T) {
+	db, srv, c := SetupTestServer(t)
+	defer c()
+	for _, k := range []string{"a1", "a2", "a3", "b1"} {
+		db.Write(k, "v"+k)
+	}
+
+	var got []string
+	url := srv.URL + "/keys?prefix=a&limit=2"
+	for {
+		resp, body := Do(t, http.MethodGet, url, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var l server.Listing
+		require.NoError(t, json.Unmarshal([]byte(body), &l))
+		for _, e := range l.Entries {
+			got = append(got, e.Key)
+			assert.Equal(t, "v"+e.Key, e.Value)
+		}
+		if l.Next == "" {
+			break
+		}
+		url = srv.URL + "/keys?prefix=a&limit=2&after=" + l.Next
+	}
+	assert.Equal(t, []string{"a1", "a2", "a3"}, got)
+}
+
+func TestList_Range(t *testing.T) {
+	db, srv, c := SetupTestServer(t)
+	defer c()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		db.Write(k, k)
+	}
+
+	_, body := Do(t, http.MethodGet, srv.URL+"/keys?start=b&end=d", "")
+	var l server.Listing
+	require.NoError(t, json.Unmarshal([]byte(body), &l))
+	assert.Equal(t, []server.Entry{{"b", "b"}, {"c", "c"}}, l.Entries)
+}
+
+func TestList_RejectsBadLimit(t *testing.T) {
+	_, srv, c := SetupTestServer(t)
+	defer c()
+
+	resp, _ := Do(t, http.MethodGet, srv.URL+"/keys?limit=zero", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestBatch_AppliesOpsAndReadsKeys(t *testing.T) {
+	db, srv, c := SetupTestServer(t)
+	defer c()
+	db.Write("gone", "soon")
+
+	req, _ := json.Marshal(server.BatchRequest{Ops: []server.Op{
+		{Op: "put", Key: "a", Value: "1"},
+		{Op: "put", Key: "b", Value: "2"},
+		{Op: "delete", Key: "gone"},
+	}})
+	resp, _ := Do(t, http.MethodPost, srv.URL+"/batch", string(req))
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	req, _ = json.Marshal(server.GetRequest{Keys: []string{"a", "b", "gone"}})
+	resp, body := Do(t, http.MethodPost, srv.URL+"/batch/get", string(req))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var got server.GetResponse
+	require.NoError(t, json.Unmarshal([]byte(body), &got))
+	assert.Equal(t, []server.Entry{{"a", "1"}, {"b", "2"}}, got.Entries)
+	assert.Equal(t, []string{"gone"}, got.Missing)
+}
+
+func TestBatch_RejectsUnknownOp(t *testing.T) {
+	db, srv, c := SetupTestServer(t)
+	defer c()
+
+	req, _ := json.Marshal(server.BatchRequest{Ops: []server.Op{
+		{Op: "put", Key: "a", Value: "1"},
+		{Op: "frob", Key: "b"},
+	}})
+	resp, _ := Do(t, http.MethodPost, srv.URL+"/batch", string(req))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "<not found>", db.Read("a").Value())
+}
+
+func TestBatch_RejectsTooLargeValues(t *testing.T) {
+	db, srv, c := SetupTestServer(t)
+	defer c()
+
+	req, _ := json.Marshal(server.BatchRequest{Ops: []server.Op{{Op: "put", Key: "a", Value: strings.Repeat("x", file.MaxLength+1)}}})
+	resp, body := Do(t, http.MethodPost, srv.URL+"/batch", string(req))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode, body)
+	assert.Equal(t, "<not found>", db.Read("a").Value())
+}
+
+func TestServeListener_ShutsDownDB(t *testing.T) {
+	db, err := database.Init("server_test")
+	require.NoError(t, err)
+	defer os.Remove("server_test.dat")
+	defer os.Remove("server_test.lock")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.ServeListener(ctx, l, db) }()
+
+	resp, err := http.Post("http://"+l.Addr().String()+"/batch", "application/json", bytes.NewBufferString(`{"ops":[]}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	cancel()
+	assert.NoError(t, <-done)
+
+	// The database is unlocked once the server has shut it down.
+	db, err = database.Init("server_test")
+	require.NoError(t, err)
+	db.Shutdown()
+}
+
+func TestServeListener_EndsLongPollsBeforeShuttingDownDB(t *testing.T) {
+	db, err := database.Init("server_test")
+	require.NoError(t, err)
+	defer os.Remove("server_test.dat")
+	defer os.Remove("server_test.lock")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.ServeListener(ctx, l, db) }()
+
+	// A replica that has caught up waits far longer than the server waits for requests to finish.
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		resp, err := http.Get("http://" + l.Addr().String() + "/replication?offset=0&wait=1m")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	cancel()
+	assert.Equal(t, context.DeadlineExceeded, <-done)
+	assert.Less(t, int64(time.Since(start)), int64(server.ShutdownTimeout+time.Second))
+	<-polled
+
+	db, err = database.Init("server_test")
+	require.NoError(t, err)
+	db.Shutdown()
+}
+
+func TestKey_PutRejectsTooLargeValues(t *testing.T) {
+	db, srv, c := SetupTestServer(t)
+	defer c()
+
+	resp, body := Do(t, http.MethodPut, srv.URL+"/keys/big", strings.Repeat("x", file.MaxLength+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	var e server.Error
+	require.NoError(t, json.Unmarshal([]byte(body), &e))
+	assert.Equal(t, server.CodeTooLarge, e.Code)
+
+	batch := `{"ops":[{"op":"put","key":"big","value":"` + strings.Repeat("x", file.MaxLength+1) + `"}]}`
+	resp, _ = Do(t, http.MethodPost, srv.URL+"/batch", batch)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	resp, _ = Do(t, http.MethodPut, srv.URL+"/keys/max", strings.Repeat("x", file.MaxLength))
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.True(t, db.Verify().OK())
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/matthew-burr/db/database"
+)
+
+// MetricsHandler returns an http.Handler that serves the DB's Stats in the Prometheus text format, for
+// a Prometheus server to scrape. See WriteMetrics for the metrics it exposes.
+func MetricsHandler(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		stats, err := db.Stats()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w, stats)
+	})
+}
+
+// ServeMetrics serves the DB's metrics at /metrics on addr until ctx is done. Unlike Serve, it leaves the
+// DB open when it stops, since it is meant to run alongside another server.
+func ServeMetrics(ctx context.Context, addr string, db *database.DB) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler(db))
+	srv := &http.Server{Handler: mux}
+
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(l) }()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// WriteMetrics writes Stats to w in the Prometheus text format. The metrics are named db_*, with
+// counters ending in _total and durations measured in seconds.
+func WriteMetrics(w io.Writer, s *database.Stats) error {
+	bw := bufio.NewWriter(w)
+	metric := func(name, kind, help string, value float64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatFloat(value))
+	}
+	metric("db_reads_total", "counter", "Keys read.", float64(s.Reads))
+	metric("db_scans_total", "counter", "Range scans.", float64(s.Scans))
+	metric("db_writes_total", "counter", "Keys written.", float64(s.Writes))
+	metric("db_deletes_total", "counter", "Keys deleted.", float64(s.Deletes))
+	metric("db_errors_total", "counter", "Operations that failed.", float64(s.Errors))
+	metric("db_appended_bytes_total", "counter", "Bytes appended to the log.", float64(s.BytesAppended))
+	metric("db_file_size_bytes", "gauge", "Size of the log.", float64(s.FileSize))
+	metric("db_live_keys", "gauge", "Keys in the index.", float64(s.LiveKeys))
+	metric("db_live_bytes", "gauge", "Bytes taken by the latest entries of live keys.", float64(s.LiveBytes))
+	metric("db_dead_bytes", "gauge", "Bytes that compaction would reclaim.", float64(s.DeadBytes))
+	metric("db_garbage_ratio", "gauge", "Fraction of the log taken by dead bytes.", s.GarbageRatio)
+	writeHistogram(bw, "db_compaction_duration_seconds", "Time taken to compact the log.", s.Compaction)
+	writeHistogram(bw, "db_read_latency_seconds", "Time taken by reads and scans.", s.ReadLatency)
+	writeHistogram(bw, "db_write_latency_seconds", "Time taken by writes, deletes and batches.", s.WriteLatency)
+	if len(s.Buckets) > 0 {
+		writeBucketMetrics(bw, s.Buckets)
+	}
+	return bw.Flush()
+}
+
+// writeBucketMetrics writes a metric for each of the named buckets' stats, labelled with the bucket.
+func writeBucketMetrics(w io.Writer, buckets []database.BucketStats) {
+	metric := func(name, kind, help string, value func(database.BucketStats) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, b := range buckets {
+			fmt.Fprintf(w, "%s{bucket=\"%s\"} %s\n", name, labelEscaper.Replace(b.Name), formatFloat(value(b)))
+		}
+	}
+	metric("db_bucket_reads_total", "counter", "Keys read from the bucket.",
+		func(b database.BucketStats) float64 { return float64(b.Reads) })
+	metric("db_bucket_writes_total", "counter", "Keys written to the bucket.",
+		func(b database.BucketStats) float64 { return float64(b.Writes) })
+	metric("db_bucket_deletes_total", "counter", "Keys deleted from the bucket.",
+		func(b database.BucketStats) float64 { return float64(b.Deletes) })
+	metric("db_bucket_live_keys", "gauge", "Keys in the bucket's index.",
+		func(b database.BucketStats) float64 { return float64(b.LiveKeys) })
+	metric("db_bucket_live_bytes", "gauge", "Bytes taken by the latest entries of the bucket's live keys.",
+		func(b database.BucketStats) float64 { return float64(b.LiveBytes) })
+}
+
+// labelEscaper escapes a label value in the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeHistogram(w io.Writer, name, help string, h database.Histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, b := range h.Buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(b.UpperBound), b.Count)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.Count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.Sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.Count)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,85 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsHandler_WritesPrometheusText(t *testing.T) {
+	db, _, c := SetupTestServer(t)
+	defer c()
+	db.Write("a", "1")
+	db.Get("a")
+
+	srv := httptest.NewServer(server.MetricsHandler(db))
+	defer srv.Close()
+
+	resp, body := Do(t, http.MethodGet, srv.URL, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "version=0.0.4")
+	for _, want := range []string{
+		"# TYPE db_writes_total counter\ndb_writes_total 1\n",
+		"db_reads_total 1\n",
+		"# TYPE db_live_keys gauge\ndb_live_keys 1\n",
+		"# TYPE db_read_latency_seconds histogram\n",
+		"db_read_latency_seconds_bucket{le=\"+Inf\"} 1\n",
+		"db_read_latency_seconds_count 1\n",
+		"db_compaction_duration_seconds_count 0\n",
+	} {
+		assert.Contains(t, body, want)
+	}
+
+	resp, _ = Do(t, http.MethodPost, srv.URL, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestWriteMetrics_LabelsBuckets(t *testing.T) {
+	db, _, c := SetupTestServer(t)
+	defer c()
+	users, err := db.Bucket(`us"ers`)
+	require.NoError(t, err)
+	users.Write("a", "1")
+
+	s, err := db.Stats()
+	require.NoError(t, err)
+	var sb strings.Builder
+	require.NoError(t, server.WriteMetrics(&sb, s))
+	assert.Contains(t, sb.String(), "# TYPE db_bucket_live_keys gauge\ndb_bucket_live_keys{bucket=\"us\\\"ers\"} 1\n")
+	assert.Contains(t, sb.String(), "db_bucket_writes_total{bucket=\"us\\\"ers\"} 1\n")
+}
+
+func TestServeMetrics_LeavesDBOpen(t *testing.T) {
+	db, _, c := SetupTestServer(t)
+	defer c()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.ServeMetrics(ctx, addr, db) }()
+
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		resp, err = http.Get("http://" + addr + "/metrics")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	cancel()
+	require.NoError(t, <-done)
+	_, err = db.Write("still", "open")
+	assert.NoError(t, err)
+}
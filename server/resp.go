@@ -0,0 +1,559 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/file"
+)
+
+// errSyntax is returned to RESP clients that send a malformed command.
+var errSyntax = errors.New("ERR syntax error")
+
+// errTooLarge is returned to RESP clients that write a key or value longer than file.MaxLength.
+var errTooLarge = errors.New("ERR string exceeds maximum allowed size")
+
+// Limits on the commands RESP clients may send, as with Redis's proto-max-bulk-len and
+// client-query-buffer-limit, so that a client cannot make the server allocate more than it will ever
+// store. A bulk string may be longer than file.MaxLength, so that writing one is refused with an error
+// reply rather than by closing the connection.
+const (
+	maxMultibulkLen = 1024 * 1024      // The most arguments a command may have.
+	maxBulkLen      = 1024 * 1024      // The longest argument, in bytes.
+	maxInlineLen    = 64 * 1024        // The longest inline command or header line, in bytes.
+	maxQueryLen     = 16 * 1024 * 1024 // The most bytes a command's arguments may take up in all.
+)
+
+// A RESPServer serves a DB to clients that speak the Redis serialization protocol (RESP), such as
+// redis-cli and Redis client libraries. It supports the commands GET, SET (with EX and PX), DEL,
+// EXISTS, KEYS, SCAN, MGET, MSET, PING, ECHO, INFO, DBSIZE, SELECT and QUIT.
+type RESPServer struct {
+	db *database.DB
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}
+
+// NewRESPServer creates a RESPServer for a DB.
+func NewRESPServer(db *database.DB) *RESPServer {
+	return &RESPServer{
+		db:    db,
+		conns: make(map[net.Conn]bool),
+	}
+}
+
+// ServeRESP serves the DB over RESP on addr until ctx is done. It then closes the listener and every
+// client connection, and shuts down the DB.
+func ServeRESP(ctx context.Context, addr string, db *database.DB) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer db.Shutdown()
+	return NewRESPServer(db).Serve(ctx, l)
+}
+
+// Serve accepts connections on l until ctx is done, serving each on its own goroutine. Once ctx is done,
+// Serve closes l and every open connection and waits for their goroutines to finish.
+func (s *RESPServer) Serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+		s.mu.Lock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.wg.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		// Once ctx is done, the goroutine closing connections may already have run, so a connection
+		// accepted since must be closed here rather than served.
+		s.mu.Lock()
+		if ctx.Err() != nil {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// serveConn reads commands from conn and writes their replies until the client quits or disconnects.
+func (s *RESPServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := &respWriter{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			w.Error(fmt.Errorf("ERR protocol error: %v", err))
+			w.Flush()
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := strings.EqualFold(args[0], "QUIT")
+		if quit {
+			w.SimpleString("OK")
+		} else {
+			s.exec(w, args)
+		}
+		if w.Flush() != nil || quit {
+			return
+		}
+	}
+}
+
+// exec executes a single command, writing its reply to w.
+func (s *RESPServer) exec(w *respWriter, args []string) {
+	name, args := args[0], args[1:]
+	cmd := strings.ToUpper(name)
+	switch cmd {
+	case "PING":
+		switch len(args) {
+		case 0:
+			w.SimpleString("PONG")
+		case 1:
+			w.Bulk(args[0])
+		default:
+			w.WrongArgs(cmd)
+		}
+	case "ECHO":
+		if len(args) != 1 {
+			w.WrongArgs(cmd)
+			return
+		}
+		w.Bulk(args[0])
+	case "SELECT":
+		if len(args) != 1 {
+			w.WrongArgs(cmd)
+			return
+		}
+		if args[0] != "0" {
+			w.Error(errors.New("ERR DB index is out of range"))
+			return
+		}
+		w.SimpleString("OK")
+	case "COMMAND":
+		// Clients such as redis-cli ask for command documentation when they connect; having none to offer
+		// is not an error.
+		w.Array(0)
+	case "GET":
+		if len(args) != 1 {
+			w.WrongArgs(cmd)
+			return
+		}
+		s.get(w, args[0])
+	case "MGET":
+		if len(args) == 0 {
+			w.WrongArgs(cmd)
+			return
+		}
+		w.Array(len(args))
+		for _, key := range args {
+			s.get(w, key)
+		}
+	case "SET":
+		s.set(w, args)
+	case "MSET":
+		if len(args) == 0 || len(args)%2 != 0 {
+			w.WrongArgs(cmd)
+			return
+		}
+		b := file.NewBatch()
+		for i := 0; i < len(args); i += 2 {
+			if tooLarge(args[i], args[i+1]) {
+				w.Error(errTooLarge)
+				return
+			}
+			b.Write(args[i], args[i+1])
+		}
+		if err := s.db.WriteBatch(b); err != nil {
+			w.Error(dbError(err))
+			return
+		}
+		w.SimpleString("OK")
+	case "DEL":
+		if len(args) == 0 {
+			w.WrongArgs(cmd)
+			return
+		}
+		b := file.NewBatch()
+		for _, key := range args {
+			if _, err := s.db.Get(key); err == nil {
+				b.Delete(key)
+			}
+		}
+		if err := s.db.WriteBatch(b); err != nil {
+			w.Error(dbError(err))
+			return
+		}
+		w.Integer(int64(b.Len()))
+	case "EXISTS":
+		if len(args) == 0 {
+			w.WrongArgs(cmd)
+			return
+		}
+		n := 0
+		for _, key := range args {
+			if _, err := s.db.Get(key); err == nil {
+				n++
+			}
+		}
+		w.Integer(int64(n))
+	case "KEYS":
+		if len(args) != 1 {
+			w.WrongArgs(cmd)
+			return
+		}
+		keys := s.keys(args[0])
+		w.Array(len(keys))
+		for _, key := range keys {
+			w.Bulk(key)
+		}
+	case "SCAN":
+		s.scan(w, args)
+	case "DBSIZE":
+		if len(args) != 0 {
+			w.WrongArgs(cmd)
+			return
+		}
+		w.Integer(int64(s.db.Len()))
+	case "INFO":
+		w.Bulk(fmt.Sprintf("# Server\r\nredis_mode:standalone\r\n\r\n# Keyspace\r\ndb0:keys=%d\r\n", s.db.Len()))
+	default:
+		w.Error(fmt.Errorf("ERR unknown command '%s'", name))
+	}
+}
+
+func (s *RESPServer) get(w *respWriter, key string) {
+	entry, err := s.db.Get(key)
+	switch {
+	case err == file.ErrNotFound:
+		w.Null()
+	case err != nil:
+		w.Error(dbError(err))
+	default:
+		w.Bulk(entry.Value())
+	}
+}
+
+// set executes SET key value [EX seconds|PX milliseconds].
+func (s *RESPServer) set(w *respWriter, args []string) {
+	if len(args) != 2 && len(args) != 4 {
+		w.WrongArgs("SET")
+		return
+	}
+
+	if tooLarge(args[0], args[1]) {
+		w.Error(errTooLarge)
+		return
+	}
+
+	var option []file.EntryOption
+	if len(args) == 4 {
+		n, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil || n <= 0 {
+			w.Error(errors.New("ERR invalid expire time in 'set' command"))
+			return
+		}
+		switch strings.ToUpper(args[2]) {
+		case "EX":
+			option = append(option, file.TTL(time.Duration(n)*time.Second))
+		case "PX":
+			option = append(option, file.TTL(time.Duration(n)*time.Millisecond))
+		default:
+			w.Error(errSyntax)
+			return
+		}
+	}
+
+	if _, err := s.db.Write(args[0], args[1], option...); err != nil {
+		w.Error(dbError(err))
+		return
+	}
+	w.SimpleString("OK")
+}
+
+// scan executes SCAN cursor [MATCH pattern] [COUNT count]. The cursor is the position in the sorted list
+// of keys at which to continue, so, as with Redis, keys written during a scan may be missed or repeated.
+func (s *RESPServer) scan(w *respWriter, args []string) {
+	if len(args) == 0 || len(args)%2 != 1 {
+		w.WrongArgs("SCAN")
+		return
+	}
+
+	cursor, err := strconv.Atoi(args[0])
+	if err != nil || cursor < 0 {
+		w.Error(errors.New("ERR invalid cursor"))
+		return
+	}
+
+	pattern, count := "*", 10
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil || count < 1 {
+				w.Error(errSyntax)
+				return
+			}
+		default:
+			w.Error(errSyntax)
+			return
+		}
+	}
+
+	page, next := s.db.KeysAt(cursor, count)
+	match := globRegexp(pattern)
+	matched := make([]string, 0, len(page))
+	for _, key := range page {
+		if match.MatchString(key) {
+			matched = append(matched, key)
+		}
+	}
+
+	w.Array(2)
+	w.Bulk(strconv.Itoa(next))
+	w.Array(len(matched))
+	for _, key := range matched {
+		w.Bulk(key)
+	}
+}
+
+// keys returns the sorted live keys that match a glob pattern. Only the keys that begin with the
+// pattern's literal prefix are considered.
+func (s *RESPServer) keys(pattern string) []string {
+	from, to := file.PrefixRange(globPrefix(pattern))
+	match := globRegexp(pattern)
+	keys := make([]string, 0)
+	for _, key := range s.db.Keys(from, to, 0) {
+		if match.MatchString(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// globPrefix returns the literal text at the start of a glob pattern, which every key it matches begins
+// with.
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+func globRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "^") {
+				class = "^" + regexp.QuoteMeta(class[1:])
+			} else {
+				class = regexp.QuoteMeta(class)
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString(`$`)
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		// Only a malformed character class can get here; match nothing, as Redis would.
+		return regexp.MustCompile(`$^`)
+	}
+	return re
+}
+
+// tooLarge returns true if any of s is too long to be written as a key or value.
+func tooLarge(s ...string) bool {
+	for _, v := range s {
+		if len(v) > file.MaxLength {
+			return true
+		}
+	}
+	return false
+}
+
+// dbError converts an error from the DB into a RESP error.
+func dbError(err error) error {
+	if err == file.ErrReadOnly {
+		return errors.New("READONLY You can't write against a read only database")
+	}
+	return fmt.Errorf("ERR %v", err)
+}
+
+// readCommand reads a single command from r, either as a RESP array of bulk strings or as an inline
+// command of space-separated words.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxMultibulkLen {
+		return nil, errors.New("invalid multibulk length")
+	}
+
+	// Grow args as arguments arrive, rather than trusting the header with the allocation.
+	args := make([]string, 0, min(n, 64))
+	total := 0
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected '$', got '%.1s'", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, errors.New("invalid bulk length")
+		}
+		if total += size; total > maxQueryLen {
+			return nil, errors.New("command exceeds the query buffer limit")
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by CRLF (or, leniently, LF) and returns it without the terminator.
+// Lines longer than maxInlineLen are refused.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxInlineLen {
+			return "", errors.New("too big inline request")
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		break
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// A respWriter writes RESP replies.
+type respWriter struct {
+	*bufio.Writer
+}
+
+func (w *respWriter) SimpleString(s string) {
+	fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+func (w *respWriter) Error(err error) {
+	fmt.Fprintf(w, "-%s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error()))
+}
+
+func (w *respWriter) WrongArgs(cmd string) {
+	w.Error(fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func (w *respWriter) Integer(n int64) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func (w *respWriter) Bulk(s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func (w *respWriter) Null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w *respWriter) Array(n int) {
+	fmt.Fprintf(w, "*%d\r\n", n)
+}
@@ -0,0 +1,218 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthew-burr/db/database"
+	"github.com/matthew-burr/db/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A RESPClient is a minimal Redis client for testing.
+type RESPClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *RESPClient) Do(t *testing.T, args ...string) interface{} {
+	fmt.Fprintf(c.conn, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(c.conn, "$%d\r\n%s\r\n", len(a), a)
+	}
+	reply, err := c.read()
+	require.NoError(t, err)
+	return reply
+}
+
+// read reads a reply, returning strings for simple and bulk strings, an error for errors, nil for null,
+// int64 for integers and []interface{} for arrays.
+func (c *RESPClient) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return fmt.Errorf("%s", line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		_, err := io.ReadFull(c.r, buf)
+		return string(buf[:n]), err
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		arr := make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("bad reply %q", line)
+}
+
+func SetupRESPServer(t *testing.T) (db *database.DB, client *RESPClient, cleanup func()) {
+	db, err := database.Init("resp_test")
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.NewRESPServer(db).Serve(ctx, l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	return db, &RESPClient{conn, bufio.NewReader(conn)}, func() {
+		conn.Close()
+		cancel()
+		<-done
+		db.Shutdown()
+		os.Remove("resp_test.dat")
+		os.Remove("resp_test.lock")
+	}
+}
+
+func TestRESP_Ping(t *testing.T) {
+	_, c, cleanup := SetupRESPServer(t)
+	defer cleanup()
+
+	assert.Equal(t, "PONG", c.Do(t, "PING"))
+	assert.Equal(t, "hi", c.Do(t, "ping", "hi"))
+}
+
+func TestRESP_SetGetDel(t *testing.T) {
+	_, c, cleanup := SetupRESPServer(t)
+	defer cleanup()
+
+	assert.Equal(t, nil, c.Do(t, "GET", "k"))
+	assert.Equal(t, "OK", c.Do(t, "SET", "k", "hello world"))
+	assert.Equal(t, "hello world", c.Do(t, "GET", "k"))
+	assert.Equal(t, int64(1), c.Do(t, "EXISTS", "k", "missing"))
+	assert.Equal(t, int64(1), c.Do(t, "DEL", "k", "missing"))
+	assert.Equal(t, nil, c.Do(t, "GET", "k"))
+}
+
+func TestRESP_SetWithExpiry(t *testing.T) {
+	_, c, cleanup := SetupRESPServer(t)
+	defer cleanup()
+
+	assert.Equal(t, "OK", c.Do(t, "SET", "short", "lived", "PX", "10"))
+	assert.Equal(t, "OK", c.Do(t, "SET", "long", "lived", "EX", "100"))
+	time.Sleep(20 * time.Millisecond)
+
+	assert.Equal(t, nil, c.Do(t, "GET", "short"))
+	assert.Equal(t, "lived", c.Do(t, "GET", "long"))
+	assert.IsType(t, fmt.Errorf(""), c.Do(t, "SET", "k", "v", "EX", "soon"))
+	assert.IsType(t, fmt.Errorf(""), c.Do(t, "SET", "k", "v", "XX", "1"))
+}
+
+func TestRESP_MultiKeyCommands(t *testing.T) {
+	_, c, cleanup := SetupRESPServer(t)
+	defer cleanup()
+
+	assert.Equal(t, "OK", c.Do(t, "MSET", "user:1", "a", "user:2", "b", "group:1", "c"))
+	assert.Equal(t, []interface{}{"a", nil, "c"}, c.Do(t, "MGET", "user:1", "user:3", "group:1"))
+	assert.Equal(t, []interface{}{"user:1", "user:2"}, c.Do(t, "KEYS", "user:*"))
+	assert.Equal(t, []interface{}{"group:1", "user:1"}, c.Do(t, "KEYS", "[gu]*:1"))
+	assert.Equal(t, []interface{}{"group:1"}, c.Do(t, "KEYS", "[^u]????:?"))
+	assert.Equal(t, int64(3), c.Do(t, "DBSIZE"))
+	assert.Contains(t, c.Do(t, "INFO"), "db0:keys=3")
+}
+
+func TestRESP_Scan(t *testing.T) {
+	_, c, cleanup := SetupRESPServer(t)
+	defer cleanup()
+	c.Do(t, "MSET", "a", "1", "b", "2", "c", "3")
+
+	var keys []interface{}
+	cursor := "0"
+	for {
+		reply := c.Do(t, "SCAN", cursor, "COUNT", "2").([]interface{})
+		keys = append(keys, reply[1].([]interface{})...)
+		cursor = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, []interface{}{"a", "b", "c"}, keys)
+
+	reply := c.Do(t, "SCAN", "0", "MATCH", "b*").([]interface{})
+	assert.Equal(t, []interface{}{"b"}, reply[1])
+}
+
+func TestRESP_Errors(t *testing.T) {
+	_, c, cleanup := SetupRESPServer(t)
+	defer cleanup()
+
+	assert.Equal(t, fmt.Errorf("ERR unknown command 'FROB'"), c.Do(t, "FROB"))
+	assert.Equal(t, fmt.Errorf("ERR wrong number of arguments for 'get' command"), c.Do(t, "GET"))
+}
+
+func TestRESP_InlineCommands(t *testing.T) {
+	_, c, cleanup := SetupRESPServer(t)
+	defer cleanup()
+
+	fmt.Fprint(c.conn, "SET inline value\r\nGET inline\r\n")
+	reply, err := c.read()
+	require.NoError(t, err)
+	assert.Equal(t, "OK", reply)
+	reply, err = c.read()
+	require.NoError(t, err)
+	assert.Equal(t, "value", reply)
+}
+
+func TestRESP_RejectsOversizedCommands(t *testing.T) {
+	_, c, cleanup := SetupRESPServer(t)
+	defer cleanup()
+
+	large := strings.Repeat("x", math.MaxInt16+1)
+	assert.Equal(t, fmt.Errorf("ERR string exceeds maximum allowed size"), c.Do(t, "SET", "a", large))
+	assert.Equal(t, fmt.Errorf("ERR string exceeds maximum allowed size"), c.Do(t, "MSET", "b", "1", "c", large))
+	assert.Nil(t, c.Do(t, "GET", "b"), "nothing in the batch is written")
+
+	// A header asking for more than the server will allocate closes the connection.
+	fmt.Fprint(c.conn, "*1\r\n$1073741824\r\n")
+	reply, err := c.read()
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Errorf("ERR protocol error: invalid bulk length"), reply)
+	_, err = c.read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRESP_RejectsCommandsOverTheQueryLimit(t *testing.T) {
+	_, c, cleanup := SetupRESPServer(t)
+	defer cleanup()
+
+	// Each argument is within the bulk length limit, but together they are not.
+	arg := strings.Repeat("x", 1024*1024)
+	go func() {
+		fmt.Fprint(c.conn, "*17\r\n")
+		for i := 0; i < 17; i++ {
+			fmt.Fprintf(c.conn, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}()
+	reply, err := c.read()
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Errorf("ERR protocol error: command exceeds the query buffer limit"), reply)
+}